@@ -0,0 +1,90 @@
+package main
+
+import (
+	prop "github.com/magiconair/properties"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/exchange/ecbinance/mock"
+	"net/http"
+	"os"
+)
+
+const propertiesFileName = "binancemock.properties"
+const propertiesListenName = "listen"
+const propertiesSymbolsName = "symbols"
+const propertiesBalancesName = "balances"
+const propertiesDefaultOutcomeName = "outcome.default"
+const propertiesOutcomesName = "outcomes"
+const propertiesMinNotionalName = "min.notional"
+
+func init() {
+
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+	})
+
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.TraceLevel)
+}
+
+func main() {
+
+	ctxLog := log.WithFields(log.Fields{"id": "BinanceMock"})
+
+	ctxLog.Info("BinanceMock is going to start")
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	properties := prop.MustLoadFile(propertiesFileName, prop.UTF8)
+
+	for k, v := range properties.Map() {
+		ctxLog.Debug("key[" + k + "] value[" + v + "]")
+	}
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	symbols, err := mock.ParseSymbols(properties.MustGet(propertiesSymbolsName))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse symbols ", err)
+	}
+
+	balances, err := mock.ParseBalances(properties.GetString(propertiesBalancesName, ""))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse balances ", err)
+	}
+
+	defaultOutcome, err := mock.ParseOutcome(properties.GetString(propertiesDefaultOutcomeName, mock.OutcomeFilled))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse default outcome ", err)
+	}
+
+	outcomes, err := mock.ParseOutcomes(properties.GetString(propertiesOutcomesName, ""))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse outcomes ", err)
+	}
+
+	minNotional, err := decimal.NewFromString(properties.GetString(propertiesMinNotionalName, "0"))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse min notional ", err)
+	}
+
+	server := mock.NewServer(symbols, balances, defaultOutcome)
+
+	server.SetMinNotional(minNotional)
+
+	server.Push(outcomes...)
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	listen := properties.MustGet(propertiesListenName)
+
+	ctxLog.Info("BinanceMock is listening on [" + listen + "]")
+
+	ctxLog.Fatal(http.ListenAndServe(listen, server.Handler()))
+}
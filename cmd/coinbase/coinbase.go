@@ -0,0 +1,26 @@
+package main
+
+import (
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/launcher"
+	_ "msq.ai/exchange/eccoinbase"
+	"os"
+)
+
+const propertiesFileName = "coinbase.properties"
+
+func init() {
+
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+	})
+
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.TraceLevel)
+}
+
+func main() {
+	launcher.Run("Coinbase", propertiesFileName)
+}
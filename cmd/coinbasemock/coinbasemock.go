@@ -0,0 +1,81 @@
+package main
+
+import (
+	prop "github.com/magiconair/properties"
+	log "github.com/sirupsen/logrus"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/eccoinbase/mock"
+	"net/http"
+	"os"
+)
+
+const propertiesFileName = "coinbasemock.properties"
+const propertiesListenName = "listen"
+const propertiesSymbolsName = "symbols"
+const propertiesBalancesName = "balances"
+const propertiesDefaultOutcomeName = "outcome.default"
+const propertiesOutcomesName = "outcomes"
+
+func init() {
+
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+	})
+
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.TraceLevel)
+}
+
+func main() {
+
+	ctxLog := log.WithFields(log.Fields{"id": "CoinbaseMock"})
+
+	ctxLog.Info("CoinbaseMock is going to start")
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	properties := prop.MustLoadFile(propertiesFileName, prop.UTF8)
+
+	for k, v := range properties.Map() {
+		ctxLog.Debug("key[" + k + "] value[" + v + "]")
+	}
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	symbols, err := spot.ParseSymbols(properties.MustGet(propertiesSymbolsName))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse symbols ", err)
+	}
+
+	balances, err := spot.ParseBalances(properties.GetString(propertiesBalancesName, ""))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse balances ", err)
+	}
+
+	defaultOutcome, err := spot.ParseOutcome(properties.GetString(propertiesDefaultOutcomeName, spot.OutcomeFilled))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse default outcome ", err)
+	}
+
+	outcomes, err := spot.ParseOutcomes(properties.GetString(propertiesOutcomesName, ""))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse outcomes ", err)
+	}
+
+	server := mock.NewServer(symbols, balances, defaultOutcome)
+
+	server.Push(outcomes...)
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	listen := properties.MustGet(propertiesListenName)
+
+	ctxLog.Info("CoinbaseMock is listening on [" + listen + "]")
+
+	ctxLog.Fatal(http.ListenAndServe(listen, server.Handler()))
+}
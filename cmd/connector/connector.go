@@ -0,0 +1,34 @@
+package main
+
+import (
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/launcher"
+	_ "msq.ai/exchange/ecbinance"
+	_ "msq.ai/exchange/ecbinancefutures"
+	_ "msq.ai/exchange/eccoinbase"
+	_ "msq.ai/exchange/ecfix"
+	_ "msq.ai/exchange/ecib"
+	_ "msq.ai/exchange/eckraken"
+	_ "msq.ai/exchange/ecsim"
+	"os"
+)
+
+const propertiesFileName = "connector.properties"
+
+func init() {
+
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+	})
+
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.TraceLevel)
+}
+
+// main starts connectors of all exchanges which are listed in properties, adding new exchange needs its package and
+// one import here
+func main() {
+	launcher.Run("Connector", propertiesFileName)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/adshao/go-binance"
 	_ "github.com/lib/pq"
 	prop "github.com/magiconair/properties"
 	log "github.com/sirupsen/logrus"
@@ -8,12 +9,19 @@ import (
 	"msq.ai/constants"
 	"msq.ai/db/postgres/dao"
 	pgh "msq.ai/db/postgres/helper"
+	"msq.ai/exchange/ecbinance"
 	"msq.ai/rest/gin"
 	"os"
 	"time"
 )
 
 const propertiesFileName = "execution.properties"
+const propertiesBinanceApiUrlName = "binance.api.url"
+const propertiesBinanceRulesRefreshSecondsName = "binance.rules.refresh.seconds"
+const propertiesBinancePricesRefreshSecondsName = "binance.prices.refresh.seconds"
+const propertiesBinanceRulesRoundName = "binance.rules.round"
+
+const binanceName = "BINANCE"
 
 func init() {
 
@@ -80,7 +88,23 @@ func main() {
 	//- using env:	export GIN_MODE=release
 	//- using code:	gin.SetMode(gin.ReleaseMode)
 
-	gin.RunGinRestService(url, dictionaries, timeForExecution)
+	validators := make(map[string]gin.Validator)
+
+	// commands of Binance are checked against its trading rules before they are inserted
+	if binanceUrl := properties.GetString(propertiesBinanceApiUrlName, ""); len(binanceUrl) > 0 {
+
+		client := binance.NewClient("", "")
+		client.BaseURL = binanceUrl
+
+		rules := ecbinance.RunRules(client,
+			time.Duration(properties.GetInt(propertiesBinanceRulesRefreshSecondsName, 300))*time.Second,
+			time.Duration(properties.GetInt(propertiesBinancePricesRefreshSecondsName, 10))*time.Second,
+			properties.GetBool(propertiesBinanceRulesRoundName, false))
+
+		validators[binanceName] = rules.Check
+	}
+
+	gin.RunGinRestService(url, dictionaries, timeForExecution, validators)
 
 	//------------------------------------------------------------------------------------------------------------------
 
@@ -0,0 +1,92 @@
+package main
+
+import (
+	prop "github.com/magiconair/properties"
+	log "github.com/sirupsen/logrus"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/ecfix/mock"
+	"net"
+	"net/http"
+	"os"
+)
+
+const propertiesFileName = "fixmock.properties"
+const propertiesListenName = "listen"
+const propertiesControlListenName = "control.listen"
+const propertiesCompIdName = "comp.id"
+const propertiesSymbolsName = "symbols"
+const propertiesDefaultOutcomeName = "outcome.default"
+const propertiesOutcomesName = "outcomes"
+
+func init() {
+
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+	})
+
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.TraceLevel)
+}
+
+func main() {
+
+	ctxLog := log.WithFields(log.Fields{"id": "FixMock"})
+
+	ctxLog.Info("FixMock is going to start")
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	properties := prop.MustLoadFile(propertiesFileName, prop.UTF8)
+
+	for k, v := range properties.Map() {
+		ctxLog.Debug("key[" + k + "] value[" + v + "]")
+	}
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	symbols, err := spot.ParseSymbols(properties.MustGet(propertiesSymbolsName))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse symbols ", err)
+	}
+
+	defaultOutcome, err := spot.ParseOutcome(properties.GetString(propertiesDefaultOutcomeName, spot.OutcomeFilled))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse default outcome ", err)
+	}
+
+	outcomes, err := spot.ParseOutcomes(properties.GetString(propertiesOutcomesName, ""))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse outcomes ", err)
+	}
+
+	server := mock.NewServer(properties.MustGet(propertiesCompIdName), symbols, defaultOutcome)
+
+	server.Push(outcomes...)
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	if control := properties.GetString(propertiesControlListenName, ""); len(control) > 0 {
+
+		go func() {
+			ctxLog.Error("Control server error ", http.ListenAndServe(control, server.Handler()))
+		}()
+
+		ctxLog.Info("FixMock outcomes are served at http://" + control + "/mock/outcomes")
+	}
+
+	listen := properties.MustGet(propertiesListenName)
+
+	listener, err := net.Listen("tcp", listen)
+
+	if err != nil {
+		ctxLog.Fatal("Cannot listen on ["+listen+"] ", err)
+	}
+
+	ctxLog.Info("FixMock is listening on [" + listen + "]")
+
+	ctxLog.Fatal(server.Serve(listener))
+}
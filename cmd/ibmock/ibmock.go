@@ -0,0 +1,85 @@
+package main
+
+import (
+	prop "github.com/magiconair/properties"
+	log "github.com/sirupsen/logrus"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/ecib/mock"
+	"net/http"
+	"os"
+	"time"
+)
+
+const propertiesFileName = "ibmock.properties"
+const propertiesListenName = "listen"
+const propertiesSymbolsName = "symbols"
+const propertiesBalancesName = "balances"
+const propertiesDefaultOutcomeName = "outcome.default"
+const propertiesOutcomesName = "outcomes"
+const propertiesReplyDelayName = "reply.delay.ms"
+
+func init() {
+
+	log.SetFormatter(&log.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+	})
+
+	log.SetOutput(os.Stdout)
+	log.SetLevel(log.TraceLevel)
+}
+
+func main() {
+
+	ctxLog := log.WithFields(log.Fields{"id": "IbMock"})
+
+	ctxLog.Info("IbMock is going to start")
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	properties := prop.MustLoadFile(propertiesFileName, prop.UTF8)
+
+	for k, v := range properties.Map() {
+		ctxLog.Debug("key[" + k + "] value[" + v + "]")
+	}
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	symbols, err := spot.ParseSymbols(properties.MustGet(propertiesSymbolsName))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse symbols ", err)
+	}
+
+	balances, err := spot.ParseBalances(properties.GetString(propertiesBalancesName, ""))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse balances ", err)
+	}
+
+	defaultOutcome, err := spot.ParseOutcome(properties.GetString(propertiesDefaultOutcomeName, spot.OutcomeFilled))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse default outcome ", err)
+	}
+
+	outcomes, err := spot.ParseOutcomes(properties.GetString(propertiesOutcomesName, ""))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse outcomes ", err)
+	}
+
+	delay := time.Duration(properties.GetInt(propertiesReplyDelayName, 0)) * time.Millisecond
+
+	server := mock.NewServer(symbols, balances, defaultOutcome, delay)
+
+	server.Push(outcomes...)
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	listen := properties.MustGet(propertiesListenName)
+
+	ctxLog.Info("IbMock is listening on [" + listen + "]")
+
+	ctxLog.Fatal(http.ListenAndServe(listen, server.Handler()))
+}
@@ -1,39 +1,55 @@
-// http://172.104.241.88:3000/dashboard
-
-const WebSocket = require('ws')
-
-const url = 'ws://172.104.241.88:8080'
-//const url = 'ws://localhost:8080'
-const connection = new WebSocket(url)
-
-var messages = []
-messages.push({ code: "PLACE-ORDER" , account: "DU997901" , op: "BUY" , symbol: "CSCO" , qty: 1 , order_type: "LMT" , price: 123.32})
-messages.push({ code: "PLACE-ORDER" , account: "DU1031917" , op: "SELL" , symbol: "TEVA" , qty: 2 , order_type: "MKT" })
-messages.push({ code: "PLACE-ORDER" , account: "DU997900" , op: "BUY" , symbol: "MSFT" , qty: 3 , order_type: "MKT" })
-
-// ADDED MESSAGES
-messages.push({ code: "ORDER-STATUS-REQUEST" , oid: 430 })
-messages.push({ code: "CANCEL-ORDER-REQUEST" , oid: 430 })
-messages.push({ code: "ACCOUNT-INFO-REQUEST" , account: "DU997900" })
-
-
-function processMessage(e){
-    console.log(e.data)
-    return 0;
-}
-
-function sendMessage(m){
-    connection.send(JSON.stringify(m));
-}
-
-connection.onopen = () => {
-    messages.forEach( sendMessage);
-}
-
-connection.onerror = (error) => {
-    console.log(`WebSocket error: ${error}`)
-}
-
-connection.onmessage = (e) => {
-    setTimeout(function(){processMessage(e)}, 10);
+// http://172.104.241.88:3000/dashboard
+
+const WebSocket = require('ws')
+
+const url = 'ws://172.104.241.88:8080'
+//const url = 'ws://localhost:8080'
+const connection = new WebSocket(url)
+
+var messages = []
+messages.push({ code: "PLACE-ORDER" , account: "DU997901" , op: "BUY" , symbol: "CSCO" , qty: 1 , order_type: "LMT" , price: 123.32})
+messages.push({ code: "PLACE-ORDER" , account: "DU1031917" , op: "SELL" , symbol: "TEVA" , qty: 2 , order_type: "MKT" })
+messages.push({ code: "PLACE-ORDER" , account: "DU997900" , op: "BUY" , symbol: "MSFT" , qty: 3 , order_type: "MKT" })
+
+// ADDED MESSAGES
+messages.push({ code: "ORDER-STATUS-REQUEST" , oid: 430 })
+
+// connector puts its command id to cid of every request and waits for replies with the same cid: ORDER-ACK and
+// ORDER-STATUS of PLACE-ORDER, ORDER-STATUS or ERROR 135 "Can't find order" of ORDER-STATUS-REQUEST. Status is asked by
+// oid when connector knows it and by cid only after restart of connector, so bridge must find order by either.
+// The whole protocol is described beside the parser of replies in src/msq.ai/exchange/ecib/ecib.go.
+messages.push({ code: "PLACE-ORDER" , account: "DU997900" , op: "BUY" , symbol: "MSFT" , qty: 1 , order_type: "LMT" , price: 100.5 , cid: "1001" })
+messages.push({ code: "ORDER-STATUS-REQUEST" , oid: 430 , cid: "1001" })
+messages.push({ code: "ORDER-STATUS-REQUEST" , cid: "1001" })
+messages.push({ code: "ORDER-STATUS-REQUEST" , cid: "999999999999" })
+messages.push({ code: "CANCEL-ORDER-REQUEST" , oid: 430 })
+messages.push({ code: "ACCOUNT-INFO-REQUEST" , account: "DU997900" })
+
+
+function processMessage(e){
+    console.log(e.data)
+
+    var reply = JSON.parse(e.data)
+
+    if ((reply.code === "ORDER-ACK" || reply.code === "ORDER-STATUS") && reply.cid === undefined) {
+        console.log("Bridge doesn't return cid, connector cannot match the reply")
+    }
+
+    return 0;
+}
+
+function sendMessage(m){
+    connection.send(JSON.stringify(m));
+}
+
+connection.onopen = () => {
+    messages.forEach( sendMessage);
+}
+
+connection.onerror = (error) => {
+    console.log(`WebSocket error: ${error}`)
+}
+
+connection.onmessage = (e) => {
+    setTimeout(function(){processMessage(e)}, 10);
 }
\ No newline at end of file
@@ -11,6 +11,10 @@ CREATE TABLE "exchange" (
 INSERT INTO "exchange" ("id", "name") VALUES (1, 'BINANCE');
 INSERT INTO "exchange" ("id", "name") VALUES (2, 'KRAKEN');
 INSERT INTO "exchange" ("id", "name") VALUES (3, 'IB');
+INSERT INTO "exchange" ("id", "name") VALUES (4, 'BINANCE_FUTURES');
+INSERT INTO "exchange" ("id", "name") VALUES (5, 'COINBASE');
+INSERT INTO "exchange" ("id", "name") VALUES (6, 'FIX');
+INSERT INTO "exchange" ("id", "name") VALUES (7, 'SIMULATOR');
 
 
 CREATE TABLE "direction" (
@@ -35,6 +39,9 @@ CREATE TABLE "order_type" (
 INSERT INTO "order_type" ("id", "type") VALUES (1, 'MARKET');
 INSERT INTO "order_type" ("id", "type") VALUES (2, 'LIMIT');
 INSERT INTO "order_type" ("id", "type") VALUES (3, 'INFO');
+INSERT INTO "order_type" ("id", "type") VALUES (4, 'LEVERAGE');
+INSERT INTO "order_type" ("id", "type") VALUES (5, 'MARGIN_ISOLATED');
+INSERT INTO "order_type" ("id", "type") VALUES (6, 'MARGIN_CROSSED');
 
 
 CREATE TABLE "time_in_force" (
@@ -48,16 +55,18 @@ INSERT INTO "time_in_force" ("id", "type") VALUES (1, 'FOK');
 INSERT INTO "time_in_force" ("id", "type") VALUES (2, 'GTC');
 
 
+-- priority is given to the command of the type when it is not set by request, commands of higher priority are executed first
 CREATE TABLE "execution_type" (
-    id   SMALLINT PRIMARY KEY,
-    type VARCHAR(10) NOT NULL,
+    id       SMALLINT PRIMARY KEY,
+    type     VARCHAR(10) NOT NULL,
+    priority SMALLINT NOT NULL,
 
     CONSTRAINT "execution_type_to_type_unique" UNIQUE (type)
 );
 
-INSERT INTO "execution_type" ("id", "type") VALUES (1, 'OPEN');
-INSERT INTO "execution_type" ("id", "type") VALUES (2, 'CLOSE');
-INSERT INTO "execution_type" ("id", "type") VALUES (3, 'REQUEST');
+INSERT INTO "execution_type" ("id", "type", "priority") VALUES (1, 'OPEN', 0);
+INSERT INTO "execution_type" ("id", "type", "priority") VALUES (2, 'CLOSE', 10);
+INSERT INTO "execution_type" ("id", "type", "priority") VALUES (3, 'REQUEST', 0);
 
 
 CREATE TABLE "execution_status" (
@@ -74,6 +83,8 @@ INSERT INTO "execution_status" ("id", "value") VALUES (4, 'COMPLETED');
 INSERT INTO "execution_status" ("id", "value") VALUES (5, 'TIMED_OUT');
 INSERT INTO "execution_status" ("id", "value") VALUES (6, 'ERROR');
 INSERT INTO "execution_status" ("id", "value") VALUES (7, 'REJECTED');
+INSERT INTO "execution_status" ("id", "value") VALUES (8, 'OPEN');
+INSERT INTO "execution_status" ("id", "value") VALUES (9, 'PARTIALLY_FILLED');
 
 
 CREATE TABLE "execution" (
@@ -82,8 +93,9 @@ CREATE TABLE "execution" (
     instrument_name   VARCHAR(20) NOT NULL,
     direction_id      SMALLINT NOT NULL,
     order_type_id     SMALLINT NOT NULL,
-    limit_price       NUMERIC(24, 10) DEFAULT NULL,
-    amount            NUMERIC(24, 10) NOT NULL,
+    limit_price       NUMERIC(40, 18) DEFAULT NULL,
+    amount            NUMERIC(40, 18) NOT NULL,
+    executed_quantity NUMERIC(40, 18) NOT NULL DEFAULT 0,
     status_id         SMALLINT NOT NULL,
     connector_id      SMALLINT DEFAULT NULL,
     execution_type_id SMALLINT NOT NULL,
@@ -95,6 +107,7 @@ CREATE TABLE "execution" (
     api_key           TEXT NOT NULL,
     secret_key        TEXT NOT NULL,
     finger_print      TEXT NOT NULL,
+    priority          SMALLINT NOT NULL DEFAULT 0,
 
     CONSTRAINT "execution_fk1" FOREIGN KEY ("exchange_id")       REFERENCES "exchange"         ("id"),
     CONSTRAINT "execution_fk2" FOREIGN KEY ("status_id")         REFERENCES "execution_status" ("id"),
@@ -105,6 +118,9 @@ CREATE TABLE "execution" (
     CONSTRAINT unique_finger_print UNIQUE(finger_print)
 );
 
+-- queue of commands waiting for a connector
+CREATE INDEX "execution_created_idx" ON "execution" ("exchange_id", "status_id", "account_id", "priority", "id") WHERE "connector_id" IS NULL;
+
 
 CREATE TABLE "execution_history" (
     id             BIGSERIAL PRIMARY KEY,
@@ -121,27 +137,102 @@ CREATE TABLE "execution_history" (
 
 
 CREATE TABLE "orders" (
-    id                BIGSERIAL PRIMARY KEY,
-    external_order_id BIGINT NOT NULL,
-    execution_id      BIGINT NOT NULL,
-    price             NUMERIC(24, 10) NOT NULL,
-    commission        NUMERIC(24,10) NOT NULL,
-    commission_asset  VARCHAR(20) NOT NULL,
+    id                 BIGSERIAL PRIMARY KEY,
+    external_order_id  BIGINT NOT NULL,
+    external_order_ref TEXT DEFAULT NULL,
+    execution_id       BIGINT NOT NULL,
+    price              NUMERIC(40, 18) NOT NULL,
+    executed_quantity  NUMERIC(40, 18) NOT NULL,
+    commission         NUMERIC(40, 18) NOT NULL,
+    commission_asset   VARCHAR(20) NOT NULL,
 
     CONSTRAINT "order_fk1" FOREIGN KEY ("execution_id") REFERENCES "execution" ("id"),
     CONSTRAINT unique_execution_id UNIQUE(execution_id)
 );
 
 
+CREATE TABLE "fills" (
+    id                 BIGSERIAL PRIMARY KEY,
+    execution_id       BIGINT NOT NULL,
+    external_trade_id  BIGINT DEFAULT NULL,
+    external_trade_ref TEXT DEFAULT NULL,
+    quantity           NUMERIC(40, 18) NOT NULL,
+    price              NUMERIC(40, 18) NOT NULL,
+    commission         NUMERIC(40, 18) NOT NULL,
+    commission_asset   VARCHAR(20) NOT NULL,
+
+    CONSTRAINT "fills_fk1" FOREIGN KEY ("execution_id") REFERENCES "execution" ("id")
+);
+
+
 CREATE TABLE "balances" (
     id           BIGSERIAL PRIMARY KEY,
     execution_id BIGINT NOT NULL,
     asset        VARCHAR(20) NOT NULL,
-    free         NUMERIC(24, 10) NOT NULL,
-    locked       NUMERIC(24,10) NOT NULL,
+    free         NUMERIC(40, 18) NOT NULL,
+    locked       NUMERIC(40, 18) NOT NULL,
 
     CONSTRAINT "balances_fk1" FOREIGN KEY ("execution_id") REFERENCES "execution" ("id")
 );
 
+
+CREATE TABLE "positions" (
+    id                BIGSERIAL PRIMARY KEY,
+    execution_id      BIGINT NOT NULL,
+    symbol            VARCHAR(20) NOT NULL,
+    position_side     VARCHAR(10) NOT NULL,
+    amount            NUMERIC(40, 18) NOT NULL,
+    entry_price       NUMERIC(40, 18) NOT NULL,
+    unrealized_profit NUMERIC(40, 18) NOT NULL,
+    leverage          SMALLINT NOT NULL,
+    margin_type       VARCHAR(10) NOT NULL,
+
+    CONSTRAINT "positions_fk1" FOREIGN KEY ("execution_id") REFERENCES "execution" ("id")
+);
+
+
+-- commands of simulated accounts go to SIMULATOR exchange whatever exchange they name
+CREATE TABLE "account" (
+    id        BIGINT PRIMARY KEY,
+    simulated BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+
+CREATE TABLE "simulated_balance" (
+    account_id BIGINT NOT NULL,
+    asset      VARCHAR(20) NOT NULL,
+    free       NUMERIC(40, 18) NOT NULL,
+    locked     NUMERIC(40, 18) NOT NULL,
+
+    CONSTRAINT "simulated_balance_pk" PRIMARY KEY ("account_id", "asset")
+);
+
+
+CREATE TABLE "simulated_order" (
+    execution_id      BIGINT PRIMARY KEY,
+    account_id        BIGINT NOT NULL,
+    status            VARCHAR(10) NOT NULL,
+    price             NUMERIC(40, 18) NOT NULL,
+    executed_quantity NUMERIC(40, 18) NOT NULL,
+    commission        NUMERIC(40, 18) NOT NULL,
+    commission_asset  VARCHAR(20) NOT NULL,
+    update_timestamp  TIMESTAMP NOT NULL,
+
+    CONSTRAINT "simulated_order_fk1" FOREIGN KEY ("execution_id") REFERENCES "execution" ("id")
+);
+
+
+-- connector renews its lease by heartbeats, commands of the connector which lease is expired are taken over by a live
+-- connector of the same exchange
+CREATE TABLE "connector" (
+    exchange_id    SMALLINT NOT NULL,
+    id             SMALLINT NOT NULL,
+    heartbeat_time TIMESTAMPTZ NOT NULL,
+    lease_till     TIMESTAMPTZ NOT NULL,
+
+    CONSTRAINT "connector_pk" PRIMARY KEY ("exchange_id", "id"),
+    CONSTRAINT "connector_fk1" FOREIGN KEY ("exchange_id") REFERENCES "exchange" ("id")
+);
+
 COMMIT;
 
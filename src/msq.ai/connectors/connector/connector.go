@@ -79,9 +79,24 @@ func RunConnector(ctxLog *log.Entry, in <-chan *proto.ExecRequest, out chan<- *p
 				ctxLog.Fatal("Protocol violation! ExecRequest Trade with empty Cmd ! ", request)
 			}
 
+			request.Progress = func(progress *proto.ExecResponse) {
+
+				if proto.IsFinal(progress.Status) {
+					ctxLog.Fatal("Protocol violation! Progress with final status ", progress)
+				}
+
+				lockOut.Lock()
+				out <- progress
+				lockOut.Unlock()
+			}
+
 			var response = &proto.ExecResponse{Request: request, Status: proto.StatusError}
 
-			if request.What == proto.ExecuteCmd {
+			if request.IsFenced() {
+				ctxLog.Warn("Lease of the connector is lost, request is dropped ", request.Cmd.Id)
+				response.Description = "Dropped on lost lease"
+				response.Dropped = true
+			} else if request.What == proto.ExecuteCmd {
 				response = tradeInternal(request, response)
 			} else if request.What == proto.CheckCmd {
 				response = check(request, response)
@@ -93,6 +108,9 @@ func RunConnector(ctxLog *log.Entry, in <-chan *proto.ExecRequest, out chan<- *p
 
 			ctxLog.Trace("Sent cmd to Binance !")
 
+			// the order is still alive at exchange, the request is over and the order is checked later
+			response.Parked = !proto.IsFinal(response.Status)
+
 			lockOut.Lock()
 			out <- response
 			lockOut.Unlock()
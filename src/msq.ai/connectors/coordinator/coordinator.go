@@ -1,6 +1,7 @@
 package coordinator
 
 import (
+	"expvar"
 	"fmt"
 	"github.com/go-errors/errors"
 	log "github.com/sirupsen/logrus"
@@ -10,14 +11,32 @@ import (
 	"msq.ai/db/postgres/dao"
 	dic "msq.ai/db/postgres/dictionaries"
 	pgh "msq.ai/db/postgres/helper"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const limit = 10
 
+// pollTime is the fallback for lost notifications about new commands
+const pollTime = 1 * time.Second
+
+// recoveryReportTime is how often progress of recovery is logged
+const recoveryReportTime = 5 * time.Second
+
+// progress of recovery of every exchange is published with expvar at /debug/vars
+var recoveryMetrics = expvar.NewMap("recovery")
+
+// RunCoordinator claims commands of the exchange for the connector. reservedPoolSize slots of the pool are taken only by
+// commands of reservedPriority or higher, so a flood of low priority commands can't delay urgent ones. Commands left in
+// execution by the previous run of the connector are checked in parallel with claiming of new commands, new commands of
+// an account wait till all its commands are recovered. The connector renews its lease by heartbeats and recovers commands
+// taken over from connectors of the exchange which leases are expired, it doesn't claim while its own lease isn't renewed.
+// Alive order is followed by its execution slot no longer than followTime, then the order is parked and the slot is free.
+// Parked orders are checked every parkedPollTime without following.
 func RunCoordinator(dburl string, dictionaries *dic.Dictionaries, out chan<- *proto.ExecRequest, in <-chan *proto.ExecResponse,
-	exchangeId int16, connectorId int16, connectorExecPoolSize uint32) {
+	exchangeId int16, connectorId int16, connectorExecPoolSize uint32, reservedPoolSize uint32, reservedPriority int16,
+	lease time.Duration, followTime time.Duration, parkedPollTime time.Duration, pauseTime func() time.Duration) {
 
 	ctxLog := log.WithFields(log.Fields{"id": "Coordinator"})
 
@@ -31,23 +50,209 @@ func RunCoordinator(dburl string, dictionaries *dic.Dictionaries, out chan<- *pr
 		ctxLog.Fatal("ExecResponse channel is nil !")
 	}
 
+	if lease <= 0 {
+		ctxLog.Fatal("Lease isn't positive !")
+	}
+
+	if followTime <= 0 || parkedPollTime <= 0 {
+		ctxLog.Fatal("Follow time or parked poll time isn't positive !")
+	}
+
 	logErrWithST := func(msg string, err error) {
 		ctxLog.WithField("stacktrace", fmt.Sprintf("%+v", err.(*errors.Error).ErrorStack())).Error(msg)
 	}
 
 	var sending uint32 = 0
 
-	makeExecRequest := func(command *cmd.Command, dic *dic.Dictionaries, eType proto.ExecType) *proto.ExecRequest {
+	// wake interrupts waiting of the claiming loop when new command is inserted or execution slot is freed
+	wake := make(chan struct{}, 1)
+
+	signal := func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+
+	// leaseTill is when the lease ends by local clock, it is counted from the time before renewal so it ends earlier than
+	// in DB
+	var leaseTill int64
+
+	// leaseExpired fences requests in the connector too, they are dropped or their orders are parked at once
+	leaseExpired := func() bool {
+		return time.Now().UnixNano() > atomic.LoadInt64(&leaseTill)
+	}
+
+	// inFlight are commands sent to the connector which execution slot isn't free yet
+	var inFlightLock sync.Mutex
+	inFlight := make(map[int64]bool)
+
+	inFlightIds := func() []int64 {
+
+		inFlightLock.Lock()
+		defer inFlightLock.Unlock()
+
+		result := make([]int64, 0, len(inFlight))
+
+		for id := range inFlight {
+			result = append(result, id)
+		}
+
+		return result
+	}
+
+	// recovering are commands sent for check with their accounts, held are accounts with number of commands to recover
+	var recoveryLock sync.Mutex
+	recovering := make(map[int64]int64)
+	held := make(map[int64]int)
+	recoverySent := false
+	recoveryFinished := false
+
+	recoveryTotal := new(expvar.Int)
+	recoveryDone := new(expvar.Int)
+	takenOver := new(expvar.Int)
+
+	exchangeName := dictionaries.Exchanges().GetNameById(exchangeId)
+
+	recoveryMetrics.Set(exchangeName+"Total", recoveryTotal)
+	recoveryMetrics.Set(exchangeName+"Done", recoveryDone)
+	recoveryMetrics.Set(exchangeName+"TakenOver", takenOver)
+
+	// finishRecovery is called under recoveryLock, recovery of commands taken over later doesn't finish it again
+	finishRecovery := func() {
+
+		if !recoverySent || len(recovering) > 0 || recoveryFinished {
+			return
+		}
+
+		recoveryFinished = true
+
+		ctxLog.Info("Recovery procedure finished, ", recoveryDone.Value(), " of ", recoveryTotal.Value(), " commands are recovered")
+	}
+
+	heldAccounts := func() []int64 {
+
+		recoveryLock.Lock()
+		defer recoveryLock.Unlock()
+
+		result := make([]int64, 0, len(held))
+
+		for accountId := range held {
+			result = append(result, accountId)
+		}
+
+		return result
+	}
+
+	recovered := func(response *proto.ExecResponse) {
+
+		recoveryLock.Lock()
+		defer recoveryLock.Unlock()
+
+		accountId, ok := recovering[response.Request.Cmd.Id]
+
+		if !ok {
+			return
+		}
+
+		delete(recovering, response.Request.Cmd.Id)
+
+		recoveryDone.Add(1)
+
+		held[accountId]--
+
+		if held[accountId] <= 0 {
+			delete(held, accountId)
+		}
+
+		finishRecovery()
+	}
+
+	makeExecRequest := func(command *cmd.Command, dic *dic.Dictionaries, eType proto.ExecType,
+		followTill time.Time) *proto.ExecRequest {
 
 		raw := cmd.ToRaw(command, dictionaries)
 
 		var et = eType
 
-		if raw.OrderType == constants.OrderTypeInfoName {
+		if constants.IsRequestOrderType(raw.OrderType) {
 			et = proto.InfoCmd
 		}
 
-		return &proto.ExecRequest{What: et, RawCmd: raw, Cmd: command}
+		return &proto.ExecRequest{What: et, RawCmd: raw, Cmd: command, FollowTill: followTill, Fenced: leaseExpired}
+	}
+
+	// send passes the command to the connector, execution slot must be taken before
+	send := func(command *cmd.Command, eType proto.ExecType, followTill time.Time) {
+
+		inFlightLock.Lock()
+		inFlight[command.Id] = true
+		inFlightLock.Unlock()
+
+		out <- makeExecRequest(command, dictionaries, eType, followTill)
+	}
+
+	// reserve takes up to n execution slots at once keeping sending within bound and returns number of taken slots.
+	// Claiming, recovery and polling of parked orders compete for the pool, so slots are taken before commands are
+	// queried, no more commands than taken slots are queried and unused slots are given back.
+	reserve := func(n uint32, bound uint32) uint32 {
+
+		for {
+
+			s := atomic.LoadUint32(&sending)
+
+			if s >= bound {
+				return 0
+			}
+
+			taken := n
+
+			if s+taken > bound {
+				taken = bound - s
+			}
+
+			if atomic.CompareAndSwapUint32(&sending, s, s+taken) {
+				return taken
+			}
+		}
+	}
+
+	// waitReserve takes up to n execution slots within bound as soon as some of them are free
+	waitReserve := func(n uint32, bound uint32) uint32 {
+
+		for {
+
+			if taken := reserve(n, bound); taken > 0 {
+				return taken
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// unreserve gives back slots which were reserved but not used
+	unreserve := func(n uint32) {
+
+		if n > 0 {
+			atomic.AddUint32(&sending, ^(n - 1))
+		}
+	}
+
+	// recoverCommand sends the command for check, hold is true when the account of the command isn't counted in held yet,
+	// execution slot must be reserved before
+	recoverCommand := func(command *cmd.Command, hold bool) {
+
+		recoveryLock.Lock()
+
+		recovering[command.Id] = command.AccountId
+
+		if hold {
+			held[command.AccountId]++
+		}
+
+		recoveryLock.Unlock()
+
+		send(command, proto.CheckCmd, time.Now().Add(followTime))
 	}
 
 	//------------------------------------------------------------------------------------------------------------------
@@ -66,7 +271,20 @@ func RunCoordinator(dburl string, dictionaries *dic.Dictionaries, out chan<- *pr
 		for {
 			response := <-in
 
-			atomic.AddUint32(&sending, ^uint32(0))
+			if !proto.IsFinal(response.Status) && !response.Parked {
+				ctxLog.Trace("Execution in progress", response)
+				continue
+			}
+
+			inFlightLock.Lock()
+			delete(inFlight, response.Request.Cmd.Id)
+			inFlightLock.Unlock()
+
+			unreserve(1)
+
+			recovered(response)
+
+			signal()
 
 			ctxLog.Trace("Finished execution", response)
 
@@ -86,14 +304,54 @@ func RunCoordinator(dburl string, dictionaries *dic.Dictionaries, out chan<- *pr
 			ctxLog.Fatal("Cannot connect to DB with URL ["+dburl+"] ", err)
 		}
 
-		db.SetMaxIdleConns(1)
-		db.SetMaxOpenConns(1)
+		// claiming, recovery and polling of parked orders
+		db.SetMaxIdleConns(3)
+		db.SetMaxOpenConns(3)
 
-		dbTryGetCommandsForRecovery := func() *[]*cmd.Command {
+		channel := dao.CommandsChannel(exchangeId)
 
-			statusExecutingId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusExecutingName)
+		listener, err := pgh.GetListenerByUrl(dburl, channel, func(err error) {
+			ctxLog.Error("Listener of channel ["+channel+"] error ", err)
+		})
+
+		if err != nil {
+			ctxLog.Fatal("Cannot listen channel ["+channel+"] ", err)
+		}
+
+		go func() {
+			// nil notification comes after reconnect, the notifications sent meanwhile are lost, so it wakes too
+			for range listener.Notify {
+				signal()
+			}
+		}()
+
+		recoveryStatusIds := []int16{
+			dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusExecutingName),
+			dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusOpenName),
+			dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusPartiallyFilledName),
+		}
+
+		// commands taken for recovery get later timestamp, so every command is taken once while new ones are claimed
+		recoveryBaseLine := time.Now()
+
+		dbCountCommandsForRecovery := func() map[int64]int {
+
+			for {
+
+				result, err := dao.CountCommandsForRecovery(db, exchangeId, connectorId, recoveryStatusIds, recoveryBaseLine)
+
+				if err == nil {
+					return result
+				}
+
+				logErrWithST("CountCommandsForRecovery error ! ", err)
+				time.Sleep(constants.DbErrorSleepTime)
+			}
+		}
+
+		dbTryGetCommandsForRecovery := func(n uint32) *[]*cmd.Command {
 
-			result, err := dao.TryGetCommandsForRecovery(db, exchangeId, connectorId, statusExecutingId, time.Now(), limit)
+			result, err := dao.TryGetCommandsForRecovery(db, exchangeId, connectorId, recoveryStatusIds, recoveryBaseLine, int16(n))
 
 			if err != nil {
 				logErrWithST("TryGetCommandsForRecovery error ! ", err)
@@ -104,12 +362,13 @@ func RunCoordinator(dburl string, dictionaries *dic.Dictionaries, out chan<- *pr
 			return result
 		}
 
-		dbTryGetCommandsForExecution := func() *[]*cmd.Command {
+		dbTryGetCommandsForExecution := func(n uint32, minPriority int16) *[]*cmd.Command {
 
 			statusCreatedId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusCreatedName)
 			statusExecutingId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusExecutingName)
 
-			result, err := dao.TryGetCommandsForExecution(db, exchangeId, connectorId, time.Now().Add(future), statusCreatedId, statusExecutingId, limit)
+			result, err := dao.TryGetCommandsForExecution(db, exchangeId, connectorId, time.Now().Add(future), statusCreatedId, statusExecutingId, int16(n),
+				minPriority, heldAccounts())
 
 			if err != nil {
 				logErrWithST("dbTryGetCommandsForExecution error ! ", err)
@@ -120,74 +379,296 @@ func RunCoordinator(dburl string, dictionaries *dic.Dictionaries, out chan<- *pr
 			return result
 		}
 
-		ctxLog.Info("Start recovery procedure")
+		//--------------------------------------------------------------------------------------------------------------
 
-		for {
+		leaseDb, err := pgh.GetDbByUrl(dburl)
+
+		if err != nil {
+			ctxLog.Fatal("Cannot connect to DB with URL ["+dburl+"] ", err)
+		}
+
+		leaseDb.SetMaxIdleConns(1)
+		leaseDb.SetMaxOpenConns(1)
+
+		renewLease := func() bool {
+
+			start := time.Now()
 
-			forRecovery := dbTryGetCommandsForRecovery()
+			err := dao.RenewConnectorLease(leaseDb, exchangeId, connectorId, lease)
 
-			if forRecovery == nil || len(*forRecovery) == 0 {
-				break
+			if err != nil {
+				logErrWithST("RenewConnectorLease error ! ", err)
+				return false
 			}
 
-			ctxLog.Trace("Has command for recovery ", forRecovery)
+			atomic.StoreInt64(&leaseTill, start.Add(lease).UnixNano())
 
-			for _, command := range *forRecovery {
+			return true
+		}
 
-				for {
+		// the lease is taken before recovery, so nobody takes over commands of the connector while it starts
+		for !renewLease() {
+			time.Sleep(constants.DbErrorSleepTime)
+		}
+
+		go func() {
+
+			ticker := time.NewTicker(lease / 3)
+
+			defer ticker.Stop()
+
+			for range ticker.C {
+				renewLease()
+			}
+		}()
+
+		//--------------------------------------------------------------------------------------------------------------
+
+		// accounts are held before the first claim, so no new command of them goes before the recovered ones
+		counts := dbCountCommandsForRecovery()
+
+		total := 0
+
+		recoveryLock.Lock()
+
+		for accountId, count := range counts {
+			held[accountId] = count
+			total += count
+		}
+
+		recoveryLock.Unlock()
+
+		recoveryTotal.Set(int64(total))
+
+		ctxLog.Info("Start recovery procedure of ", total, " commands of ", len(counts), " accounts")
+
+		go func() {
+
+			for {
+
+				taken := waitReserve(limit, connectorExecPoolSize)
+
+				forRecovery := dbTryGetCommandsForRecovery(taken)
+
+				if forRecovery == nil || len(*forRecovery) == 0 {
+					unreserve(taken)
+					break
+				}
+
+				unreserve(taken - uint32(len(*forRecovery)))
+
+				ctxLog.Trace("Has command for recovery ", forRecovery)
+
+				for _, command := range *forRecovery {
+					recoverCommand(command, false)
+				}
+			}
+
+			// accounts which commands are finished meanwhile by somebody else aren't held any more
+			recoveryLock.Lock()
+
+			for accountId := range held {
+				delete(held, accountId)
+			}
+
+			for _, accountId := range recovering {
+				held[accountId]++
+			}
+
+			recoverySent = true
+
+			finishRecovery()
+
+			recoveryLock.Unlock()
+
+			signal()
+		}()
+
+		go func() {
+
+			ticker := time.NewTicker(recoveryReportTime)
+
+			defer ticker.Stop()
+
+			for range ticker.C {
+
+				recoveryLock.Lock()
+				finished := recoveryFinished
+				checking := len(recovering)
+				accounts := len(held)
+				recoveryLock.Unlock()
+
+				if finished {
+					return
+				}
+
+				ctxLog.Info("Recovery progress: ", recoveryDone.Value(), " of ", total, " commands are recovered, ", checking,
+					" are in check, ", accounts, " accounts are held")
+			}
+		}()
+
+		dbTakeOverCommands := func(n uint32) *[]*cmd.Command {
+
+			result, err := dao.TakeOverCommands(db, exchangeId, connectorId, recoveryStatusIds, int16(n))
 
-					s := atomic.LoadUint32(&sending)
+			if err != nil {
+				logErrWithST("TakeOverCommands error ! ", err)
+				return nil
+			}
+
+			return result
+		}
+
+		go func() {
+
+			ticker := time.NewTicker(lease / 3)
 
-					if s <= connectorExecPoolSize {
+			defer ticker.Stop()
 
-						atomic.AddUint32(&sending, 1)
+			for range ticker.C {
 
-						out <- makeExecRequest(command, dictionaries, proto.CheckCmd)
+				for !leaseExpired() {
 
-						for atomic.LoadUint32(&sending) != 0 {
-							time.Sleep(100 * time.Millisecond)
-						}
+					taken := waitReserve(limit, connectorExecPoolSize)
 
+					orphaned := dbTakeOverCommands(taken)
+
+					if orphaned == nil || len(*orphaned) == 0 {
+						unreserve(taken)
 						break
 					}
 
-					time.Sleep(100 * time.Millisecond)
+					unreserve(taken - uint32(len(*orphaned)))
+
+					ctxLog.Warn("Took over ", len(*orphaned), " commands of connectors which leases are expired")
+
+					takenOver.Add(int64(len(*orphaned)))
+					recoveryTotal.Add(int64(len(*orphaned)))
+
+					for _, command := range *orphaned {
+						recoverCommand(command, true)
+					}
 				}
+			}
+		}()
 
+		// executing commands which aren't in flight were dropped when the lease was lost, they are checked as parked ones
+		parkedStatusIds := []int16{
+			dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusExecutingName),
+			dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusOpenName),
+			dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusPartiallyFilledName),
+		}
+
+		dbTryGetParkedCommands := func(n uint32) *[]*cmd.Command {
+
+			result, err := dao.TryGetParkedCommands(db, exchangeId, connectorId, parkedStatusIds,
+				time.Now().Add(-parkedPollTime), inFlightIds(), int16(n))
+
+			if err != nil {
+				logErrWithST("TryGetParkedCommands error ! ", err)
+				return nil
 			}
+
+			return result
 		}
 
-		ctxLog.Info("Recovery procedure finished")
+		// the only poller of parked orders, every check takes a slot for one status request and never takes reserved
+		// slots, so resting orders don't hold the pool
+		go func() {
+
+			ticker := time.NewTicker(parkedPollTime)
+
+			defer ticker.Stop()
+
+			for range ticker.C {
+
+				recoveryLock.Lock()
+				sent := recoverySent
+				recoveryLock.Unlock()
+
+				if !sent || leaseExpired() {
+					continue
+				}
+
+				for {
+
+					taken := waitReserve(limit, connectorExecPoolSize-reservedPoolSize)
+
+					parked := dbTryGetParkedCommands(taken)
+
+					if parked == nil || len(*parked) == 0 {
+						unreserve(taken)
+						break
+					}
+
+					unreserve(taken - uint32(len(*parked)))
+
+					for _, command := range *parked {
+						send(command, proto.CheckCmd, time.Now())
+					}
+				}
+			}
+		}()
+
+		//--------------------------------------------------------------------------------------------------------------
 
 		var commands *[]*cmd.Command
 		var raw *cmd.RawCommand
 
 		for {
 
-			s := atomic.LoadUint32(&sending)
+			if pauseTime != nil {
 
-			if s+limit <= connectorExecPoolSize {
+				if pause := pauseTime(); pause > 0 {
+					ctxLog.Warn("Claiming of new commands is paused for ", pause)
+					time.Sleep(pause)
+					continue
+				}
+			}
+
+			if leaseExpired() {
+				ctxLog.Warn("Lease of the connector isn't renewed, claiming of new commands is paused")
+				time.Sleep(pollTime)
+				continue
+			}
+
+			// slots of the claim are reserved before the query, reserved part of the pool is taken only by urgent commands
+			var minPriority int16 = 0
+
+			taken := reserve(limit, connectorExecPoolSize-reservedPoolSize)
 
-				commands = dbTryGetCommandsForExecution()
+			if taken == 0 {
+				minPriority = reservedPriority
+				taken = reserve(limit, connectorExecPoolSize)
+			}
+
+			if taken > 0 {
+
+				commands = dbTryGetCommandsForExecution(taken, minPriority)
 
 				if commands != nil && len(*commands) > 0 {
 
+					unreserve(taken - uint32(len(*commands)))
+
 					for _, command := range *commands {
 
 						raw = cmd.ToRaw(command, dictionaries)
 
 						ctxLog.Trace("New command for execution", raw)
 
-						atomic.AddUint32(&sending, 1)
-
-						out <- makeExecRequest(command, dictionaries, proto.ExecuteCmd)
+						send(command, proto.ExecuteCmd, time.Now().Add(followTime))
 					}
 
 					continue
 				}
+
+				unreserve(taken)
 			}
 
-			time.Sleep(100 * time.Millisecond)
+			select {
+			case <-wake:
+			case <-time.After(pollTime):
+			}
 		}
 
 	}()
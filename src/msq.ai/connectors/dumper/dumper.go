@@ -3,6 +3,7 @@ package dumper
 import (
 	"fmt"
 	"github.com/go-errors/errors"
+	"github.com/shopspring/decimal"
 	log "github.com/sirupsen/logrus"
 	"msq.ai/connectors/proto"
 	"msq.ai/constants"
@@ -10,10 +11,16 @@ import (
 	dic "msq.ai/db/postgres/dictionaries"
 	pgh "msq.ai/db/postgres/helper"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
+// commandState is status and executed quantity of command in DB as the dumper expects them, request's Cmd keeps what
+// the command was claimed with and isn't changed
+type commandState struct {
+	statusId int16
+	executed decimal.Decimal
+}
+
 func RunDumper(dburl string, dictionaries *dic.Dictionaries, in <-chan *proto.ExecResponse, out chan<- *proto.ExecResponse, execPoolSize int) {
 
 	ctxLog := log.WithFields(log.Fields{"id": "Dumper"})
@@ -60,32 +67,67 @@ func RunDumper(dburl string, dictionaries *dic.Dictionaries, in <-chan *proto.Ex
 
 	var lockOut = &sync.Mutex{}
 
-	statusExecutingId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusExecutingName)
 	statusErrorId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusErrorName)
 	statusCompletedId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusCompletedName)
 	statusTimedOutId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusTimedOutName)
 	statusRejectedId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusRejectedName)
+	statusOpenId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusOpenName)
+	statusPartiallyFilledId := dictionaries.ExecutionStatuses().GetIdByName(constants.ExecutionStatusPartiallyFilledName)
 
-	dumpResponse := func(response *proto.ExecResponse) error {
+	dumpResponse := func(response *proto.ExecResponse, state *commandState) error {
 
 		ctxLog.Trace("Dumping response", response)
 
+		if response.Dropped {
+			return nil
+		}
+
+		if response.Status == proto.StatusOpen || response.Status == proto.StatusPartiallyFilled {
+
+			var newStatusId = statusOpenId
+
+			if response.Status == proto.StatusPartiallyFilled {
+				newStatusId = statusPartiallyFilledId
+			}
+
+			// parked orders are checked from time to time, unchanged progress isn't written again, only check time is
+			if newStatusId == state.statusId && response.ExecutedQuantity.Equal(state.executed) {
+
+				if response.Parked {
+					return dao.TouchCommand(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId),
+						state.statusId)
+				}
+
+				return nil
+			}
+
+			err := dao.UpdateExecutionProgress(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId),
+				state.statusId, newStatusId, response.ExecutedQuantity, response.Description)
+
+			if err == nil {
+				state.statusId = newStatusId
+				state.executed = response.ExecutedQuantity
+			}
+
+			return err
+		}
+
 		if response.Status == proto.StatusRejected {
 
-			return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), statusExecutingId,
-				statusRejectedId, response.Description, nil, &response.Balances)
+			return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), state.statusId,
+				statusRejectedId, response.Description, nil, &response.Balances, &response.Positions)
 		}
 
 		if response.Status == proto.StatusTimedOut {
 
-			return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), statusExecutingId,
-				statusTimedOutId, response.Description, nil, &response.Balances)
+			return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), state.statusId,
+				statusTimedOutId, response.Description, nil, &response.Balances, &response.Positions)
 		}
 
 		if response.Status == proto.StatusError {
 
-			return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), statusExecutingId,
-				statusErrorId, response.Description, nil, &response.Balances)
+			return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), state.statusId,
+				statusErrorId, response.Description, nil, &response.Balances, &response.Positions)
 		}
 
 		if response.Status == proto.StatusOk {
@@ -95,15 +137,15 @@ func RunDumper(dburl string, dictionaries *dic.Dictionaries, in <-chan *proto.Ex
 				ctxLog.Trace("Dumping response", response)
 				ctxLog.Trace("Dumping order", response.Order)
 
-				return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), statusExecutingId,
-					statusCompletedId, response.Description, response.Order, &response.Balances)
+				return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), state.statusId,
+					statusCompletedId, response.Description, response.Order, &response.Balances, &response.Positions)
 
 			} else if response.Request.What == proto.InfoCmd {
 
 				ctxLog.Trace("Dumping Info response", response)
 
-				return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), statusExecutingId,
-					statusCompletedId, response.Description, nil, &response.Balances)
+				return dao.FinishExecution(db, response.Request.Cmd.Id, int16(response.Request.Cmd.ConnectorId), state.statusId,
+					statusCompletedId, response.Description, nil, &response.Balances, &response.Positions)
 			}
 
 			ctxLog.Fatal("Protocol violation! Illegal request What !!!!", response, response.Request)
@@ -118,6 +160,9 @@ func RunDumper(dburl string, dictionaries *dic.Dictionaries, in <-chan *proto.Ex
 		var err error
 		var response *proto.ExecResponse
 
+		// all responses of a command come to the same performer, so states of its requests in flight are kept here
+		states := make(map[*proto.ExecRequest]*commandState)
+
 		ticker := time.NewTicker(time.Second * 300)
 
 		for {
@@ -137,17 +182,37 @@ func RunDumper(dburl string, dictionaries *dic.Dictionaries, in <-chan *proto.Ex
 			}
 
 			if response != nil {
+
+				state := states[response.Request]
+
+				if state == nil {
+					state = &commandState{statusId: response.Request.Cmd.StatusId,
+						executed: response.Request.Cmd.ExecutedQuantity}
+					states[response.Request] = state
+				}
+
 				for {
-					err = dumpResponse(response)
+					err = dumpResponse(response, state)
 
 					if err == nil {
 						break
 					}
 
+					// the command is taken over by another connector, it writes the command from now on
+					if err == dao.ErrCommandNotOwned {
+						ctxLog.Warn("Response isn't saved, command isn't owned any more ", response.Request.Cmd.Id, " ",
+							response.Description)
+						break
+					}
+
 					logErrWithST("Cannot save response error", err)
 
 					time.Sleep(5 * time.Second)
 				}
+
+				if proto.IsFinal(response.Status) || response.Parked || response.Dropped {
+					delete(states, response.Request)
+				}
 			}
 
 			lockOut.Lock()
@@ -167,21 +232,9 @@ func RunDumper(dburl string, dictionaries *dic.Dictionaries, in <-chan *proto.Ex
 
 	//------------------------------------------------------------------------------------------------------------------
 
-	var pointer int32 = 0
-
-	getNextChannel := func() chan<- *proto.ExecResponse {
-
-		p := int(atomic.LoadInt32(&pointer))
-
-		c := inChannels[p]
-
-		if p+1 == execPoolSize {
-			atomic.StoreInt32(&pointer, 0)
-		} else {
-			atomic.AddInt32(&pointer, 1)
-		}
-
-		return c
+	// all responses of one command go through the same channel, so progress and final status are dumped in order
+	getChannel := func(response *proto.ExecResponse) chan<- *proto.ExecResponse {
+		return inChannels[response.Request.Cmd.Id%int64(execPoolSize)]
 	}
 
 	go func() {
@@ -191,10 +244,10 @@ func RunDumper(dburl string, dictionaries *dic.Dictionaries, in <-chan *proto.Ex
 			response := <-in
 
 			if response == nil {
-				ctxLog.Error("Protocol violation! ExecResponse is nil")
+				ctxLog.Fatal("Protocol violation! ExecResponse is nil")
 			}
 
-			getNextChannel() <- response
+			getChannel(response) <- response
 		}
 	}()
 
@@ -0,0 +1,260 @@
+package launcher
+
+import (
+	prop "github.com/magiconair/properties"
+	log "github.com/sirupsen/logrus"
+	"math"
+	cord "msq.ai/connectors/coordinator"
+	"msq.ai/connectors/dumper"
+	"msq.ai/connectors/proto"
+	"msq.ai/connectors/registry"
+	"msq.ai/constants"
+	"msq.ai/db/postgres/dao"
+	pgh "msq.ai/db/postgres/helper"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const propertiesExchangesName = "connector.exchanges"
+const propertiesExecPoolSizeName = "exec.pool.size"
+const propertiesDumperPoolSizeName = "dumper.pool.size"
+const propertiesExecPoolReservedName = "exec.pool.reserved"
+const propertiesExecReservedPriorityName = "exec.reserved.priority"
+const propertiesConnectorLeaseSecondsName = "connector.lease.seconds"
+const propertiesFollowSecondsName = "follow.seconds"
+const propertiesParkedPollSecondsName = "parked.poll.seconds"
+const propertiesMetricsListenName = "metrics.listen"
+const dumperExecPoolSize = 10
+
+// priority of CLOSE commands by default
+const reservedPriority = 10
+
+// commands of the connector which doesn't renew its lease so long are taken over by another connector of the exchange
+const connectorLeaseSeconds = 30
+
+// properties which are shared by all exchanges of the connector, exchange may override them with its prefix
+var globalKeys = map[string]bool{
+	constants.PostgresUrlPropertyName:   true,
+	constants.ConnectorIdPropertyName:   true,
+	propertiesExecPoolSizeName:          true,
+	propertiesDumperPoolSizeName:        true,
+	propertiesExecPoolReservedName:      true,
+	propertiesExecReservedPriorityName:  true,
+	propertiesConnectorLeaseSecondsName: true,
+	propertiesFollowSecondsName:         true,
+	propertiesParkedPollSecondsName:     true,
+}
+
+// alive order holds execution slot so long, then it is parked and checked every parkedPollSeconds
+const followSeconds = 60
+const parkedPollSeconds = 10
+
+// settings of exchange are looked up with prefix of lower case exchange name, e.g. "binance.api.url", global keys are
+// looked up without prefix if the exchange doesn't override them. Connector of one exchange has empty prefix, so its
+// properties need no prefixes.
+type settings struct {
+	properties *prop.Properties
+	prefix     string
+	ctxLog     *log.Entry
+}
+
+func (s *settings) key(key string) string {
+
+	resolved := s.prefix + key
+
+	if _, ok := s.properties.Get(resolved); !ok && globalKeys[key] {
+		resolved = key
+	}
+
+	value, ok := s.properties.Get(resolved)
+
+	if !ok {
+		value = "<default>"
+	} else if strings.Contains(key, "password") || strings.Contains(key, "secret") {
+		value = "***"
+	}
+
+	s.ctxLog.Info("Property " + key + " is resolved to " + resolved + " [" + value + "]")
+
+	return resolved
+}
+
+func (s *settings) MustGet(key string) string {
+	return s.properties.MustGet(s.key(key))
+}
+
+func (s *settings) GetString(key string, def string) string {
+	return s.properties.GetString(s.key(key), def)
+}
+
+func (s *settings) GetInt(key string, def int) int {
+	return s.properties.GetInt(s.key(key), def)
+}
+
+func (s *settings) GetBool(key string, def bool) bool {
+	return s.properties.GetBool(s.key(key), def)
+}
+
+func (s *settings) GetFloat64(key string, def float64) float64 {
+	return s.properties.GetFloat64(s.key(key), def)
+}
+
+// Run starts connectors of exchanges which are listed by connector.exchanges or the one named by exchange.name. Every
+// exchange gets its own connector, dumper and coordinator, exchange packages must be imported by the binary to be
+// registered.
+func Run(name string, propertiesFileName string) {
+
+	ctxLog := log.WithFields(log.Fields{"id": name})
+
+	ctxLog.Info(name + " is going to start")
+
+	pwd, err := os.Getwd()
+
+	if err != nil {
+		ctxLog.Fatal("Getwd error", err)
+	}
+
+	ctxLog.Trace("Current folder is [" + pwd + "]")
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	properties := prop.MustLoadFile(propertiesFileName, prop.UTF8)
+
+	for k, v := range properties.Map() {
+		ctxLog.Debug("key[" + k + "] value[" + v + "]")
+	}
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	url := properties.MustGet(constants.PostgresUrlPropertyName)
+
+	db, err := pgh.GetDbByUrl(url)
+
+	if err != nil {
+		ctxLog.Fatal("Cannot connect to DB with URL ["+url+"] ", err)
+	}
+
+	//-------------------------------------- load dictionaries from DB -------------------------------------------------
+
+	dictionaries, err := dao.LoadDictionaries(db)
+
+	if err != nil {
+		ctxLog.Fatal("Cannot load Dictionaries from DB with URL ["+url+"] ", err)
+	}
+
+	pgh.CloseDb(db)
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	names := make([]string, 0)
+
+	for _, exchangeName := range strings.Split(properties.GetString(propertiesExchangesName, ""), ",") {
+		if exchangeName = strings.TrimSpace(exchangeName); len(exchangeName) > 0 {
+			names = append(names, exchangeName)
+		}
+	}
+
+	// connector of several exchanges needs prefixes to tell their properties apart
+	prefixed := len(names) > 0
+
+	if !prefixed {
+		names = append(names, properties.MustGet(constants.ExchangeNamePropertyName))
+	}
+
+	for _, exchangeName := range names {
+
+		exchange, ok := registry.Get(exchangeName)
+
+		if !ok {
+			ctxLog.Fatal("Exchange "+exchangeName+" isn't registered, registered are ", registry.Names())
+		}
+
+		exchangeId := dictionaries.Exchanges().GetIdByName(exchangeName)
+
+		if exchangeId < 1 {
+			ctxLog.Fatal("Illegal Exchange name ! ", exchangeName)
+		}
+
+		prefix := ""
+
+		if prefixed {
+			prefix = strings.ToLower(exchangeName) + "."
+		}
+
+		s := &settings{properties: properties, prefix: prefix, ctxLog: ctxLog.WithFields(log.Fields{"exchange": exchangeName})}
+
+		connectorId := int16(s.GetInt(constants.ConnectorIdPropertyName, 0))
+
+		if connectorId < 1 {
+			ctxLog.Fatal("Illegal connectorId ! ", connectorId)
+		}
+
+		execPoolSize := s.GetInt(propertiesExecPoolSizeName, exchange.ExecPoolSize)
+
+		// by default tenth of the pool is kept for closing of positions
+		reservedPoolSize := s.GetInt(propertiesExecPoolReservedName, execPoolSize/10)
+
+		if reservedPoolSize < 0 || reservedPoolSize >= execPoolSize {
+			ctxLog.Fatal("Reserved pool size of ", exchangeName, " must be less than pool size ", execPoolSize)
+		}
+
+		priority := s.GetInt(propertiesExecReservedPriorityName, reservedPriority)
+
+		if priority < 0 || priority > math.MaxInt16 {
+			ctxLog.Fatal("Wrong reserved priority of ", exchangeName, " [", priority, "]")
+		}
+
+		leaseSeconds := s.GetInt(propertiesConnectorLeaseSecondsName, connectorLeaseSeconds)
+
+		if leaseSeconds < 1 {
+			ctxLog.Fatal("Lease of connector of ", exchangeName, " must be at least a second")
+		}
+
+		followTime := time.Duration(s.GetInt(propertiesFollowSecondsName, followSeconds)) * time.Second
+		parkedPollTime := time.Duration(s.GetInt(propertiesParkedPollSecondsName, parkedPollSeconds)) * time.Second
+
+		if followTime <= 0 || parkedPollTime <= 0 {
+			ctxLog.Fatal("Follow time and parked poll time of ", exchangeName, " must be at least a second")
+		}
+
+		ctxLog.Info("Exchange ", exchangeName, " is starting with connector id ", connectorId, " and pool size ", execPoolSize,
+			" of which ", reservedPoolSize, " are reserved for priority ", priority)
+
+		//---------------------------------- start exchange connector --------------------------------------------------
+
+		requests := make(chan *proto.ExecRequest)
+		responses := make(chan *proto.ExecResponse)
+		dump := make(chan *proto.ExecResponse)
+
+		pauseTime := exchange.Run(s, requests, responses, execPoolSize)
+
+		//----------------------------------------- start dumper -------------------------------------------------------
+
+		dumper.RunDumper(url, dictionaries, responses, dump, s.GetInt(propertiesDumperPoolSizeName, dumperExecPoolSize))
+
+		//----------------------------------------- start coordinator --------------------------------------------------
+
+		cord.RunCoordinator(url, dictionaries, requests, dump, exchangeId, connectorId, uint32(execPoolSize),
+			uint32(reservedPoolSize), int16(priority), time.Duration(leaseSeconds)*time.Second, followTime, parkedPollTime,
+			pauseTime)
+	}
+
+	//----------------------------------------- start metrics ---------------------------------------------------------
+
+	if metricsListen := properties.GetString(propertiesMetricsListenName, ""); len(metricsListen) > 0 {
+
+		go func() {
+			ctxLog.Error("Metrics server error ", http.ListenAndServe(metricsListen, nil))
+		}()
+
+		ctxLog.Info("Metrics are published at http://" + metricsListen + "/debug/vars")
+	}
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	for {
+		time.Sleep(60 * time.Second)
+	}
+}
@@ -1,6 +1,7 @@
 package proto
 
 import (
+	"github.com/shopspring/decimal"
 	"msq.ai/data/cmd"
 	"time"
 )
@@ -20,12 +21,37 @@ const (
 	StatusOk
 	StatusTimedOut
 	StatusRejected
+	StatusOpen
+	StatusPartiallyFilled
 )
 
+// IsFinal returns false for statuses of orders which are still alive at exchange
+func IsFinal(status Status) bool {
+	return status != StatusOpen && status != StatusPartiallyFilled
+}
+
 type ExecRequest struct {
 	What   ExecType
 	RawCmd *cmd.RawCommand
 	Cmd    *cmd.Command
+	// FollowTill is set by coordinator, connector doesn't wait for final status of alive order after it, so resting
+	// order doesn't hold execution slot. Such order is parked and checked from time to time by coordinator.
+	FollowTill time.Time
+	// Fenced is set by coordinator, it tells that the connector lost its lease and the command may be taken over by
+	// another connector, so the request must not go on
+	Fenced func() bool
+	// Progress is set by connector, it sends intermediate (not final) responses while order is alive at exchange
+	Progress func(response *ExecResponse)
+}
+
+// IsFenced tells whether the request must be dropped or its order parked at once
+func (r *ExecRequest) IsFenced() bool {
+	return r.Fenced != nil && r.Fenced()
+}
+
+// KeepFollowing tells whether connector may keep waiting for final status of alive order
+func (r *ExecRequest) KeepFollowing() bool {
+	return time.Now().Before(r.FollowTill) && !r.IsFenced()
 }
 
 type ExecResponse struct {
@@ -35,4 +61,25 @@ type ExecResponse struct {
 	Order            *cmd.Order
 	OutsideExecution time.Duration
 	Balances         []cmd.Balance
+	Positions        []cmd.Position
+	ExecutedQuantity decimal.Decimal
+	// Parked is set by connector on the last response of request which order is still alive at exchange
+	Parked bool
+	// Dropped is set by connector on the response of fenced request which wasn't executed, nothing is written by it
+	Dropped bool
+}
+
+// Park fills response of order which is alive at exchange when connector stops following it
+func Park(response *ExecResponse, executed decimal.Decimal, description string) *ExecResponse {
+
+	response.Status = StatusOpen
+
+	if executed.IsPositive() {
+		response.Status = StatusPartiallyFilled
+	}
+
+	response.ExecutedQuantity = executed
+	response.Description = "Order is parked alive " + description
+
+	return response
 }
@@ -0,0 +1,76 @@
+package registry
+
+import (
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/proto"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Settings are properties of one exchange, magiconair properties suit it
+type Settings interface {
+	MustGet(key string) string
+	GetString(key string, def string) string
+	GetInt(key string, def int) int
+	GetBool(key string, def bool) bool
+	GetFloat64(key string, def float64) float64
+}
+
+// Exchange is an implementation of exchange connector which can be started by name from properties. Run starts the
+// connector which takes requests from in and gives responses to out, it returns function which tells coordinator how long
+// to pause before taking new commands or nil when the exchange doesn't need pauses.
+type Exchange struct {
+	// Name is the name of exchange in DB
+	Name string
+	// ExecPoolSize is used when properties don't give pool size
+	ExecPoolSize int
+	Run          func(settings Settings, in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse,
+		execPoolSize int) func() time.Duration
+}
+
+var lock sync.Mutex
+var exchanges = make(map[string]Exchange)
+
+// Register is called from init of exchange package, so the package has to be imported by the binary which starts it
+func Register(exchange Exchange) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := exchanges[exchange.Name]; ok {
+		log.Fatal("Exchange " + exchange.Name + " is registered twice !")
+	}
+
+	if exchange.Run == nil || exchange.ExecPoolSize < 1 {
+		log.Fatal("Exchange " + exchange.Name + " is registered without Run function or pool size !")
+	}
+
+	exchanges[exchange.Name] = exchange
+}
+
+func Get(name string) (Exchange, bool) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	exchange, ok := exchanges[name]
+
+	return exchange, ok
+}
+
+func Names() []string {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	names := make([]string, 0, len(exchanges))
+
+	for name := range exchanges {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -9,21 +9,43 @@ const ConnectorIdPropertyName = "connector.id"
 
 const DbName = "postgres"
 
+// ExchangeSimulatorName is the paper trading exchange, commands of simulated accounts go there whatever exchange they name
+const ExchangeSimulatorName = "SIMULATOR"
+
+// DecimalScale is the scale of NUMERIC columns, all amounts, prices and balances are kept with it
+const DecimalScale = 18
+
 const OrderTypeLimitName = "LIMIT"
 const OrderTypeMarketName = "MARKET"
 const OrderTypeInfoName = "INFO"
+const OrderTypeLeverageName = "LEVERAGE"
+const OrderTypeMarginIsolatedName = "MARGIN_ISOLATED"
+const OrderTypeMarginCrossedName = "MARGIN_CROSSED"
+
+// IsRequestOrderType returns true for order types which don't create an order, they ask or set up an account
+func IsRequestOrderType(orderType string) bool {
+	return orderType == OrderTypeInfoName || orderType == OrderTypeLeverageName ||
+		orderType == OrderTypeMarginIsolatedName || orderType == OrderTypeMarginCrossedName
+}
 
 const OrderDirectionBuyName = "BUY"
 const OrderDirectionSellName = "SELL"
 
+const ExecutionTypeOpenName = "OPEN"
+const ExecutionTypeCloseName = "CLOSE"
+
 const TimeInForceFokName = "FOK"
 const TimeInForceGtcName = "GTC"
 
+const CommissionAssetMixedName = "MIXED"
+
 const ExecutionStatusCreatedName = "CREATED"
 const ExecutionStatusExecutingName = "EXECUTING"
 const ExecutionStatusErrorName = "ERROR"
 const ExecutionStatusCompletedName = "COMPLETED"
 const ExecutionStatusTimedOutName = "TIMED_OUT"
 const ExecutionStatusRejectedName = "REJECTED"
+const ExecutionStatusOpenName = "OPEN"
+const ExecutionStatusPartiallyFilledName = "PARTIALLY_FILLED"
 
 const DbErrorSleepTime = 10 * time.Second
@@ -2,108 +2,121 @@ package cmd
 
 import (
 	"database/sql"
+	"github.com/shopspring/decimal"
+	"msq.ai/constants"
 	dic "msq.ai/db/postgres/dictionaries"
 	"msq.ai/utils/math"
 	"time"
 )
 
 type Command struct {
-	Id              int64
-	ExchangeId      int16
-	InstrumentName  string
-	DirectionId     int16
-	OrderTypeId     int16
-	LimitPrice      float64
-	Amount          float64
-	StatusId        int16
-	ConnectorId     int64
-	ExecutionTypeId int16
-	ExecuteTillTime time.Time
-	RefPositionId   string
-	TimeInForceId   int16
-	UpdateTimestamp time.Time
-	AccountId       int64
-	ApiKey          string
-	SecretKey       string
-	FingerPrint     string
+	Id               int64
+	ExchangeId       int16
+	InstrumentName   string
+	DirectionId      int16
+	OrderTypeId      int16
+	LimitPrice       decimal.Decimal
+	Amount           decimal.Decimal
+	ExecutedQuantity decimal.Decimal
+	StatusId         int16
+	ConnectorId      int64
+	ExecutionTypeId  int16
+	ExecuteTillTime  time.Time
+	RefPositionId    string
+	TimeInForceId    int16
+	UpdateTimestamp  time.Time
+	AccountId        int64
+	ApiKey           string
+	SecretKey        string
+	FingerPrint      string
+	Priority         int16
 }
 
 type RawCommand struct {
-	Id              string
-	Exchange        string
-	Instrument      string
-	Direction       string
-	OrderType       string
-	LimitPrice      string
-	Amount          string
-	Status          string
-	ConnectorId     string
-	ExecutionType   string
-	ExecuteTillTime string
-	RefPositionId   string
-	TimeInForce     string
-	UpdateTime      string
-	AccountId       string
-	ApiKey          string
-	SecretKey       string
-	FingerPrint     string
+	Id               string
+	Exchange         string
+	Instrument       string
+	Direction        string
+	OrderType        string
+	LimitPrice       string
+	Amount           string
+	ExecutedQuantity string
+	Status           string
+	ConnectorId      string
+	ExecutionType    string
+	ExecuteTillTime  string
+	RefPositionId    string
+	TimeInForce      string
+	UpdateTime       string
+	AccountId        string
+	Priority         string
+	ApiKey           string
+	SecretKey        string
+	FingerPrint      string
 }
 
 type RawCommandWithOrder struct {
-	Id              string
-	Exchange        string
-	Instrument      string
-	Direction       string
-	OrderType       string
-	LimitPrice      string
-	Amount          string
-	Status          string
-	ConnectorId     string
-	ExecutionType   string
-	ExecuteTillTime string
-	RefPositionId   string
-	TimeInForce     string
-	UpdateTime      string
-	AccountId       string
-	Order           RawOrder
+	Id               string
+	Exchange         string
+	Instrument       string
+	Direction        string
+	OrderType        string
+	LimitPrice       string
+	Amount           string
+	ExecutedQuantity string
+	Status           string
+	ConnectorId      string
+	ExecutionType    string
+	ExecuteTillTime  string
+	RefPositionId    string
+	TimeInForce      string
+	UpdateTime       string
+	AccountId        string
+	Priority         string
+	Order            RawOrder
 }
 
 type RawCommandWithBalances struct {
-	Id              string
-	Exchange        string
-	Instrument      string
-	Direction       string
-	OrderType       string
-	LimitPrice      string
-	Amount          string
-	Status          string
-	ConnectorId     string
-	ExecutionType   string
-	ExecuteTillTime string
-	RefPositionId   string
-	TimeInForce     string
-	UpdateTime      string
-	AccountId       string
-	Balances        []RawBalance
+	Id               string
+	Exchange         string
+	Instrument       string
+	Direction        string
+	OrderType        string
+	LimitPrice       string
+	Amount           string
+	ExecutedQuantity string
+	Status           string
+	ConnectorId      string
+	ExecutionType    string
+	ExecuteTillTime  string
+	RefPositionId    string
+	TimeInForce      string
+	UpdateTime       string
+	AccountId        string
+	Priority         string
+	Balances         []RawBalance
+	Positions        []RawPosition
 }
 
 type RawCommandWithDescription struct {
-	Id              string
-	Exchange        string
-	Instrument      string
-	Direction       string
-	OrderType       string
-	LimitPrice      string
-	Amount          string
-	Status          string
-	ConnectorId     string
-	ExecutionType   string
-	ExecuteTillTime string
-	RefPositionId   string
-	TimeInForce     string
-	UpdateTime      string
-	AccountId       string
-	Description     string
+	Id               string
+	Exchange         string
+	Instrument       string
+	Direction        string
+	OrderType        string
+	LimitPrice       string
+	Amount           string
+	ExecutedQuantity string
+	Status           string
+	ConnectorId      string
+	ExecutionType    string
+	ExecuteTillTime  string
+	RefPositionId    string
+	TimeInForce      string
+	UpdateTime       string
+	AccountId        string
+	Priority         string
+	Description      string
 }
 
 func ToRaw(cmd *Command, dictionaries *dic.Dictionaries) *RawCommand {
@@ -116,18 +129,19 @@ func ToRaw(cmd *Command, dictionaries *dic.Dictionaries) *RawCommand {
 	raw.Direction = dictionaries.Directions().GetNameById(cmd.DirectionId)
 	raw.OrderType = dictionaries.OrderTypes().GetNameById(cmd.OrderTypeId)
 
-	if cmd.LimitPrice < 0 {
+	if cmd.LimitPrice.IsNegative() {
 		raw.LimitPrice = ""
 	} else {
-		raw.LimitPrice = math.Float64ToString(cmd.LimitPrice)
+		raw.LimitPrice = cmd.LimitPrice.String()
 	}
 
-	if cmd.Amount <= 0 {
+	if cmd.Amount.Sign() <= 0 {
 		raw.Amount = ""
 	} else {
-		raw.Amount = math.Float64ToString(cmd.Amount)
+		raw.Amount = cmd.Amount.String()
 	}
 
+	raw.ExecutedQuantity = cmd.ExecutedQuantity.String()
 	raw.Status = dictionaries.ExecutionStatuses().GetNameById(cmd.StatusId)
 
 	if cmd.ConnectorId < 0 {
@@ -142,6 +156,7 @@ func ToRaw(cmd *Command, dictionaries *dic.Dictionaries) *RawCommand {
 	raw.TimeInForce = dictionaries.TimeInForces().GetNameById(cmd.TimeInForceId)
 	raw.UpdateTime = cmd.UpdateTimestamp.Format(time.RFC3339)
 	raw.AccountId = math.Int64ToString(cmd.AccountId)
+	raw.Priority = math.Int64ToString(int64(cmd.Priority))
 	raw.ApiKey = cmd.ApiKey
 	raw.SecretKey = cmd.SecretKey
 	raw.FingerPrint = cmd.FingerPrint
@@ -154,22 +169,24 @@ func ToRawWithDescription(cmd *Command, dictionaries *dic.Dictionaries, descript
 	raw := ToRaw(cmd, dictionaries)
 
 	var rawCommandWithDescription = RawCommandWithDescription{
-		Id:              raw.Id,
-		Exchange:        raw.Exchange,
-		Instrument:      raw.Instrument,
-		Direction:       raw.Direction,
-		OrderType:       raw.OrderType,
-		LimitPrice:      raw.LimitPrice,
-		Amount:          raw.Amount,
-		Status:          raw.Status,
-		ConnectorId:     raw.ConnectorId,
-		ExecutionType:   raw.ExecutionType,
-		ExecuteTillTime: raw.ExecuteTillTime,
-		RefPositionId:   raw.RefPositionId,
-		TimeInForce:     raw.TimeInForce,
-		UpdateTime:      raw.UpdateTime,
-		AccountId:       raw.AccountId,
-		Description:     "",
+		Id:               raw.Id,
+		Exchange:         raw.Exchange,
+		Instrument:       raw.Instrument,
+		Direction:        raw.Direction,
+		OrderType:        raw.OrderType,
+		LimitPrice:       raw.LimitPrice,
+		Amount:           raw.Amount,
+		ExecutedQuantity: raw.ExecutedQuantity,
+		Status:           raw.Status,
+		ConnectorId:      raw.ConnectorId,
+		ExecutionType:    raw.ExecutionType,
+		ExecuteTillTime:  raw.ExecuteTillTime,
+		RefPositionId:    raw.RefPositionId,
+		TimeInForce:      raw.TimeInForce,
+		UpdateTime:       raw.UpdateTime,
+		AccountId:        raw.AccountId,
+		Priority:         raw.Priority,
+		Description:      "",
 	}
 
 	if description != nil && description.Valid {
@@ -184,62 +201,130 @@ func ToRawWithOrder(cmd *Command, dictionaries *dic.Dictionaries, order *Order)
 	raw := ToRaw(cmd, dictionaries)
 
 	var rawCommandWithOrder = RawCommandWithOrder{
-		Id:              raw.Id,
-		Exchange:        raw.Exchange,
-		Instrument:      raw.Instrument,
-		Direction:       raw.Direction,
-		OrderType:       raw.OrderType,
-		LimitPrice:      raw.LimitPrice,
-		Amount:          raw.Amount,
-		Status:          raw.Status,
-		ConnectorId:     raw.ConnectorId,
-		ExecutionType:   raw.ExecutionType,
-		ExecuteTillTime: raw.ExecuteTillTime,
-		RefPositionId:   raw.RefPositionId,
-		TimeInForce:     raw.TimeInForce,
-		UpdateTime:      raw.UpdateTime,
-		AccountId:       raw.AccountId,
-		Order:           *toRawOrder(order),
+		Id:               raw.Id,
+		Exchange:         raw.Exchange,
+		Instrument:       raw.Instrument,
+		Direction:        raw.Direction,
+		OrderType:        raw.OrderType,
+		LimitPrice:       raw.LimitPrice,
+		Amount:           raw.Amount,
+		ExecutedQuantity: raw.ExecutedQuantity,
+		Status:           raw.Status,
+		ConnectorId:      raw.ConnectorId,
+		ExecutionType:    raw.ExecutionType,
+		ExecuteTillTime:  raw.ExecuteTillTime,
+		RefPositionId:    raw.RefPositionId,
+		TimeInForce:      raw.TimeInForce,
+		UpdateTime:       raw.UpdateTime,
+		AccountId:        raw.AccountId,
+		Priority:         raw.Priority,
+		Order:            *toRawOrder(order),
 	}
 
 	return &rawCommandWithOrder
 }
 
-func ToRawWithBalances(cmd *Command, dictionaries *dic.Dictionaries, balances *[]*Balance) *RawCommandWithBalances {
+func ToRawWithBalances(cmd *Command, dictionaries *dic.Dictionaries, balances *[]*Balance,
+	positions *[]*Position) *RawCommandWithBalances {
 
 	raw := ToRaw(cmd, dictionaries)
 
 	var rawCommandWithBalances = RawCommandWithBalances{
-		Id:              raw.Id,
-		Exchange:        raw.Exchange,
-		Instrument:      raw.Instrument,
-		Direction:       raw.Direction,
-		OrderType:       raw.OrderType,
-		LimitPrice:      raw.LimitPrice,
-		Amount:          raw.Amount,
-		Status:          raw.Status,
-		ConnectorId:     raw.ConnectorId,
-		ExecutionType:   raw.ExecutionType,
-		ExecuteTillTime: raw.ExecuteTillTime,
-		RefPositionId:   raw.RefPositionId,
-		TimeInForce:     raw.TimeInForce,
-		UpdateTime:      raw.UpdateTime,
-		AccountId:       raw.AccountId,
-		Balances:        *toRawBalances(balances),
+		Id:               raw.Id,
+		Exchange:         raw.Exchange,
+		Instrument:       raw.Instrument,
+		Direction:        raw.Direction,
+		OrderType:        raw.OrderType,
+		LimitPrice:       raw.LimitPrice,
+		Amount:           raw.Amount,
+		ExecutedQuantity: raw.ExecutedQuantity,
+		Status:           raw.Status,
+		ConnectorId:      raw.ConnectorId,
+		ExecutionType:    raw.ExecutionType,
+		ExecuteTillTime:  raw.ExecuteTillTime,
+		RefPositionId:    raw.RefPositionId,
+		TimeInForce:      raw.TimeInForce,
+		UpdateTime:       raw.UpdateTime,
+		AccountId:        raw.AccountId,
+		Priority:         raw.Priority,
+		Balances:         *toRawBalances(balances),
+		Positions:        *toRawPositions(positions),
 	}
 
 	return &rawCommandWithBalances
 }
 
+// ApplyFills sets order price to VWAP of the fills, executed quantity to their total and commission to their sum.
+// If fills were charged in different assets the commission is left zero and the asset is marked as mixed.
+func ApplyFills(order *Order) {
+
+	var quantity, notional, commission decimal.Decimal
+
+	commissionAsset := ""
+
+	for i, fill := range order.Fills {
+
+		quantity = quantity.Add(fill.Quantity)
+		notional = notional.Add(fill.Quantity.Mul(fill.Price))
+		commission = commission.Add(fill.Commission)
+
+		if i == 0 {
+			commissionAsset = fill.CommissionAsset
+		} else if commissionAsset != fill.CommissionAsset {
+			commissionAsset = constants.CommissionAssetMixedName
+		}
+	}
+
+	if commissionAsset == constants.CommissionAssetMixedName {
+		commission = decimal.Zero
+	}
+
+	order.ExecutedQuantity = quantity
+	order.Commission = commission
+	order.CommissionAsset = commissionAsset
+
+	if !quantity.IsZero() {
+		order.Price = notional.DivRound(quantity, constants.DecimalScale)
+	}
+}
+
 func toRawOrder(order *Order) *RawOrder {
 
 	raw := RawOrder{
-		Id:              math.Int64ToString(order.Id),
-		ExternalOrderId: math.Int64ToString(order.ExternalOrderId),
-		ExecutionId:     math.Int64ToString(order.ExecutionId),
-		Price:           math.Float64ToString(order.Price),
-		Commission:      math.Float64ToString(order.Commission),
-		CommissionAsset: order.CommissionAsset,
+		Id:               math.Int64ToString(order.Id),
+		ExternalOrderId:  math.Int64ToString(order.ExternalOrderId),
+		ExternalOrderRef: order.ExternalOrderRef,
+		ExecutionId:      math.Int64ToString(order.ExecutionId),
+		Price:            order.Price.String(),
+		ExecutedQuantity: order.ExecutedQuantity.String(),
+		Commission:       order.Commission.String(),
+		CommissionAsset:  order.CommissionAsset,
+		Fills:            *toRawFills(&order.Fills),
+	}
+
+	return &raw
+}
+
+func toRawFills(fills *[]Fill) *[]RawFill {
+
+	raw := make([]RawFill, len(*fills))
+
+	for i, val := range *fills {
+
+		raw[i] = RawFill{
+			Id:               math.Int64ToString(val.Id),
+			ExecutionId:      math.Int64ToString(val.ExecutionId),
+			ExternalTradeId:  "",
+			ExternalTradeRef: val.ExternalTradeRef,
+			Quantity:         val.Quantity.String(),
+			Price:            val.Price.String(),
+			Commission:       val.Commission.String(),
+			CommissionAsset:  val.CommissionAsset,
+		}
+
+		if val.ExternalTradeId >= 0 {
+			raw[i].ExternalTradeId = math.Int64ToString(val.ExternalTradeId)
+		}
 	}
 
 	return &raw
@@ -254,8 +339,29 @@ func toRawBalances(balances *[]*Balance) *[]RawBalance {
 			Id:          math.Int64ToString(val.Id),
 			ExecutionId: math.Int64ToString(val.ExecutionId),
 			Asset:       val.Asset,
-			Free:        math.Float64ToString(val.Free),
-			Locked:      math.Float64ToString(val.Locked),
+			Free:        val.Free.String(),
+			Locked:      val.Locked.String(),
+		}
+	}
+
+	return &raw
+}
+
+func toRawPositions(positions *[]*Position) *[]RawPosition {
+
+	raw := make([]RawPosition, len(*positions))
+
+	for i, val := range *positions {
+		raw[i] = RawPosition{
+			Id:               math.Int64ToString(val.Id),
+			ExecutionId:      math.Int64ToString(val.ExecutionId),
+			Symbol:           val.Symbol,
+			PositionSide:     val.PositionSide,
+			Amount:           val.Amount.String(),
+			EntryPrice:       val.EntryPrice.String(),
+			UnrealizedProfit: val.UnrealizedProfit.String(),
+			Leverage:         math.Int64ToString(int64(val.Leverage)),
+			MarginType:       val.MarginType,
 		}
 	}
 
@@ -263,29 +369,57 @@ func toRawBalances(balances *[]*Balance) *[]RawBalance {
 }
 
 type Order struct {
-	Id              int64
-	ExternalOrderId int64
-	ExecutionId     int64
-	Price           float64
-	Commission      float64
-	CommissionAsset string
+	Id               int64
+	ExternalOrderId  int64
+	ExternalOrderRef string // string id of the order for exchanges which don't number orders, empty otherwise
+	ExecutionId      int64
+	Price            decimal.Decimal
+	ExecutedQuantity decimal.Decimal
+	Commission       decimal.Decimal
+	CommissionAsset  string
+	Fills            []Fill
 }
 
 type RawOrder struct {
-	Id              string
-	ExternalOrderId string
-	ExecutionId     string
-	Price           string
-	Commission      string
-	CommissionAsset string
+	Id               string
+	ExternalOrderId  string
+	ExternalOrderRef string
+	ExecutionId      string
+	Price            string
+	ExecutedQuantity string
+	Commission       string
+	CommissionAsset  string
+	Fills            []RawFill
+}
+
+type Fill struct {
+	Id               int64
+	ExecutionId      int64
+	ExternalTradeId  int64
+	ExternalTradeRef string // string id of the trade for exchanges which don't number trades, empty otherwise
+	Quantity         decimal.Decimal
+	Price            decimal.Decimal
+	Commission       decimal.Decimal
+	CommissionAsset  string
+}
+
+type RawFill struct {
+	Id               string
+	ExecutionId      string
+	ExternalTradeId  string
+	ExternalTradeRef string
+	Quantity         string
+	Price            string
+	Commission       string
+	CommissionAsset  string
 }
 
 type Balance struct {
 	Id          int64
 	ExecutionId int64
 	Asset       string
-	Free        float64
-	Locked      float64
+	Free        decimal.Decimal
+	Locked      decimal.Decimal
 }
 
 type RawBalance struct {
@@ -295,3 +429,28 @@ type RawBalance struct {
 	Free        string
 	Locked      string
 }
+
+// Position is an open position of futures account, Amount is negative for short one
+type Position struct {
+	Id               int64
+	ExecutionId      int64
+	Symbol           string
+	PositionSide     string
+	Amount           decimal.Decimal
+	EntryPrice       decimal.Decimal
+	UnrealizedProfit decimal.Decimal
+	Leverage         int16
+	MarginType       string
+}
+
+type RawPosition struct {
+	Id               string
+	ExecutionId      string
+	Symbol           string
+	PositionSide     string
+	Amount           string
+	EntryPrice       string
+	UnrealizedProfit string
+	Leverage         string
+	MarginType       string
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"github.com/shopspring/decimal"
+	"msq.ai/constants"
+	"testing"
+)
+
+func fill(quantity string, price string, commission string, asset string) Fill {
+	return Fill{Quantity: decimal.RequireFromString(quantity), Price: decimal.RequireFromString(price),
+		Commission: decimal.RequireFromString(commission), CommissionAsset: asset}
+}
+
+func TestApplyFills(t *testing.T) {
+
+	cases := []struct {
+		name            string
+		fills           []Fill
+		price           string
+		executed        string
+		commission      string
+		commissionAsset string
+	}{
+		{
+			name:  "price is VWAP of fills",
+			fills: []Fill{fill("0.1", "100", "0.0001", "BTC"), fill("0.3", "104", "0.0003", "BTC")},
+			price: "103", executed: "0.4", commission: "0.0004", commissionAsset: "BTC",
+		},
+		{
+			name:  "commission of mixed assets isn't summed",
+			fills: []Fill{fill("1", "10", "0.01", "BNB"), fill("1", "20", "0.02", "USDT")},
+			price: "15", executed: "2", commission: "0", commissionAsset: constants.CommissionAssetMixedName,
+		},
+		{
+			name:  "VWAP is rounded to DB scale",
+			fills: []Fill{fill("1", "1", "0", "USDT"), fill("2", "2", "0", "USDT")},
+			price: "1.666666666666666667", executed: "3", commission: "0", commissionAsset: "USDT",
+		},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			order := &Order{Fills: c.fills}
+
+			ApplyFills(order)
+
+			if !order.Price.Equal(decimal.RequireFromString(c.price)) {
+				t.Error("price ", order.Price, ", expected ", c.price)
+			}
+
+			if !order.ExecutedQuantity.Equal(decimal.RequireFromString(c.executed)) {
+				t.Error("executed ", order.ExecutedQuantity, ", expected ", c.executed)
+			}
+
+			if !order.Commission.Equal(decimal.RequireFromString(c.commission)) || order.CommissionAsset != c.commissionAsset {
+				t.Error("commission ", order.Commission, " ", order.CommissionAsset, ", expected ", c.commission, " ",
+					c.commissionAsset)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-errors/errors"
+	"msq.ai/data/cmd"
+	"strconv"
+	"time"
+)
+
+// lease is counted by DB clock, so clocks of connectors don't matter
+const renewConnectorLeaseSql = "INSERT INTO connector (exchange_id, id, heartbeat_time, lease_till) VALUES ($1, $2, NOW(), " +
+	"NOW() + $3::FLOAT8 * INTERVAL '1 second') ON CONFLICT (exchange_id, id) DO UPDATE SET " +
+	"heartbeat_time = EXCLUDED.heartbeat_time, lease_till = EXCLUDED.lease_till"
+
+const tryGetOrphanedCommandsSql = selectCommandSql + " WHERE exchange_id = $1 AND status_id = ANY($2) AND connector_id IN " +
+	"(SELECT id FROM connector WHERE exchange_id = $1 AND id <> $3 AND lease_till < NOW()) ORDER BY id LIMIT $4 " +
+	"FOR UPDATE SKIP LOCKED"
+
+const updateCommandConnectorByIdSql = "UPDATE execution SET connector_id = $1, update_timestamp = $2 WHERE id = $3"
+
+// RenewConnectorLease is heartbeat of the connector, commands of the connector are safe from takeover till the lease
+// is over
+func RenewConnectorLease(db *sql.DB, exchangeId int16, conId int16, lease time.Duration) error {
+
+	stmt, err := db.Prepare(renewConnectorLeaseSql)
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	_, err = stmt.Exec(exchangeId, conId, lease.Seconds())
+
+	if err != nil {
+		_ = stmt.Close()
+		return errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	return nil
+}
+
+// TakeOverCommands moves commands in execution from connectors of the exchange which leases are expired to conId and
+// records the takeover in history of every command. The commands are returned for recovery.
+func TakeOverCommands(db *sql.DB, exchangeId int16, conId int16, statusIds []int16, limit int16) (*[]*cmd.Command, error) {
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: false})
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	stmt, err := tx.Prepare(tryGetOrphanedCommandsSql)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	rows, err := stmt.Query(exchangeId, int16Array(statusIds), conId, limit)
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	commands := make([]*cmd.Command, 0)
+
+	for rows.Next() {
+
+		command, err := scanRowCommand(nil, rows)
+
+		if err != nil {
+			_ = rows.Close()
+			_ = stmt.Close()
+			_ = tx.Rollback()
+			return nil, err
+		}
+
+		if command != nil {
+			commands = append(commands, command)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	err = rows.Close()
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	if len(commands) == 0 {
+		_ = tx.Rollback()
+		return nil, nil
+	}
+
+	stmt, err = tx.Prepare(updateCommandConnectorByIdSql)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	stmt2, err := tx.Prepare(insertCommandHistorySql)
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	for _, command := range commands {
+
+		now := time.Now()
+
+		_, err = stmt.Exec(conId, now, command.Id)
+
+		if err != nil {
+			_ = stmt.Close()
+			_ = stmt2.Close()
+			_ = tx.Rollback()
+			return nil, errors.New(err)
+		}
+
+		description := "Taken over by connector " + strconv.Itoa(int(conId)) + " from connector " +
+			strconv.FormatInt(command.ConnectorId, 10) + " which lease is expired"
+
+		_, err = stmt2.Exec(command.Id, command.StatusId, command.StatusId, now, description)
+
+		if err != nil {
+			_ = stmt.Close()
+			_ = stmt2.Close()
+			_ = tx.Rollback()
+			return nil, errors.New(err)
+		}
+
+		command.ConnectorId = int64(conId)
+		command.UpdateTimestamp = now
+	}
+
+	err = stmt2.Close()
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, errors.New(err)
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	return &commands, nil
+}
@@ -5,9 +5,11 @@ import (
 	"database/sql"
 	"github.com/go-errors/errors"
 	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
 	"github.com/vishalkuo/bimap"
 	"msq.ai/data/cmd"
 	dic "msq.ai/db/postgres/dictionaries"
+	"strconv"
 	"time"
 )
 
@@ -22,46 +24,90 @@ const loadTimeInForceSql = "SELECT id, type FROM time_in_force"
 const loadExecutionTypesSql = "SELECT id, type FROM execution_type"
 const loadExecutionStatusSql = "SELECT id, value FROM execution_status"
 
-const getOrderByIdSql = "SELECT id, external_order_id, execution_id, price, commission, commission_asset FROM orders WHERE execution_id = $1"
+const getOrderByIdSql = "SELECT id, external_order_id, external_order_ref, execution_id, price, executed_quantity, commission, commission_asset FROM orders " +
+	"WHERE execution_id = $1"
+
+const getFillsByExecutionIdSql = "SELECT id, execution_id, external_trade_id, external_trade_ref, quantity, price, commission, commission_asset FROM fills " +
+	"WHERE execution_id = $1 ORDER BY id"
 
 const getErrorDescriptionByIdSql = "SELECT description FROM execution_history WHERE execution_id = $1 AND status_to_id = $2"
 
 const getBalancesByExecutionIdSql = "SELECT id, execution_id, asset, free, locked FROM balances WHERE execution_id = $1"
 
+const getPositionsByExecutionIdSql = "SELECT id, execution_id, symbol, position_side, amount, entry_price, unrealized_profit, " +
+	"leverage, margin_type FROM positions WHERE execution_id = $1 ORDER BY id"
+
 const getCommandIdByFingerPrintSql = "SELECT id FROM execution WHERE finger_print = $1"
 
+// commands of simulated accounts are routed to the exchange given by $17
 const insertCommandSql = "INSERT INTO execution (exchange_id, instrument_name, direction_id, order_type_id, limit_price, time_in_force_id, " +
 	"amount, status_id, execution_type_id, execute_till_time, ref_position_id, update_timestamp, account_id, api_key, secret_key, " +
-	"finger_print) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING id"
+	"finger_print, priority) VALUES (CASE WHEN EXISTS (SELECT 1 FROM account WHERE id = $13 AND simulated) THEN $17::SMALLINT " +
+	"ELSE $1::SMALLINT END, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, " +
+	"COALESCE($18::SMALLINT, (SELECT priority FROM execution_type WHERE id = $9))) RETURNING id, exchange_id"
+
+// notification is delivered to listeners only when the transaction commits
+const notifyNewCommandSql = "SELECT pg_notify($1, $2)"
 
 const insertCommandHistorySql = "INSERT INTO execution_history (execution_id, status_from_id, status_to_id, timestamp, description) " +
 	"VALUES ($1, $2, $3, $4, $5)"
 
-const selectCommandSql = "SELECT id, exchange_id, instrument_name, direction_id, order_type_id, limit_price, amount, " +
+const selectCommandSql = "SELECT id, exchange_id, instrument_name, direction_id, order_type_id, limit_price, amount, executed_quantity, " +
 	"status_id, connector_id, execution_type_id,execute_till_time, ref_position_id, time_in_force_id, update_timestamp, account_id, " +
-	"api_key, secret_key, finger_print FROM execution"
+	"api_key, secret_key, finger_print, priority FROM execution"
 
 const loadCommandByIdSql = selectCommandSql + " WHERE id = $1"
 
-const tryGetCommandForExecutionSql = selectCommandSql + " WHERE exchange_id = $1 AND status_id = $2 AND connector_id ISNULL " +
-	"AND execute_till_time > $3 FOR UPDATE LIMIT $4"
+// Commands of higher priority are claimed first. turn is the place of the command in the queue of its account among the
+// commands of the same priority, so every account gets its oldest command claimed before the second command of any
+// account. Rows locked by another connector are skipped, the conditions are checked again after locking.
+const tryGetCommandForExecutionSql = selectCommandSql + " JOIN (SELECT id AS ranked_id, ROW_NUMBER() OVER " +
+	"(PARTITION BY account_id, priority ORDER BY id) AS turn FROM execution WHERE exchange_id = $1 AND status_id = $2 AND " +
+	"connector_id ISNULL AND execute_till_time > $3 AND priority >= $5 AND account_id <> ALL($6)) AS ranked " +
+	"ON ranked_id = id WHERE status_id = $2 AND connector_id ISNULL ORDER BY priority DESC, turn, id LIMIT $4 " +
+	"FOR UPDATE OF execution SKIP LOCKED"
 
 const finishStaleCommandsSql = selectCommandSql + " WHERE status_id = $1 AND execute_till_time < $2 FOR UPDATE LIMIT $3"
 
-const tryGetCommandForRecoverySql = selectCommandSql + " WHERE exchange_id = $1 AND status_id = $2 AND connector_id = $3 " +
+const tryGetCommandForRecoverySql = selectCommandSql + " WHERE exchange_id = $1 AND status_id = ANY($2) AND connector_id = $3 " +
 	"AND update_timestamp < $4 FOR UPDATE LIMIT $5"
 
+const tryGetParkedCommandsSql = selectCommandSql + " WHERE exchange_id = $1 AND status_id = ANY($2) AND connector_id = $3 " +
+	"AND update_timestamp < $4 AND id <> ALL($5) ORDER BY update_timestamp LIMIT $6 FOR UPDATE SKIP LOCKED"
+
+const countCommandsForRecoverySql = "SELECT account_id, COUNT(*) FROM execution WHERE exchange_id = $1 AND status_id = ANY($2) " +
+	"AND connector_id = $3 AND update_timestamp < $4 GROUP BY account_id"
+
 const updateCommandStatusByIdSql = "UPDATE execution SET status_id = $1, connector_id = $2, update_timestamp = $3 WHERE id = $4"
 
 const updateCommandTimestampByIdSql = "UPDATE execution SET update_timestamp = $1 WHERE id = $2"
 
-const insertNewOrderSql = "INSERT INTO orders (external_order_id, execution_id, price, commission, commission_asset) VALUES ($1, $2, $3, $4, $5)"
+// finish and progress are fenced, they change the command only while it is owned by the connector and is in the status
+// the connector knows, so a connector which lost its lease doesn't overwrite the command taken over by another one
+const finishCommandByIdSql = "UPDATE execution SET status_id = $1, update_timestamp = $3 WHERE id = $4 AND " +
+	"connector_id IS NOT DISTINCT FROM $2 AND status_id = $5"
+
+const updateCommandProgressByIdSql = "UPDATE execution SET status_id = $1, executed_quantity = $2, update_timestamp = $3 " +
+	"WHERE id = $4 AND connector_id = $5 AND status_id = $6"
+
+const touchCommandByIdSql = "UPDATE execution SET update_timestamp = $1 WHERE id = $2 AND connector_id = $3 AND status_id = $4"
+
+const updateCommandExecutedQuantityByIdSql = "UPDATE execution SET executed_quantity = $1 WHERE id = $2"
+
+const insertNewOrderSql = "INSERT INTO orders (external_order_id, external_order_ref, execution_id, price, executed_quantity, " +
+	"commission, commission_asset) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+
+const insertNewFillSql = "INSERT INTO fills (execution_id, external_trade_id, external_trade_ref, quantity, price, commission, " +
+	"commission_asset) VALUES ($1, $2, $3, $4, $5, $6, $7)"
 
 const insertNewBalanceSql = "INSERT INTO balances(execution_id, asset, free, locked) VALUES ($1, $2, $3, $4)"
 
+const insertNewPositionSql = "INSERT INTO positions(execution_id, symbol, position_side, amount, entry_price, unrealized_profit, " +
+	"leverage, margin_type) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+
 func scanRowCommand(row *sql.Row, rows *sql.Rows) (*cmd.Command, error) {
 	var (
-		limitPrice    sql.NullFloat64
+		limitPrice    decimal.NullDecimal
 		connectorId   sql.NullInt64
 		refPositionId sql.NullString
 
@@ -72,14 +118,14 @@ func scanRowCommand(row *sql.Row, rows *sql.Rows) (*cmd.Command, error) {
 
 	if row != nil {
 		err = row.Scan(&command.Id, &command.ExchangeId, &command.InstrumentName, &command.DirectionId, &command.OrderTypeId,
-			&limitPrice, &command.Amount, &command.StatusId, &connectorId, &command.ExecutionTypeId, &command.ExecuteTillTime,
+			&limitPrice, &command.Amount, &command.ExecutedQuantity, &command.StatusId, &connectorId, &command.ExecutionTypeId, &command.ExecuteTillTime,
 			&refPositionId, &command.TimeInForceId, &command.UpdateTimestamp, &command.AccountId, &command.ApiKey, &command.SecretKey,
-			&command.FingerPrint)
+			&command.FingerPrint, &command.Priority)
 	} else {
 		err = rows.Scan(&command.Id, &command.ExchangeId, &command.InstrumentName, &command.DirectionId, &command.OrderTypeId,
-			&limitPrice, &command.Amount, &command.StatusId, &connectorId, &command.ExecutionTypeId, &command.ExecuteTillTime,
+			&limitPrice, &command.Amount, &command.ExecutedQuantity, &command.StatusId, &connectorId, &command.ExecutionTypeId, &command.ExecuteTillTime,
 			&refPositionId, &command.TimeInForceId, &command.UpdateTimestamp, &command.AccountId, &command.ApiKey, &command.SecretKey,
-			&command.FingerPrint)
+			&command.FingerPrint, &command.Priority)
 	}
 
 	if err != nil {
@@ -91,9 +137,9 @@ func scanRowCommand(row *sql.Row, rows *sql.Rows) (*cmd.Command, error) {
 	}
 
 	if limitPrice.Valid {
-		command.LimitPrice = limitPrice.Float64
+		command.LimitPrice = limitPrice.Decimal
 	} else {
-		command.LimitPrice = -1
+		command.LimitPrice = decimal.NewFromInt(-1)
 	}
 
 	if connectorId.Valid {
@@ -176,7 +222,8 @@ func FinishStaleCommands(db *sql.DB, statusCreatedId int16, statusTimedOutId int
 
 	for _, command := range commands {
 
-		err = finishExecution(tx, command.Id, -1, statusCreatedId, statusTimedOutId, timedOutWithOutExecutionDescription, nil, nil)
+		err = finishExecution(tx, command.Id, -1, statusCreatedId, statusTimedOutId, timedOutWithOutExecutionDescription, nil, nil,
+			nil)
 
 		if err != nil {
 			_ = tx.Rollback()
@@ -200,7 +247,83 @@ func FinishStaleCommands(db *sql.DB, statusCreatedId int16, statusTimedOutId int
 	return &commands, nil
 }
 
-func TryGetCommandsForRecovery(db *sql.DB, exchangeId int16, conId int16, statusExecutingId int16, baseLine time.Time, limit int16) (*[]*cmd.Command, error) {
+// CountCommandsForRecovery gives number of commands which TryGetCommandsForRecovery would return by every account
+func CountCommandsForRecovery(db *sql.DB, exchangeId int16, conId int16, statusIds []int16, baseLine time.Time) (map[int64]int,
+	error) {
+
+	stmt, err := db.Prepare(countCommandsForRecoverySql)
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	rows, err := stmt.Query(exchangeId, int16Array(statusIds), conId, baseLine)
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	result := make(map[int64]int)
+
+	for rows.Next() {
+
+		var accountId int64
+		var count int
+
+		err = rows.Scan(&accountId, &count)
+
+		if err != nil {
+			_ = rows.Close()
+			_ = stmt.Close()
+			return nil, errors.New(err)
+		}
+
+		result[accountId] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = rows.Close()
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	return result, nil
+}
+
+func TryGetCommandsForRecovery(db *sql.DB, exchangeId int16, conId int16, statusIds []int16, baseLine time.Time, limit int16) (*[]*cmd.Command, error) {
+	return tryGetCommandsTouched(db, tryGetCommandForRecoverySql, exchangeId, int16Array(statusIds), conId, baseLine, limit)
+}
+
+// TryGetParkedCommands returns commands of the connector which orders are parked alive at exchange and weren't touched
+// since olderThan, commands in execution are excluded. Update time of returned commands is touched, so all parked
+// commands are taken in turn.
+func TryGetParkedCommands(db *sql.DB, exchangeId int16, conId int16, statusIds []int16, olderThan time.Time,
+	excludedIds []int64, limit int16) (*[]*cmd.Command, error) {
+
+	if excludedIds == nil {
+		excludedIds = []int64{}
+	}
+
+	return tryGetCommandsTouched(db, tryGetParkedCommandsSql, exchangeId, int16Array(statusIds), conId, olderThan,
+		pq.Array(excludedIds), limit)
+}
+
+// tryGetCommandsTouched selects commands for update by the query and touches their update time
+func tryGetCommandsTouched(db *sql.DB, query string, args ...interface{}) (*[]*cmd.Command, error) {
 
 	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: false})
 
@@ -208,14 +331,14 @@ func TryGetCommandsForRecovery(db *sql.DB, exchangeId int16, conId int16, status
 		return nil, errors.New(err)
 	}
 
-	stmt, err := tx.Prepare(tryGetCommandForRecoverySql)
+	stmt, err := tx.Prepare(query)
 
 	if err != nil {
 		_ = tx.Rollback()
 		return nil, errors.New(err)
 	}
 
-	rows, err := stmt.Query(exchangeId, statusExecutingId, conId, baseLine, limit)
+	rows, err := stmt.Query(args...)
 
 	if err != nil {
 		_ = stmt.Close()
@@ -304,10 +427,30 @@ func TryGetCommandsForRecovery(db *sql.DB, exchangeId int16, conId int16, status
 	return &commands, nil
 }
 
+// ErrCommandNotOwned is returned when the command is taken over by another connector or its status is changed by
+// somebody else, nothing is written then
+var ErrCommandNotOwned = errors.New("command isn't owned by the connector in the known status any more")
+
+// checkOwned tells ErrCommandNotOwned when fenced update didn't change the command
+func checkOwned(result sql.Result) error {
+
+	affected, err := result.RowsAffected()
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	if affected == 0 {
+		return ErrCommandNotOwned
+	}
+
+	return nil
+}
+
 func finishExecution(tx *sql.Tx, executionId int64, connectorId int16, currentStatusId int16, newStatusId int16, description string,
-	order *cmd.Order, balances *[]cmd.Balance) error {
+	order *cmd.Order, balances *[]cmd.Balance, positions *[]cmd.Position) error {
 
-	stmt, err := tx.Prepare(updateCommandStatusByIdSql)
+	stmt, err := tx.Prepare(finishCommandByIdSql)
 
 	if err != nil {
 		return errors.New(err)
@@ -315,7 +458,7 @@ func finishExecution(tx *sql.Tx, executionId int64, connectorId int16, currentSt
 
 	now := time.Now()
 
-	_, err = stmt.Exec(newStatusId, nullInt64(int64(connectorId)), now, executionId)
+	result, err := stmt.Exec(newStatusId, nullInt64(int64(connectorId)), now, executionId, currentStatusId)
 
 	if err != nil {
 		_ = stmt.Close()
@@ -328,6 +471,10 @@ func finishExecution(tx *sql.Tx, executionId int64, connectorId int16, currentSt
 		return errors.New(err)
 	}
 
+	if err = checkOwned(result); err != nil {
+		return err
+	}
+
 	stmt, err = tx.Prepare(insertCommandHistorySql)
 
 	if err != nil {
@@ -355,7 +502,8 @@ func finishExecution(tx *sql.Tx, executionId int64, connectorId int16, currentSt
 			return errors.New(err)
 		}
 
-		_, err = stmt.Exec(order.ExternalOrderId, order.ExecutionId, order.Price, order.Commission, order.CommissionAsset)
+		_, err = stmt.Exec(order.ExternalOrderId, nullString(order.ExternalOrderRef), order.ExecutionId, order.Price, order.ExecutedQuantity, order.Commission,
+			order.CommissionAsset)
 
 		if err != nil {
 			_ = stmt.Close()
@@ -367,6 +515,51 @@ func finishExecution(tx *sql.Tx, executionId int64, connectorId int16, currentSt
 		if err != nil {
 			return errors.New(err)
 		}
+
+		stmt, err = tx.Prepare(updateCommandExecutedQuantityByIdSql)
+
+		if err != nil {
+			return errors.New(err)
+		}
+
+		_, err = stmt.Exec(order.ExecutedQuantity, executionId)
+
+		if err != nil {
+			_ = stmt.Close()
+			return errors.New(err)
+		}
+
+		err = stmt.Close()
+
+		if err != nil {
+			return errors.New(err)
+		}
+
+		if len(order.Fills) > 0 {
+
+			stmt, err = tx.Prepare(insertNewFillSql)
+
+			if err != nil {
+				return errors.New(err)
+			}
+
+			for _, fill := range order.Fills {
+
+				_, err = stmt.Exec(executionId, nullInt64(fill.ExternalTradeId), nullString(fill.ExternalTradeRef), fill.Quantity, fill.Price, fill.Commission,
+					fill.CommissionAsset)
+
+				if err != nil {
+					_ = stmt.Close()
+					return errors.New(err)
+				}
+			}
+
+			err = stmt.Close()
+
+			if err != nil {
+				return errors.New(err)
+			}
+		}
 	}
 
 	if balances != nil && len(*balances) > 0 {
@@ -394,11 +587,37 @@ func finishExecution(tx *sql.Tx, executionId int64, connectorId int16, currentSt
 		}
 	}
 
+	if positions != nil && len(*positions) > 0 {
+
+		stmt, err = tx.Prepare(insertNewPositionSql)
+
+		if err != nil {
+			return errors.New(err)
+		}
+
+		for _, pos := range *positions {
+
+			_, err = stmt.Exec(executionId, pos.Symbol, pos.PositionSide, pos.Amount, pos.EntryPrice, pos.UnrealizedProfit,
+				pos.Leverage, pos.MarginType)
+
+			if err != nil {
+				_ = stmt.Close()
+				return errors.New(err)
+			}
+		}
+
+		err = stmt.Close()
+
+		if err != nil {
+			return errors.New(err)
+		}
+	}
+
 	return nil
 }
 
 func FinishExecution(db *sql.DB, executionId int64, connectorId int16, currentStatusId int16, newStatusId int16,
-	description string, order *cmd.Order, balances *[]cmd.Balance) error {
+	description string, order *cmd.Order, balances *[]cmd.Balance, positions *[]cmd.Position) error {
 
 	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: false})
 
@@ -406,7 +625,7 @@ func FinishExecution(db *sql.DB, executionId int64, connectorId int16, currentSt
 		return errors.New(err)
 	}
 
-	err = finishExecution(tx, executionId, connectorId, currentStatusId, newStatusId, description, order, balances)
+	err = finishExecution(tx, executionId, connectorId, currentStatusId, newStatusId, description, order, balances, positions)
 
 	if err != nil {
 		_ = tx.Rollback()
@@ -422,8 +641,110 @@ func FinishExecution(db *sql.DB, executionId int64, connectorId int16, currentSt
 	return nil
 }
 
+func UpdateExecutionProgress(db *sql.DB, executionId int64, connectorId int16, currentStatusId int16, newStatusId int16,
+	executedQuantity decimal.Decimal, description string) error {
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: false})
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	stmt, err := tx.Prepare(updateCommandProgressByIdSql)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.New(err)
+	}
+
+	now := time.Now()
+
+	result, err := stmt.Exec(newStatusId, executedQuantity, now, executionId, connectorId, currentStatusId)
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.New(err)
+	}
+
+	if err = checkOwned(result); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	stmt, err = tx.Prepare(insertCommandHistorySql)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.New(err)
+	}
+
+	_, err = stmt.Exec(executionId, currentStatusId, newStatusId, now, nullString(description))
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.New(err)
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	return nil
+}
+
+// TouchCommand moves update time of the command which state isn't changed, so parked order is checked again only after
+// poll time
+func TouchCommand(db *sql.DB, executionId int64, connectorId int16, statusId int16) error {
+
+	stmt, err := db.Prepare(touchCommandByIdSql)
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	result, err := stmt.Exec(time.Now(), executionId, connectorId, statusId)
+
+	if err != nil {
+		_ = stmt.Close()
+		return errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	return checkOwned(result)
+}
+
+// TryGetCommandsForExecution claims only commands of priority not lower than minPriority and of accounts which aren't
+// excluded
 func TryGetCommandsForExecution(db *sql.DB, exchangeId int16, conId int16, validTimeTo time.Time, statusCreatedId int16,
-	statusExecutingId int16, limit int16) (*[]*cmd.Command, error) {
+	statusExecutingId int16, limit int16, minPriority int16, excludedAccountIds []int64) (*[]*cmd.Command, error) {
+
+	// NULL array would exclude everything
+	if excludedAccountIds == nil {
+		excludedAccountIds = []int64{}
+	}
 
 	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: false})
 
@@ -438,7 +759,7 @@ func TryGetCommandsForExecution(db *sql.DB, exchangeId int16, conId int16, valid
 		return nil, errors.New(err)
 	}
 
-	rows, err := stmt.Query(exchangeId, statusCreatedId, validTimeTo, limit)
+	rows, err := stmt.Query(exchangeId, statusCreatedId, validTimeTo, limit, minPriority, pq.Array(excludedAccountIds))
 
 	if err != nil {
 		_ = stmt.Close()
@@ -558,20 +879,20 @@ func TryGetCommandsForExecution(db *sql.DB, exchangeId int16, conId int16, valid
 	return &commands, nil
 }
 
-func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorId int16, orderTypeInfoId int16) (*cmd.Command,
-	*cmd.Order, *[]*cmd.Balance, *sql.NullString, error) {
+func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorId int16, requestOrderTypeIds []int16) (*cmd.Command,
+	*cmd.Order, *[]*cmd.Balance, *[]*cmd.Position, *sql.NullString, error) {
 
 	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: true})
 
 	if err != nil {
-		return nil, nil, nil, nil, errors.New(err)
+		return nil, nil, nil, nil, nil, errors.New(err)
 	}
 
 	stmt, err := tx.Prepare(loadCommandByIdSql)
 
 	if err != nil {
 		_ = tx.Rollback()
-		return nil, nil, nil, nil, errors.New(err)
+		return nil, nil, nil, nil, nil, errors.New(err)
 	}
 
 	row := stmt.QueryRow(id)
@@ -581,34 +902,35 @@ func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorI
 	if err != nil {
 		_ = stmt.Close()
 		_ = tx.Rollback()
-		return nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, err
 	}
 
 	err = stmt.Close()
 
 	if err != nil {
 		_ = tx.Rollback()
-		return nil, nil, nil, nil, errors.New(err)
+		return nil, nil, nil, nil, nil, errors.New(err)
 	}
 
 	if command == nil {
 		_ = tx.Rollback()
-		return nil, nil, nil, nil, nil
+		return nil, nil, nil, nil, nil, nil
 	}
 
 	var order *cmd.Order = nil
 	var balances []*cmd.Balance = nil
+	var positions []*cmd.Position = nil
 	var description = &sql.NullString{Valid: true, String: ""}
 
 	if statusCompletedId == command.StatusId {
 
-		if orderTypeInfoId == command.OrderTypeId {
+		if containsId(requestOrderTypeIds, command.OrderTypeId) {
 
 			stmt, err := tx.Prepare(getBalancesByExecutionIdSql)
 
 			if err != nil {
 				_ = tx.Rollback()
-				return nil, nil, nil, nil, errors.New(err)
+				return nil, nil, nil, nil, nil, errors.New(err)
 			}
 
 			rows, err := stmt.Query(command.Id)
@@ -616,7 +938,7 @@ func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorI
 			if err != nil {
 				_ = stmt.Close()
 				_ = tx.Rollback()
-				return nil, nil, nil, nil, errors.New(err)
+				return nil, nil, nil, nil, nil, errors.New(err)
 			}
 
 			balances = make([]*cmd.Balance, 0)
@@ -631,7 +953,7 @@ func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorI
 					_ = rows.Close()
 					_ = stmt.Close()
 					_ = tx.Rollback()
-					return nil, nil, nil, nil, errors.New(err)
+					return nil, nil, nil, nil, nil, errors.New(err)
 				}
 
 				balances = append(balances, &b)
@@ -641,7 +963,7 @@ func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorI
 				_ = rows.Close()
 				_ = stmt.Close()
 				_ = tx.Rollback()
-				return nil, nil, nil, nil, errors.New(err)
+				return nil, nil, nil, nil, nil, errors.New(err)
 			}
 
 			err = rows.Close()
@@ -649,14 +971,21 @@ func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorI
 			if err != nil {
 				_ = stmt.Close()
 				_ = tx.Rollback()
-				return nil, nil, nil, nil, errors.New(err)
+				return nil, nil, nil, nil, nil, errors.New(err)
 			}
 
 			err = stmt.Close()
 
 			if err != nil {
 				_ = tx.Rollback()
-				return nil, nil, nil, nil, errors.New(err)
+				return nil, nil, nil, nil, nil, errors.New(err)
+			}
+
+			positions, err = loadPositions(tx, command.Id)
+
+			if err != nil {
+				_ = tx.Rollback()
+				return nil, nil, nil, nil, nil, err
 			}
 
 		} else {
@@ -665,26 +994,38 @@ func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorI
 
 			if err != nil {
 				_ = tx.Rollback()
-				return nil, nil, nil, nil, errors.New(err)
+				return nil, nil, nil, nil, nil, errors.New(err)
 			}
 
 			row := stmt.QueryRow(command.Id)
 
 			order = &cmd.Order{}
 
-			err = row.Scan(&order.Id, &order.ExternalOrderId, &order.ExecutionId, &order.Price, &order.Commission, &order.CommissionAsset)
+			var orderRef sql.NullString
+
+			err = row.Scan(&order.Id, &order.ExternalOrderId, &orderRef, &order.ExecutionId, &order.Price, &order.ExecutedQuantity,
+				&order.Commission, &order.CommissionAsset)
 
 			if err != nil {
 				_ = stmt.Close()
 				_ = tx.Rollback()
-				return nil, nil, nil, nil, err
+				return nil, nil, nil, nil, nil, err
 			}
 
 			err = stmt.Close()
 
 			if err != nil {
 				_ = tx.Rollback()
-				return nil, nil, nil, nil, errors.New(err)
+				return nil, nil, nil, nil, nil, errors.New(err)
+			}
+
+			order.ExternalOrderRef = orderRef.String
+
+			order.Fills, err = loadFills(tx, command.Id)
+
+			if err != nil {
+				_ = tx.Rollback()
+				return nil, nil, nil, nil, nil, err
 			}
 		}
 	} else if statusErrorId == command.StatusId {
@@ -693,7 +1034,7 @@ func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorI
 
 		if err != nil {
 			_ = tx.Rollback()
-			return nil, nil, nil, nil, errors.New(err)
+			return nil, nil, nil, nil, nil, errors.New(err)
 		}
 
 		row := stmt.QueryRow(command.Id, statusErrorId)
@@ -703,24 +1044,165 @@ func LoadCommandById(db *sql.DB, id int64, statusCompletedId int16, statusErrorI
 		if err != nil {
 			_ = stmt.Close()
 			_ = tx.Rollback()
-			return nil, nil, nil, nil, err
+			return nil, nil, nil, nil, nil, err
 		}
 
 		err = stmt.Close()
 
 		if err != nil {
 			_ = tx.Rollback()
-			return nil, nil, nil, nil, errors.New(err)
+			return nil, nil, nil, nil, nil, errors.New(err)
 		}
 	}
 
 	err = tx.Commit()
 
 	if err != nil {
-		return nil, nil, nil, nil, errors.New(err)
+		return nil, nil, nil, nil, nil, errors.New(err)
+	}
+
+	return command, order, &balances, &positions, description, nil
+}
+
+func containsId(ids []int16, id int16) bool {
+
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func loadPositions(tx *sql.Tx, executionId int64) ([]*cmd.Position, error) {
+
+	stmt, err := tx.Prepare(getPositionsByExecutionIdSql)
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	rows, err := stmt.Query(executionId)
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	positions := make([]*cmd.Position, 0)
+
+	for rows.Next() {
+
+		var p cmd.Position
+
+		err = rows.Scan(&p.Id, &p.ExecutionId, &p.Symbol, &p.PositionSide, &p.Amount, &p.EntryPrice, &p.UnrealizedProfit,
+			&p.Leverage, &p.MarginType)
+
+		if err != nil {
+			_ = rows.Close()
+			_ = stmt.Close()
+			return nil, errors.New(err)
+		}
+
+		positions = append(positions, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = rows.Close()
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	return positions, nil
+}
+
+func loadFills(tx *sql.Tx, executionId int64) ([]cmd.Fill, error) {
+
+	stmt, err := tx.Prepare(getFillsByExecutionIdSql)
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	rows, err := stmt.Query(executionId)
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	fills := make([]cmd.Fill, 0)
+
+	for rows.Next() {
+
+		var f cmd.Fill
+		var tradeId sql.NullInt64
+		var tradeRef sql.NullString
+
+		err = rows.Scan(&f.Id, &f.ExecutionId, &tradeId, &tradeRef, &f.Quantity, &f.Price, &f.Commission, &f.CommissionAsset)
+
+		if err != nil {
+			_ = rows.Close()
+			_ = stmt.Close()
+			return nil, errors.New(err)
+		}
+
+		if tradeId.Valid {
+			f.ExternalTradeId = tradeId.Int64
+		} else {
+			f.ExternalTradeId = -1
+		}
+
+		f.ExternalTradeRef = tradeRef.String
+
+		fills = append(fills, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = rows.Close()
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	return fills, nil
+}
+
+func int16Array(values []int16) interface{} {
+
+	result := make([]int64, len(values))
+
+	for i, v := range values {
+		result[i] = int64(v)
 	}
 
-	return command, order, &balances, description, nil
+	return pq.Array(result)
 }
 
 func nullString(s string) sql.NullString {
@@ -732,13 +1214,13 @@ func nullString(s string) sql.NullString {
 	return sql.NullString{String: s, Valid: true}
 }
 
-func nullFloat(value float64) sql.NullFloat64 {
+func nullDecimal(value decimal.Decimal) decimal.NullDecimal {
 
-	if value < 0 {
-		return sql.NullFloat64{Valid: false}
+	if value.IsNegative() {
+		return decimal.NullDecimal{Valid: false}
 	}
 
-	return sql.NullFloat64{Float64: value, Valid: true}
+	return decimal.NullDecimal{Decimal: value, Valid: true}
 }
 
 func nullInt64(value int64) sql.NullInt64 {
@@ -794,9 +1276,16 @@ func getCommandIdByFingerPrint(db *sql.DB, fingerPrint string) (int64, error) {
 	return id, nil
 }
 
-func InsertCommand(db *sql.DB, exchangeId int16, instrument string, directionId int16, orderTypeId int16, limitPrice float64, timeInForceId int16,
-	amount float64, statusId int16, executionTypeId int16, future time.Time, refPositionIdVal string, now time.Time, accountId int64,
-	apiKey string, secretKey string, fingerPrint string) (int64, error) {
+// CommandsChannel is the channel which is notified with id of every new command of the exchange
+func CommandsChannel(exchangeId int16) string {
+	return "execution_" + strconv.Itoa(int(exchangeId))
+}
+
+// InsertCommand gives the command priority of its execution type when priority is negative
+func InsertCommand(db *sql.DB, exchangeId int16, instrument string, directionId int16, orderTypeId int16, limitPrice decimal.Decimal,
+	timeInForceId int16, amount decimal.Decimal, statusId int16, executionTypeId int16, future time.Time, refPositionIdVal string,
+	now time.Time, accountId int64, apiKey string, secretKey string, fingerPrint string, priority int16,
+	simulatorExchangeId int16) (int64, error) {
 
 	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: false})
 
@@ -811,12 +1300,14 @@ func InsertCommand(db *sql.DB, exchangeId int16, instrument string, directionId
 		return -1, errors.New(err)
 	}
 
-	row := stmt.QueryRow(exchangeId, instrument, directionId, orderTypeId, nullFloat(limitPrice), timeInForceId, amount, statusId,
-		executionTypeId, future, nullString(refPositionIdVal), now, accountId, apiKey, secretKey, fingerPrint)
+	row := stmt.QueryRow(exchangeId, instrument, directionId, orderTypeId, nullDecimal(limitPrice), timeInForceId, amount, statusId,
+		executionTypeId, future, nullString(refPositionIdVal), now, accountId, apiKey, secretKey, fingerPrint, simulatorExchangeId,
+		nullInt64(int64(priority)))
 
 	var id int64
+	var routedExchangeId int16
 
-	err = row.Scan(&id)
+	err = row.Scan(&id, &routedExchangeId)
 
 	if err != nil {
 		_ = stmt.Close()
@@ -860,6 +1351,13 @@ func InsertCommand(db *sql.DB, exchangeId int16, instrument string, directionId
 		return -1, errors.New(err)
 	}
 
+	_, err = tx.Exec(notifyNewCommandSql, CommandsChannel(routedExchangeId), strconv.FormatInt(id, 10))
+
+	if err != nil {
+		_ = tx.Rollback()
+		return -1, errors.New(err)
+	}
+
 	err = tx.Commit()
 
 	if err != nil {
@@ -0,0 +1,406 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-errors/errors"
+	"github.com/shopspring/decimal"
+	"msq.ai/data/cmd"
+	"sort"
+	"time"
+)
+
+const SimulatedOrderOpen = "OPEN"
+const SimulatedOrderFilled = "FILLED"
+const SimulatedOrderCanceled = "CANCELED"
+
+const countSimulatedBalancesSql = "SELECT COUNT(*) FROM simulated_balance WHERE account_id = $1"
+
+const insertSimulatedBalanceSql = "INSERT INTO simulated_balance (account_id, asset, free, locked) VALUES ($1, $2, $3, $4) " +
+	"ON CONFLICT DO NOTHING"
+
+const updateSimulatedBalanceSql = "UPDATE simulated_balance SET free = free + $1, locked = locked + $2 " +
+	"WHERE account_id = $3 AND asset = $4 AND free + $1 >= 0 AND locked + $2 >= 0"
+
+const getSimulatedBalancesSql = "SELECT asset, free, locked FROM simulated_balance WHERE account_id = $1 ORDER BY asset"
+
+const insertSimulatedOrderSql = "INSERT INTO simulated_order (execution_id, account_id, status, price, executed_quantity, " +
+	"commission, commission_asset, update_timestamp) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+
+const getSimulatedOrderSql = "SELECT execution_id, account_id, status, price, executed_quantity, commission, commission_asset " +
+	"FROM simulated_order WHERE execution_id = $1"
+
+const finishSimulatedOrderSql = "UPDATE simulated_order SET status = $1, price = $2, executed_quantity = $3, commission = $4, " +
+	"update_timestamp = $5 WHERE execution_id = $6 AND status = $7"
+
+// SimulatedOrder is an order of the paper trading exchange. Price is limit price while the order is open and execution
+// price when it is filled.
+type SimulatedOrder struct {
+	ExecutionId      int64
+	AccountId        int64
+	Status           string
+	Price            decimal.Decimal
+	ExecutedQuantity decimal.Decimal
+	Commission       decimal.Decimal
+	CommissionAsset  string
+}
+
+// BalanceChange is added to simulated balance of the asset, neither free nor locked amount may become negative
+type BalanceChange struct {
+	Asset  string
+	Free   decimal.Decimal
+	Locked decimal.Decimal
+}
+
+// seedSimulatedBalances funds the account which has no balances yet with initial amounts
+func seedSimulatedBalances(tx *sql.Tx, accountId int64, initial map[string]decimal.Decimal) error {
+
+	stmt, err := tx.Prepare(countSimulatedBalancesSql)
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	var count int64
+
+	err = stmt.QueryRow(accountId).Scan(&count)
+
+	if err != nil {
+		_ = stmt.Close()
+		return errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	stmt, err = tx.Prepare(insertSimulatedBalanceSql)
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	for asset, amount := range initial {
+
+		_, err = stmt.Exec(accountId, asset, amount, decimal.Zero)
+
+		if err != nil {
+			_ = stmt.Close()
+			return errors.New(err)
+		}
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return errors.New(err)
+	}
+
+	return nil
+}
+
+// applyBalanceChanges returns false when some balance isn't enough for the change. Changes are applied in order of
+// assets, so concurrent transactions of one account don't deadlock.
+func applyBalanceChanges(tx *sql.Tx, accountId int64, changes []BalanceChange) (bool, error) {
+
+	sorted := make([]BalanceChange, len(changes))
+
+	copy(sorted, changes)
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Asset < sorted[j].Asset })
+
+	stmt, err := tx.Prepare(updateSimulatedBalanceSql)
+
+	if err != nil {
+		return false, errors.New(err)
+	}
+
+	stmt2, err := tx.Prepare(insertSimulatedBalanceSql)
+
+	if err != nil {
+		_ = stmt.Close()
+		return false, errors.New(err)
+	}
+
+	closeAll := func() {
+		_ = stmt.Close()
+		_ = stmt2.Close()
+	}
+
+	for _, change := range sorted {
+
+		result, err := stmt.Exec(change.Free, change.Locked, accountId, change.Asset)
+
+		if err != nil {
+			closeAll()
+			return false, errors.New(err)
+		}
+
+		affected, err := result.RowsAffected()
+
+		if err != nil {
+			closeAll()
+			return false, errors.New(err)
+		}
+
+		if affected > 0 {
+			continue
+		}
+
+		// the asset is new for the account, it can be only received
+		if change.Free.IsNegative() || change.Locked.IsNegative() {
+			closeAll()
+			return false, nil
+		}
+
+		_, err = stmt2.Exec(accountId, change.Asset, change.Free, change.Locked)
+
+		if err != nil {
+			closeAll()
+			return false, errors.New(err)
+		}
+	}
+
+	err = stmt2.Close()
+
+	if err != nil {
+		_ = stmt.Close()
+		return false, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return false, errors.New(err)
+	}
+
+	return true, nil
+}
+
+// InsertSimulatedOrder saves new order and changes balances of the account in one transaction. False means balances
+// aren't enough and nothing is saved.
+func InsertSimulatedOrder(db *sql.DB, order *SimulatedOrder, changes []BalanceChange, initial map[string]decimal.Decimal) (bool,
+	error) {
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: false})
+
+	if err != nil {
+		return false, errors.New(err)
+	}
+
+	err = seedSimulatedBalances(tx, order.AccountId, initial)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	stmt, err := tx.Prepare(insertSimulatedOrderSql)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return false, errors.New(err)
+	}
+
+	_, err = stmt.Exec(order.ExecutionId, order.AccountId, order.Status, order.Price, order.ExecutedQuantity, order.Commission,
+		order.CommissionAsset, time.Now())
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return false, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		_ = tx.Rollback()
+		return false, errors.New(err)
+	}
+
+	enough, err := applyBalanceChanges(tx, order.AccountId, changes)
+
+	if err != nil || !enough {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return false, errors.New(err)
+	}
+
+	return true, nil
+}
+
+// FinishSimulatedOrder moves open order to final status and changes balances of the account in one transaction. False
+// means the order isn't open already, it is finished by somebody else.
+func FinishSimulatedOrder(db *sql.DB, order *SimulatedOrder, changes []BalanceChange) (bool, error) {
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: false})
+
+	if err != nil {
+		return false, errors.New(err)
+	}
+
+	stmt, err := tx.Prepare(finishSimulatedOrderSql)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return false, errors.New(err)
+	}
+
+	result, err := stmt.Exec(order.Status, order.Price, order.ExecutedQuantity, order.Commission, time.Now(), order.ExecutionId,
+		SimulatedOrderOpen)
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return false, errors.New(err)
+	}
+
+	affected, err := result.RowsAffected()
+
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return false, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		_ = tx.Rollback()
+		return false, errors.New(err)
+	}
+
+	if affected == 0 {
+		_ = tx.Rollback()
+		return false, nil
+	}
+
+	enough, err := applyBalanceChanges(tx, order.AccountId, changes)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	// funds of open order are locked, so they are always enough
+	if !enough {
+		_ = tx.Rollback()
+		return false, errors.New("Locked balances of simulated order aren't enough")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return false, errors.New(err)
+	}
+
+	return true, nil
+}
+
+// LoadSimulatedOrder returns nil when the execution has no order
+func LoadSimulatedOrder(db *sql.DB, executionId int64) (*SimulatedOrder, error) {
+
+	stmt, err := db.Prepare(getSimulatedOrderSql)
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	var o SimulatedOrder
+
+	err = stmt.QueryRow(executionId).Scan(&o.ExecutionId, &o.AccountId, &o.Status, &o.Price, &o.ExecutedQuantity, &o.Commission,
+		&o.CommissionAsset)
+
+	if err == sql.ErrNoRows {
+		_ = stmt.Close()
+		return nil, nil
+	}
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	return &o, nil
+}
+
+// LoadSimulatedBalances returns initial balances for the account which hasn't traded yet
+func LoadSimulatedBalances(db *sql.DB, accountId int64, initial map[string]decimal.Decimal) ([]cmd.Balance, error) {
+
+	stmt, err := db.Prepare(getSimulatedBalancesSql)
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	rows, err := stmt.Query(accountId)
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	balances := make([]cmd.Balance, 0)
+
+	for rows.Next() {
+
+		var b cmd.Balance
+
+		err = rows.Scan(&b.Asset, &b.Free, &b.Locked)
+
+		if err != nil {
+			_ = rows.Close()
+			_ = stmt.Close()
+			return nil, errors.New(err)
+		}
+
+		balances = append(balances, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = rows.Close()
+
+	if err != nil {
+		_ = stmt.Close()
+		return nil, errors.New(err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	if len(balances) == 0 {
+
+		for asset, amount := range initial {
+			balances = append(balances, cmd.Balance{Asset: asset, Free: amount, Locked: decimal.Zero})
+		}
+
+		sort.Slice(balances, func(i, j int) bool { return balances[i].Asset < balances[j].Asset })
+	}
+
+	return balances, nil
+}
@@ -2,10 +2,11 @@ package helper
 
 import (
 	"database/sql"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"msq.ai/constants"
+	"time"
 )
 
 func CloseDb(db *sql.DB) {
@@ -69,3 +70,27 @@ func CheckDbUrl(url string) error {
 
 	return nil
 }
+
+// GetListenerByUrl listens the channel with own connection which is reconnected in background, onError is called
+// when the connection is lost or cannot be reestablished
+func GetListenerByUrl(url string, channel string, onError func(err error)) (*pq.Listener, error) {
+
+	log.Trace("Try listen channel [" + channel + "] ...")
+
+	listener := pq.NewListener(url, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			onError(err)
+		}
+	})
+
+	err := listener.Listen(channel)
+
+	if err != nil {
+		_ = listener.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	log.Trace("Successfully listen channel [" + channel + "]")
+
+	return listener, nil
+}
@@ -0,0 +1,153 @@
+package ecbinance
+
+import (
+	"container/list"
+	"expvar"
+	"github.com/adshao/go-binance"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 5 * time.Second
+const keepAliveTime = 30 * time.Second
+const idleConnTimeout = 90 * time.Second
+const tlsHandshakeTimeout = 5 * time.Second
+const responseHeaderTimeout = 10 * time.Second
+const requestTimeout = 30 * time.Second
+
+// metrics of clients cache are published with expvar at /debug/vars
+var clientsHits = new(expvar.Int)
+var clientsMisses = new(expvar.Int)
+var clientsEvictions = new(expvar.Int)
+var clientsLifetimeTotal = new(expvar.Float)
+var clientsLifetimeMax = new(expvar.Float)
+
+func init() {
+
+	metrics := expvar.NewMap("binanceClients")
+
+	metrics.Set("hits", clientsHits)
+	metrics.Set("misses", clientsMisses)
+	metrics.Set("evictions", clientsEvictions)
+	metrics.Set("evictedLifetimeSecondsTotal", clientsLifetimeTotal)
+	metrics.Set("evictedLifetimeSecondsMax", clientsLifetimeMax)
+
+	metrics.Set("hitRatio", expvar.Func(func() interface{} {
+
+		hits := clientsHits.Value()
+		total := hits + clientsMisses.Value()
+
+		if total == 0 {
+			return 0.0
+		}
+
+		return float64(hits) / float64(total)
+	}))
+}
+
+// newTransport returns transport for all Binance calls, every worker may hold a connection to the same host, so the
+// number of idle connections per host is raised to the pool size
+func newTransport(maxIdleConns int) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: keepAliveTime,
+		}).DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+func newHttpClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: transport, Timeout: requestTimeout}
+}
+
+// NewHttpClient returns client for pool of workers which passes every request through the limiter, it is shared with
+// connectors of other Binance markets
+func NewHttpClient(limiter *Limiter, maxIdleConns int) *http.Client {
+	return newHttpClient(limiter.wrap(newTransport(maxIdleConns)))
+}
+
+type clientEntry struct {
+	apiKey    string
+	secretKey string
+	client    *binance.Client
+	created   time.Time
+}
+
+// clientCache keeps clients of the most recently used API keys, the least recently used one is evicted when it is full.
+// Clients are never changed after creation, so they are renewed when server time offset changes.
+type clientCache struct {
+	lock       sync.Mutex
+	size       int
+	entries    map[string]*list.Element
+	order      *list.List
+	timeOffset func() int64
+	newClient  func(apiKey string, secretKey string) *binance.Client
+}
+
+func newClientCache(size int, timeOffset func() int64, newClient func(apiKey string, secretKey string) *binance.Client) *clientCache {
+	return &clientCache{
+		size:       size,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		timeOffset: timeOffset,
+		newClient:  newClient,
+	}
+}
+
+func (c *clientCache) get(apiKey string, secretKey string) *binance.Client {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[apiKey]; ok {
+
+		entry := element.Value.(*clientEntry)
+
+		if entry.secretKey == secretKey && entry.client.TimeOffset == c.timeOffset() {
+			clientsHits.Add(1)
+			c.order.MoveToFront(element)
+			return entry.client
+		}
+
+		// secret key of API key or time offset has been changed, client with the old one is useless
+		c.remove(element)
+	}
+
+	clientsMisses.Add(1)
+
+	entry := &clientEntry{apiKey: apiKey, secretKey: secretKey, client: c.newClient(apiKey, secretKey), created: time.Now()}
+
+	c.entries[apiKey] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+
+	return entry.client
+}
+
+// remove must be called under lock
+func (c *clientCache) remove(element *list.Element) {
+
+	entry := c.order.Remove(element).(*clientEntry)
+
+	delete(c.entries, entry.apiKey)
+
+	lifetime := time.Since(entry.created).Seconds()
+
+	clientsEvictions.Add(1)
+	clientsLifetimeTotal.Add(lifetime)
+
+	if lifetime > clientsLifetimeMax.Value() {
+		clientsLifetimeMax.Set(lifetime)
+	}
+}
@@ -0,0 +1,82 @@
+package ecbinance
+
+import (
+	"context"
+	"github.com/adshao/go-binance"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const timestampError = -1021
+
+// offset changes smaller than that don't renew cached clients
+const clockTolerance = 50
+
+// many workers get timestamp errors at once, only one of them resyncs the clock
+const clockResyncTime = time.Second
+
+// serverClock keeps difference between local and Binance server time in milliseconds, in terms of client.TimeOffset
+type serverClock struct {
+	lock   sync.Mutex
+	offset int64
+
+	syncLock sync.Mutex
+	synced   time.Time
+
+	ctxLog *log.Entry
+}
+
+func newServerClock() *serverClock {
+	return &serverClock{ctxLog: log.WithFields(log.Fields{"id": "BinanceClock"})}
+}
+
+func (c *serverClock) get() int64 {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.offset
+}
+
+// sync asks server time and takes the middle of the call as the local time of the answer
+func (c *serverClock) sync(client *binance.Client) error {
+
+	c.syncLock.Lock()
+	defer c.syncLock.Unlock()
+
+	if time.Since(c.synced) < clockResyncTime {
+		return nil
+	}
+
+	start := time.Now()
+
+	serverTime, err := client.NewServerTimeService().Do(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	end := time.Now()
+
+	local := start.Add(end.Sub(start)/2).UnixNano() / int64(time.Millisecond)
+
+	offset := local - serverTime
+
+	c.lock.Lock()
+
+	if diff := offset - c.offset; diff > clockTolerance || diff < -clockTolerance {
+		c.ctxLog.Info("Server time offset changed from ", c.offset, " to ", offset, " ms")
+		c.offset = offset
+	}
+
+	c.lock.Unlock()
+
+	c.synced = end
+
+	return nil
+}
+
+func isTimestampError(err error) bool {
+	return binance.IsAPIError(err) && err.(*binance.APIError).Code == timestampError
+}
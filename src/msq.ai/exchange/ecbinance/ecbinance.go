@@ -4,24 +4,196 @@ import (
 	"context"
 	"fmt"
 	"github.com/adshao/go-binance"
+	"github.com/shopspring/decimal"
 	log "github.com/sirupsen/logrus"
 	"msq.ai/connectors/connector"
 	"msq.ai/connectors/proto"
 	"msq.ai/constants"
 	"msq.ai/data/cmd"
-	"msq.ai/utils/math"
-	"strconv"
+	"msq.ai/exchange/ecbinance/orders"
 	"time"
 )
 
-const filledValue = "FILLED"
-const expiredValue = "EXPIRED"
 const orderNotExistError = -2013
+const sendStatusUnknownError = -1007
 
-func RunBinanceConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse, execPoolSize int) {
+const followPollTime = 2 * time.Second
+
+// while user data stream is connected polling is only a safety net for dropped events
+const streamPollTime = 30 * time.Second
+
+// create order response doesn't carry trade ids, so such fills are stored without them
+const unknownTradeId = -1
+
+func toFills(fills []*binance.Fill) ([]cmd.Fill, error) {
+
+	var err error
+
+	result := make([]cmd.Fill, len(fills))
+
+	for i, f := range fills {
+
+		result[i].ExternalTradeId = unknownTradeId
+		result[i].CommissionAsset = f.CommissionAsset
+
+		result[i].Quantity, err = decimal.NewFromString(f.Quantity)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i].Price, err = decimal.NewFromString(f.Price)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i].Commission, err = decimal.NewFromString(f.Commission)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// toOrder keeps fields of the order which are needed to settle it
+func toOrder(order *binance.Order) *orders.Order {
+	return &orders.Order{
+		OrderId:          order.OrderID,
+		ClientOrderId:    order.ClientOrderID,
+		Symbol:           order.Symbol,
+		Status:           string(order.Status),
+		ExecutedQuantity: order.ExecutedQuantity,
+		Time:             order.Time,
+		UpdateTime:       order.UpdateTime,
+		Description:      fmt.Sprintf("%+v", order),
+	}
+}
+
+// createdToOrder gives order of create response, which executes the order at once
+func createdToOrder(order *binance.CreateOrderResponse, description string) *orders.Order {
+	return &orders.Order{
+		OrderId:          order.OrderID,
+		ClientOrderId:    order.ClientOrderID,
+		Symbol:           order.Symbol,
+		Status:           string(order.Status),
+		ExecutedQuantity: order.ExecutedQuantity,
+		Time:             order.TransactTime,
+		UpdateTime:       order.TransactTime,
+		Description:      description,
+	}
+}
+
+func toTrades(trades []*binance.TradeV3) []*orders.Trade {
+
+	result := make([]*orders.Trade, len(trades))
+
+	for i, t := range trades {
+		result[i] = &orders.Trade{Id: t.ID, OrderId: t.OrderID, Quantity: t.Quantity, Price: t.Price,
+			Commission: t.Commission, CommissionAsset: t.CommissionAsset, Time: t.Time}
+	}
+
+	return result
+}
+
+func isAlive(status binance.OrderStatusType) bool {
+	return orders.IsAlive(string(status))
+}
+
+// Config keeps settings of Binance connector
+type Config struct {
+	ApiUrl            string
+	WsUrl             string
+	RulesRefreshTime  time.Duration
+	PricesRefreshTime time.Duration
+	RoundToRules      bool
+	ClientsCacheSize  int
+	TimeSyncTime      time.Duration
+	RecvWindow        time.Duration
+}
+
+func RunBinanceConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse, execPoolSize int, config Config,
+	limiter *Limiter) {
 
 	ctxLog := log.WithFields(log.Fields{"id": "BinanceConnector"})
 
+	if len(config.ApiUrl) < 1 {
+		ctxLog.Fatal("ApiUrl is empty !")
+	}
+
+	if len(config.WsUrl) < 1 {
+		ctxLog.Fatal("WsUrl is empty !")
+	}
+
+	if config.RulesRefreshTime <= 0 {
+		ctxLog.Fatal("RulesRefreshTime must be positive !")
+	}
+
+	if config.PricesRefreshTime <= 0 {
+		ctxLog.Fatal("PricesRefreshTime must be positive !")
+	}
+
+	if config.ClientsCacheSize < 1 {
+		ctxLog.Fatal("ClientsCacheSize less than 1 !")
+	}
+
+	if config.TimeSyncTime <= 0 {
+		ctxLog.Fatal("TimeSyncTime must be positive !")
+	}
+
+	if config.RecvWindow < time.Millisecond {
+		ctxLog.Fatal("RecvWindow less than 1 ms !")
+	}
+
+	if limiter == nil {
+		ctxLog.Fatal("limiter is nil !")
+	}
+
+	httpClient := NewHttpClient(limiter, execPoolSize)
+
+	clock := newServerClock()
+
+	newClient := func(apiKey string, secretKey string) *binance.Client {
+
+		client := binance.NewClient(apiKey, secretKey)
+
+		client.BaseURL = config.ApiUrl
+		client.HTTPClient = httpClient
+		client.TimeOffset = clock.get()
+
+		return client
+	}
+
+	clients := newClientCache(config.ClientsCacheSize, clock.get, newClient)
+
+	streams := newUserStreams(config.WsUrl, clients.get)
+
+	recvWindow := binance.WithRecvWindow(int64(config.RecvWindow / time.Millisecond))
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	go func() {
+
+		client := newClient("", "")
+
+		for {
+
+			if err := clock.sync(client); err != nil {
+				ctxLog.Error("Cannot sync server time ", err)
+			}
+
+			time.Sleep(config.TimeSyncTime)
+		}
+	}()
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	rules := RunRules(newClient("", ""), config.RulesRefreshTime, config.PricesRefreshTime, config.RoundToRules)
+
+	//------------------------------------------------------------------------------------------------------------------
+
 	orderToString := func(order *binance.CreateOrderResponse) string {
 
 		if order == nil {
@@ -30,11 +202,13 @@ func RunBinanceConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecRes
 
 		var fill = ""
 
-		if order.Fills != nil && len(order.Fills) > 0 && order.Fills[0] != nil {
-			fill = fmt.Sprintf("%+v", order.Fills[0])
+		for _, f := range order.Fills {
+			if f != nil {
+				fill = fill + fmt.Sprintf(" %+v", f)
+			}
 		}
 
-		return fmt.Sprintf("%+v %s", order, fill)
+		return fmt.Sprintf("%+v%s", order, fill)
 	}
 
 	errorResponse := func(response *proto.ExecResponse, err error) *proto.ExecResponse {
@@ -46,27 +220,253 @@ func RunBinanceConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecRes
 		return response
 	}
 
+	// signed calls Binance with client of the request, on timestamp error server time is resynced and call is repeated
+	signed := func(request *proto.ExecRequest, call func(client *binance.Client) error) error {
+
+		client := clients.get(request.RawCmd.ApiKey, request.RawCmd.SecretKey)
+
+		err := call(client)
+
+		if !isTimestampError(err) {
+			return err
+		}
+
+		ctxLog.Warn("Timestamp error, server time will be resynced ", err)
+
+		if err := clock.sync(client); err != nil {
+			ctxLog.Error("Cannot sync server time ", err)
+		}
+
+		return call(clients.get(request.RawCmd.ApiKey, request.RawCmd.SecretKey))
+	}
+
+	notFilled := func(request *proto.ExecRequest, response *proto.ExecResponse, status binance.OrderStatusType) *proto.ExecResponse {
+		return orders.NotFilled(request, response, string(status))
+	}
+
+	progress := func(request *proto.ExecRequest, status binance.OrderStatusType, executed string) {
+		orders.Progress(ctxLog, request, string(status), executed)
+	}
+
+	// follow waits till alive order gets final status, every change of status or executed quantity is reported. Order
+	// state comes from user data stream, GetOrder is polled when stream is down or too rarely to fill gaps. Returns final
+	// order with fills and balances taken from the stream, or the last state of alive order when the request may not
+	// follow it any more.
+	follow := func(request *proto.ExecRequest, events <-chan *streamEvent,
+		status binance.OrderStatusType, executed string) (*binance.Order, []cmd.Fill, []cmd.Balance) {
+
+		last := &binance.Order{Symbol: request.RawCmd.Instrument, ClientOrderID: request.RawCmd.Id, Status: status,
+			ExecutedQuantity: executed}
+
+		fills := make([]cmd.Fill, 0)
+		balances := make(map[string]cmd.Balance)
+
+		result := func(order *binance.Order) (*binance.Order, []cmd.Fill, []cmd.Balance) {
+
+			list := make([]cmd.Balance, 0, len(balances))
+
+			for _, b := range balances {
+				list = append(list, b)
+			}
+
+			return order, fills, list
+		}
+
+		progress(request, status, executed)
+
+		for {
+
+			if !request.KeepFollowing() {
+				return result(last)
+			}
+
+			pollTime := followPollTime
+
+			if streams.isConnected(request.RawCmd.ApiKey) {
+				pollTime = streamPollTime
+			}
+
+			// the last state is polled when follow time ends, so parked order isn't older than it
+			if till := time.Until(request.FollowTill); till < pollTime {
+				pollTime = till
+			}
+
+			var order *binance.Order
+
+			select {
+
+			case event := <-events:
+
+				if event.report == nil {
+
+					for _, b := range event.balances {
+						balances[b.Asset] = b
+					}
+
+					continue
+				}
+
+				ctxLog.Trace("Execution report ", event.report)
+
+				if event.report.ExecutionType == tradeExecutionType {
+
+					fill, err := event.report.toFill()
+
+					if err != nil {
+						ctxLog.Error("Cannot parse execution report fill ", err)
+					} else {
+						fills = append(fills, fill)
+					}
+				}
+
+				order = event.report.toOrder()
+
+			case <-time.After(pollTime):
+
+				err := signed(request, func(client *binance.Client) (err error) {
+					order, err = client.NewGetOrderService().Symbol(request.RawCmd.Instrument).
+						OrigClientOrderID(request.RawCmd.Id).Do(context.Background(), recvWindow)
+					return err
+				})
+
+				if err != nil {
+					ctxLog.Error("Follow error ", err)
+					continue
+				}
+			}
+
+			last = order
+
+			if !isAlive(order.Status) {
+				return result(order)
+			}
+
+			if order.Status != status || order.ExecutedQuantity != executed {
+
+				status = order.Status
+				executed = order.ExecutedQuantity
+
+				progress(request, status, executed)
+			}
+		}
+	}
+
+	// settle builds final response of existing order, fills from the stream are used when they cover executed quantity,
+	// otherwise they are taken from account trade list
+	settle := func(request *proto.ExecRequest, response *proto.ExecResponse, order *orders.Order,
+		fills []cmd.Fill, balances []cmd.Balance) *proto.ExecResponse {
+
+		if len(balances) > 0 {
+			response.Balances = balances
+		}
+
+		list := func(symbol string, startTime int64, fromId int64, limit int) ([]*orders.Trade, error) {
+
+			var trades []*binance.TradeV3
+
+			err := signed(request, func(client *binance.Client) (err error) {
+
+				service := client.NewListTradesService().Symbol(symbol).Limit(limit)
+
+				if fromId < 0 {
+					service = service.StartTime(startTime)
+				} else {
+					service = service.FromID(fromId)
+				}
+
+				trades, err = service.Do(context.Background(), recvWindow)
+				return err
+			})
+
+			if err != nil {
+				return nil, err
+			}
+
+			return toTrades(trades), nil
+		}
+
+		return orders.Settle(ctxLog, request, response, order, fills, list)
+	}
+
+	// finish follows existing order till final status and settles it, the order is parked when it is still alive
+	finish := func(request *proto.ExecRequest, response *proto.ExecResponse, events <-chan *streamEvent,
+		order *binance.Order) *proto.ExecResponse {
+
+		if isAlive(order.Status) {
+
+			final, fills, balances := follow(request, events, order.Status, order.ExecutedQuantity)
+
+			if isAlive(final.Status) {
+				return orders.Park(response, string(final.Status), final.ExecutedQuantity)
+			}
+
+			return settle(request, response, toOrder(final), fills, balances)
+		}
+
+		return settle(request, response, toOrder(order), nil, nil)
+	}
+
+	getOrder := func(request *proto.ExecRequest) (order *binance.Order, err error) {
+
+		err = signed(request, func(client *binance.Client) (err error) {
+			order, err = client.NewGetOrderService().Symbol(request.RawCmd.Instrument).
+				OrigClientOrderID(request.RawCmd.Id).Do(context.Background(), recvWindow)
+			return err
+		})
+
+		return order, err
+	}
+
+	// findLost looks for the order which answer is lost by its client order id, unknown order is parked, so it is
+	// checked again later and traded again or timed out then
+	findLost := func(request *proto.ExecRequest, response *proto.ExecResponse, events <-chan *streamEvent,
+		cause error) *proto.ExecResponse {
+
+		order, err := getOrder(request)
+
+		if err != nil {
+			ctxLog.Warn("Order of lost answer isn't found ", err)
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown after ["+cause.Error()+"]")
+		}
+
+		ctxLog.Info("Order of lost answer is found ", order)
+
+		return finish(request, response, events, order)
+	}
+
 	trade := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
 
-		client := binance.NewClient(request.RawCmd.ApiKey, request.RawCmd.SecretKey)
+		adjusted, loaded, err := rules.Apply(request.RawCmd)
+
+		if err != nil {
+			response.Description = "Trading rules violation: " + err.Error()
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		if !loaded {
+			ctxLog.Warn("Trading rules aren't loaded yet, command is sent without validation ", request.RawCmd)
+		} else if len(adjusted) > 0 {
+			ctxLog.Info("Command adjusted to trading rules ", adjusted, request.RawCmd)
+		}
 
-		orderService := client.NewCreateOrderService().Symbol(request.RawCmd.Instrument)
-		orderService = orderService.NewClientOrderID(request.RawCmd.Id)
+		var orderType binance.OrderType
+		var side binance.SideType
+		var timeInForce binance.TimeInForceType
 
 		if request.RawCmd.OrderType == constants.OrderTypeMarketName {
-			orderService = orderService.Type(binance.OrderTypeMarket)
+			orderType = binance.OrderTypeMarket
 		} else if request.RawCmd.OrderType == constants.OrderTypeLimitName {
-			orderService = orderService.Type(binance.OrderTypeLimit)
-			orderService = orderService.Price(request.RawCmd.LimitPrice)
+			orderType = binance.OrderTypeLimit
 		} else {
 			ctxLog.Fatal("Protocol violation! ExecRequest wrong OrderType ! ", request)
 			return nil
 		}
 
 		if request.RawCmd.Direction == constants.OrderDirectionBuyName {
-			orderService = orderService.Side(binance.SideTypeBuy)
+			side = binance.SideTypeBuy
 		} else if request.RawCmd.Direction == constants.OrderDirectionSellName {
-			orderService = orderService.Side(binance.SideTypeSell)
+			side = binance.SideTypeSell
 		} else {
 			ctxLog.Fatal("Protocol violation! ExecRequest wrong Direction with empty cmd ! ", request)
 			return nil
@@ -74,9 +474,9 @@ func RunBinanceConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecRes
 
 		if request.RawCmd.OrderType == constants.OrderTypeLimitName {
 			if request.RawCmd.TimeInForce == constants.TimeInForceGtcName {
-				orderService = orderService.TimeInForce(binance.TimeInForceGTC)
+				timeInForce = binance.TimeInForceGTC
 			} else if request.RawCmd.TimeInForce == constants.TimeInForceFokName {
-				orderService = orderService.TimeInForce(binance.TimeInForceFOK)
+				timeInForce = binance.TimeInForceFOK
 			} else {
 				msg := "Protocol violation! ExecRequest has wrong TimeInForce."
 				ctxLog.Error(msg, request)
@@ -85,80 +485,106 @@ func RunBinanceConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecRes
 			}
 		}
 
-		orderService = orderService.Quantity(request.RawCmd.Amount)
+		events := streams.subscribe(request.RawCmd.ApiKey, request.RawCmd.SecretKey, request.RawCmd.Id)
+		defer streams.unsubscribe(request.RawCmd.ApiKey, request.RawCmd.Id)
 
-		start := time.Now()
+		var order *binance.CreateOrderResponse
 
-		order, err := orderService.Do(context.Background())
+		// rejected requests are repeated, order isn't created by Binance on rate limit and timestamp errors
+		for {
 
-		response.OutsideExecution = time.Now().Sub(start)
+			client := clients.get(request.RawCmd.ApiKey, request.RawCmd.SecretKey)
 
-		if err != nil {
-			ctxLog.Error("Trade error ", err)
-			response.Description = err.Error()
-			return response
-		}
+			orderService := client.NewCreateOrderService().Symbol(request.RawCmd.Instrument)
+			orderService = orderService.NewClientOrderID(request.RawCmd.Id)
+			orderService = orderService.Type(orderType).Side(side).Quantity(request.RawCmd.Amount)
 
-		response.Description = orderToString(order)
+			if orderType == binance.OrderTypeLimit {
+				orderService = orderService.Price(request.RawCmd.LimitPrice).TimeInForce(timeInForce)
+			}
 
-		ctxLog.Trace("Order from Binance ", response.Description)
+			start := time.Now()
 
-		if request.RawCmd.OrderType == constants.OrderTypeMarketName {
+			order, err = orderService.Do(context.Background(), recvWindow)
 
-			if order.Status != filledValue {
-				response.Description = "Order wasn't fill"
-				return response
+			response.OutsideExecution = time.Now().Sub(start)
+
+			if err == nil {
+				break
 			}
 
-		} else if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+			if IsRateLimitError(err) {
 
-			if order.Status == expiredValue {
-				response.Description = "Order rejected"
-				response.Status = proto.StatusRejected
-				return response
-			} else if order.Status != filledValue {
-				response.Description = "Order wasn't fill"
+				ctxLog.Warn("Trade is rate limited, will be repeated after back off ", err)
+
+				time.Sleep(limiter.PauseTime())
+
+			} else if isTimestampError(err) {
+
+				ctxLog.Warn("Trade timestamp error, will be repeated after server time resync ", err)
+
+				if err := clock.sync(client); err != nil {
+					ctxLog.Error("Cannot sync server time ", err)
+				}
+
+			} else {
+				break
+			}
+
+			if request.Cmd.ExecuteTillTime.Before(time.Now()) {
+				response.Description = "TimedOut while repeating rejected trade " + err.Error()
+				response.Status = proto.StatusTimedOut
 				return response
 			}
+		}
 
-		} else {
-			ctxLog.Fatal("Protocol violation! ExecRequest has wrong OrderType.", request)
+		// the order may be created when Binance doesn't answer or doesn't know the result
+		if err != nil && (!binance.IsAPIError(err) || err.(*binance.APIError).Code == sendStatusUnknownError) {
+			ctxLog.Error("Trade answer is lost ", err)
+			return findLost(request, response, events, err)
+		}
+
+		if err != nil {
+			ctxLog.Error("Trade error ", err)
+			response.Description = err.Error()
+			return response
 		}
 
-		response.Order = &cmd.Order{}
+		response.Description = adjusted + orderToString(order)
 
-		response.Order.ExternalOrderId = order.OrderID
+		ctxLog.Trace("Order from Binance ", response.Description)
 
-		response.Order.ExecutionId, err = strconv.ParseInt(order.ClientOrderID, 10, 64)
+		if isAlive(order.Status) {
 
-		if err != nil {
-			return errorResponse(response, err)
-		}
+			final, fills, balances := follow(request, events, order.Status, order.ExecutedQuantity)
 
-		response.Order.Price, err = strconv.ParseFloat(order.Fills[0].Price, 64)
+			if isAlive(final.Status) {
+				return orders.Park(response, string(final.Status), final.ExecutedQuantity)
+			}
 
-		if err != nil {
-			return errorResponse(response, err)
+			return settle(request, response, toOrder(final), fills, balances)
+		}
+
+		if order.Status != orders.FilledValue && len(order.Fills) == 0 {
+			return notFilled(request, response, order.Status)
 		}
 
-		response.Order.Commission, err = strconv.ParseFloat(order.Fills[0].Commission, 64)
+		fills, err := toFills(order.Fills)
 
 		if err != nil {
 			return errorResponse(response, err)
 		}
 
-		response.Order.CommissionAsset = order.Fills[0].CommissionAsset
-
-		response.Status = proto.StatusOk
-
-		return response
+		// fills of the response are used when they cover executed quantity, ACK or RESULT response has none of them
+		return settle(request, response, createdToOrder(order, response.Description), fills, nil)
 	}
 
 	check := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
 
-		client := binance.NewClient(request.RawCmd.ApiKey, request.RawCmd.SecretKey)
+		events := streams.subscribe(request.RawCmd.ApiKey, request.RawCmd.SecretKey, request.RawCmd.Id)
+		defer streams.unsubscribe(request.RawCmd.ApiKey, request.RawCmd.Id)
 
-		order, err := client.NewGetOrderService().Symbol(request.RawCmd.Instrument).OrigClientOrderID(request.RawCmd.Id).Do(context.Background())
+		order, err := getOrder(request)
 
 		if err != nil {
 
@@ -182,60 +608,23 @@ func RunBinanceConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecRes
 
 		ctxLog.Trace("Order from Binance ", order)
 
-		response.Description = fmt.Sprintf("%+v", order)
-
-		if request.RawCmd.OrderType == constants.OrderTypeMarketName {
-
-			if order.Status != filledValue {
-				response.Description = "Order wasn't fill"
-				return response
-			}
-
-		} else if request.RawCmd.OrderType == constants.OrderTypeLimitName {
-
-			if order.Status == expiredValue {
-				response.Description = "Order rejected"
-				response.Status = proto.StatusRejected
-				return response
-			} else if order.Status != filledValue {
-				response.Description = "Order wasn't fill"
-				return response
-			}
-
-		} else {
-			ctxLog.Fatal("Protocol violation! ExecRequest has wrong OrderType.", request)
-		}
-
-		response.Order = &cmd.Order{}
-
-		response.Order.ExternalOrderId = order.OrderID
-
-		response.Order.ExecutionId, err = strconv.ParseInt(order.ClientOrderID, 10, 64)
-
-		if err != nil {
-			return errorResponse(response, err)
-		}
-
-		response.Order.Price, err = strconv.ParseFloat(order.Price, 64)
-
-		if err != nil {
-			return errorResponse(response, err)
-		}
-
-		response.Order.Commission = 0
-
-		response.Order.CommissionAsset = "UNKNOWN"
-
-		response.Status = proto.StatusOk
-
-		return response
+		return finish(request, response, events, order)
 	}
 
 	info := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
 
-		client := binance.NewClient(request.RawCmd.ApiKey, request.RawCmd.SecretKey)
+		if request.RawCmd.OrderType != constants.OrderTypeInfoName {
+			response.Description = "Order type " + request.RawCmd.OrderType + " isn't supported by Binance spot"
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		var account *binance.Account
 
-		account, err := client.NewGetAccountService().Do(context.Background())
+		err := signed(request, func(client *binance.Client) (err error) {
+			account, err = client.NewGetAccountService().Do(context.Background(), recvWindow)
+			return err
+		})
 
 		if err != nil {
 			ctxLog.Error("Info error ", err)
@@ -247,19 +636,19 @@ func RunBinanceConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecRes
 
 		for _, b := range account.Balances {
 
-			free, err := strconv.ParseFloat(b.Free, 64)
+			free, err := decimal.NewFromString(b.Free)
 
 			if err != nil {
 				return errorResponse(response, err)
 			}
 
-			locked, err := strconv.ParseFloat(b.Locked, 64)
+			locked, err := decimal.NewFromString(b.Locked)
 
 			if err != nil {
 				return errorResponse(response, err)
 			}
 
-			if !math.IsZero(free) || !math.IsZero(locked) {
+			if !free.IsZero() || !locked.IsZero() {
 				response.Balances = append(response.Balances, cmd.Balance{Asset: b.Asset, Free: free, Locked: locked})
 			}
 		}
@@ -0,0 +1,251 @@
+package ecbinance
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"msq.ai/exchange/ecbinance/mock"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTimeout = 20 * time.Second
+
+type testConnector struct {
+	server *mock.Server
+	in     chan *proto.ExecRequest
+	out    chan *proto.ExecResponse
+}
+
+// startConnector runs connector against mock Binance with BTCUSDT at 10000, wrap may change answers of the mock
+func startConnector(t *testing.T, wrap func(http.Handler) http.Handler) *testConnector {
+
+	c := &testConnector{
+		server: mock.NewServer(
+			[]mock.Symbol{{Name: "BTCUSDT", Base: "BTC", Quote: "USDT", Price: decimal.NewFromInt(10000)}},
+			map[string]decimal.Decimal{"BTC": decimal.NewFromInt(10), "USDT": decimal.NewFromInt(100000)},
+			mock.Outcome{Status: mock.OutcomeFilled}),
+		in:  make(chan *proto.ExecRequest),
+		out: make(chan *proto.ExecResponse, 100),
+	}
+
+	handler := c.server.Handler()
+
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+
+	httpServer := httptest.NewServer(handler)
+
+	t.Cleanup(httpServer.Close)
+
+	config := Config{
+		ApiUrl:            httpServer.URL,
+		WsUrl:             "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws",
+		RulesRefreshTime:  time.Minute,
+		PricesRefreshTime: time.Minute,
+		ClientsCacheSize:  10,
+		TimeSyncTime:      time.Minute,
+		RecvWindow:        5 * time.Second,
+	}
+
+	RunBinanceConnector(c.in, c.out, 2, config, NewLimiter(1200, 50))
+
+	return c
+}
+
+func buy(id int64, orderType string, amount string, price string) *cmd.RawCommand {
+
+	raw := &cmd.RawCommand{Id: strconv.FormatInt(id, 10), Instrument: "BTCUSDT",
+		Direction: constants.OrderDirectionBuyName, OrderType: orderType, Amount: amount, ApiKey: "key",
+		SecretKey: "secret"}
+
+	if orderType == constants.OrderTypeLimitName {
+		raw.LimitPrice = price
+		raw.TimeInForce = constants.TimeInForceGtcName
+	}
+
+	return raw
+}
+
+// request wraps command as coordinator does
+func request(what proto.ExecType, raw *cmd.RawCommand, executeFor time.Duration,
+	followFor time.Duration) *proto.ExecRequest {
+
+	now := time.Now()
+
+	id, _ := strconv.ParseInt(raw.Id, 10, 64)
+
+	command := &cmd.Command{Id: id, InstrumentName: raw.Instrument, ExecuteTillTime: now.Add(executeFor),
+		ApiKey: raw.ApiKey, SecretKey: raw.SecretKey}
+
+	command.Amount, _ = decimal.NewFromString(raw.Amount)
+	command.LimitPrice, _ = decimal.NewFromString(raw.LimitPrice)
+
+	return &proto.ExecRequest{What: what, RawCmd: raw, Cmd: command, FollowTill: now.Add(followFor)}
+}
+
+// execute sends the request and waits for its last response, progress responses are skipped
+func (c *testConnector) execute(t *testing.T, r *proto.ExecRequest) *proto.ExecResponse {
+
+	t.Helper()
+
+	c.in <- r
+
+	deadline := time.After(testTimeout)
+
+	for {
+		select {
+		case <-deadline:
+			t.Fatal("No response of command ", r.RawCmd.Id)
+			return nil
+		case response := <-c.out:
+			if response.Request == r && (proto.IsFinal(response.Status) || response.Parked) {
+				return response
+			}
+		}
+	}
+}
+
+// withoutFills drops fills from create order responses as Binance does for RESULT and ACK response types
+func withoutFills(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v3/order" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := httptest.NewRecorder()
+
+		next.ServeHTTP(recorder, r)
+
+		var body map[string]interface{}
+
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err == nil {
+			delete(body, "fills")
+		}
+
+		var buffer bytes.Buffer
+
+		_ = json.NewEncoder(&buffer).Encode(body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(buffer.Bytes())
+	})
+}
+
+func TestFilledOrderIsSettledWithFillsOfResponse(t *testing.T) {
+
+	c := startConnector(t, nil)
+
+	response := c.execute(t, request(proto.ExecuteCmd, buy(1, constants.OrderTypeMarketName, "0.03", ""), time.Minute,
+		time.Minute))
+
+	if response.Status != proto.StatusOk || len(response.Order.Fills) != 1 {
+		t.Fatal("Filled order isn't settled with its fill ", response.Status, " ", response.Description)
+	}
+
+	// fills of create response carry no trade id
+	if response.Order.Fills[0].ExternalTradeId != unknownTradeId || response.Order.ExecutionId != 1 {
+		t.Error("Fills aren't taken from response ", response.Order)
+	}
+
+	if !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.03")) ||
+		!response.Order.Price.Equal(decimal.NewFromInt(10000)) {
+		t.Error("Wrong order ", response.Order)
+	}
+}
+
+func TestFilledOrderWithoutFillsIsSettledFromTrades(t *testing.T) {
+
+	c := startConnector(t, withoutFills)
+
+	response := c.execute(t, request(proto.ExecuteCmd, buy(1, constants.OrderTypeMarketName, "0.01", ""), time.Minute,
+		time.Minute))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Filled order isn't settled ", response.Status, " ", response.Description)
+	}
+
+	if len(response.Order.Fills) != 1 || response.Order.Fills[0].ExternalTradeId == unknownTradeId {
+		t.Fatal("Fills aren't taken from trade list ", response.Order.Fills)
+	}
+
+	if !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.01")) {
+		t.Error("Wrong executed quantity ", response.Order.ExecutedQuantity)
+	}
+}
+
+func TestRestingOrderIsFollowedTillFilled(t *testing.T) {
+
+	c := startConnector(t, nil)
+
+	c.server.Push(mock.Outcome{Status: mock.OutcomePartiallyFilled, FillAfter: 500 * time.Millisecond})
+
+	r := request(proto.ExecuteCmd, buy(1, constants.OrderTypeLimitName, "0.02", "9000"), time.Minute, time.Minute)
+
+	response := c.execute(t, r)
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.02")) {
+		t.Fatal("Resting order isn't followed till filled ", response.Status, " ", response.Description)
+	}
+}
+
+func TestRestingOrderIsParkedAndChecked(t *testing.T) {
+
+	c := startConnector(t, nil)
+
+	c.server.Push(mock.Outcome{Status: mock.OutcomePartiallyFilled})
+
+	raw := buy(1, constants.OrderTypeLimitName, "0.02", "9000")
+
+	response := c.execute(t, request(proto.ExecuteCmd, raw, time.Minute, time.Second))
+
+	if response.Status != proto.StatusPartiallyFilled || !response.Parked ||
+		!response.ExecutedQuantity.Equal(decimal.RequireFromString("0.01")) {
+		t.Fatal("Resting order isn't parked with executed quantity ", response.Status, " ", response.Description)
+	}
+
+	// parked poll of coordinator follows the order no more
+	start := time.Now()
+
+	response = c.execute(t, request(proto.CheckCmd, raw, time.Minute, 0))
+
+	if response.Status != proto.StatusPartiallyFilled || !response.Parked {
+		t.Fatal("Parked order isn't parked by check ", response.Status, " ", response.Description)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Error("Parked order is followed by check ", time.Since(start))
+	}
+}
+
+func TestLostAnswerIsRecoveredByClientOrderId(t *testing.T) {
+
+	c := startConnector(t, nil)
+
+	// the order is filled, but connection is closed instead of the answer
+	c.server.Push(mock.Outcome{Status: mock.OutcomeLost})
+
+	response := c.execute(t, request(proto.ExecuteCmd, buy(1, constants.OrderTypeMarketName, "0.03", ""), time.Minute,
+		time.Minute))
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.03")) {
+		t.Fatal("Order of lost answer isn't settled ", response.Status, " ", response.Description)
+	}
+
+	// the order is settled from the account trade list, the recovered order has no fills of its own
+	if len(response.Order.Fills) != 1 || response.Order.Fills[0].ExternalTradeId == unknownTradeId {
+		t.Error("Order of lost answer isn't settled from trades ", response.Order)
+	}
+}
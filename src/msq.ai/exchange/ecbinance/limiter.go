@@ -0,0 +1,231 @@
+package ecbinance
+
+import (
+	"github.com/adshao/go-binance"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usedWeightHeader = "X-Mbx-Used-Weight-1m"
+const orderCount10sHeader = "X-Mbx-Order-Count-10s"
+const retryAfterHeader = "Retry-After"
+const apiKeyHeader = "X-Mbx-Apikey"
+
+const tooManyRequestsError = -1003
+const tooManyOrdersError = -1015
+
+// used when Retry-After is absent in 429/418 response
+const defaultRetryAfter = 60 * time.Second
+
+const weightWindow = time.Minute
+const ordersWindow = 10 * time.Second
+
+// weights of endpoints we call, everything else is counted as 1
+var endpointWeights = map[string]int{
+	"/api/v3/order":        2,
+	"/api/v3/account":      10,
+	"/api/v3/myTrades":     10,
+	"/api/v3/exchangeInfo": 10,
+	"/fapi/v1/order":       1,
+	"/fapi/v1/account":     5,
+	"/fapi/v1/userTrades":  5,
+}
+
+type keyOrders struct {
+	window time.Time
+	count  int
+}
+
+// Limiter is shared by all workers of Binance connector, it keeps request weight of our IP and order count of every
+// API key within Binance limits and stops everything when Binance asks to back off.
+type Limiter struct {
+	lock sync.Mutex
+
+	weightLimit int
+	weightStart time.Time
+	weightUsed  int
+
+	ordersLimit int
+	orders      map[string]*keyOrders
+
+	bannedUntil time.Time
+
+	ctxLog *log.Entry
+}
+
+func NewLimiter(weightLimit int, ordersLimit int) *Limiter {
+
+	ctxLog := log.WithFields(log.Fields{"id": "BinanceLimiter"})
+
+	if weightLimit < 1 {
+		ctxLog.Fatal("weightLimit less than 1 !")
+	}
+
+	if ordersLimit < 1 {
+		ctxLog.Fatal("ordersLimit less than 1 !")
+	}
+
+	return &Limiter{weightLimit: weightLimit, ordersLimit: ordersLimit, orders: make(map[string]*keyOrders), ctxLog: ctxLog}
+}
+
+// PauseTime returns how long new commands shouldn't be claimed, it is zero when Binance doesn't ask us to back off
+func (l *Limiter) PauseTime() time.Duration {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+
+	if l.bannedUntil.After(now) {
+		return l.bannedUntil.Sub(now)
+	}
+
+	return 0
+}
+
+// acquire blocks till request can be sent without breaking the limits
+func (l *Limiter) acquire(apiKey string, weight int, isOrder bool) {
+
+	for {
+
+		wait := l.tryAcquire(apiKey, weight, isOrder, time.Now())
+
+		if wait <= 0 {
+			return
+		}
+
+		l.ctxLog.Trace("Waiting for rate limits ", wait)
+
+		time.Sleep(wait)
+	}
+}
+
+func (l *Limiter) tryAcquire(apiKey string, weight int, isOrder bool, now time.Time) time.Duration {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.bannedUntil.After(now) {
+		return l.bannedUntil.Sub(now)
+	}
+
+	if now.Sub(l.weightStart) >= weightWindow {
+		l.weightStart = now.Truncate(weightWindow)
+		l.weightUsed = 0
+	}
+
+	if l.weightUsed+weight > l.weightLimit {
+		return l.weightStart.Add(weightWindow).Sub(now)
+	}
+
+	var ko *keyOrders
+
+	if isOrder {
+
+		ko = l.orders[apiKey]
+
+		if ko == nil {
+			ko = &keyOrders{}
+			l.orders[apiKey] = ko
+		}
+
+		if now.Sub(ko.window) >= ordersWindow {
+			ko.window = now.Truncate(ordersWindow)
+			ko.count = 0
+		}
+
+		if ko.count+1 > l.ordersLimit {
+			return ko.window.Add(ordersWindow).Sub(now)
+		}
+
+		ko.count++
+	}
+
+	l.weightUsed += weight
+
+	return 0
+}
+
+// update takes actual usage reported by Binance, it is authoritative as other processes may share our IP and keys
+func (l *Limiter) update(apiKey string, response *http.Response) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if used, err := strconv.Atoi(response.Header.Get(usedWeightHeader)); err == nil && used > l.weightUsed {
+		l.weightUsed = used
+	}
+
+	if ko := l.orders[apiKey]; ko != nil {
+		if count, err := strconv.Atoi(response.Header.Get(orderCount10sHeader)); err == nil && count > ko.count {
+			ko.count = count
+		}
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusTeapot {
+
+		retryAfter := defaultRetryAfter
+
+		if seconds, err := strconv.Atoi(response.Header.Get(retryAfterHeader)); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+
+		until := time.Now().Add(retryAfter)
+
+		if until.After(l.bannedUntil) {
+			l.bannedUntil = until
+		}
+
+		l.ctxLog.Error("Binance asks to back off, status ", response.StatusCode, " retry after ", retryAfter)
+	}
+}
+
+// wrap returns transport which passes every request through the limiter
+func (l *Limiter) wrap(next http.RoundTripper) http.RoundTripper {
+	return &limitedTransport{limiter: l, next: next}
+}
+
+type limitedTransport struct {
+	limiter *Limiter
+	next    http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+
+	apiKey := request.Header.Get(apiKeyHeader)
+
+	weight, ok := endpointWeights[request.URL.Path]
+
+	if !ok {
+		weight = 1
+	}
+
+	isOrder := request.Method == http.MethodPost && strings.HasSuffix(request.URL.Path, "/order")
+
+	t.limiter.acquire(apiKey, weight, isOrder)
+
+	response, err := t.next.RoundTrip(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.limiter.update(apiKey, response)
+
+	return response, nil
+}
+
+func IsRateLimitError(err error) bool {
+
+	if !binance.IsAPIError(err) {
+		return false
+	}
+
+	code := err.(*binance.APIError).Code
+
+	return code == tooManyRequestsError || code == tooManyOrdersError
+}
@@ -0,0 +1,825 @@
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiKeyHeader = "X-MBX-APIKEY"
+
+const orderPath = "/api/v3/order"
+const accountPath = "/api/v3/account"
+const tradesPath = "/api/v3/myTrades"
+const exchangeInfoPath = "/api/v3/exchangeInfo"
+const tickerPricePath = "/api/v3/ticker/price"
+const timePath = "/api/v3/time"
+const userStreamPath = "/api/v3/userDataStream"
+const wsPath = "/ws/"
+const outcomesPath = "/mock/outcomes"
+
+const buySide = "BUY"
+const limitType = "LIMIT"
+
+const tradesDefaultLimit = 500
+const tradesMaxLimit = 1000
+
+// all amounts are formatted with precision of Binance
+const precision = 8
+
+var commissionRate = decimal.New(1, -3)
+
+// Symbol is traded by mock at the fixed price, limit orders are executed at their limit price
+type Symbol struct {
+	Name  string
+	Base  string
+	Quote string
+	Price decimal.Decimal
+}
+
+// ParseSymbols parses comma separated list of symbols in format SYMBOL:BASE:QUOTE:PRICE, e.g. BTTBTC:BTT:BTC:0.00000005
+func ParseSymbols(spec string) ([]Symbol, error) {
+
+	result := make([]Symbol, 0)
+
+	for _, s := range strings.Split(spec, ",") {
+
+		if len(strings.TrimSpace(s)) == 0 {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSpace(s), ":")
+
+		if len(parts) != 4 {
+			return nil, errors.New("wrong symbol [" + s + "]")
+		}
+
+		price, err := decimal.NewFromString(parts[3])
+
+		if err != nil || !price.IsPositive() {
+			return nil, errors.New("wrong price of symbol [" + s + "]")
+		}
+
+		result = append(result, Symbol{Name: parts[0], Base: parts[1], Quote: parts[2], Price: price})
+	}
+
+	return result, nil
+}
+
+// ParseBalances parses comma separated list of balances in format ASSET:FREE, e.g. BTC:1,BTT:100000
+func ParseBalances(spec string) (map[string]decimal.Decimal, error) {
+
+	result := make(map[string]decimal.Decimal)
+
+	for _, s := range strings.Split(spec, ",") {
+
+		if len(strings.TrimSpace(s)) == 0 {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSpace(s), ":")
+
+		if len(parts) != 2 {
+			return nil, errors.New("wrong balance [" + s + "]")
+		}
+
+		free, err := decimal.NewFromString(parts[1])
+
+		if err != nil {
+			return nil, errors.New("wrong value of balance [" + s + "]")
+		}
+
+		result[parts[0]] = free
+	}
+
+	return result, nil
+}
+
+type order struct {
+	symbol        *Symbol
+	id            int64
+	clientOrderId string
+	side          string
+	orderType     string
+	timeInForce   string
+	price         decimal.Decimal
+	quantity      decimal.Decimal
+	executed      decimal.Decimal
+	quoteExecuted decimal.Decimal
+	status        string
+	time          int64
+	updateTime    int64
+}
+
+func (o *order) isAlive() bool {
+	return o.status == OutcomeNew || o.status == OutcomePartiallyFilled
+}
+
+type trade struct {
+	id              int64
+	order           *order
+	price           decimal.Decimal
+	quantity        decimal.Decimal
+	commission      decimal.Decimal
+	commissionAsset string
+	time            int64
+}
+
+type account struct {
+	balances map[string]decimal.Decimal
+	orders   map[string]*order
+	trades   []*trade
+	streams  map[*websocket.Conn]bool
+}
+
+// Server imitates Binance spot REST API and user data stream for one process, every API key gets its own account
+// with initial balances. What happens with created orders is scripted with outcomes.
+type Server struct {
+	lock sync.Mutex
+
+	symbols         map[string]*Symbol
+	initialBalances map[string]decimal.Decimal
+	minNotional     decimal.Decimal
+
+	defaultOutcome Outcome
+	outcomes       []Outcome
+
+	accounts   map[string]*account
+	listenKeys map[string]string
+
+	nextOrderId int64
+	nextTradeId int64
+
+	upgrader websocket.Upgrader
+
+	ctxLog *log.Entry
+}
+
+func NewServer(symbols []Symbol, balances map[string]decimal.Decimal, defaultOutcome Outcome) *Server {
+
+	s := &Server{
+		symbols:         make(map[string]*Symbol),
+		initialBalances: balances,
+		defaultOutcome:  defaultOutcome,
+		outcomes:        make([]Outcome, 0),
+		accounts:        make(map[string]*account),
+		listenKeys:      make(map[string]string),
+		nextOrderId:     1,
+		nextTradeId:     1,
+		ctxLog:          log.WithFields(log.Fields{"id": "BinanceMock"}),
+	}
+
+	for i := range symbols {
+		s.symbols[symbols[i].Name] = &symbols[i]
+	}
+
+	return s
+}
+
+// Push appends outcomes to the script, they are taken by created orders one by one, default outcome is used after
+func (s *Server) Push(outcomes ...Outcome) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.outcomes = append(s.outcomes, outcomes...)
+}
+
+// SetMinNotional sets MIN_NOTIONAL of all symbols, orders of less notional are rejected, MARKET ones at fixed price
+func (s *Server) SetMinNotional(minNotional decimal.Decimal) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.minNotional = minNotional
+}
+
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(orderPath, s.handleOrder)
+	mux.HandleFunc(accountPath, s.handleAccount)
+	mux.HandleFunc(tradesPath, s.handleTrades)
+	mux.HandleFunc(exchangeInfoPath, s.handleExchangeInfo)
+	mux.HandleFunc(tickerPricePath, s.handleTickerPrice)
+	mux.HandleFunc(timePath, s.handleTime)
+	mux.HandleFunc(userStreamPath, s.handleUserStream)
+	mux.HandleFunc(wsPath, s.handleWs)
+	mux.HandleFunc(outcomesPath, s.handleOutcomes)
+
+	return mux
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func format(val decimal.Decimal) string {
+	return val.StringFixed(precision)
+}
+
+// params merges query and form body, DELETE body isn't parsed by net/http
+func params(r *http.Request) (url.Values, error) {
+
+	result := r.URL.Query()
+
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	form, err := url.ParseQuery(string(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range form {
+		result[k] = append(result[k], v...)
+	}
+
+	return result, nil
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func apiError(w http.ResponseWriter, status int, code int, msg string) {
+	writeJson(w, status, map[string]interface{}{"code": code, "msg": msg})
+}
+
+// getAccount must be called under lock
+func (s *Server) getAccount(apiKey string) *account {
+
+	a := s.accounts[apiKey]
+
+	if a == nil {
+
+		a = &account{
+			balances: make(map[string]decimal.Decimal),
+			orders:   make(map[string]*order),
+			trades:   make([]*trade, 0),
+			streams:  make(map[*websocket.Conn]bool),
+		}
+
+		for asset, free := range s.initialBalances {
+			a.balances[asset] = free
+		}
+
+		s.accounts[apiKey] = a
+	}
+
+	return a
+}
+
+// signedAccount returns account of the request or writes error if request has no API key
+func (s *Server) signedAccount(w http.ResponseWriter, r *http.Request) *account {
+
+	apiKey := r.Header.Get(apiKeyHeader)
+
+	if len(apiKey) == 0 {
+		apiError(w, http.StatusUnauthorized, -2014, "API-key format invalid.")
+		return nil
+	}
+
+	return s.getAccount(apiKey)
+}
+
+func (s *Server) nextOutcome() Outcome {
+
+	if len(s.outcomes) == 0 {
+		return s.defaultOutcome
+	}
+
+	outcome := s.outcomes[0]
+
+	s.outcomes = s.outcomes[1:]
+
+	return outcome
+}
+
+func (s *Server) findOrder(a *account, p url.Values) *order {
+
+	if clientOrderId := p.Get("origClientOrderId"); len(clientOrderId) > 0 {
+
+		if o := a.orders[clientOrderId]; o != nil && o.symbol.Name == p.Get("symbol") {
+			return o
+		}
+
+		return nil
+	}
+
+	id, err := strconv.ParseInt(p.Get("orderId"), 10, 64)
+
+	if err != nil {
+		return nil
+	}
+
+	for _, o := range a.orders {
+		if o.id == id && o.symbol.Name == p.Get("symbol") {
+			return o
+		}
+	}
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+// fill executes quantity of the order, must be called under lock
+func (s *Server) fill(a *account, o *order, quantity decimal.Decimal) *trade {
+
+	t := &trade{id: s.nextTradeId, order: o, price: o.price, quantity: quantity, time: now()}
+
+	s.nextTradeId++
+
+	quote := quantity.Mul(o.price)
+
+	if o.side == buySide {
+		t.commission = quantity.Mul(commissionRate)
+		t.commissionAsset = o.symbol.Base
+		a.balances[o.symbol.Base] = a.balances[o.symbol.Base].Add(quantity).Sub(t.commission)
+		a.balances[o.symbol.Quote] = a.balances[o.symbol.Quote].Sub(quote)
+	} else {
+		t.commission = quote.Mul(commissionRate)
+		t.commissionAsset = o.symbol.Quote
+		a.balances[o.symbol.Base] = a.balances[o.symbol.Base].Sub(quantity)
+		a.balances[o.symbol.Quote] = a.balances[o.symbol.Quote].Add(quote).Sub(t.commission)
+	}
+
+	o.executed = o.executed.Add(quantity)
+	o.quoteExecuted = o.quoteExecuted.Add(quote)
+	o.updateTime = t.time
+
+	if o.executed.Equal(o.quantity) {
+		o.status = OutcomeFilled
+	} else {
+		o.status = OutcomePartiallyFilled
+	}
+
+	a.trades = append(a.trades, t)
+
+	s.report(a, o, "TRADE", t)
+	s.position(a, o.symbol.Base, o.symbol.Quote)
+
+	return t
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, p url.Values) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	symbol := s.symbols[p.Get("symbol")]
+
+	if symbol == nil {
+		apiError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+		return
+	}
+
+	quantity, err := decimal.NewFromString(p.Get("quantity"))
+
+	if err != nil || !quantity.IsPositive() {
+		apiError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'quantity'.")
+		return
+	}
+
+	clientOrderId := p.Get("newClientOrderId")
+
+	if len(clientOrderId) == 0 {
+		clientOrderId = "mock" + strconv.FormatInt(s.nextOrderId, 10)
+	}
+
+	if a.orders[clientOrderId] != nil {
+		apiError(w, http.StatusBadRequest, -2010, "Duplicate order sent.")
+		return
+	}
+
+	o := &order{
+		symbol:        symbol,
+		id:            s.nextOrderId,
+		clientOrderId: clientOrderId,
+		side:          p.Get("side"),
+		orderType:     p.Get("type"),
+		timeInForce:   p.Get("timeInForce"),
+		price:         symbol.Price,
+		quantity:      quantity,
+		status:        OutcomeNew,
+		time:          now(),
+	}
+
+	if o.orderType == limitType {
+
+		o.price, err = decimal.NewFromString(p.Get("price"))
+
+		if err != nil || !o.price.IsPositive() {
+			apiError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'price'.")
+			return
+		}
+	}
+
+	if o.price.Mul(quantity).LessThan(s.minNotional) {
+		apiError(w, http.StatusBadRequest, -1013, "Filter failure: MIN_NOTIONAL")
+		return
+	}
+
+	outcome := s.nextOutcome()
+
+	s.ctxLog.Info("Order ", clientOrderId, " ", o.side, " ", o.orderType, " ", format(quantity), " ", symbol.Name,
+		" outcome ", outcome)
+
+	switch outcome.Status {
+
+	case OutcomeRejected:
+		apiError(w, http.StatusBadRequest, -2010, "Account has insufficient balance for requested action.")
+		return
+
+	case OutcomeError:
+		apiError(w, http.StatusInternalServerError, -1001, "Internal error; unable to process your request. Please try again.")
+		return
+
+	case OutcomeRateLimit:
+		w.Header().Set("Retry-After", "1")
+		apiError(w, http.StatusTooManyRequests, -1003, "Too many requests.")
+		return
+	}
+
+	s.nextOrderId++
+
+	o.updateTime = o.time
+
+	a.orders[clientOrderId] = o
+
+	s.report(a, o, "NEW", nil)
+
+	fills := make([]map[string]interface{}, 0)
+
+	addFill := func(t *trade) {
+		fills = append(fills, map[string]interface{}{
+			"price":           format(t.price),
+			"qty":             format(t.quantity),
+			"commission":      format(t.commission),
+			"commissionAsset": t.commissionAsset,
+		})
+	}
+
+	switch outcome.Status {
+
+	case OutcomeFilled, OutcomeLost:
+		addFill(s.fill(a, o, quantity))
+
+	case OutcomePartiallyFilled:
+		addFill(s.fill(a, o, quantity.Div(decimal.New(2, 0)).Truncate(precision)))
+
+	case OutcomeExpired, OutcomeCanceled:
+		o.status = outcome.Status
+		s.report(a, o, outcome.Status, nil)
+	}
+
+	if o.isAlive() && outcome.FillAfter > 0 {
+
+		time.AfterFunc(outcome.FillAfter, func() {
+
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			if o.isAlive() {
+				s.fill(a, o, o.quantity.Sub(o.executed))
+			}
+		})
+	}
+
+	if outcome.Status == OutcomeLost {
+
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if connection, _, err := hijacker.Hijack(); err == nil {
+				_ = connection.Close()
+				return
+			}
+		}
+
+		apiError(w, http.StatusGatewayTimeout, -1007, "Timeout waiting for response from backend server. Send status unknown; execution status unknown.")
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"symbol":              symbol.Name,
+		"orderId":             o.id,
+		"clientOrderId":       o.clientOrderId,
+		"transactTime":        o.updateTime,
+		"price":               format(o.price),
+		"origQty":             format(o.quantity),
+		"executedQty":         format(o.executed),
+		"cummulativeQuoteQty": format(o.quoteExecuted),
+		"status":              o.status,
+		"timeInForce":         o.timeInForce,
+		"type":                o.orderType,
+		"side":                o.side,
+		"fills":               fills,
+	})
+}
+
+func orderJson(o *order) map[string]interface{} {
+	return map[string]interface{}{
+		"symbol":              o.symbol.Name,
+		"orderId":             o.id,
+		"clientOrderId":       o.clientOrderId,
+		"price":               format(o.price),
+		"origQty":             format(o.quantity),
+		"executedQty":         format(o.executed),
+		"cummulativeQuoteQty": format(o.quoteExecuted),
+		"status":              o.status,
+		"timeInForce":         o.timeInForce,
+		"type":                o.orderType,
+		"side":                o.side,
+		"stopPrice":           format(decimal.Zero),
+		"icebergQty":          format(decimal.Zero),
+		"time":                o.time,
+		"updateTime":          o.updateTime,
+		"isWorking":           o.isAlive(),
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, p url.Values) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	o := s.findOrder(a, p)
+
+	if o == nil {
+		apiError(w, http.StatusBadRequest, -2013, "Order does not exist.")
+		return
+	}
+
+	writeJson(w, http.StatusOK, orderJson(o))
+}
+
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request, p url.Values) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	o := s.findOrder(a, p)
+
+	if o == nil || !o.isAlive() {
+		apiError(w, http.StatusBadRequest, -2011, "Unknown order sent.")
+		return
+	}
+
+	o.status = OutcomeCanceled
+	o.updateTime = now()
+
+	s.report(a, o, OutcomeCanceled, nil)
+
+	result := orderJson(o)
+
+	result["origClientOrderId"] = o.clientOrderId
+	result["transactTime"] = o.updateTime
+
+	writeJson(w, http.StatusOK, result)
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+
+	p, err := params(r)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, -1100, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.create(w, r, p)
+	case http.MethodGet:
+		s.get(w, r, p)
+	case http.MethodDelete:
+		s.cancel(w, r, p)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	assets := make([]string, 0, len(a.balances))
+
+	for asset := range a.balances {
+		assets = append(assets, asset)
+	}
+
+	sort.Strings(assets)
+
+	balances := make([]map[string]string, 0, len(assets))
+
+	for _, asset := range assets {
+		balances = append(balances, map[string]string{
+			"asset":  asset,
+			"free":   format(a.balances[asset]),
+			"locked": format(decimal.Zero),
+		})
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"makerCommission":  10,
+		"takerCommission":  10,
+		"buyerCommission":  0,
+		"sellerCommission": 0,
+		"canTrade":         true,
+		"canWithdraw":      true,
+		"canDeposit":       true,
+		"balances":         balances,
+	})
+}
+
+func (s *Server) handleTrades(w http.ResponseWriter, r *http.Request) {
+
+	p, err := params(r)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, -1100, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	limit, err := strconv.Atoi(p.Get("limit"))
+
+	if err != nil || limit < 1 {
+		limit = tradesDefaultLimit
+	}
+
+	if limit > tradesMaxLimit {
+		limit = tradesMaxLimit
+	}
+
+	startTime, _ := strconv.ParseInt(p.Get("startTime"), 10, 64)
+	fromId, _ := strconv.ParseInt(p.Get("fromId"), 10, 64)
+
+	result := make([]map[string]interface{}, 0)
+
+	for _, t := range a.trades {
+
+		if len(result) == limit {
+			break
+		}
+
+		if t.order.symbol.Name != p.Get("symbol") || t.time < startTime || t.id < fromId {
+			continue
+		}
+
+		result = append(result, map[string]interface{}{
+			"id":              t.id,
+			"symbol":          t.order.symbol.Name,
+			"orderId":         t.order.id,
+			"price":           format(t.price),
+			"qty":             format(t.quantity),
+			"quoteQty":        format(t.quantity.Mul(t.price)),
+			"commission":      format(t.commission),
+			"commissionAsset": t.commissionAsset,
+			"time":            t.time,
+			"isBuyer":         t.order.side == buySide,
+			"isMaker":         false,
+			"isBestMatch":     true,
+		})
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
+
+// handleExchangeInfo returns all symbols with loose filters, so any sane command passes trading rules
+func (s *Server) handleExchangeInfo(w http.ResponseWriter, r *http.Request) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	symbols := make([]map[string]interface{}, 0, len(s.symbols))
+
+	for _, symbol := range s.symbols {
+		symbols = append(symbols, map[string]interface{}{
+			"symbol":     symbol.Name,
+			"status":     "TRADING",
+			"baseAsset":  symbol.Base,
+			"quoteAsset": symbol.Quote,
+			"orderTypes": []string{"LIMIT", "MARKET"},
+			"filters": []map[string]interface{}{
+				{"filterType": "LOT_SIZE", "minQty": "0.00000001", "maxQty": "9000000000.00000000", "stepSize": "0.00000001"},
+				{"filterType": "PRICE_FILTER", "minPrice": "0.00000001", "maxPrice": "1000000.00000000", "tickSize": "0.00000001"},
+				{"filterType": "MIN_NOTIONAL", "minNotional": format(s.minNotional), "applyToMarket": true, "avgPriceMins": 5},
+			},
+		})
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"timezone":   "UTC",
+		"serverTime": now(),
+		"symbols":    symbols,
+	})
+}
+
+// handleTickerPrice returns fixed prices of all symbols
+func (s *Server) handleTickerPrice(w http.ResponseWriter, r *http.Request) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prices := make([]map[string]string, 0, len(s.symbols))
+
+	for _, symbol := range s.symbols {
+		prices = append(prices, map[string]string{"symbol": symbol.Name, "price": format(symbol.Price)})
+	}
+
+	writeJson(w, http.StatusOK, prices)
+}
+
+func (s *Server) handleTime(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]interface{}{"serverTime": now()})
+}
+
+// handleOutcomes appends outcomes posted as comma separated list, GET returns outcomes which are not taken yet
+func (s *Server) handleOutcomes(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost {
+
+		body, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		outcomes, err := ParseOutcomes(string(body))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Push(outcomes...)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := make([]string, len(s.outcomes))
+
+	for i, o := range s.outcomes {
+		result[i] = o.String()
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{"default": s.defaultOutcome.String(), "outcomes": result})
+}
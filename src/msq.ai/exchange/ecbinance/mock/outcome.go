@@ -0,0 +1,102 @@
+package mock
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outcomes of order creation, order statuses are returned as is, others are failures of different kinds
+const OutcomeFilled = "FILLED"
+const OutcomePartiallyFilled = "PARTIALLY_FILLED"
+const OutcomeNew = "NEW"
+const OutcomeExpired = "EXPIRED"
+const OutcomeCanceled = "CANCELED"
+const OutcomeRejected = "REJECTED"
+const OutcomeError = "ERROR"
+const OutcomeRateLimit = "RATE_LIMIT"
+const OutcomeLost = "LOST"
+
+var outcomes = map[string]bool{
+	OutcomeFilled:          true,
+	OutcomePartiallyFilled: true,
+	OutcomeNew:             true,
+	OutcomeExpired:         true,
+	OutcomeCanceled:        true,
+	OutcomeRejected:        true,
+	OutcomeError:           true,
+	OutcomeRateLimit:       true,
+	OutcomeLost:            true,
+}
+
+// Outcome scripts what happens with the next created order. NEW and PARTIALLY_FILLED orders rest in the book and get
+// filled after FillAfter, if it is zero they rest till canceled. REJECTED, ERROR and RATE_LIMIT don't create an order.
+// LOST creates and fills the order, but the connection is dropped instead of response, as it happens on network failures.
+type Outcome struct {
+	Status    string
+	FillAfter time.Duration
+}
+
+func (o Outcome) String() string {
+
+	if o.FillAfter > 0 {
+		return o.Status + ":" + strconv.FormatInt(int64(o.FillAfter/time.Millisecond), 10)
+	}
+
+	return o.Status
+}
+
+// ParseOutcome parses outcome in format STATUS[:fill after milliseconds], e.g. FILLED or NEW:3000
+func ParseOutcome(spec string) (Outcome, error) {
+
+	var outcome Outcome
+
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+
+	if len(parts) > 2 {
+		return outcome, errors.New("wrong outcome [" + spec + "]")
+	}
+
+	outcome.Status = strings.ToUpper(parts[0])
+
+	if !outcomes[outcome.Status] {
+		return outcome, errors.New("unknown outcome [" + spec + "]")
+	}
+
+	if len(parts) == 2 {
+
+		ms, err := strconv.ParseInt(parts[1], 10, 64)
+
+		if err != nil || ms < 0 {
+			return outcome, errors.New("wrong fill time of outcome [" + spec + "]")
+		}
+
+		outcome.FillAfter = time.Duration(ms) * time.Millisecond
+	}
+
+	return outcome, nil
+}
+
+// ParseOutcomes parses comma separated list of outcomes
+func ParseOutcomes(spec string) ([]Outcome, error) {
+
+	result := make([]Outcome, 0)
+
+	for _, s := range strings.Split(spec, ",") {
+
+		if len(strings.TrimSpace(s)) == 0 {
+			continue
+		}
+
+		outcome, err := ParseOutcome(s)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, outcome)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,170 @@
+package mock
+
+import (
+	"github.com/shopspring/decimal"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const wsWriteTime = time.Second
+
+func (s *Server) handleUserStream(w http.ResponseWriter, r *http.Request) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	apiKey := r.Header.Get(apiKeyHeader)
+
+	if len(apiKey) == 0 {
+		apiError(w, http.StatusUnauthorized, -2014, "API-key format invalid.")
+		return
+	}
+
+	switch r.Method {
+
+	case http.MethodPost:
+
+		listenKey := "mock" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+		s.listenKeys[listenKey] = apiKey
+
+		writeJson(w, http.StatusOK, map[string]string{"listenKey": listenKey})
+
+	case http.MethodPut, http.MethodDelete:
+
+		p, err := params(r)
+
+		if err != nil || s.listenKeys[p.Get("listenKey")] != apiKey {
+			apiError(w, http.StatusBadRequest, -1125, "This listenKey does not exist.")
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			delete(s.listenKeys, p.Get("listenKey"))
+		}
+
+		writeJson(w, http.StatusOK, map[string]string{})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleWs(w http.ResponseWriter, r *http.Request) {
+
+	listenKey := strings.TrimPrefix(r.URL.Path, wsPath)
+
+	s.lock.Lock()
+	apiKey, ok := s.listenKeys[listenKey]
+	s.lock.Unlock()
+
+	if !ok {
+		http.Error(w, "This listenKey does not exist.", http.StatusBadRequest)
+		return
+	}
+
+	connection, err := s.upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		s.ctxLog.Error("Upgrade error ", err)
+		return
+	}
+
+	s.lock.Lock()
+	a := s.getAccount(apiKey)
+	a.streams[connection] = true
+	s.lock.Unlock()
+
+	for {
+		if _, _, err := connection.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.lock.Lock()
+	delete(a.streams, connection)
+	s.lock.Unlock()
+
+	_ = connection.Close()
+}
+
+// send writes event to all streams of the account, must be called under lock
+func (s *Server) send(a *account, event interface{}) {
+
+	for connection := range a.streams {
+
+		_ = connection.SetWriteDeadline(time.Now().Add(wsWriteTime))
+
+		if err := connection.WriteJSON(event); err != nil {
+			s.ctxLog.Error("Stream write error ", err)
+			delete(a.streams, connection)
+			_ = connection.Close()
+		}
+	}
+}
+
+// report sends executionReport of the order, t is set for TRADE execution type only
+func (s *Server) report(a *account, o *order, executionType string, t *trade) {
+
+	event := map[string]interface{}{
+		"e": "executionReport",
+		"E": now(),
+		"s": o.symbol.Name,
+		"c": o.clientOrderId,
+		"C": "",
+		"S": o.side,
+		"o": o.orderType,
+		"f": o.timeInForce,
+		"q": format(o.quantity),
+		"p": format(o.price),
+		"x": executionType,
+		"X": o.status,
+		"i": o.id,
+		"l": format(decimal.Zero),
+		"z": format(o.executed),
+		"L": format(decimal.Zero),
+		"n": format(decimal.Zero),
+		"N": nil,
+		"T": o.updateTime,
+		"t": -1,
+		"O": o.time,
+	}
+
+	if executionType == OutcomeCanceled {
+		event["c"] = "cancel" + o.clientOrderId
+		event["C"] = o.clientOrderId
+	}
+
+	if t != nil {
+		event["l"] = format(t.quantity)
+		event["L"] = format(t.price)
+		event["n"] = format(t.commission)
+		event["N"] = t.commissionAsset
+		event["t"] = t.id
+	}
+
+	s.send(a, event)
+}
+
+// position sends outboundAccountPosition with balances of given assets
+func (s *Server) position(a *account, assets ...string) {
+
+	balances := make([]map[string]string, 0, len(assets))
+
+	for _, asset := range assets {
+		balances = append(balances, map[string]string{
+			"a": asset,
+			"f": format(a.balances[asset]),
+			"l": format(decimal.Zero),
+		})
+	}
+
+	s.send(a, map[string]interface{}{
+		"e": "outboundAccountPosition",
+		"E": now(),
+		"u": now(),
+		"B": balances,
+	})
+}
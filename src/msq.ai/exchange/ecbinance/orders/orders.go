@@ -0,0 +1,272 @@
+package orders
+
+import (
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"strconv"
+	"time"
+)
+
+// statuses of orders are the same for Binance spot and futures
+const NewValue = "NEW"
+const PartiallyFilledValue = "PARTIALLY_FILLED"
+const FilledValue = "FILLED"
+const CanceledValue = "CANCELED"
+const RejectedValue = "REJECTED"
+const ExpiredValue = "EXPIRED"
+
+const tradesPageLimit = 1000
+const tradesAttempts = 3
+const tradesRetryTime = 2 * time.Second
+
+// Order keeps fields of spot or futures order which are needed to settle it
+type Order struct {
+	OrderId          int64
+	ClientOrderId    string
+	Symbol           string
+	Status           string
+	ExecutedQuantity string
+	Time             int64
+	UpdateTime       int64
+	// Description is the order as it is returned by Binance
+	Description string
+}
+
+// Trade keeps fields of spot or futures account trade which are needed to settle the order
+type Trade struct {
+	Id              int64
+	OrderId         int64
+	Quantity        string
+	Price           string
+	Commission      string
+	CommissionAsset string
+	Time            int64
+}
+
+// ListTrades returns page of account trades of the symbol since startTime, or since trade fromId when it isn't negative
+type ListTrades func(symbol string, startTime int64, fromId int64, limit int) ([]*Trade, error)
+
+func IsAlive(status string) bool {
+	return status == NewValue || status == PartiallyFilledValue
+}
+
+func TradesToFills(trades []*Trade) ([]cmd.Fill, error) {
+
+	var err error
+
+	result := make([]cmd.Fill, len(trades))
+
+	for i, t := range trades {
+
+		result[i].ExternalTradeId = t.Id
+		result[i].CommissionAsset = t.CommissionAsset
+
+		result[i].Quantity, err = decimal.NewFromString(t.Quantity)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i].Price, err = decimal.NewFromString(t.Price)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i].Commission, err = decimal.NewFromString(t.Commission)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func errorResponse(response *proto.ExecResponse, err error) *proto.ExecResponse {
+
+	response.Status = proto.StatusError
+
+	response.Description = response.Description + " Parse error [" + err.Error() + "]"
+
+	return response
+}
+
+// NotFilled builds response of order which is final without fills, LIMIT order is rejected, MARKET one wasn't filled
+func NotFilled(request *proto.ExecRequest, response *proto.ExecResponse, status string) *proto.ExecResponse {
+
+	if request.RawCmd.OrderType == constants.OrderTypeLimitName &&
+		(status == ExpiredValue || status == CanceledValue || status == RejectedValue) {
+
+		response.Description = "Order rejected"
+		response.Status = proto.StatusRejected
+		return response
+	}
+
+	response.Description = "Order wasn't fill"
+	return response
+}
+
+// Progress reports status and executed quantity of alive order
+func Progress(ctxLog *log.Entry, request *proto.ExecRequest, status string, executed string) {
+
+	quantity, err := decimal.NewFromString(executed)
+
+	if err != nil {
+		ctxLog.Error("Cannot parse executed quantity ["+executed+"] ", err)
+		return
+	}
+
+	var response = proto.ExecResponse{Request: request, Status: proto.StatusOpen, ExecutedQuantity: quantity}
+
+	if status == PartiallyFilledValue {
+		response.Status = proto.StatusPartiallyFilled
+	}
+
+	response.Description = "Order is " + status + " executed [" + executed + "]"
+
+	request.Progress(&response)
+}
+
+// Park builds response of alive order which isn't followed any more
+func Park(response *proto.ExecResponse, status string, executed string) *proto.ExecResponse {
+
+	quantity, err := decimal.NewFromString(executed)
+
+	if err != nil {
+		return errorResponse(response, err)
+	}
+
+	return proto.Park(response, quantity, "status ["+status+"] executed ["+executed+"]")
+}
+
+// unsettled parks executed order which fills aren't known yet, the parked order is checked again later and the trades
+// are looked for once more, so the order is never finished without its fills
+func unsettled(response *proto.ExecResponse, executed decimal.Decimal, description string) *proto.ExecResponse {
+
+	response.Order = nil
+
+	proto.Park(response, executed, "")
+
+	response.Description = "Order isn't settled yet " + description
+
+	return response
+}
+
+// OrderTrades collects trades of the order from account trade list, which is the only place with fills of existing order
+func OrderTrades(list ListTrades, order *Order) ([]*Trade, decimal.Decimal, error) {
+
+	var quantity = decimal.Zero
+	var fromId int64 = -1
+
+	result := make([]*Trade, 0)
+
+	for {
+
+		trades, err := list(order.Symbol, order.Time, fromId, tradesPageLimit)
+
+		if err != nil {
+			return nil, decimal.Zero, err
+		}
+
+		for _, t := range trades {
+
+			if t.OrderId != order.OrderId {
+				continue
+			}
+
+			q, err := decimal.NewFromString(t.Quantity)
+
+			if err != nil {
+				return nil, decimal.Zero, err
+			}
+
+			quantity = quantity.Add(q)
+
+			result = append(result, t)
+		}
+
+		if len(trades) < tradesPageLimit || trades[len(trades)-1].Time > order.UpdateTime {
+			return result, quantity, nil
+		}
+
+		fromId = trades[len(trades)-1].Id + 1
+	}
+}
+
+// Settle builds final response of existing order, given fills are used when they cover executed quantity, otherwise
+// they are taken from account trade list. The order is parked when the trades can't be taken or don't match.
+func Settle(ctxLog *log.Entry, request *proto.ExecRequest, response *proto.ExecResponse, order *Order, fills []cmd.Fill,
+	list ListTrades) *proto.ExecResponse {
+
+	response.Description = order.Description
+
+	executed, err := decimal.NewFromString(order.ExecutedQuantity)
+
+	if err != nil {
+		return errorResponse(response, err)
+	}
+
+	if order.Status != FilledValue && executed.IsZero() {
+		return NotFilled(request, response, order.Status)
+	}
+
+	response.Order = &cmd.Order{}
+
+	response.Order.ExternalOrderId = order.OrderId
+
+	response.Order.ExecutionId, err = strconv.ParseInt(order.ClientOrderId, 10, 64)
+
+	if err != nil {
+		return errorResponse(response, err)
+	}
+
+	var given = decimal.Zero
+
+	for _, f := range fills {
+		given = given.Add(f.Quantity)
+	}
+
+	if len(fills) > 0 && given.Equal(executed) {
+		response.Order.Fills = fills
+	}
+
+	for attempt := 1; response.Order.Fills == nil; attempt++ {
+
+		trades, quantity, err := OrderTrades(list, order)
+
+		if err != nil {
+			ctxLog.Error("Trades error ", err)
+			return unsettled(response, executed, "trades error ["+err.Error()+"] "+order.Description)
+		}
+
+		if quantity.Equal(executed) {
+
+			response.Order.Fills, err = TradesToFills(trades)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			break
+		}
+
+		if attempt == tradesAttempts {
+			return unsettled(response, executed, "trades don't match executed quantity ["+order.ExecutedQuantity+"] "+
+				order.Description)
+		}
+
+		ctxLog.Warn("Trades of order don't match executed quantity yet ", order)
+
+		time.Sleep(tradesRetryTime)
+	}
+
+	cmd.ApplyFills(response.Order)
+
+	response.Status = proto.StatusOk
+
+	return response
+}
@@ -0,0 +1,132 @@
+package orders
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"testing"
+)
+
+var testLog = log.WithFields(log.Fields{"id": "OrdersTest"})
+
+func filledOrder(executed string) *Order {
+	return &Order{OrderId: 7, ClientOrderId: "42", Symbol: "BTCUSDT", Status: FilledValue, ExecutedQuantity: executed,
+		Time: 1000, UpdateTime: 2000}
+}
+
+func settle(order *Order, fills []cmd.Fill, list ListTrades) *proto.ExecResponse {
+
+	request := &proto.ExecRequest{RawCmd: &cmd.RawCommand{Id: "42", OrderType: constants.OrderTypeMarketName}}
+
+	return Settle(testLog, request, &proto.ExecResponse{Request: request}, order, fills, list)
+}
+
+func TestSettleCollectsTradesOfOrderFromPages(t *testing.T) {
+
+	// the first page is full of trades of other orders with one of ours, the second page has the rest
+	pages := 0
+
+	list := func(symbol string, startTime int64, fromId int64, limit int) ([]*Trade, error) {
+
+		pages++
+
+		if fromId < 0 {
+
+			trades := make([]*Trade, limit)
+
+			for i := range trades {
+				trades[i] = &Trade{Id: int64(i), OrderId: 8, Quantity: "1", Price: "1", Commission: "0", Time: 1500}
+			}
+
+			trades[10] = &Trade{Id: 10, OrderId: 7, Quantity: "0.4", Price: "100", Commission: "0.1",
+				CommissionAsset: "USDT", Time: 1500}
+
+			return trades, nil
+		}
+
+		if fromId != int64(limit) {
+			t.Error("Next page is asked from ", fromId)
+		}
+
+		return []*Trade{{Id: fromId, OrderId: 7, Quantity: "0.6", Price: "110", Commission: "0.2",
+			CommissionAsset: "USDT", Time: 1600}}, nil
+	}
+
+	response := settle(filledOrder("1"), nil, list)
+
+	if response.Status != proto.StatusOk || pages != 2 {
+		t.Fatal("Order isn't settled by two pages ", response.Status, " ", pages, " ", response.Description)
+	}
+
+	if len(response.Order.Fills) != 2 || !response.Order.Price.Equal(decimal.NewFromInt(106)) ||
+		!response.Order.Commission.Equal(decimal.RequireFromString("0.3")) {
+		t.Error("Wrong order ", response.Order)
+	}
+}
+
+func TestSettleUsesFillsOnlyWhenTheyCoverExecuted(t *testing.T) {
+
+	listed := false
+
+	list := func(symbol string, startTime int64, fromId int64, limit int) ([]*Trade, error) {
+		listed = true
+		return []*Trade{{Id: 1, OrderId: 7, Quantity: "1", Price: "100", Commission: "0"}}, nil
+	}
+
+	fill := cmd.Fill{ExternalTradeId: -1, Quantity: decimal.RequireFromString("0.5"), Price: decimal.NewFromInt(100)}
+
+	response := settle(filledOrder("1"), []cmd.Fill{fill}, list)
+
+	if response.Status != proto.StatusOk || !listed || response.Order.Fills[0].ExternalTradeId != 1 {
+		t.Fatal("Fills which don't cover executed quantity are used ", response.Order)
+	}
+
+	listed = false
+
+	response = settle(filledOrder("0.5"), []cmd.Fill{fill}, list)
+
+	if response.Status != proto.StatusOk || listed {
+		t.Fatal("Trades are listed while fills cover executed quantity ", response.Description)
+	}
+}
+
+func TestSettleParksFilledOrderWithoutTrades(t *testing.T) {
+
+	cases := []struct {
+		name string
+		list ListTrades
+	}{
+		{
+			name: "trade list fails",
+			list: func(symbol string, startTime int64, fromId int64, limit int) ([]*Trade, error) {
+				return nil, errors.New("connection reset")
+			},
+		},
+		{
+			name: "trades don't match executed quantity",
+			list: func(symbol string, startTime int64, fromId int64, limit int) ([]*Trade, error) {
+				return []*Trade{{Id: 1, OrderId: 7, Quantity: "0.5", Price: "100", Commission: "0"}}, nil
+			},
+		},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			response := settle(filledOrder("1"), nil, c.list)
+
+			// the order is filled at Binance, so it must be checked again instead of being finished as error
+			if proto.IsFinal(response.Status) || response.Order != nil {
+				t.Fatal("Filled order without trades is finished ", response.Status, " ", response.Description)
+			}
+
+			if !response.ExecutedQuantity.Equal(decimal.NewFromInt(1)) {
+				t.Error("Wrong executed quantity ", response.ExecutedQuantity)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package ecbinance
+
+import (
+	"msq.ai/connectors/proto"
+	"msq.ai/connectors/registry"
+	"time"
+)
+
+const propertiesApiUrlName = "api.url"
+const propertiesWsUrlName = "ws.url"
+const propertiesRulesRefreshSecondsName = "rules.refresh.seconds"
+const propertiesRulesRoundName = "rules.round"
+const propertiesPricesRefreshSecondsName = "prices.refresh.seconds"
+const propertiesLimitsWeightName = "limits.weight.per.minute"
+const propertiesLimitsOrdersName = "limits.orders.per.10s"
+const propertiesClientsCacheSizeName = "clients.cache.size"
+const propertiesTimeSyncSecondsName = "time.sync.seconds"
+const propertiesRecvWindowMsName = "recv.window.ms"
+
+func init() {
+	registry.Register(registry.Exchange{Name: "BINANCE", ExecPoolSize: 200, Run: run})
+}
+
+func run(settings registry.Settings, in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse,
+	execPoolSize int) func() time.Duration {
+
+	config := Config{
+		ApiUrl:            settings.MustGet(propertiesApiUrlName),
+		WsUrl:             settings.MustGet(propertiesWsUrlName),
+		RulesRefreshTime:  time.Duration(settings.GetInt(propertiesRulesRefreshSecondsName, 300)) * time.Second,
+		PricesRefreshTime: time.Duration(settings.GetInt(propertiesPricesRefreshSecondsName, 10)) * time.Second,
+		RoundToRules:      settings.GetBool(propertiesRulesRoundName, false),
+		ClientsCacheSize:  settings.GetInt(propertiesClientsCacheSizeName, 1000),
+		TimeSyncTime:      time.Duration(settings.GetInt(propertiesTimeSyncSecondsName, 60)) * time.Second,
+		RecvWindow:        time.Duration(settings.GetInt(propertiesRecvWindowMsName, 5000)) * time.Millisecond,
+	}
+
+	limiter := NewLimiter(settings.GetInt(propertiesLimitsWeightName, 1200), settings.GetInt(propertiesLimitsOrdersName, 50))
+
+	RunBinanceConnector(in, out, execPoolSize, config, limiter)
+
+	return limiter.PauseTime
+}
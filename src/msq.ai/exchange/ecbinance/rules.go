@@ -0,0 +1,343 @@
+package ecbinance
+
+import (
+	"context"
+	"fmt"
+	"github.com/adshao/go-binance"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"sync"
+	"time"
+)
+
+const symbolTradingStatus = "TRADING"
+
+const filterTypeName = "filterType"
+const lotSizeFilter = "LOT_SIZE"
+const priceFilter = "PRICE_FILTER"
+const minNotionalFilter = "MIN_NOTIONAL"
+const notionalFilter = "NOTIONAL"
+
+const rulesErrorSleepTime = 10 * time.Second
+
+type symbolRules struct {
+	status      string
+	minQty      decimal.Decimal
+	maxQty      decimal.Decimal
+	stepSize    decimal.Decimal
+	minPrice    decimal.Decimal
+	maxPrice    decimal.Decimal
+	tickSize    decimal.Decimal
+	minNotional decimal.Decimal
+	// notional of MARKET order is checked against average price by Binance
+	minNotionalToMarket bool
+}
+
+// Rules keeps trading rules of all symbols from the last successful exchangeInfo call and the last prices of symbols,
+// both are refreshed in background. Commands are checked against them by connector before they are sent and by REST
+// service before they are inserted, so violations are rejected with clear reason before commands are claimed.
+type Rules struct {
+	lock    sync.RWMutex
+	symbols map[string]*symbolRules
+	prices  map[string]decimal.Decimal
+	round   bool
+}
+
+// RunRules starts refreshing of rules and prices with the client, round tells whether amount and limit price are
+// aligned to the rules or violations are rejected
+func RunRules(client *binance.Client, rulesRefreshTime time.Duration, pricesRefreshTime time.Duration,
+	round bool) *Rules {
+
+	ctxLog := log.WithFields(log.Fields{"id": "BinanceRules"})
+
+	rules := &Rules{round: round}
+
+	go func() {
+
+		for {
+
+			symbols, err := loadRules(client)
+
+			if err != nil {
+				ctxLog.Error("Cannot load trading rules ", err)
+				time.Sleep(rulesErrorSleepTime)
+				continue
+			}
+
+			rules.lock.Lock()
+			rules.symbols = symbols
+			rules.lock.Unlock()
+
+			ctxLog.Trace("Trading rules refreshed, symbols ", len(symbols))
+
+			time.Sleep(rulesRefreshTime)
+		}
+	}()
+
+	go func() {
+
+		for {
+
+			prices, err := loadPrices(client)
+
+			if err != nil {
+				ctxLog.Error("Cannot load prices ", err)
+			} else {
+				rules.lock.Lock()
+				rules.prices = prices
+				rules.lock.Unlock()
+			}
+
+			time.Sleep(pricesRefreshTime)
+		}
+	}()
+
+	return rules
+}
+
+// Apply checks the command against rules of its symbol and aligns it if rules are set to round. Returns description of
+// adjustments, false when rules have never been loaded or error with the reason of rejection.
+func (r *Rules) Apply(raw *cmd.RawCommand) (string, bool, error) {
+
+	r.lock.RLock()
+
+	if r.symbols == nil {
+		r.lock.RUnlock()
+		return "", false, nil
+	}
+
+	symbol := r.symbols[raw.Instrument]
+	price := r.prices[raw.Instrument]
+
+	r.lock.RUnlock()
+
+	if symbol == nil {
+		return "", true, fmt.Errorf("unknown symbol %s", raw.Instrument)
+	}
+
+	adjusted, err := applyRules(symbol, raw, r.round, price)
+
+	return adjusted, true, err
+}
+
+// Check tells whether the command passes the rules, the command isn't changed. Commands are passed while rules aren't
+// loaded yet.
+func (r *Rules) Check(raw cmd.RawCommand) error {
+	_, _, err := r.Apply(&raw)
+	return err
+}
+
+func filterValue(filter map[string]interface{}, name string) (decimal.Decimal, error) {
+
+	val, ok := filter[name]
+
+	if !ok {
+		return decimal.Zero, nil
+	}
+
+	str, ok := val.(string)
+
+	if !ok {
+		return decimal.Zero, fmt.Errorf("filter %v has wrong value of [%s]", filter[filterTypeName], name)
+	}
+
+	return decimal.NewFromString(str)
+}
+
+func loadRules(client *binance.Client) (map[string]*symbolRules, error) {
+
+	info, err := client.NewExchangeInfoService().Do(context.Background())
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*symbolRules, len(info.Symbols))
+
+	for _, s := range info.Symbols {
+
+		r := &symbolRules{status: s.Status}
+
+		for _, f := range s.Filters {
+
+			switch f[filterTypeName] {
+
+			case lotSizeFilter:
+				if r.minQty, err = filterValue(f, "minQty"); err != nil {
+					return nil, err
+				}
+				if r.maxQty, err = filterValue(f, "maxQty"); err != nil {
+					return nil, err
+				}
+				if r.stepSize, err = filterValue(f, "stepSize"); err != nil {
+					return nil, err
+				}
+
+			case priceFilter:
+				if r.minPrice, err = filterValue(f, "minPrice"); err != nil {
+					return nil, err
+				}
+				if r.maxPrice, err = filterValue(f, "maxPrice"); err != nil {
+					return nil, err
+				}
+				if r.tickSize, err = filterValue(f, "tickSize"); err != nil {
+					return nil, err
+				}
+
+			case minNotionalFilter:
+				if r.minNotional, err = filterValue(f, "minNotional"); err != nil {
+					return nil, err
+				}
+				r.minNotionalToMarket, _ = f["applyToMarket"].(bool)
+
+			case notionalFilter:
+				if r.minNotional, err = filterValue(f, "minNotional"); err != nil {
+					return nil, err
+				}
+				r.minNotionalToMarket, _ = f["applyMinToMarket"].(bool)
+			}
+		}
+
+		result[s.Symbol] = r
+	}
+
+	return result, nil
+}
+
+// loadPrices returns the last prices of all symbols
+func loadPrices(client *binance.Client) (map[string]decimal.Decimal, error) {
+
+	prices, err := client.NewListPricesService().Do(context.Background())
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]decimal.Decimal, len(prices))
+
+	for _, p := range prices {
+
+		price, err := decimal.NewFromString(p.Price)
+
+		if err != nil {
+			return nil, fmt.Errorf("symbol %s has wrong price [%s]", p.Symbol, p.Price)
+		}
+
+		result[p.Symbol] = price
+	}
+
+	return result, nil
+}
+
+// alignDown returns the biggest value which is not more than val and is equal to base + N * step
+func alignDown(val decimal.Decimal, base decimal.Decimal, step decimal.Decimal) decimal.Decimal {
+	return val.Sub(base).Div(step).Floor().Mul(step).Add(base)
+}
+
+// alignUp returns the smallest value which is not less than val and is equal to base + N * step
+func alignUp(val decimal.Decimal, base decimal.Decimal, step decimal.Decimal) decimal.Decimal {
+	return val.Sub(base).Div(step).Ceil().Mul(step).Add(base)
+}
+
+// applyRules checks the command against symbol rules and, if round is set, aligns amount to step size and limit price
+// to tick size. Price is aligned in the direction that never makes it worse for us. Notional of MARKET order is checked
+// with the last price of the symbol, zero price means it is unknown. Returns description of adjustments or error with
+// the reason of rejection.
+func applyRules(rules *symbolRules, raw *cmd.RawCommand, round bool, lastPrice decimal.Decimal) (string, error) {
+
+	if rules.status != symbolTradingStatus {
+		return "", fmt.Errorf("symbol %s is not trading, status [%s]", raw.Instrument, rules.status)
+	}
+
+	adjusted := ""
+
+	qty, err := decimal.NewFromString(raw.Amount)
+
+	if err != nil {
+		return "", fmt.Errorf("wrong amount [%s]", raw.Amount)
+	}
+
+	if rules.stepSize.IsPositive() {
+
+		aligned := alignDown(qty, rules.minQty, rules.stepSize)
+
+		if !aligned.Equal(qty) {
+
+			if !round {
+				return "", fmt.Errorf("LOT_SIZE amount [%s] is not multiple of step size [%s]", raw.Amount, rules.stepSize)
+			}
+
+			adjusted = adjusted + fmt.Sprintf("amount [%s] rounded to [%s] ", raw.Amount, aligned)
+			qty = aligned
+		}
+	}
+
+	if qty.LessThan(rules.minQty) || qty.Sign() <= 0 {
+		return "", fmt.Errorf("LOT_SIZE amount [%s] is less than min quantity [%s]", qty, rules.minQty)
+	}
+
+	if rules.maxQty.IsPositive() && qty.GreaterThan(rules.maxQty) {
+		return "", fmt.Errorf("LOT_SIZE amount [%s] is more than max quantity [%s]", qty, rules.maxQty)
+	}
+
+	raw.Amount = qty.String()
+
+	if raw.OrderType != constants.OrderTypeLimitName {
+
+		if !rules.minNotionalToMarket || !lastPrice.IsPositive() {
+			return adjusted, nil
+		}
+
+		if notional := lastPrice.Mul(qty); notional.LessThan(rules.minNotional) {
+			return "", fmt.Errorf("MIN_NOTIONAL notional [%s] at last price [%s] is less than [%s]", notional, lastPrice,
+				rules.minNotional)
+		}
+
+		return adjusted, nil
+	}
+
+	price, err := decimal.NewFromString(raw.LimitPrice)
+
+	if err != nil {
+		return "", fmt.Errorf("wrong limit price [%s]", raw.LimitPrice)
+	}
+
+	if rules.tickSize.IsPositive() {
+
+		var aligned decimal.Decimal
+
+		if raw.Direction == constants.OrderDirectionSellName {
+			aligned = alignUp(price, rules.minPrice, rules.tickSize)
+		} else {
+			aligned = alignDown(price, rules.minPrice, rules.tickSize)
+		}
+
+		if !aligned.Equal(price) {
+
+			if !round {
+				return "", fmt.Errorf("PRICE_FILTER limit price [%s] is not multiple of tick size [%s]", raw.LimitPrice, rules.tickSize)
+			}
+
+			adjusted = adjusted + fmt.Sprintf("limit price [%s] rounded to [%s] ", raw.LimitPrice, aligned)
+			price = aligned
+		}
+	}
+
+	if price.LessThan(rules.minPrice) || price.Sign() <= 0 {
+		return "", fmt.Errorf("PRICE_FILTER limit price [%s] is less than min price [%s]", price, rules.minPrice)
+	}
+
+	if rules.maxPrice.IsPositive() && price.GreaterThan(rules.maxPrice) {
+		return "", fmt.Errorf("PRICE_FILTER limit price [%s] is more than max price [%s]", price, rules.maxPrice)
+	}
+
+	if notional := price.Mul(qty); notional.LessThan(rules.minNotional) {
+		return "", fmt.Errorf("MIN_NOTIONAL notional [%s] is less than [%s]", notional, rules.minNotional)
+	}
+
+	raw.LimitPrice = price.String()
+
+	return adjusted, nil
+}
@@ -0,0 +1,406 @@
+package ecbinance
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/adshao/go-binance"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/data/cmd"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const executionReportEvent = "executionReport"
+const accountPositionEvent = "outboundAccountPosition"
+const listenKeyExpiredEvent = "listenKeyExpired"
+
+const tradeExecutionType = "TRADE"
+
+const streamKeepAliveTime = 30 * time.Minute
+const streamIdleTime = 5 * time.Minute
+const streamCheckTime = time.Minute
+const streamErrorSleepTime = 5 * time.Second
+
+// events are dropped when subscriber is that far behind, it will fall back to polling and trade list
+const streamEventsBuffer = 256
+
+// encoding/json matches keys case insensitively, so keys differing only in case from the used ones are declared as well
+type executionReport struct {
+	Event              string `json:"e"`
+	EventTime          int64  `json:"E"`
+	Symbol             string `json:"s"`
+	Side               string `json:"S"`
+	ClientOrderId      string `json:"c"`
+	OrigClientOrderId  string `json:"C"`
+	OrderType          string `json:"o"`
+	ExecutionType      string `json:"x"`
+	Status             string `json:"X"`
+	OrderId            int64  `json:"i"`
+	Ignore             int64  `json:"I"`
+	LastQuantity       string `json:"l"`
+	CumulativeQty      string `json:"z"`
+	CumulativeQuoteQty string `json:"Z"`
+	LastPrice          string `json:"L"`
+	Commission         string `json:"n"`
+	CommissionAsset    string `json:"N"`
+	TransactionTime    int64  `json:"T"`
+	TradeId            int64  `json:"t"`
+	OrderCreationTime  int64  `json:"O"`
+}
+
+type accountPosition struct {
+	Event     string `json:"e"`
+	EventTime int64  `json:"E"`
+	Balances  []struct {
+		Asset  string `json:"a"`
+		Free   string `json:"f"`
+		Locked string `json:"l"`
+	} `json:"B"`
+}
+
+type streamEvent struct {
+	report   *executionReport
+	balances []cmd.Balance
+}
+
+// clientOrderId returns id of the order the report is about, cancel reports carry it in the original id
+func (r *executionReport) clientOrderId() string {
+
+	if len(r.OrigClientOrderId) > 0 {
+		return r.OrigClientOrderId
+	}
+
+	return r.ClientOrderId
+}
+
+func (r *executionReport) toOrder() *binance.Order {
+	return &binance.Order{
+		Symbol:           r.Symbol,
+		OrderID:          r.OrderId,
+		ClientOrderID:    r.clientOrderId(),
+		ExecutedQuantity: r.CumulativeQty,
+		Status:           binance.OrderStatusType(r.Status),
+		Time:             r.OrderCreationTime,
+		UpdateTime:       r.TransactionTime,
+	}
+}
+
+func (r *executionReport) toFill() (cmd.Fill, error) {
+
+	var err error
+
+	fill := cmd.Fill{ExternalTradeId: r.TradeId, CommissionAsset: r.CommissionAsset}
+
+	if fill.Quantity, err = decimal.NewFromString(r.LastQuantity); err != nil {
+		return fill, err
+	}
+
+	if fill.Price, err = decimal.NewFromString(r.LastPrice); err != nil {
+		return fill, err
+	}
+
+	if fill.Commission, err = decimal.NewFromString(r.Commission); err != nil {
+		return fill, err
+	}
+
+	return fill, nil
+}
+
+type userStream struct {
+	apiKey    string
+	secretKey string
+
+	orders    map[string]chan *streamEvent
+	idleSince time.Time
+	stopped   bool
+
+	connection *websocket.Conn
+	connected  int32
+}
+
+// userStreams keeps one user data stream per API key while there are orders of that key to follow
+type userStreams struct {
+	lock      sync.Mutex
+	streams   map[string]*userStream
+	newClient func(apiKey string, secretKey string) *binance.Client
+	wsUrl     string
+	ctxLog    *log.Entry
+}
+
+func newUserStreams(wsUrl string, newClient func(apiKey string, secretKey string) *binance.Client) *userStreams {
+
+	u := &userStreams{
+		streams:   make(map[string]*userStream),
+		newClient: newClient,
+		wsUrl:     wsUrl,
+		ctxLog:    log.WithFields(log.Fields{"id": "BinanceUserStreams"}),
+	}
+
+	go u.closeIdle()
+
+	return u
+}
+
+// subscribe must be called before the order is sent, so no event of the order is missed
+func (u *userStreams) subscribe(apiKey string, secretKey string, clientOrderId string) <-chan *streamEvent {
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	stream := u.streams[apiKey]
+
+	if stream == nil {
+		stream = &userStream{apiKey: apiKey, secretKey: secretKey, orders: make(map[string]chan *streamEvent)}
+		u.streams[apiKey] = stream
+		go u.run(stream)
+	}
+
+	events := make(chan *streamEvent, streamEventsBuffer)
+
+	stream.orders[clientOrderId] = events
+
+	return events
+}
+
+func (u *userStreams) unsubscribe(apiKey string, clientOrderId string) {
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	stream := u.streams[apiKey]
+
+	if stream == nil {
+		return
+	}
+
+	delete(stream.orders, clientOrderId)
+
+	if len(stream.orders) == 0 {
+		stream.idleSince = time.Now()
+	}
+}
+
+func (u *userStreams) isConnected(apiKey string) bool {
+
+	u.lock.Lock()
+	stream := u.streams[apiKey]
+	u.lock.Unlock()
+
+	return stream != nil && atomic.LoadInt32(&stream.connected) == 1
+}
+
+func (u *userStreams) closeIdle() {
+
+	for {
+
+		time.Sleep(streamCheckTime)
+
+		u.lock.Lock()
+
+		for apiKey, stream := range u.streams {
+
+			if len(stream.orders) == 0 && time.Since(stream.idleSince) > streamIdleTime {
+
+				delete(u.streams, apiKey)
+
+				stream.stopped = true
+
+				if stream.connection != nil {
+					_ = stream.connection.Close()
+				}
+			}
+		}
+
+		u.lock.Unlock()
+	}
+}
+
+func (u *userStreams) isStopped(stream *userStream) bool {
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return stream.stopped
+}
+
+func (u *userStreams) dispatch(stream *userStream, bytes []byte) bool {
+
+	var event struct {
+		Event     string `json:"e"`
+		EventTime int64  `json:"E"`
+	}
+
+	if err := json.Unmarshal(bytes, &event); err != nil {
+		u.ctxLog.Error("Cannot parse user stream event ["+string(bytes)+"] ", err)
+		return true
+	}
+
+	var se *streamEvent
+
+	switch event.Event {
+
+	case executionReportEvent:
+
+		var report executionReport
+
+		if err := json.Unmarshal(bytes, &report); err != nil {
+			u.ctxLog.Error("Cannot parse executionReport ["+string(bytes)+"] ", err)
+			return true
+		}
+
+		se = &streamEvent{report: &report}
+
+	case accountPositionEvent:
+
+		var position accountPosition
+
+		if err := json.Unmarshal(bytes, &position); err != nil {
+			u.ctxLog.Error("Cannot parse outboundAccountPosition ["+string(bytes)+"] ", err)
+			return true
+		}
+
+		balances := make([]cmd.Balance, 0, len(position.Balances))
+
+		for _, b := range position.Balances {
+
+			free, err := decimal.NewFromString(b.Free)
+
+			if err != nil {
+				u.ctxLog.Error("Cannot parse free balance ["+string(bytes)+"] ", err)
+				return true
+			}
+
+			locked, err := decimal.NewFromString(b.Locked)
+
+			if err != nil {
+				u.ctxLog.Error("Cannot parse locked balance ["+string(bytes)+"] ", err)
+				return true
+			}
+
+			balances = append(balances, cmd.Balance{Asset: b.Asset, Free: free, Locked: locked})
+		}
+
+		se = &streamEvent{balances: balances}
+
+	case listenKeyExpiredEvent:
+		u.ctxLog.Warn("Listen key expired")
+		return false
+
+	default:
+		return true
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	send := func(events chan *streamEvent) {
+		select {
+		case events <- se:
+		default:
+			u.ctxLog.Error("Subscriber is too slow, event dropped ", string(bytes))
+		}
+	}
+
+	if se.report != nil {
+
+		if events := stream.orders[se.report.clientOrderId()]; events != nil {
+			send(events)
+		}
+
+	} else {
+
+		for _, events := range stream.orders {
+			send(events)
+		}
+	}
+
+	return true
+}
+
+// run keeps stream connected till it gets idle: gets listen key, keeps it alive and reconnects on any error
+func (u *userStreams) run(stream *userStream) {
+
+	client := u.newClient(stream.apiKey, stream.secretKey)
+
+	for !u.isStopped(stream) {
+
+		listenKey, err := client.NewStartUserStreamService().Do(context.Background())
+
+		if err != nil {
+			u.ctxLog.Error("Cannot start user stream ", err)
+			time.Sleep(streamErrorSleepTime)
+			continue
+		}
+
+		connection, _, err := websocket.DefaultDialer.Dial(u.wsUrl+"/"+listenKey, nil)
+
+		if err != nil {
+			u.ctxLog.Error("User stream dial error ", err)
+			time.Sleep(streamErrorSleepTime)
+			continue
+		}
+
+		u.lock.Lock()
+		stream.connection = connection
+		stopped := stream.stopped
+		u.lock.Unlock()
+
+		if stopped {
+			_ = connection.Close()
+			break
+		}
+
+		atomic.StoreInt32(&stream.connected, 1)
+
+		done := make(chan struct{})
+
+		go func() {
+
+			ticker := time.NewTicker(streamKeepAliveTime)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := client.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(context.Background()); err != nil {
+						u.ctxLog.Error("User stream keepalive error ", err)
+						_ = connection.Close()
+					}
+				}
+			}
+		}()
+
+		for {
+
+			_, bytes, err := connection.ReadMessage()
+
+			if err != nil {
+				if !u.isStopped(stream) {
+					u.ctxLog.Error("User stream read error ", err)
+				}
+				break
+			}
+
+			if !u.dispatch(stream, bytes) {
+				break
+			}
+		}
+
+		atomic.StoreInt32(&stream.connected, 0)
+
+		close(done)
+
+		_ = connection.Close()
+
+		if u.isStopped(stream) {
+			if err := client.NewCloseUserStreamService().ListenKey(listenKey).Do(context.Background()); err != nil {
+				u.ctxLog.Error("Cannot close user stream ", err)
+			}
+		}
+	}
+}
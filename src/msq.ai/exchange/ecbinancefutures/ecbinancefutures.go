@@ -0,0 +1,578 @@
+package ecbinancefutures
+
+import (
+	"context"
+	"fmt"
+	"github.com/adshao/go-binance/common"
+	"github.com/adshao/go-binance/futures"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/connector"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"msq.ai/exchange/ecbinance"
+	"msq.ai/exchange/ecbinance/orders"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const isolatedValue = "ISOLATED"
+const crossedValue = "CROSSED"
+
+const orderNotExistError = -2013
+const sendStatusUnknownError = -1007
+const positionSideError = -4061
+const noNeedToChangeMarginTypeError = -4046
+
+const followPollTime = 2 * time.Second
+
+// create order response has no creation time, trades are looked for since update time minus that
+const createdOrderLookBack = time.Minute
+
+func isAlive(status futures.OrderStatusType) bool {
+	return orders.IsAlive(string(status))
+}
+
+func isApiError(err error, code int64) bool {
+	return common.IsAPIError(err) && err.(*common.APIError).Code == code
+}
+
+// toOrder keeps fields of the order which are needed to settle it
+func toOrder(order *futures.Order) *orders.Order {
+	return &orders.Order{
+		OrderId:          order.OrderID,
+		ClientOrderId:    order.ClientOrderID,
+		Symbol:           order.Symbol,
+		Status:           string(order.Status),
+		ExecutedQuantity: order.ExecutedQuantity,
+		Time:             order.Time,
+		UpdateTime:       order.UpdateTime,
+		Description:      fmt.Sprintf("%+v", order),
+	}
+}
+
+func toTrades(trades []*futures.AccountTrade) []*orders.Trade {
+
+	result := make([]*orders.Trade, len(trades))
+
+	for i, t := range trades {
+		result[i] = &orders.Trade{Id: t.ID, OrderId: t.OrderID, Quantity: t.Quantity, Price: t.Price,
+			Commission: t.Commission, CommissionAsset: t.CommissionAsset, Time: t.Time}
+	}
+
+	return result
+}
+
+// createdOrder keeps fields of create order response which are needed to follow and settle the order
+func createdOrder(order *futures.CreateOrderResponse) *futures.Order {
+	return &futures.Order{
+		Symbol:           order.Symbol,
+		OrderID:          order.OrderID,
+		ClientOrderID:    order.ClientOrderID,
+		Price:            order.Price,
+		ReduceOnly:       order.ReduceOnly,
+		OrigQuantity:     order.OrigQuantity,
+		ExecutedQuantity: order.ExecutedQuantity,
+		CumQuote:         order.CumQuote,
+		Status:           order.Status,
+		TimeInForce:      order.TimeInForce,
+		Type:             order.Type,
+		Side:             order.Side,
+		Time:             order.UpdateTime - int64(createdOrderLookBack/time.Millisecond),
+		UpdateTime:       order.UpdateTime,
+		AvgPrice:         order.AvgPrice,
+		PositionSide:     order.PositionSide,
+	}
+}
+
+// positionSide returns position which is opened or closed by the order. In hedge mode BUY opens LONG and closes SHORT.
+// In one-way mode there is the only BOTH position, closing orders are sent reduce-only so they never reverse it.
+func positionSide(dual bool, side futures.SideType, executionType string) (futures.PositionSideType, bool) {
+
+	closing := executionType == constants.ExecutionTypeCloseName
+
+	if !dual {
+		return futures.PositionSideTypeBoth, closing
+	}
+
+	if (side == futures.SideTypeBuy) != closing {
+		return futures.PositionSideTypeLong, false
+	}
+
+	return futures.PositionSideTypeShort, false
+}
+
+// Config keeps settings of Binance futures connector
+type Config struct {
+	ApiUrl     string
+	RecvWindow time.Duration
+}
+
+func RunBinanceFuturesConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse, execPoolSize int,
+	config Config, limiter *ecbinance.Limiter) {
+
+	ctxLog := log.WithFields(log.Fields{"id": "BinanceFuturesConnector"})
+
+	if len(config.ApiUrl) < 1 {
+		ctxLog.Fatal("ApiUrl is empty !")
+	}
+
+	if config.RecvWindow < time.Millisecond {
+		ctxLog.Fatal("RecvWindow less than 1 ms !")
+	}
+
+	if limiter == nil {
+		ctxLog.Fatal("limiter is nil !")
+	}
+
+	httpClient := ecbinance.NewHttpClient(limiter, execPoolSize)
+
+	newClient := func(request *proto.ExecRequest) *futures.Client {
+
+		client := futures.NewClient(request.RawCmd.ApiKey, request.RawCmd.SecretKey)
+
+		client.BaseURL = config.ApiUrl
+		client.HTTPClient = httpClient
+
+		return client
+	}
+
+	modes := newPositionModes()
+
+	recvWindow := futures.WithRecvWindow(int64(config.RecvWindow / time.Millisecond))
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	errorResponse := func(response *proto.ExecResponse, err error) *proto.ExecResponse {
+
+		response.Status = proto.StatusError
+
+		response.Description = response.Description + " Parse error [" + err.Error() + "]"
+
+		return response
+	}
+
+	progress := func(request *proto.ExecRequest, status futures.OrderStatusType, executed string) {
+		orders.Progress(ctxLog, request, string(status), executed)
+	}
+
+	// follow polls alive order till it gets final status, every change of status or executed quantity is reported.
+	// Returns the last state of alive order when the request may not follow it any more.
+	follow := func(request *proto.ExecRequest, client *futures.Client, order *futures.Order) *futures.Order {
+
+		status := order.Status
+		executed := order.ExecutedQuantity
+
+		progress(request, status, executed)
+
+		for {
+
+			if !request.KeepFollowing() {
+				return order
+			}
+
+			time.Sleep(followPollTime)
+
+			current, err := client.NewGetOrderService().Symbol(request.RawCmd.Instrument).
+				OrigClientOrderID(request.RawCmd.Id).Do(context.Background(), recvWindow)
+
+			if err != nil {
+				ctxLog.Error("Follow error ", err)
+				continue
+			}
+
+			order = current
+
+			if !isAlive(order.Status) {
+				return order
+			}
+
+			if order.Status != status || order.ExecutedQuantity != executed {
+
+				status = order.Status
+				executed = order.ExecutedQuantity
+
+				progress(request, status, executed)
+			}
+		}
+	}
+
+	// settle builds final response of existing order with fills taken from account trade list
+	settle := func(request *proto.ExecRequest, response *proto.ExecResponse, client *futures.Client,
+		order *futures.Order) *proto.ExecResponse {
+
+		list := func(symbol string, startTime int64, fromId int64, limit int) ([]*orders.Trade, error) {
+
+			service := client.NewListAccountTradeService().Symbol(symbol).Limit(limit)
+
+			if fromId < 0 {
+				service = service.StartTime(startTime)
+			} else {
+				service = service.FromID(fromId)
+			}
+
+			trades, err := service.Do(context.Background(), recvWindow)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return toTrades(trades), nil
+		}
+
+		return orders.Settle(ctxLog, request, response, toOrder(order), nil, list)
+	}
+
+	// finish follows existing order till final status and settles it, the order is parked when it is still alive
+	finish := func(request *proto.ExecRequest, response *proto.ExecResponse, client *futures.Client,
+		order *futures.Order) *proto.ExecResponse {
+
+		if isAlive(order.Status) {
+			order = follow(request, client, order)
+		}
+
+		if isAlive(order.Status) {
+			return orders.Park(response, string(order.Status), order.ExecutedQuantity)
+		}
+
+		return settle(request, response, client, order)
+	}
+
+	// findLost looks for the order which answer is lost by its client order id, unknown order is parked, so it is
+	// checked again later and traded again or timed out then
+	findLost := func(request *proto.ExecRequest, response *proto.ExecResponse, client *futures.Client,
+		cause error) *proto.ExecResponse {
+
+		order, err := client.NewGetOrderService().Symbol(request.RawCmd.Instrument).
+			OrigClientOrderID(request.RawCmd.Id).Do(context.Background(), recvWindow)
+
+		if err != nil {
+			ctxLog.Warn("Order of lost answer isn't found ", err)
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown after ["+cause.Error()+"]")
+		}
+
+		ctxLog.Info("Order of lost answer is found ", order)
+
+		return finish(request, response, client, order)
+	}
+
+	trade := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		var orderType futures.OrderType
+		var side futures.SideType
+		var timeInForce futures.TimeInForceType
+
+		if request.RawCmd.OrderType == constants.OrderTypeMarketName {
+			orderType = futures.OrderTypeMarket
+		} else if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+			orderType = futures.OrderTypeLimit
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong OrderType ! ", request)
+			return nil
+		}
+
+		if request.RawCmd.Direction == constants.OrderDirectionBuyName {
+			side = futures.SideTypeBuy
+		} else if request.RawCmd.Direction == constants.OrderDirectionSellName {
+			side = futures.SideTypeSell
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong Direction with empty cmd ! ", request)
+			return nil
+		}
+
+		if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+			if request.RawCmd.TimeInForce == constants.TimeInForceGtcName {
+				timeInForce = futures.TimeInForceTypeGTC
+			} else if request.RawCmd.TimeInForce == constants.TimeInForceFokName {
+				timeInForce = futures.TimeInForceTypeFOK
+			} else {
+				msg := "Protocol violation! ExecRequest has wrong TimeInForce."
+				ctxLog.Error(msg, request)
+				response.Description = msg
+				return response
+			}
+		}
+
+		client := newClient(request)
+
+		loadMode := func() (bool, error) {
+
+			mode, err := client.NewGetPositionModeService().Do(context.Background(), recvWindow)
+
+			if err != nil {
+				return false, err
+			}
+
+			return mode.DualSidePosition, nil
+		}
+
+		var order *futures.CreateOrderResponse
+		var err error
+		var lost bool
+
+		// rejected requests are repeated, order isn't created by Binance on rate limit and position side errors
+		for {
+
+			var dual bool
+
+			dual, err = modes.get(request.RawCmd.ApiKey, loadMode)
+
+			if err != nil {
+				break
+			}
+
+			position, reduceOnly := positionSide(dual, side, request.RawCmd.ExecutionType)
+
+			orderService := client.NewCreateOrderService().Symbol(request.RawCmd.Instrument)
+			orderService = orderService.NewClientOrderID(request.RawCmd.Id).NewOrderResponseType(futures.NewOrderRespTypeRESULT)
+			orderService = orderService.Type(orderType).Side(side).PositionSide(position).Quantity(request.RawCmd.Amount)
+
+			if reduceOnly {
+				orderService = orderService.ReduceOnly(true)
+			}
+
+			if orderType == futures.OrderTypeLimit {
+				orderService = orderService.Price(request.RawCmd.LimitPrice).TimeInForce(timeInForce)
+			}
+
+			start := time.Now()
+
+			order, err = orderService.Do(context.Background(), recvWindow)
+
+			response.OutsideExecution = time.Now().Sub(start)
+
+			if err == nil {
+				break
+			}
+
+			if ecbinance.IsRateLimitError(err) {
+
+				ctxLog.Warn("Trade is rate limited, will be repeated after back off ", err)
+
+				time.Sleep(limiter.PauseTime())
+
+			} else if isApiError(err, positionSideError) {
+
+				ctxLog.Warn("Position mode of API key has been changed, trade will be repeated ", err)
+
+				modes.forget(request.RawCmd.ApiKey)
+
+			} else {
+				// the order may be created when Binance doesn't answer or doesn't know the result
+				lost = !common.IsAPIError(err) || isApiError(err, sendStatusUnknownError)
+				break
+			}
+
+			if request.Cmd.ExecuteTillTime.Before(time.Now()) {
+				response.Description = "TimedOut while repeating rejected trade " + err.Error()
+				response.Status = proto.StatusTimedOut
+				return response
+			}
+		}
+
+		if err != nil && lost {
+			ctxLog.Error("Trade answer is lost ", err)
+			return findLost(request, response, client, err)
+		}
+
+		if err != nil {
+			ctxLog.Error("Trade error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		ctxLog.Trace("Order from Binance futures ", order)
+
+		return finish(request, response, client, createdOrder(order))
+	}
+
+	check := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		client := newClient(request)
+
+		order, err := client.NewGetOrderService().Symbol(request.RawCmd.Instrument).
+			OrigClientOrderID(request.RawCmd.Id).Do(context.Background(), recvWindow)
+
+		if err != nil {
+
+			if isApiError(err, orderNotExistError) {
+
+				if request.Cmd.ExecuteTillTime.After(time.Now()) {
+					return trade(request, response)
+				} else {
+					ctxLog.Info("Check error, order not exist and will be marked timed_out ", err)
+					response.Description = err.Error()
+					response.Status = proto.StatusTimedOut
+					return response
+				}
+
+			} else {
+				ctxLog.Error("Check error ", err)
+				response.Description = err.Error()
+				return response
+			}
+		}
+
+		ctxLog.Trace("Order from Binance futures ", order)
+
+		return finish(request, response, client, order)
+	}
+
+	// accountState puts balances and positions into response, all positions of the symbol are taken when it is given and
+	// open positions of all symbols otherwise. Free balance is what can be withdrawn, the rest of wallet is margin.
+	accountState := func(response *proto.ExecResponse, client *futures.Client, symbol string) *proto.ExecResponse {
+
+		account, err := client.NewGetAccountService().Do(context.Background(), recvWindow)
+
+		if err != nil {
+			ctxLog.Error("Account error ", err)
+			response.Description = strings.TrimSpace(response.Description + " " + err.Error())
+			return response
+		}
+
+		response.Balances = make([]cmd.Balance, 0)
+
+		for _, a := range account.Assets {
+
+			wallet, err := decimal.NewFromString(a.WalletBalance)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			free, err := decimal.NewFromString(a.MaxWithdrawAmount)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			if wallet.IsZero() && free.IsZero() {
+				continue
+			}
+
+			locked := wallet.Sub(free)
+
+			if locked.IsNegative() {
+				locked = decimal.Zero
+			}
+
+			response.Balances = append(response.Balances, cmd.Balance{Asset: a.Asset, Free: free, Locked: locked})
+		}
+
+		response.Positions = make([]cmd.Position, 0)
+
+		for _, p := range account.Positions {
+
+			if len(symbol) > 0 && p.Symbol != symbol {
+				continue
+			}
+
+			amount, err := decimal.NewFromString(p.PositionAmt)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			if len(symbol) == 0 && amount.IsZero() {
+				continue
+			}
+
+			position := cmd.Position{Symbol: p.Symbol, PositionSide: string(p.PositionSide), Amount: amount,
+				MarginType: crossedValue}
+
+			if p.Isolated {
+				position.MarginType = isolatedValue
+			}
+
+			position.EntryPrice, err = decimal.NewFromString(p.EntryPrice)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			position.UnrealizedProfit, err = decimal.NewFromString(p.UnrealizedProfit)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			leverage, err := strconv.ParseInt(p.Leverage, 10, 16)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			position.Leverage = int16(leverage)
+
+			response.Positions = append(response.Positions, position)
+		}
+
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	// info answers INFO with account state and sets up leverage or margin type of the symbol, set-ups answer with
+	// positions of the symbol to show the result
+	info := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		client := newClient(request)
+
+		symbol := strings.TrimSpace(request.RawCmd.Instrument)
+
+		switch request.RawCmd.OrderType {
+
+		case constants.OrderTypeInfoName:
+			return accountState(response, client, "")
+
+		case constants.OrderTypeLeverageName:
+
+			leverage, err := decimal.NewFromString(request.RawCmd.Amount)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			result, err := client.NewChangeLeverageService().Symbol(symbol).Leverage(int(leverage.IntPart())).
+				Do(context.Background(), recvWindow)
+
+			if err != nil {
+				ctxLog.Error("Leverage error ", err)
+				response.Description = err.Error()
+				return response
+			}
+
+			response.Description = fmt.Sprintf("%+v", result)
+
+		case constants.OrderTypeMarginIsolatedName, constants.OrderTypeMarginCrossedName:
+
+			marginType := futures.MarginTypeCrossed
+
+			if request.RawCmd.OrderType == constants.OrderTypeMarginIsolatedName {
+				marginType = futures.MarginTypeIsolated
+			}
+
+			err := client.NewChangeMarginTypeService().Symbol(symbol).MarginType(marginType).
+				Do(context.Background(), recvWindow)
+
+			if err != nil && !isApiError(err, noNeedToChangeMarginTypeError) {
+				ctxLog.Error("Margin type error ", err)
+				response.Description = err.Error()
+				return response
+			}
+
+			response.Description = "Margin type of " + symbol + " is " + string(marginType)
+
+		default:
+			response.Description = "Order type " + request.RawCmd.OrderType + " isn't supported by Binance futures"
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		return accountState(response, client, symbol)
+	}
+
+	connector.RunConnector(ctxLog, in, out, execPoolSize, trade, check, info)
+}
@@ -0,0 +1,244 @@
+package ecbinancefutures
+
+import (
+	"github.com/shopspring/decimal"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"msq.ai/exchange/ecbinance"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/ecbinancefutures/mock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTimeout = 20 * time.Second
+
+type testConnector struct {
+	server  *mock.Server
+	url     string
+	in      chan *proto.ExecRequest
+	out     chan *proto.ExecResponse
+	apiKey  string
+	lastId  int64
+	dropped bool
+}
+
+// startConnector runs connector against mock Binance futures with BTCUSDT at 10000, every test trades with its own
+// API key, so it has its own account at the mock. Create order request is dropped unanswered while dropped is set.
+func startConnector(t *testing.T) *testConnector {
+
+	c := &testConnector{
+		server: mock.NewServer(
+			[]spot.Symbol{{Name: "BTCUSDT", Base: "BTC", Quote: "USDT", Price: decimal.NewFromInt(10000)}},
+			map[string]decimal.Decimal{"USDT": decimal.NewFromInt(100000)},
+			spot.Outcome{Status: spot.OutcomeFilled}),
+		in:     make(chan *proto.ExecRequest),
+		out:    make(chan *proto.ExecResponse, 100),
+		apiKey: t.Name(),
+	}
+
+	handler := c.server.Handler()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if c.dropped && r.Method == http.MethodPost && r.URL.Path == "/fapi/v1/order" {
+			if connection, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				_ = connection.Close()
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+
+	t.Cleanup(httpServer.Close)
+
+	c.url = httpServer.URL
+
+	RunBinanceFuturesConnector(c.in, c.out, 2, Config{ApiUrl: httpServer.URL, RecvWindow: 5 * time.Second},
+		ecbinance.NewLimiter(2400, 300))
+
+	return c
+}
+
+// request wraps command of the test account as coordinator does, every command gets next id
+func (c *testConnector) request(what proto.ExecType, orderType string, direction string, executionType string,
+	amount string, executeFor time.Duration) *proto.ExecRequest {
+
+	if what != proto.CheckCmd {
+		c.lastId++
+	}
+
+	now := time.Now()
+
+	raw := &cmd.RawCommand{Id: strconv.FormatInt(c.lastId, 10), Instrument: "BTCUSDT", Direction: direction,
+		OrderType: orderType, Amount: amount, ExecutionType: executionType, ApiKey: c.apiKey, SecretKey: "secret"}
+
+	command := &cmd.Command{Id: c.lastId, InstrumentName: raw.Instrument, ExecuteTillTime: now.Add(executeFor),
+		ApiKey: raw.ApiKey, SecretKey: raw.SecretKey}
+
+	command.Amount, _ = decimal.NewFromString(amount)
+
+	return &proto.ExecRequest{What: what, RawCmd: raw, Cmd: command, FollowTill: now.Add(time.Minute)}
+}
+
+func (c *testConnector) market(direction string, executionType string, amount string) *proto.ExecRequest {
+	return c.request(proto.ExecuteCmd, constants.OrderTypeMarketName, direction, executionType, amount, time.Minute)
+}
+
+// execute sends the request and waits for its last response, progress responses are skipped
+func (c *testConnector) execute(t *testing.T, r *proto.ExecRequest) *proto.ExecResponse {
+
+	t.Helper()
+
+	c.in <- r
+
+	deadline := time.After(testTimeout)
+
+	for {
+		select {
+		case <-deadline:
+			t.Fatal("No response of command ", r.RawCmd.Id)
+			return nil
+		case response := <-c.out:
+			if response.Request == r && (proto.IsFinal(response.Status) || response.Parked) {
+				return response
+			}
+		}
+	}
+}
+
+// positions returns amounts of open positions of the test account by position sides
+func (c *testConnector) positions(t *testing.T) map[string]string {
+
+	t.Helper()
+
+	response := c.execute(t, c.request(proto.InfoCmd, constants.OrderTypeInfoName, "", "", "", time.Minute))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Info error ", response.Description)
+	}
+
+	result := make(map[string]string)
+
+	for _, p := range response.Positions {
+		result[p.PositionSide] = p.Amount.String()
+	}
+
+	return result
+}
+
+func TestLostAnswerIsRecoveredByClientOrderId(t *testing.T) {
+
+	c := startConnector(t)
+
+	// the order is filled, but connection is closed instead of the answer
+	c.server.Push(spot.Outcome{Status: spot.OutcomeLost})
+
+	response := c.execute(t, c.market(constants.OrderDirectionBuyName, constants.ExecutionTypeOpenName, "0.01"))
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.01")) {
+		t.Fatal("Order of lost answer isn't settled ", response.Status, " ", response.Description)
+	}
+}
+
+func TestUnknownOrderOfLostAnswerIsParked(t *testing.T) {
+
+	c := startConnector(t)
+
+	c.dropped = true
+
+	r := c.market(constants.OrderDirectionBuyName, constants.ExecutionTypeOpenName, "0.01")
+
+	response := c.execute(t, r)
+
+	if response.Status != proto.StatusOpen || !response.Parked {
+		t.Fatal("Unknown order isn't parked ", response.Status, " ", response.Description)
+	}
+
+	// the order never reached Binance, so parked check times it out when the command has no time
+	check := c.request(proto.CheckCmd, constants.OrderTypeMarketName, constants.OrderDirectionBuyName,
+		constants.ExecutionTypeOpenName, "0.01", -time.Second)
+
+	if response = c.execute(t, check); response.Status != proto.StatusTimedOut {
+		t.Fatal("Unknown order isn't timed out by check ", response.Status, " ", response.Description)
+	}
+}
+
+func TestCloseInOneWayModeIsReduceOnly(t *testing.T) {
+
+	c := startConnector(t)
+
+	response := c.execute(t, c.market(constants.OrderDirectionBuyName, constants.ExecutionTypeOpenName, "0.01"))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Position isn't opened ", response.Description)
+	}
+
+	// closing more than the position would reverse it without reduce-only
+	response = c.execute(t, c.market(constants.OrderDirectionSellName, constants.ExecutionTypeCloseName, "0.02"))
+
+	if response.Status != proto.StatusError || !strings.Contains(response.Description, "-2022") {
+		t.Fatal("Close larger than position isn't refused ", response.Status, " ", response.Description)
+	}
+
+	if amount := c.positions(t)["BOTH"]; amount != "0.01" {
+		t.Fatal("Position is changed by refused close ", amount)
+	}
+
+	response = c.execute(t, c.market(constants.OrderDirectionSellName, constants.ExecutionTypeCloseName, "0.01"))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Position isn't closed ", response.Description)
+	}
+}
+
+func TestChangedPositionModeIsReloaded(t *testing.T) {
+
+	c := startConnector(t)
+
+	response := c.execute(t, c.market(constants.OrderDirectionBuyName, constants.ExecutionTypeOpenName, "0.01"))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Position isn't opened ", response.Description)
+	}
+
+	response = c.execute(t, c.market(constants.OrderDirectionSellName, constants.ExecutionTypeCloseName, "0.01"))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Position isn't closed ", response.Description)
+	}
+
+	// the account switches to hedge mode while the connector keeps one-way mode of the key
+	body := url.Values{"dualSidePosition": {"true"}}
+
+	switchRequest, _ := http.NewRequest(http.MethodPost, c.url+"/fapi/v1/positionSide/dual",
+		strings.NewReader(body.Encode()))
+
+	switchRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	switchRequest.Header.Set("X-MBX-APIKEY", c.apiKey)
+
+	switched, err := http.DefaultClient.Do(switchRequest)
+
+	if err != nil || switched.StatusCode != http.StatusOK {
+		t.Fatal("Position mode isn't switched ", err)
+	}
+
+	_ = switched.Body.Close()
+
+	response = c.execute(t, c.market(constants.OrderDirectionSellName, constants.ExecutionTypeOpenName, "0.01"))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Order isn't repeated in hedge mode ", response.Status, " ", response.Description)
+	}
+
+	if amount := c.positions(t)["SHORT"]; amount != "-0.01" {
+		t.Fatal("SHORT position isn't opened ", c.positions(t))
+	}
+}
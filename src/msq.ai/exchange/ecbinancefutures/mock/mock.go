@@ -0,0 +1,1053 @@
+package mock
+
+import (
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const apiKeyHeader = "X-MBX-APIKEY"
+
+const orderPath = "/fapi/v1/order"
+const accountPath = "/fapi/v1/account"
+const tradesPath = "/fapi/v1/userTrades"
+const leveragePath = "/fapi/v1/leverage"
+const marginTypePath = "/fapi/v1/marginType"
+const positionModePath = "/fapi/v1/positionSide/dual"
+const timePath = "/fapi/v1/time"
+const outcomesPath = "/mock/outcomes"
+
+const buySide = "BUY"
+const limitType = "LIMIT"
+
+const bothPosition = "BOTH"
+const longPosition = "LONG"
+const shortPosition = "SHORT"
+
+const isolatedMargin = "ISOLATED"
+const crossedMargin = "CROSSED"
+
+const defaultLeverage = 20
+const maxLeverage = 125
+
+const tradesDefaultLimit = 500
+const tradesMaxLimit = 1000
+
+// all amounts are formatted with precision of Binance
+const precision = 8
+
+var commissionRate = decimal.New(4, -4)
+
+type setting struct {
+	leverage int
+	isolated bool
+}
+
+// position amount is negative for short one
+type position struct {
+	symbol     string
+	amount     decimal.Decimal
+	entryPrice decimal.Decimal
+}
+
+type order struct {
+	symbol        *spot.Symbol
+	id            int64
+	clientOrderId string
+	side          string
+	positionSide  string
+	reduceOnly    bool
+	orderType     string
+	timeInForce   string
+	price         decimal.Decimal
+	quantity      decimal.Decimal
+	executed      decimal.Decimal
+	quoteExecuted decimal.Decimal
+	status        string
+	time          int64
+	updateTime    int64
+}
+
+func (o *order) isAlive() bool {
+	return o.status == spot.OutcomeNew || o.status == spot.OutcomePartiallyFilled
+}
+
+type trade struct {
+	id          int64
+	order       *order
+	price       decimal.Decimal
+	quantity    decimal.Decimal
+	commission  decimal.Decimal
+	realizedPnl decimal.Decimal
+	time        int64
+}
+
+type account struct {
+	wallet    map[string]decimal.Decimal
+	dual      bool
+	settings  map[string]*setting
+	positions map[string]*position
+	orders    map[string]*order
+	trades    []*trade
+}
+
+// Server imitates Binance USDⓈ-M futures REST API for one process, every API key gets its own account in one-way
+// position mode with initial wallet balances. Symbols are margined in their quote asset. What happens with created
+// orders is scripted with outcomes of spot mock.
+type Server struct {
+	lock sync.Mutex
+
+	symbols         map[string]*spot.Symbol
+	initialBalances map[string]decimal.Decimal
+
+	defaultOutcome spot.Outcome
+	outcomes       []spot.Outcome
+
+	accounts map[string]*account
+
+	nextOrderId int64
+	nextTradeId int64
+
+	ctxLog *log.Entry
+}
+
+func NewServer(symbols []spot.Symbol, balances map[string]decimal.Decimal, defaultOutcome spot.Outcome) *Server {
+
+	s := &Server{
+		symbols:         make(map[string]*spot.Symbol),
+		initialBalances: balances,
+		defaultOutcome:  defaultOutcome,
+		outcomes:        make([]spot.Outcome, 0),
+		accounts:        make(map[string]*account),
+		nextOrderId:     1,
+		nextTradeId:     1,
+		ctxLog:          log.WithFields(log.Fields{"id": "BinanceFuturesMock"}),
+	}
+
+	for i := range symbols {
+		s.symbols[symbols[i].Name] = &symbols[i]
+	}
+
+	return s
+}
+
+// Push appends outcomes to the script, they are taken by created orders one by one, default outcome is used after
+func (s *Server) Push(outcomes ...spot.Outcome) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.outcomes = append(s.outcomes, outcomes...)
+}
+
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(orderPath, s.handleOrder)
+	mux.HandleFunc(accountPath, s.handleAccount)
+	mux.HandleFunc(tradesPath, s.handleTrades)
+	mux.HandleFunc(leveragePath, s.handleLeverage)
+	mux.HandleFunc(marginTypePath, s.handleMarginType)
+	mux.HandleFunc(positionModePath, s.handlePositionMode)
+	mux.HandleFunc(timePath, s.handleTime)
+	mux.HandleFunc(outcomesPath, s.handleOutcomes)
+
+	return mux
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func format(val decimal.Decimal) string {
+	return val.StringFixed(precision)
+}
+
+// params merges query and form body, DELETE body isn't parsed by net/http
+func params(r *http.Request) (url.Values, error) {
+
+	result := r.URL.Query()
+
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	form, err := url.ParseQuery(string(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range form {
+		result[k] = append(result[k], v...)
+	}
+
+	return result, nil
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func apiError(w http.ResponseWriter, status int, code int, msg string) {
+	writeJson(w, status, map[string]interface{}{"code": code, "msg": msg})
+}
+
+func success(w http.ResponseWriter) {
+	writeJson(w, http.StatusOK, map[string]interface{}{"code": 200, "msg": "success"})
+}
+
+func positionKey(symbol string, positionSide string) string {
+	return symbol + ":" + positionSide
+}
+
+// getAccount must be called under lock
+func (s *Server) getAccount(apiKey string) *account {
+
+	a := s.accounts[apiKey]
+
+	if a == nil {
+
+		a = &account{
+			wallet:    make(map[string]decimal.Decimal),
+			settings:  make(map[string]*setting),
+			positions: make(map[string]*position),
+			orders:    make(map[string]*order),
+			trades:    make([]*trade, 0),
+		}
+
+		for asset, free := range s.initialBalances {
+			a.wallet[asset] = free
+		}
+
+		s.accounts[apiKey] = a
+	}
+
+	return a
+}
+
+// signedAccount returns account of the request or writes error if request has no API key
+func (s *Server) signedAccount(w http.ResponseWriter, r *http.Request) *account {
+
+	apiKey := r.Header.Get(apiKeyHeader)
+
+	if len(apiKey) == 0 {
+		apiError(w, http.StatusUnauthorized, -2014, "API-key format invalid.")
+		return nil
+	}
+
+	return s.getAccount(apiKey)
+}
+
+func (a *account) setting(symbol string) *setting {
+
+	st := a.settings[symbol]
+
+	if st == nil {
+		st = &setting{leverage: defaultLeverage}
+		a.settings[symbol] = st
+	}
+
+	return st
+}
+
+func (a *account) position(symbol string, positionSide string) *position {
+
+	p := a.positions[positionKey(symbol, positionSide)]
+
+	if p == nil {
+		p = &position{symbol: symbol}
+		a.positions[positionKey(symbol, positionSide)] = p
+	}
+
+	return p
+}
+
+func (a *account) hasPositions(symbol string) bool {
+
+	for _, p := range a.positions {
+		if !p.amount.IsZero() && (len(symbol) == 0 || p.symbol == symbol) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// usedMargin returns initial margin of all positions margined in the asset
+func (s *Server) usedMargin(a *account, asset string) decimal.Decimal {
+
+	var margin = decimal.Zero
+
+	for _, symbol := range s.symbols {
+
+		if symbol.Quote != asset {
+			continue
+		}
+
+		leverage := decimal.New(int64(a.setting(symbol.Name).leverage), 0)
+
+		for _, side := range []string{bothPosition, longPosition, shortPosition} {
+			if p := a.positions[positionKey(symbol.Name, side)]; p != nil {
+				margin = margin.Add(p.amount.Abs().Mul(symbol.Price).DivRound(leverage, precision))
+			}
+		}
+	}
+
+	return margin
+}
+
+func (s *Server) unrealizedProfit(symbol *spot.Symbol, p *position) decimal.Decimal {
+	return symbol.Price.Sub(p.entryPrice).Mul(p.amount)
+}
+
+func (s *Server) nextOutcome() spot.Outcome {
+
+	if len(s.outcomes) == 0 {
+		return s.defaultOutcome
+	}
+
+	outcome := s.outcomes[0]
+
+	s.outcomes = s.outcomes[1:]
+
+	return outcome
+}
+
+func (s *Server) findOrder(a *account, p url.Values) *order {
+
+	if clientOrderId := p.Get("origClientOrderId"); len(clientOrderId) > 0 {
+
+		if o := a.orders[clientOrderId]; o != nil && o.symbol.Name == p.Get("symbol") {
+			return o
+		}
+
+		return nil
+	}
+
+	id, err := strconv.ParseInt(p.Get("orderId"), 10, 64)
+
+	if err != nil {
+		return nil
+	}
+
+	for _, o := range a.orders {
+		if o.id == id && o.symbol.Name == p.Get("symbol") {
+			return o
+		}
+	}
+
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+// fill executes quantity of the order, it moves position and realizes profit of its reduced part, must be called
+// under lock
+func (s *Server) fill(a *account, o *order, quantity decimal.Decimal) *trade {
+
+	t := &trade{id: s.nextTradeId, order: o, price: o.price, quantity: quantity, time: now()}
+
+	s.nextTradeId++
+
+	signed := quantity
+
+	if o.side != buySide {
+		signed = signed.Neg()
+	}
+
+	p := a.position(o.symbol.Name, o.positionSide)
+
+	amount := p.amount.Add(signed)
+
+	if p.amount.IsZero() || p.amount.Sign() == signed.Sign() {
+
+		p.entryPrice = p.amount.Abs().Mul(p.entryPrice).Add(quantity.Mul(o.price)).DivRound(amount.Abs(), precision)
+
+	} else {
+
+		t.realizedPnl = o.price.Sub(p.entryPrice).Mul(decimal.Min(quantity, p.amount.Abs()))
+
+		if p.amount.IsNegative() {
+			t.realizedPnl = t.realizedPnl.Neg()
+		}
+
+		if amount.IsZero() {
+			p.entryPrice = decimal.Zero
+		} else if amount.Sign() != p.amount.Sign() {
+			p.entryPrice = o.price
+		}
+	}
+
+	p.amount = amount
+
+	quote := quantity.Mul(o.price)
+
+	t.commission = quote.Mul(commissionRate)
+
+	a.wallet[o.symbol.Quote] = a.wallet[o.symbol.Quote].Add(t.realizedPnl).Sub(t.commission)
+
+	o.executed = o.executed.Add(quantity)
+	o.quoteExecuted = o.quoteExecuted.Add(quote)
+	o.updateTime = t.time
+
+	if o.executed.Equal(o.quantity) {
+		o.status = spot.OutcomeFilled
+	} else {
+		o.status = spot.OutcomePartiallyFilled
+	}
+
+	a.trades = append(a.trades, t)
+
+	return t
+}
+
+// checkPosition validates position side and reduce-only flag against position mode and position, it returns error code
+// and message of Binance or zero code
+func (s *Server) checkPosition(a *account, o *order) (int, string) {
+
+	if a.dual == (o.positionSide == bothPosition) {
+		return -4061, "Order's position side does not match user's setting."
+	}
+
+	if a.dual && o.reduceOnly {
+		return -1106, "Parameter 'reduceOnly' sent when not required."
+	}
+
+	p := a.position(o.symbol.Name, o.positionSide)
+
+	reducing := (o.side == buySide && p.amount.IsNegative()) || (o.side != buySide && p.amount.IsPositive())
+
+	if o.positionSide == longPosition && o.side != buySide || o.positionSide == shortPosition && o.side == buySide ||
+		o.reduceOnly {
+
+		if !reducing || o.quantity.GreaterThan(p.amount.Abs()) {
+			return -2022, "ReduceOnly Order is rejected."
+		}
+
+		return 0, ""
+	}
+
+	if reducing {
+		return 0, ""
+	}
+
+	leverage := decimal.New(int64(a.setting(o.symbol.Name).leverage), 0)
+
+	required := o.quantity.Mul(o.price).DivRound(leverage, precision)
+
+	if required.GreaterThan(a.wallet[o.symbol.Quote].Sub(s.usedMargin(a, o.symbol.Quote))) {
+		return -2019, "Margin is insufficient."
+	}
+
+	return 0, ""
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, p url.Values) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	symbol := s.symbols[p.Get("symbol")]
+
+	if symbol == nil {
+		apiError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+		return
+	}
+
+	quantity, err := decimal.NewFromString(p.Get("quantity"))
+
+	if err != nil || !quantity.IsPositive() {
+		apiError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'quantity'.")
+		return
+	}
+
+	clientOrderId := p.Get("newClientOrderId")
+
+	if len(clientOrderId) == 0 {
+		clientOrderId = "mock" + strconv.FormatInt(s.nextOrderId, 10)
+	}
+
+	if a.orders[clientOrderId] != nil {
+		apiError(w, http.StatusBadRequest, -4015, "Client order id is not valid.")
+		return
+	}
+
+	o := &order{
+		symbol:        symbol,
+		id:            s.nextOrderId,
+		clientOrderId: clientOrderId,
+		side:          p.Get("side"),
+		positionSide:  p.Get("positionSide"),
+		reduceOnly:    p.Get("reduceOnly") == "true",
+		orderType:     p.Get("type"),
+		timeInForce:   p.Get("timeInForce"),
+		price:         symbol.Price,
+		quantity:      quantity,
+		status:        spot.OutcomeNew,
+		time:          now(),
+	}
+
+	if len(o.positionSide) == 0 {
+		o.positionSide = bothPosition
+	}
+
+	if o.orderType == limitType {
+
+		o.price, err = decimal.NewFromString(p.Get("price"))
+
+		if err != nil || !o.price.IsPositive() {
+			apiError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'price'.")
+			return
+		}
+	}
+
+	if code, msg := s.checkPosition(a, o); code != 0 {
+		apiError(w, http.StatusBadRequest, code, msg)
+		return
+	}
+
+	outcome := s.nextOutcome()
+
+	s.ctxLog.Info("Order ", clientOrderId, " ", o.side, " ", o.positionSide, " ", o.orderType, " ", format(quantity), " ",
+		symbol.Name, " reduceOnly ", o.reduceOnly, " outcome ", outcome)
+
+	switch outcome.Status {
+
+	case spot.OutcomeRejected:
+		apiError(w, http.StatusBadRequest, -2019, "Margin is insufficient.")
+		return
+
+	case spot.OutcomeError:
+		apiError(w, http.StatusInternalServerError, -1001, "Internal error; unable to process your request. Please try again.")
+		return
+
+	case spot.OutcomeRateLimit:
+		w.Header().Set("Retry-After", "1")
+		apiError(w, http.StatusTooManyRequests, -1003, "Too many requests.")
+		return
+	}
+
+	s.nextOrderId++
+
+	o.updateTime = o.time
+
+	a.orders[clientOrderId] = o
+
+	switch outcome.Status {
+
+	case spot.OutcomeFilled, spot.OutcomeLost:
+		s.fill(a, o, quantity)
+
+	case spot.OutcomePartiallyFilled:
+		s.fill(a, o, quantity.Div(decimal.New(2, 0)).Truncate(precision))
+
+	case spot.OutcomeExpired, spot.OutcomeCanceled:
+		o.status = outcome.Status
+	}
+
+	if o.isAlive() && outcome.FillAfter > 0 {
+
+		time.AfterFunc(outcome.FillAfter, func() {
+
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			if !o.isAlive() {
+				return
+			}
+
+			// position may be closed by other orders meanwhile, Binance expires such reduce-only orders
+			if p := a.position(o.symbol.Name, o.positionSide); o.reduceOnly && p.amount.Abs().LessThan(o.quantity.Sub(o.executed)) {
+				o.status = spot.OutcomeExpired
+				o.updateTime = now()
+				return
+			}
+
+			s.fill(a, o, o.quantity.Sub(o.executed))
+		})
+	}
+
+	if outcome.Status == spot.OutcomeLost {
+
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if connection, _, err := hijacker.Hijack(); err == nil {
+				_ = connection.Close()
+				return
+			}
+		}
+
+		apiError(w, http.StatusGatewayTimeout, -1007, "Timeout waiting for response from backend server. Send status unknown; execution status unknown.")
+		return
+	}
+
+	writeJson(w, http.StatusOK, orderJson(o))
+}
+
+func orderJson(o *order) map[string]interface{} {
+
+	var avgPrice = decimal.Zero
+
+	if o.executed.IsPositive() {
+		avgPrice = o.quoteExecuted.DivRound(o.executed, precision)
+	}
+
+	return map[string]interface{}{
+		"symbol":        o.symbol.Name,
+		"orderId":       o.id,
+		"clientOrderId": o.clientOrderId,
+		"price":         format(o.price),
+		"avgPrice":      format(avgPrice),
+		"reduceOnly":    o.reduceOnly,
+		"origQty":       format(o.quantity),
+		"executedQty":   format(o.executed),
+		"cumQty":        format(o.executed),
+		"cumQuote":      format(o.quoteExecuted),
+		"status":        o.status,
+		"timeInForce":   o.timeInForce,
+		"type":          o.orderType,
+		"origType":      o.orderType,
+		"side":          o.side,
+		"positionSide":  o.positionSide,
+		"stopPrice":     format(decimal.Zero),
+		"workingType":   "CONTRACT_PRICE",
+		"time":          o.time,
+		"updateTime":    o.updateTime,
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, p url.Values) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	o := s.findOrder(a, p)
+
+	if o == nil {
+		apiError(w, http.StatusBadRequest, -2013, "Order does not exist.")
+		return
+	}
+
+	writeJson(w, http.StatusOK, orderJson(o))
+}
+
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request, p url.Values) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	o := s.findOrder(a, p)
+
+	if o == nil || !o.isAlive() {
+		apiError(w, http.StatusBadRequest, -2011, "Unknown order sent.")
+		return
+	}
+
+	o.status = spot.OutcomeCanceled
+	o.updateTime = now()
+
+	writeJson(w, http.StatusOK, orderJson(o))
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+
+	p, err := params(r)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, -1100, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.create(w, r, p)
+	case http.MethodGet:
+		s.get(w, r, p)
+	case http.MethodDelete:
+		s.cancel(w, r, p)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAccount returns wallet of every asset and positions of every symbol, free margin can be withdrawn
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	names := make([]string, 0, len(s.symbols))
+
+	for name := range s.symbols {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	sides := []string{bothPosition}
+
+	if a.dual {
+		sides = []string{longPosition, shortPosition}
+	}
+
+	positions := make([]map[string]interface{}, 0)
+	unrealized := make(map[string]decimal.Decimal)
+
+	for _, name := range names {
+
+		symbol := s.symbols[name]
+		st := a.setting(name)
+
+		for _, side := range sides {
+
+			p := a.position(name, side)
+
+			profit := s.unrealizedProfit(symbol, p)
+			margin := p.amount.Abs().Mul(symbol.Price).DivRound(decimal.New(int64(st.leverage), 0), precision)
+
+			unrealized[symbol.Quote] = unrealized[symbol.Quote].Add(profit)
+
+			positions = append(positions, map[string]interface{}{
+				"symbol":                 name,
+				"initialMargin":          format(margin),
+				"maintMargin":            format(decimal.Zero),
+				"unrealizedProfit":       format(profit),
+				"positionInitialMargin":  format(margin),
+				"openOrderInitialMargin": format(decimal.Zero),
+				"leverage":               strconv.Itoa(st.leverage),
+				"isolated":               st.isolated,
+				"entryPrice":             format(p.entryPrice),
+				"maxNotional":            "1000000",
+				"positionSide":           side,
+				"positionAmt":            format(p.amount),
+			})
+		}
+	}
+
+	assetNames := make([]string, 0, len(a.wallet))
+
+	for asset := range a.wallet {
+		assetNames = append(assetNames, asset)
+	}
+
+	sort.Strings(assetNames)
+
+	assets := make([]map[string]string, 0, len(assetNames))
+
+	for _, asset := range assetNames {
+
+		wallet := a.wallet[asset]
+		margin := s.usedMargin(a, asset)
+
+		free := wallet.Sub(margin)
+
+		if free.IsNegative() {
+			free = decimal.Zero
+		}
+
+		assets = append(assets, map[string]string{
+			"asset":                  asset,
+			"initialMargin":          format(margin),
+			"maintMargin":            format(decimal.Zero),
+			"marginBalance":          format(wallet.Add(unrealized[asset])),
+			"maxWithdrawAmount":      format(free),
+			"openOrderInitialMargin": format(decimal.Zero),
+			"positionInitialMargin":  format(margin),
+			"unrealizedProfit":       format(unrealized[asset]),
+			"walletBalance":          format(wallet),
+		})
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"assets":      assets,
+		"canDeposit":  true,
+		"canTrade":    true,
+		"canWithdraw": true,
+		"feeTier":     0,
+		"positions":   positions,
+		"updateTime":  now(),
+	})
+}
+
+func (s *Server) handleTrades(w http.ResponseWriter, r *http.Request) {
+
+	p, err := params(r)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, -1100, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	limit, err := strconv.Atoi(p.Get("limit"))
+
+	if err != nil || limit < 1 {
+		limit = tradesDefaultLimit
+	}
+
+	if limit > tradesMaxLimit {
+		limit = tradesMaxLimit
+	}
+
+	startTime, _ := strconv.ParseInt(p.Get("startTime"), 10, 64)
+	fromId, _ := strconv.ParseInt(p.Get("fromId"), 10, 64)
+
+	result := make([]map[string]interface{}, 0)
+
+	for _, t := range a.trades {
+
+		if len(result) == limit {
+			break
+		}
+
+		if t.order.symbol.Name != p.Get("symbol") || t.time < startTime || t.id < fromId {
+			continue
+		}
+
+		result = append(result, map[string]interface{}{
+			"id":              t.id,
+			"symbol":          t.order.symbol.Name,
+			"orderId":         t.order.id,
+			"side":            t.order.side,
+			"positionSide":    t.order.positionSide,
+			"price":           format(t.price),
+			"qty":             format(t.quantity),
+			"quoteQty":        format(t.quantity.Mul(t.price)),
+			"realizedPnl":     format(t.realizedPnl),
+			"commission":      format(t.commission),
+			"commissionAsset": t.order.symbol.Quote,
+			"time":            t.time,
+			"buyer":           t.order.side == buySide,
+			"maker":           false,
+		})
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
+
+func (s *Server) handleLeverage(w http.ResponseWriter, r *http.Request) {
+
+	p, err := params(r)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, -1100, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	if s.symbols[p.Get("symbol")] == nil {
+		apiError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+		return
+	}
+
+	leverage, err := strconv.Atoi(p.Get("leverage"))
+
+	if err != nil || leverage < 1 || leverage > maxLeverage {
+		apiError(w, http.StatusBadRequest, -4028, "Leverage "+p.Get("leverage")+" is not valid")
+		return
+	}
+
+	a.setting(p.Get("symbol")).leverage = leverage
+
+	s.ctxLog.Info("Leverage of ", p.Get("symbol"), " is ", leverage)
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"leverage":         leverage,
+		"maxNotionalValue": "1000000",
+		"symbol":           p.Get("symbol"),
+	})
+}
+
+func (s *Server) handleMarginType(w http.ResponseWriter, r *http.Request) {
+
+	p, err := params(r)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, -1100, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	symbol := p.Get("symbol")
+
+	if s.symbols[symbol] == nil {
+		apiError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+		return
+	}
+
+	marginType := p.Get("marginType")
+
+	if marginType != isolatedMargin && marginType != crossedMargin {
+		apiError(w, http.StatusBadRequest, -1116, "Invalid marginType.")
+		return
+	}
+
+	st := a.setting(symbol)
+
+	if st.isolated == (marginType == isolatedMargin) {
+		apiError(w, http.StatusBadRequest, -4046, "No need to change margin type.")
+		return
+	}
+
+	if a.hasPositions(symbol) {
+		apiError(w, http.StatusBadRequest, -4048, "Margin type cannot be changed if there exists position.")
+		return
+	}
+
+	st.isolated = marginType == isolatedMargin
+
+	s.ctxLog.Info("Margin type of ", symbol, " is ", marginType)
+
+	success(w)
+}
+
+// handlePositionMode returns position mode, POST switches between one-way and hedge mode
+func (s *Server) handlePositionMode(w http.ResponseWriter, r *http.Request) {
+
+	p, err := params(r)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, -1100, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a := s.signedAccount(w, r)
+
+	if a == nil {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeJson(w, http.StatusOK, map[string]interface{}{"dualSidePosition": a.dual})
+		return
+	}
+
+	dual, err := strconv.ParseBool(p.Get("dualSidePosition"))
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'dualSidePosition'.")
+		return
+	}
+
+	if dual == a.dual {
+		apiError(w, http.StatusBadRequest, -4059, "No need to change position side.")
+		return
+	}
+
+	if a.hasPositions("") {
+		apiError(w, http.StatusBadRequest, -4068, "Position side cannot be changed if there exists position.")
+		return
+	}
+
+	a.dual = dual
+
+	s.ctxLog.Info("Position mode is dual ", dual)
+
+	success(w)
+}
+
+func (s *Server) handleTime(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]interface{}{"serverTime": now()})
+}
+
+// handleOutcomes appends outcomes posted as comma separated list, GET returns outcomes which are not taken yet
+func (s *Server) handleOutcomes(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost {
+
+		body, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		outcomes, err := spot.ParseOutcomes(string(body))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Push(outcomes...)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := make([]string, len(s.outcomes))
+
+	for i, o := range s.outcomes {
+		result[i] = o.String()
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{"default": s.defaultOutcome.String(), "outcomes": result})
+}
@@ -0,0 +1,47 @@
+package ecbinancefutures
+
+import (
+	"sync"
+)
+
+// positionModes keeps position mode of every API key, it is asked once and forgotten when Binance says it has been changed
+type positionModes struct {
+	lock  sync.Mutex
+	modes map[string]bool
+}
+
+func newPositionModes() *positionModes {
+	return &positionModes{modes: make(map[string]bool)}
+}
+
+// get returns true for hedge mode (dual side position), load is called when mode of API key isn't known
+func (m *positionModes) get(apiKey string, load func() (bool, error)) (bool, error) {
+
+	m.lock.Lock()
+	dual, ok := m.modes[apiKey]
+	m.lock.Unlock()
+
+	if ok {
+		return dual, nil
+	}
+
+	dual, err := load()
+
+	if err != nil {
+		return false, err
+	}
+
+	m.lock.Lock()
+	m.modes[apiKey] = dual
+	m.lock.Unlock()
+
+	return dual, nil
+}
+
+func (m *positionModes) forget(apiKey string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.modes, apiKey)
+}
@@ -0,0 +1,32 @@
+package ecbinancefutures
+
+import (
+	"msq.ai/connectors/proto"
+	"msq.ai/connectors/registry"
+	"msq.ai/exchange/ecbinance"
+	"time"
+)
+
+const propertiesApiUrlName = "api.url"
+const propertiesLimitsWeightName = "limits.weight.per.minute"
+const propertiesLimitsOrdersName = "limits.orders.per.10s"
+const propertiesRecvWindowMsName = "recv.window.ms"
+
+func init() {
+	registry.Register(registry.Exchange{Name: "BINANCE_FUTURES", ExecPoolSize: 200, Run: run})
+}
+
+func run(settings registry.Settings, in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse,
+	execPoolSize int) func() time.Duration {
+
+	config := Config{
+		ApiUrl:     settings.MustGet(propertiesApiUrlName),
+		RecvWindow: time.Duration(settings.GetInt(propertiesRecvWindowMsName, 5000)) * time.Millisecond,
+	}
+
+	limiter := ecbinance.NewLimiter(settings.GetInt(propertiesLimitsWeightName, 2400), settings.GetInt(propertiesLimitsOrdersName, 300))
+
+	RunBinanceFuturesConnector(in, out, execPoolSize, config, limiter)
+
+	return limiter.PauseTime
+}
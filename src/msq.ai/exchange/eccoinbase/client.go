@@ -0,0 +1,360 @@
+package eccoinbase
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const ordersPath = "/api/v3/brokerage/orders"
+const orderPath = "/api/v3/brokerage/orders/historical/"
+const listOrdersPath = "/api/v3/brokerage/orders/historical/batch"
+const fillsPath = "/api/v3/brokerage/orders/historical/fills"
+const accountsPath = "/api/v3/brokerage/accounts"
+const productPath = "/api/v3/brokerage/products/"
+
+// token lives two minutes as Coinbase requires, every request gets its own one
+const tokenLifetime = 2 * time.Minute
+const tokenIssuer = "cdp"
+
+const dialTimeout = 5 * time.Second
+const keepAliveTime = 30 * time.Second
+const idleConnTimeout = 90 * time.Second
+const tlsHandshakeTimeout = 5 * time.Second
+const responseHeaderTimeout = 10 * time.Second
+const requestTimeout = 30 * time.Second
+
+// APIError is returned for non 2xx responses of Coinbase
+type APIError struct {
+	Status  int
+	Code    string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Coinbase error status %d [%s] %s", e.Status, e.Code, e.Message)
+}
+
+func IsRateLimitError(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Status == http.StatusTooManyRequests
+}
+
+func isNotFoundError(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Status == http.StatusNotFound
+}
+
+// parseKey parses EC private key of CDP API key in SEC1 or PKCS8 PEM, keys copied from JSON keep escaped new lines
+func parseKey(secretKey string) (*ecdsa.PrivateKey, error) {
+
+	block, _ := pem.Decode([]byte(strings.Replace(secretKey, `\n`, "\n", -1)))
+
+	if block == nil {
+		return nil, errors.New("secret key isn't PEM")
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+
+	if !ok {
+		return nil, errors.New("secret key isn't EC key")
+	}
+
+	return key, nil
+}
+
+func encodeSegment(value interface{}) (string, error) {
+
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// token builds ES256 JWT for one request, uri claim binds it to method, host and path of the request
+func token(apiKey string, key *ecdsa.PrivateKey, uri string) (string, error) {
+
+	nonce := make([]byte, 16)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+
+	header, err := encodeSegment(map[string]interface{}{
+		"alg": "ES256", "typ": "JWT", "kid": apiKey, "nonce": hex.EncodeToString(nonce)})
+
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := encodeSegment(map[string]interface{}{
+		"sub": apiKey, "iss": tokenIssuer, "nbf": now, "exp": now + int64(tokenLifetime/time.Second), "uri": uri})
+
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(header + "." + claims))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+
+	if err != nil {
+		return "", err
+	}
+
+	// ES256 signature is r and s as 32 bytes big endian each
+	signature := make([]byte, 64)
+
+	rb := r.Bytes()
+	sb := s.Bytes()
+
+	copy(signature[32-len(rb):32], rb)
+	copy(signature[64-len(sb):], sb)
+
+	return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+type client struct {
+	apiUrl     string
+	host       string
+	httpClient *http.Client
+}
+
+func newClient(apiUrl string, maxIdleConns int) (*client, error) {
+
+	parsed, err := url.Parse(apiUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: keepAliveTime,
+		}).DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+	}
+
+	return &client{
+		apiUrl:     strings.TrimRight(apiUrl, "/"),
+		host:       parsed.Host,
+		httpClient: &http.Client{Transport: transport, Timeout: requestTimeout},
+	}, nil
+}
+
+// call sends signed request, body is marshalled to JSON when it isn't nil, result is unmarshalled from 2xx response
+func (c *client) call(apiKey string, secretKey string, method string, path string, query url.Values, body interface{},
+	result interface{}) error {
+
+	key, err := parseKey(secretKey)
+
+	if err != nil {
+		return err
+	}
+
+	jwt, err := token(apiKey, key, method+" "+c.host+path)
+
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+
+	if body != nil {
+
+		data, err = json.Marshal(body)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	target := c.apiUrl + path
+
+	if len(query) > 0 {
+		target = target + "?" + query.Encode()
+	}
+
+	request, err := http.NewRequest(method, target, bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+jwt)
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	answer, err := ioutil.ReadAll(resp.Body)
+
+	_ = resp.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+
+		apiErr := &APIError{Status: resp.StatusCode}
+
+		if json.Unmarshal(answer, apiErr) != nil || len(apiErr.Message) == 0 {
+			apiErr.Message = string(answer)
+		}
+
+		return apiErr
+	}
+
+	return json.Unmarshal(answer, result)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+type marketConfiguration struct {
+	BaseSize string `json:"base_size"`
+}
+
+type limitConfiguration struct {
+	BaseSize   string `json:"base_size"`
+	LimitPrice string `json:"limit_price"`
+	PostOnly   bool   `json:"post_only"`
+}
+
+type orderConfiguration struct {
+	MarketIoc *marketConfiguration `json:"market_market_ioc,omitempty"`
+	LimitGtc  *limitConfiguration  `json:"limit_limit_gtc,omitempty"`
+	LimitFok  *limitConfiguration  `json:"limit_limit_fok,omitempty"`
+}
+
+type createOrderRequest struct {
+	ClientOrderId      string             `json:"client_order_id"`
+	ProductId          string             `json:"product_id"`
+	Side               string             `json:"side"`
+	OrderConfiguration orderConfiguration `json:"order_configuration"`
+}
+
+type createOrderResponse struct {
+	Success         bool   `json:"success"`
+	FailureReason   string `json:"failure_reason"`
+	OrderId         string `json:"order_id"`
+	SuccessResponse struct {
+		OrderId       string `json:"order_id"`
+		ProductId     string `json:"product_id"`
+		Side          string `json:"side"`
+		ClientOrderId string `json:"client_order_id"`
+	} `json:"success_response"`
+	ErrorResponse struct {
+		Error                 string `json:"error"`
+		Message               string `json:"message"`
+		ErrorDetails          string `json:"error_details"`
+		NewOrderFailureReason string `json:"new_order_failure_reason"`
+	} `json:"error_response"`
+}
+
+type order struct {
+	OrderId            string `json:"order_id"`
+	ProductId          string `json:"product_id"`
+	Side               string `json:"side"`
+	ClientOrderId      string `json:"client_order_id"`
+	Status             string `json:"status"`
+	TimeInForce        string `json:"time_in_force"`
+	CreatedTime        string `json:"created_time"`
+	FilledSize         string `json:"filled_size"`
+	AverageFilledPrice string `json:"average_filled_price"`
+	NumberOfFills      string `json:"number_of_fills"`
+	FilledValue        string `json:"filled_value"`
+	TotalFees          string `json:"total_fees"`
+	OrderType          string `json:"order_type"`
+	RejectReason       string `json:"reject_reason"`
+	RejectMessage      string `json:"reject_message"`
+	CancelMessage      string `json:"cancel_message"`
+}
+
+type orderResponse struct {
+	Order *order `json:"order"`
+}
+
+type listOrdersResponse struct {
+	Orders  []*order `json:"orders"`
+	HasNext bool     `json:"has_next"`
+	Cursor  string   `json:"cursor"`
+}
+
+type fill struct {
+	EntryId     string `json:"entry_id"`
+	TradeId     string `json:"trade_id"`
+	OrderId     string `json:"order_id"`
+	TradeTime   string `json:"trade_time"`
+	Price       string `json:"price"`
+	Size        string `json:"size"`
+	Commission  string `json:"commission"`
+	SizeInQuote bool   `json:"size_in_quote"`
+}
+
+type fillsResponse struct {
+	Fills  []*fill `json:"fills"`
+	Cursor string  `json:"cursor"`
+}
+
+type amount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+type account struct {
+	Uuid             string `json:"uuid"`
+	Currency         string `json:"currency"`
+	AvailableBalance amount `json:"available_balance"`
+	Hold             amount `json:"hold"`
+}
+
+type accountsResponse struct {
+	Accounts []*account `json:"accounts"`
+	HasNext  bool       `json:"has_next"`
+	Cursor   string     `json:"cursor"`
+}
+
+type product struct {
+	ProductId       string `json:"product_id"`
+	BaseCurrencyId  string `json:"base_currency_id"`
+	QuoteCurrencyId string `json:"quote_currency_id"`
+}
@@ -0,0 +1,627 @@
+package eccoinbase
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/connector"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const openValue = "OPEN"
+const pendingValue = "PENDING"
+const queuedValue = "QUEUED"
+const cancelQueuedValue = "CANCEL_QUEUED"
+const unknownValue = "UNKNOWN_ORDER_STATUS"
+const filledValue = "FILLED"
+const cancelledValue = "CANCELLED"
+const expiredValue = "EXPIRED"
+const failedValue = "FAILED"
+
+const buyValue = "BUY"
+const sellValue = "SELL"
+
+const followPollTime = 2 * time.Second
+
+// private endpoints of Coinbase allow 30 requests per second per key, rejected trade is repeated after a pause
+const rateLimitSleepTime = time.Second
+
+const tradesAttempts = 3
+
+const pageLimit = 100
+
+// orders of expired commands are looked for among orders created that long before execute till time
+const checkLookBack = 24 * time.Hour
+
+// Coinbase identifies orders and trades by UUIDs, they are kept as external refs
+const unknownOrderId = -1
+const unknownTradeId = -1
+
+func isAlive(status string) bool {
+	return status == openValue || status == pendingValue || status == queuedValue || status == cancelQueuedValue ||
+		status == unknownValue
+}
+
+func toFills(fills []*fill, commissionAsset string) ([]cmd.Fill, error) {
+
+	var err error
+
+	result := make([]cmd.Fill, len(fills))
+
+	for i, f := range fills {
+
+		result[i].ExternalTradeId = unknownTradeId
+		result[i].ExternalTradeRef = f.TradeId
+		result[i].CommissionAsset = commissionAsset
+
+		result[i].Price, err = decimal.NewFromString(f.Price)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i].Quantity, err = decimal.NewFromString(f.Size)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if f.SizeInQuote {
+			result[i].Quantity = result[i].Quantity.DivRound(result[i].Price, constants.DecimalScale)
+		}
+
+		result[i].Commission, err = decimal.NewFromString(f.Commission)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Config keeps settings of Coinbase connector
+type Config struct {
+	ApiUrl string
+}
+
+func RunCoinbaseConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse, execPoolSize int, config Config) {
+
+	ctxLog := log.WithFields(log.Fields{"id": "CoinbaseConnector"})
+
+	if len(config.ApiUrl) < 1 {
+		ctxLog.Fatal("ApiUrl is empty !")
+	}
+
+	coinbase, err := newClient(config.ApiUrl, execPoolSize)
+
+	if err != nil {
+		ctxLog.Fatal("Wrong ApiUrl ! ", err)
+	}
+
+	products := newProductsCache()
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	errorResponse := func(response *proto.ExecResponse, err error) *proto.ExecResponse {
+
+		response.Status = proto.StatusError
+
+		response.Description = response.Description + " Parse error [" + err.Error() + "]"
+
+		return response
+	}
+
+	call := func(request *proto.ExecRequest, method string, path string, query url.Values, body interface{},
+		result interface{}) error {
+		return coinbase.call(request.RawCmd.ApiKey, request.RawCmd.SecretKey, method, path, query, body, result)
+	}
+
+	getOrder := func(request *proto.ExecRequest, orderId string) (*order, error) {
+
+		var result orderResponse
+
+		err := call(request, http.MethodGet, orderPath+url.PathEscape(orderId), nil, nil, &result)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if result.Order == nil {
+			return nil, fmt.Errorf("order %s isn't returned by Coinbase", orderId)
+		}
+
+		return result.Order, nil
+	}
+
+	// findOrder looks for order with client order id of the command among orders of the product
+	findOrder := func(request *proto.ExecRequest) (*order, error) {
+
+		query := url.Values{}
+
+		query.Set("product_ids", request.RawCmd.Instrument)
+		query.Set("limit", strconv.Itoa(pageLimit))
+		query.Set("start_date", request.Cmd.ExecuteTillTime.Add(-checkLookBack).UTC().Format(time.RFC3339))
+
+		for {
+
+			var result listOrdersResponse
+
+			err := call(request, http.MethodGet, listOrdersPath, query, nil, &result)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, o := range result.Orders {
+				if o.ClientOrderId == request.RawCmd.Id {
+					return o, nil
+				}
+			}
+
+			if !result.HasNext || len(result.Cursor) == 0 {
+				return nil, nil
+			}
+
+			query.Set("cursor", result.Cursor)
+		}
+	}
+
+	notFilled := func(request *proto.ExecRequest, response *proto.ExecResponse, status string) *proto.ExecResponse {
+
+		if request.RawCmd.OrderType == constants.OrderTypeLimitName &&
+			(status == expiredValue || status == cancelledValue || status == failedValue) {
+
+			response.Description = "Order rejected"
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		response.Description = "Order wasn't fill"
+		return response
+	}
+
+	progress := func(request *proto.ExecRequest, o *order) {
+
+		quantity, err := decimal.NewFromString(o.FilledSize)
+
+		if err != nil {
+			ctxLog.Error("Cannot parse executed quantity ["+o.FilledSize+"] ", err)
+			return
+		}
+
+		var response = proto.ExecResponse{Request: request, Status: proto.StatusOpen, ExecutedQuantity: quantity}
+
+		if quantity.IsPositive() {
+			response.Status = proto.StatusPartiallyFilled
+		}
+
+		response.Description = "Order is " + o.Status + " executed [" + o.FilledSize + "]"
+
+		request.Progress(&response)
+	}
+
+	// follow polls the order till it gets final status, every change of status or executed quantity is reported.
+	// Returns the last known state of the order when the request may not follow it any more, nil if it is unknown.
+	follow := func(request *proto.ExecRequest, orderId string, o *order) *order {
+
+		status := ""
+		executed := ""
+
+		for polled := false; ; polled = true {
+
+			if o != nil {
+
+				if !isAlive(o.Status) {
+					return o
+				}
+
+				if o.Status != status || o.FilledSize != executed {
+
+					status = o.Status
+					executed = o.FilledSize
+
+					progress(request, o)
+				}
+			}
+
+			// state of new order is queried at once, even when the request may not follow it any more
+			if polled || o != nil {
+
+				if !request.KeepFollowing() {
+					return o
+				}
+
+				time.Sleep(followPollTime)
+			}
+
+			current, err := getOrder(request, orderId)
+
+			if err != nil {
+				ctxLog.Error("Follow error ", err)
+			} else {
+				o = current
+			}
+		}
+	}
+
+	// park builds response of alive order which isn't followed any more
+	park := func(request *proto.ExecRequest, response *proto.ExecResponse, o *order) *proto.ExecResponse {
+
+		if o == nil {
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown")
+		}
+
+		quantity, err := decimal.NewFromString(o.FilledSize)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		return proto.Park(response, quantity, "status ["+o.Status+"] executed ["+o.FilledSize+"]")
+	}
+
+	orderFills := func(request *proto.ExecRequest, orderId string) ([]*fill, decimal.Decimal, error) {
+
+		var quantity = decimal.Zero
+
+		result := make([]*fill, 0)
+
+		query := url.Values{}
+
+		query.Set("order_id", orderId)
+		query.Set("limit", strconv.Itoa(pageLimit))
+
+		for {
+
+			var page fillsResponse
+
+			err := call(request, http.MethodGet, fillsPath, query, nil, &page)
+
+			if err != nil {
+				return nil, decimal.Zero, err
+			}
+
+			for _, f := range page.Fills {
+
+				q, err := decimal.NewFromString(f.Size)
+
+				if err != nil {
+					return nil, decimal.Zero, err
+				}
+
+				if f.SizeInQuote {
+
+					price, err := decimal.NewFromString(f.Price)
+
+					if err != nil {
+						return nil, decimal.Zero, err
+					}
+
+					q = q.DivRound(price, constants.DecimalScale)
+				}
+
+				quantity = quantity.Add(q)
+
+				result = append(result, f)
+			}
+
+			if len(page.Fills) < pageLimit || len(page.Cursor) == 0 {
+				return result, quantity, nil
+			}
+
+			query.Set("cursor", page.Cursor)
+		}
+	}
+
+	// settle builds final response of existing order, fills are taken from fills of the order, Coinbase charges
+	// commission in quote currency
+	settle := func(request *proto.ExecRequest, response *proto.ExecResponse, p *product, o *order) *proto.ExecResponse {
+
+		response.Description = fmt.Sprintf("%+v", *o)
+
+		executed, err := decimal.NewFromString(o.FilledSize)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		if o.Status != filledValue && executed.IsZero() {
+			return notFilled(request, response, o.Status)
+		}
+
+		response.Order = &cmd.Order{ExternalOrderId: unknownOrderId, ExternalOrderRef: o.OrderId, ExecutionId: request.Cmd.Id}
+
+		for attempt := 1; response.Order.Fills == nil; attempt++ {
+
+			fills, quantity, err := orderFills(request, o.OrderId)
+
+			if err != nil {
+				ctxLog.Error("Fills error ", err)
+				response.Description = err.Error()
+				return response
+			}
+
+			if quantity.Equal(executed) {
+
+				response.Order.Fills, err = toFills(fills, p.QuoteCurrencyId)
+
+				if err != nil {
+					return errorResponse(response, err)
+				}
+
+				break
+			}
+
+			if attempt == tradesAttempts {
+				response.Description = "Fills of order don't match executed quantity [" + o.FilledSize + "] " +
+					response.Description
+				return response
+			}
+
+			ctxLog.Warn("Fills of order don't match executed quantity yet ", o.OrderId)
+
+			time.Sleep(followPollTime)
+		}
+
+		cmd.ApplyFills(response.Order)
+
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	// productOf returns product of the command, nil response means product is known and command can go on
+	productOf := func(request *proto.ExecRequest, response *proto.ExecResponse) (*product, *proto.ExecResponse) {
+
+		p, err := products.get(coinbase, request.RawCmd.ApiKey, request.RawCmd.SecretKey, request.RawCmd.Instrument)
+
+		if err != nil {
+			ctxLog.Error("Product error ", err)
+			response.Description = err.Error()
+			return nil, response
+		}
+
+		if p == nil {
+			response.Description = "Unknown product " + request.RawCmd.Instrument
+			response.Status = proto.StatusRejected
+			return nil, response
+		}
+
+		return p, nil
+	}
+
+	// finish follows the order while the request allows and then parks it if it is still alive or settles it
+	finish := func(request *proto.ExecRequest, response *proto.ExecResponse, p *product, orderId string,
+		o *order) *proto.ExecResponse {
+
+		o = follow(request, orderId, o)
+
+		if o == nil || isAlive(o.Status) {
+			return park(request, response, o)
+		}
+
+		return settle(request, response, p, o)
+	}
+
+	// findLost looks for the order which answer is lost by its client order id, unknown order is parked, so it is
+	// checked again later and traded again or timed out then
+	findLost := func(request *proto.ExecRequest, response *proto.ExecResponse, p *product,
+		cause error) *proto.ExecResponse {
+
+		o, err := findOrder(request)
+
+		if err != nil {
+			ctxLog.Error("Find order error ", err)
+		}
+
+		if o == nil {
+			ctxLog.Warn("Order of lost answer isn't found ", request.RawCmd.Id)
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown after ["+cause.Error()+"]")
+		}
+
+		ctxLog.Info("Order of lost answer is found ", o.OrderId)
+
+		return finish(request, response, p, o.OrderId, o)
+	}
+
+	trade := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		p, failed := productOf(request, response)
+
+		if failed != nil {
+			return failed
+		}
+
+		create := createOrderRequest{ClientOrderId: request.RawCmd.Id, ProductId: request.RawCmd.Instrument}
+
+		if request.RawCmd.Direction == constants.OrderDirectionBuyName {
+			create.Side = buyValue
+		} else if request.RawCmd.Direction == constants.OrderDirectionSellName {
+			create.Side = sellValue
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong Direction with empty cmd ! ", request)
+			return nil
+		}
+
+		if request.RawCmd.OrderType == constants.OrderTypeMarketName {
+
+			create.OrderConfiguration.MarketIoc = &marketConfiguration{BaseSize: request.RawCmd.Amount}
+
+		} else if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+
+			limit := &limitConfiguration{BaseSize: request.RawCmd.Amount, LimitPrice: request.RawCmd.LimitPrice}
+
+			if request.RawCmd.TimeInForce == constants.TimeInForceGtcName {
+				create.OrderConfiguration.LimitGtc = limit
+			} else if request.RawCmd.TimeInForce == constants.TimeInForceFokName {
+				create.OrderConfiguration.LimitFok = limit
+			} else {
+				msg := "Protocol violation! ExecRequest has wrong TimeInForce."
+				ctxLog.Error(msg, request)
+				response.Description = msg
+				return response
+			}
+
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong OrderType ! ", request)
+			return nil
+		}
+
+		var created createOrderResponse
+		var err error
+
+		// rate limited requests are repeated, client order id keeps them from creating a second order anyway
+		for {
+
+			start := time.Now()
+
+			err = call(request, http.MethodPost, ordersPath, nil, &create, &created)
+
+			response.OutsideExecution = time.Now().Sub(start)
+
+			if !IsRateLimitError(err) {
+				break
+			}
+
+			ctxLog.Warn("Trade is rate limited, will be repeated after back off ", err)
+
+			time.Sleep(rateLimitSleepTime)
+
+			if request.Cmd.ExecuteTillTime.Before(time.Now()) {
+				response.Description = "TimedOut while repeating rejected trade " + err.Error()
+				response.Status = proto.StatusTimedOut
+				return response
+			}
+		}
+
+		// order may be placed when the answer is lost or Coinbase fails itself, only its client errors reject it
+		if apiErr, answered := err.(*APIError); err != nil && (!answered || apiErr.Status >= http.StatusInternalServerError) {
+			ctxLog.Error("Trade answer is lost ", err)
+			return findLost(request, response, p, err)
+		}
+
+		if err != nil {
+			ctxLog.Error("Trade error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		if !created.Success {
+			response.Description = "Order failed [" + created.FailureReason + "] " + created.ErrorResponse.Error + " " +
+				created.ErrorResponse.Message + " " + created.ErrorResponse.NewOrderFailureReason
+			ctxLog.Error("Trade error ", response.Description)
+			return response
+		}
+
+		orderId := created.SuccessResponse.OrderId
+
+		if len(orderId) == 0 {
+			orderId = created.OrderId
+		}
+
+		ctxLog.Trace("Order from Coinbase ", orderId)
+
+		return finish(request, response, p, orderId, nil)
+	}
+
+	// check repeats the trade while command has time, Coinbase returns existing order instead of creating a new one
+	// for the same client order id. Later the order is looked for among orders of the product.
+	check := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		if request.Cmd.ExecuteTillTime.After(time.Now()) {
+			return trade(request, response)
+		}
+
+		p, failed := productOf(request, response)
+
+		if failed != nil {
+			return failed
+		}
+
+		o, err := findOrder(request)
+
+		if err != nil {
+			ctxLog.Error("Check error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		if o == nil {
+			ctxLog.Info("Check error, order not exist and will be marked timed_out ", request.RawCmd.Id)
+			response.Description = "Order with client order id " + request.RawCmd.Id + " not exist"
+			response.Status = proto.StatusTimedOut
+			return response
+		}
+
+		ctxLog.Trace("Order from Coinbase ", o)
+
+		return finish(request, response, p, o.OrderId, o)
+	}
+
+	info := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		if request.RawCmd.OrderType != constants.OrderTypeInfoName {
+			response.Description = "Order type " + request.RawCmd.OrderType + " isn't supported by Coinbase"
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		response.Balances = make([]cmd.Balance, 0)
+
+		query := url.Values{}
+
+		query.Set("limit", strconv.Itoa(pageLimit))
+
+		for {
+
+			var page accountsResponse
+
+			err := call(request, http.MethodGet, accountsPath, query, nil, &page)
+
+			if err != nil {
+				ctxLog.Error("Info error ", err)
+				response.Balances = nil
+				response.Description = err.Error()
+				return response
+			}
+
+			for _, a := range page.Accounts {
+
+				free, err := decimal.NewFromString(a.AvailableBalance.Value)
+
+				if err != nil {
+					return errorResponse(response, err)
+				}
+
+				locked, err := decimal.NewFromString(a.Hold.Value)
+
+				if err != nil {
+					return errorResponse(response, err)
+				}
+
+				if !free.IsZero() || !locked.IsZero() {
+					response.Balances = append(response.Balances, cmd.Balance{Asset: a.Currency, Free: free, Locked: locked})
+				}
+			}
+
+			if !page.HasNext || len(page.Cursor) == 0 {
+				break
+			}
+
+			query.Set("cursor", page.Cursor)
+		}
+
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	connector.RunConnector(ctxLog, in, out, execPoolSize, trade, check, info)
+}
@@ -0,0 +1,283 @@
+package eccoinbase
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/eccoinbase/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTimeout = 20 * time.Second
+
+type testConnector struct {
+	server    *mock.Server
+	in        chan *proto.ExecRequest
+	out       chan *proto.ExecResponse
+	apiKey    string
+	secretKey string
+	key       *ecdsa.PrivateKey
+
+	lock    sync.Mutex
+	tokens  []string
+	dropped bool
+}
+
+// startConnector runs connector against mock Coinbase with BTC-USD at 30000 and a new EC key of the test, tokens of
+// requests are recorded and create order requests are dropped unanswered while dropped is set
+func startConnector(t *testing.T) *testConnector {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal("Cannot generate key ", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal("Cannot marshal key ", err)
+	}
+
+	c := &testConnector{
+		server: mock.NewServer(
+			[]spot.Symbol{{Name: "BTC-USD", Base: "BTC", Quote: "USD", Price: decimal.NewFromInt(30000)}},
+			map[string]decimal.Decimal{"USD": decimal.NewFromInt(100000)},
+			spot.Outcome{Status: spot.OutcomeFilled}),
+		in:        make(chan *proto.ExecRequest),
+		out:       make(chan *proto.ExecResponse, 100),
+		apiKey:    "organizations/test/apiKeys/" + t.Name(),
+		secretKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		key:       key,
+	}
+
+	handler := c.server.Handler()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		c.lock.Lock()
+		c.tokens = append(c.tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		dropped := c.dropped && r.Method == http.MethodPost && r.URL.Path == ordersPath
+		c.lock.Unlock()
+
+		if dropped {
+			if connection, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				_ = connection.Close()
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+
+	t.Cleanup(httpServer.Close)
+
+	RunCoinbaseConnector(c.in, c.out, 2, Config{ApiUrl: httpServer.URL})
+
+	return c
+}
+
+func (c *testConnector) setDropped(dropped bool) {
+	c.lock.Lock()
+	c.dropped = dropped
+	c.lock.Unlock()
+}
+
+// request wraps buy of the test key as coordinator does, price makes it a GTC limit order
+func (c *testConnector) request(what proto.ExecType, id string, price string, executeFor time.Duration,
+	followFor time.Duration) *proto.ExecRequest {
+
+	now := time.Now()
+
+	raw := &cmd.RawCommand{Id: id, Instrument: "BTC-USD", Direction: constants.OrderDirectionBuyName,
+		OrderType: constants.OrderTypeMarketName, Amount: "0.01", ApiKey: c.apiKey, SecretKey: c.secretKey}
+
+	if len(price) > 0 {
+		raw.OrderType = constants.OrderTypeLimitName
+		raw.LimitPrice = price
+		raw.TimeInForce = constants.TimeInForceGtcName
+	}
+
+	command := &cmd.Command{InstrumentName: raw.Instrument, ExecuteTillTime: now.Add(executeFor), ApiKey: raw.ApiKey,
+		SecretKey: raw.SecretKey}
+
+	command.Amount, _ = decimal.NewFromString(raw.Amount)
+
+	return &proto.ExecRequest{What: what, RawCmd: raw, Cmd: command, FollowTill: now.Add(followFor)}
+}
+
+// execute sends the request and waits for its last response, progress responses are skipped
+func (c *testConnector) execute(t *testing.T, r *proto.ExecRequest) *proto.ExecResponse {
+
+	t.Helper()
+
+	c.in <- r
+
+	deadline := time.After(testTimeout)
+
+	for {
+		select {
+		case <-deadline:
+			t.Fatal("No response of command ", r.RawCmd.Id)
+			return nil
+		case response := <-c.out:
+			if response.Request == r && (proto.IsFinal(response.Status) || response.Parked) {
+				return response
+			}
+		}
+	}
+}
+
+// verify checks signature of the token with public key of the test and returns its claims
+func (c *testConnector) verify(t *testing.T, jwt string) map[string]interface{} {
+
+	t.Helper()
+
+	parts := strings.Split(jwt, ".")
+
+	if len(parts) != 3 {
+		t.Fatal("Token isn't JWT ", jwt)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil || len(signature) != 64 {
+		t.Fatal("Token signature isn't ES256 ", err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+
+	if !ecdsa.Verify(&c.key.PublicKey, digest[:], r, s) {
+		t.Fatal("Token signature doesn't match the key")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		t.Fatal("Token claims aren't base64url ", err)
+	}
+
+	claims := make(map[string]interface{})
+
+	if err = json.Unmarshal(data, &claims); err != nil {
+		t.Fatal("Token claims aren't JSON ", err)
+	}
+
+	return claims
+}
+
+func TestEveryRequestOfLongFollowGetsFreshToken(t *testing.T) {
+
+	c := startConnector(t)
+
+	// the order rests for a few polls of follow
+	c.server.Push(spot.Outcome{Status: spot.OutcomeNew, FillAfter: 3 * time.Second})
+
+	start := time.Now().Unix()
+
+	response := c.execute(t, c.request(proto.ExecuteCmd, "1", "30000", time.Minute, time.Minute))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Resting order isn't followed till filled ", response.Status, " ", response.Description)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.tokens) < 3 {
+		t.Fatal("Order isn't polled, requests ", len(c.tokens))
+	}
+
+	seen := make(map[string]bool)
+
+	for _, jwt := range c.tokens {
+
+		if seen[jwt] {
+			t.Fatal("Token is reused ", jwt)
+		}
+
+		seen[jwt] = true
+
+		claims := c.verify(t, jwt)
+
+		nbf := int64(claims["nbf"].(float64))
+		exp := int64(claims["exp"].(float64))
+
+		// a token made once and cached would be expired by Coinbase after two minutes of following
+		if nbf < start || exp-nbf != int64(tokenLifetime/time.Second) {
+			t.Error("Token isn't made for the request nbf ", nbf, " exp ", exp, " start ", start)
+		}
+
+		if claims["sub"] != c.apiKey || claims["iss"] != tokenIssuer {
+			t.Error("Wrong token subject ", claims)
+		}
+
+		if uri, ok := claims["uri"].(string); !ok || !strings.Contains(uri, " 127.0.0.1:") {
+			t.Error("Token uri isn't bound to host ", claims["uri"])
+		}
+	}
+}
+
+func TestLostAnswerIsRecoveredByClientOrderId(t *testing.T) {
+
+	c := startConnector(t)
+
+	// the order is filled, but connection is closed instead of the answer
+	c.server.Push(spot.Outcome{Status: spot.OutcomeLost})
+
+	response := c.execute(t, c.request(proto.ExecuteCmd, "1", "", time.Minute, time.Minute))
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.01")) {
+		t.Fatal("Order of lost answer isn't settled ", response.Status, " ", response.Description)
+	}
+}
+
+func TestUnknownOrderOfLostAnswerIsParkedAndChecked(t *testing.T) {
+
+	c := startConnector(t)
+
+	c.setDropped(true)
+
+	response := c.execute(t, c.request(proto.ExecuteCmd, "1", "", time.Minute, time.Minute))
+
+	if response.Status != proto.StatusOpen || !response.Parked {
+		t.Fatal("Unknown order isn't parked ", response.Status, " ", response.Description)
+	}
+
+	c.setDropped(false)
+
+	// the order never reached Coinbase, parked check places it while the command has time
+	response = c.execute(t, c.request(proto.CheckCmd, "1", "", time.Minute, 0))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Unknown order isn't traded by check ", response.Status, " ", response.Description)
+	}
+
+	orderId := response.Order.ExternalOrderRef
+
+	// later check finds the same order among orders of the product
+	response = c.execute(t, c.request(proto.CheckCmd, "1", "", -time.Second, 0))
+
+	if response.Status != proto.StatusOk || response.Order.ExternalOrderRef != orderId {
+		t.Fatal("Order of command isn't found by check ", response.Status, " ", response.Order)
+	}
+}
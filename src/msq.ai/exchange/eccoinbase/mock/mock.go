@@ -0,0 +1,771 @@
+package mock
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ordersPath = "/api/v3/brokerage/orders"
+const orderPath = "/api/v3/brokerage/orders/historical/"
+const listOrdersPath = "/api/v3/brokerage/orders/historical/batch"
+const fillsPath = "/api/v3/brokerage/orders/historical/fills"
+const accountsPath = "/api/v3/brokerage/accounts"
+const productPath = "/api/v3/brokerage/products/"
+const outcomesPath = "/mock/outcomes"
+
+const openStatus = "OPEN"
+const filledStatus = "FILLED"
+const cancelledStatus = "CANCELLED"
+const expiredStatus = "EXPIRED"
+
+const buySide = "BUY"
+
+const marketType = "MARKET"
+const limitType = "LIMIT"
+
+const defaultLimit = 100
+
+// all amounts are formatted with precision of Coinbase
+const precision = 8
+
+// taker fee of the lowest volume tier
+var commissionRate = decimal.New(6, -3)
+
+type order struct {
+	id            string
+	clientOrderId string
+	product       *spot.Symbol
+	side          string
+	orderType     string
+	timeInForce   string
+	price         decimal.Decimal
+	size          decimal.Decimal
+	filled        decimal.Decimal
+	value         decimal.Decimal
+	fees          decimal.Decimal
+	status        string
+	created       time.Time
+}
+
+func (o *order) isAlive() bool {
+	return o.status == openStatus
+}
+
+type fill struct {
+	entryId    string
+	tradeId    string
+	order      *order
+	price      decimal.Decimal
+	size       decimal.Decimal
+	commission decimal.Decimal
+	time       time.Time
+}
+
+type account struct {
+	balances map[string]decimal.Decimal
+	orders   map[string]*order
+	clients  map[string]*order
+	fills    []*fill
+}
+
+// Server imitates Coinbase Advanced Trade REST API for one process, every API key gets its own account with initial
+// balances. JWT of requests is checked for key, lifetime and URI, signature isn't verified, the mock doesn't know
+// public keys. What happens with created orders is scripted with outcomes of Binance spot mock.
+type Server struct {
+	lock sync.Mutex
+
+	products        map[string]*spot.Symbol
+	initialBalances map[string]decimal.Decimal
+
+	defaultOutcome spot.Outcome
+	outcomes       []spot.Outcome
+
+	accounts map[string]*account
+
+	nextOrderId int64
+	nextFillId  int64
+
+	ctxLog *log.Entry
+}
+
+func NewServer(products []spot.Symbol, balances map[string]decimal.Decimal, defaultOutcome spot.Outcome) *Server {
+
+	s := &Server{
+		products:        make(map[string]*spot.Symbol),
+		initialBalances: balances,
+		defaultOutcome:  defaultOutcome,
+		outcomes:        make([]spot.Outcome, 0),
+		accounts:        make(map[string]*account),
+		nextOrderId:     1,
+		nextFillId:      1,
+		ctxLog:          log.WithFields(log.Fields{"id": "CoinbaseMock"}),
+	}
+
+	for i := range products {
+		s.products[products[i].Name] = &products[i]
+	}
+
+	return s
+}
+
+// Push appends outcomes to the script, they are taken by created orders one by one, default outcome is used after
+func (s *Server) Push(outcomes ...spot.Outcome) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.outcomes = append(s.outcomes, outcomes...)
+}
+
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(ordersPath, s.private(http.MethodPost, s.create))
+	mux.HandleFunc(orderPath, s.private(http.MethodGet, s.get))
+	mux.HandleFunc(listOrdersPath, s.private(http.MethodGet, s.listOrders))
+	mux.HandleFunc(fillsPath, s.private(http.MethodGet, s.listFills))
+	mux.HandleFunc(accountsPath, s.private(http.MethodGet, s.listAccounts))
+	mux.HandleFunc(productPath, s.private(http.MethodGet, s.product))
+	mux.HandleFunc(outcomesPath, s.handleOutcomes)
+
+	return mux
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func format(val decimal.Decimal) string {
+	return val.StringFixed(precision)
+}
+
+func uuid(prefix int64, id int64) string {
+	return fmt.Sprintf("%08x-0000-4000-8000-%012x", prefix, id)
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func apiError(w http.ResponseWriter, status int, code string, msg string) {
+	writeJson(w, status, map[string]interface{}{"error": code, "message": msg})
+}
+
+// getAccount must be called under lock
+func (s *Server) getAccount(apiKey string) *account {
+
+	a := s.accounts[apiKey]
+
+	if a == nil {
+
+		a = &account{
+			balances: make(map[string]decimal.Decimal),
+			orders:   make(map[string]*order),
+			clients:  make(map[string]*order),
+			fills:    make([]*fill, 0),
+		}
+
+		for asset, free := range s.initialBalances {
+			a.balances[asset] = free
+		}
+
+		s.accounts[apiKey] = a
+	}
+
+	return a
+}
+
+// authenticate returns key of the request JWT or describes why it is not accepted
+func authenticate(r *http.Request) (string, string) {
+
+	auth := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", "no bearer token"
+	}
+
+	parts := strings.Split(strings.TrimPrefix(auth, "Bearer "), ".")
+
+	if len(parts) != 3 {
+		return "", "token isn't JWT"
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	var claims struct {
+		Sub string `json:"sub"`
+		Iss string `json:"iss"`
+		Nbf int64  `json:"nbf"`
+		Exp int64  `json:"exp"`
+		Uri string `json:"uri"`
+	}
+
+	for i, target := range []interface{}{&header, &claims} {
+
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+
+		if err != nil {
+			return "", "token segment isn't base64url"
+		}
+
+		if err = json.Unmarshal(data, target); err != nil {
+			return "", "token segment isn't JSON"
+		}
+	}
+
+	if signature, err := base64.RawURLEncoding.DecodeString(parts[2]); err != nil || len(signature) != 64 {
+		return "", "token signature isn't ES256"
+	}
+
+	now := time.Now().Unix()
+
+	if header.Alg != "ES256" || len(header.Kid) == 0 || header.Kid != claims.Sub || claims.Iss != "cdp" {
+		return "", "wrong token header or subject"
+	}
+
+	if claims.Nbf > now+1 || claims.Exp < now {
+		return "", "token expired"
+	}
+
+	if uri := r.Method + " " + r.Host + r.URL.Path; claims.Uri != uri {
+		return "", "token uri [" + claims.Uri + "] doesn't match [" + uri + "]"
+	}
+
+	return header.Kid, ""
+}
+
+// private authenticates request and calls handler under lock with account of the key
+func (s *Server) private(method string, handler func(w http.ResponseWriter, r *http.Request, a *account)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != method {
+			apiError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", "method not allowed")
+			return
+		}
+
+		apiKey, reason := authenticate(r)
+
+		if len(apiKey) == 0 {
+			apiError(w, http.StatusUnauthorized, "UNAUTHENTICATED", reason)
+			return
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		handler(w, r, s.getAccount(apiKey))
+	}
+}
+
+func (s *Server) nextOutcome() spot.Outcome {
+
+	if len(s.outcomes) == 0 {
+		return s.defaultOutcome
+	}
+
+	outcome := s.outcomes[0]
+
+	s.outcomes = s.outcomes[1:]
+
+	return outcome
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+// fill executes size of the order, must be called under lock
+func (s *Server) fill(a *account, o *order, size decimal.Decimal) {
+
+	f := &fill{
+		entryId: uuid(0xe, s.nextFillId),
+		tradeId: uuid(0xf, s.nextFillId),
+		order:   o,
+		price:   o.price,
+		size:    size,
+		time:    time.Now().UTC(),
+	}
+
+	s.nextFillId++
+
+	value := size.Mul(o.price)
+
+	f.commission = value.Mul(commissionRate)
+
+	base := o.product.Base
+	quote := o.product.Quote
+
+	if o.side == buySide {
+		a.balances[base] = a.balances[base].Add(size)
+		a.balances[quote] = a.balances[quote].Sub(value).Sub(f.commission)
+	} else {
+		a.balances[base] = a.balances[base].Sub(size)
+		a.balances[quote] = a.balances[quote].Add(value).Sub(f.commission)
+	}
+
+	o.filled = o.filled.Add(size)
+	o.value = o.value.Add(value)
+	o.fees = o.fees.Add(f.commission)
+
+	if o.filled.Equal(o.size) {
+		o.status = filledStatus
+	}
+
+	a.fills = append(a.fills, f)
+}
+
+type configuration struct {
+	BaseSize   string `json:"base_size"`
+	LimitPrice string `json:"limit_price"`
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, a *account) {
+
+	var request struct {
+		ClientOrderId      string                    `json:"client_order_id"`
+		ProductId          string                    `json:"product_id"`
+		Side               string                    `json:"side"`
+		OrderConfiguration map[string]*configuration `json:"order_configuration"`
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "body isn't JSON")
+		return
+	}
+
+	failure := func(reason string, msg string) {
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"success":        false,
+			"failure_reason": reason,
+			"order_id":       "",
+			"error_response": map[string]interface{}{"error": reason, "message": msg},
+		})
+	}
+
+	success := func(o *order) {
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"success":  true,
+			"order_id": o.id,
+			"success_response": map[string]interface{}{
+				"order_id":        o.id,
+				"product_id":      o.product.Name,
+				"side":            o.side,
+				"client_order_id": o.clientOrderId,
+			},
+		})
+	}
+
+	// order with the same client order id isn't created again, the existing one is returned
+	if existing := a.clients[request.ClientOrderId]; existing != nil && len(request.ClientOrderId) > 0 {
+		s.ctxLog.Info("Order ", request.ClientOrderId, " exists ", existing.id)
+		success(existing)
+		return
+	}
+
+	product := s.products[request.ProductId]
+
+	if product == nil {
+		failure("INVALID_PRODUCT_ID", "Invalid product_id")
+		return
+	}
+
+	if len(request.OrderConfiguration) != 1 {
+		failure("INVALID_ARGUMENT", "Exactly one order configuration is expected")
+		return
+	}
+
+	o := &order{
+		id:            uuid(0, s.nextOrderId),
+		clientOrderId: request.ClientOrderId,
+		product:       product,
+		side:          request.Side,
+		price:         product.Price,
+		status:        openStatus,
+		created:       time.Now().UTC(),
+	}
+
+	var config *configuration
+
+	for name, c := range request.OrderConfiguration {
+
+		config = c
+
+		switch name {
+		case "market_market_ioc":
+			o.orderType = marketType
+			o.timeInForce = "IMMEDIATE_OR_CANCEL"
+		case "limit_limit_gtc":
+			o.orderType = limitType
+			o.timeInForce = "GOOD_UNTIL_CANCELLED"
+		case "limit_limit_fok":
+			o.orderType = limitType
+			o.timeInForce = "FILL_OR_KILL"
+		default:
+			failure("UNSUPPORTED_ORDER_CONFIGURATION", "Order configuration "+name+" isn't supported by mock")
+			return
+		}
+	}
+
+	if config == nil {
+		failure("INVALID_ARGUMENT", "Order configuration is empty")
+		return
+	}
+
+	o.size, err = decimal.NewFromString(config.BaseSize)
+
+	if err != nil || !o.size.IsPositive() {
+		failure("INVALID_SIZE_PRECISION", "Invalid base_size")
+		return
+	}
+
+	if o.orderType == limitType {
+
+		o.price, err = decimal.NewFromString(config.LimitPrice)
+
+		if err != nil || !o.price.IsPositive() {
+			failure("INVALID_PRICE_PRECISION", "Invalid limit_price")
+			return
+		}
+	}
+
+	outcome := s.nextOutcome()
+
+	s.ctxLog.Info("Order ", o.clientOrderId, " ", o.side, " ", o.orderType, " ", format(o.size), " ", product.Name,
+		" outcome ", outcome)
+
+	switch outcome.Status {
+
+	case spot.OutcomeRejected:
+		failure("INSUFFICIENT_FUND", "Insufficient balance in source account")
+		return
+
+	case spot.OutcomeError:
+		apiError(w, http.StatusInternalServerError, "INTERNAL", "Internal server error")
+		return
+
+	case spot.OutcomeRateLimit:
+		apiError(w, http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", "Too many requests")
+		return
+	}
+
+	s.nextOrderId++
+
+	a.orders[o.id] = o
+
+	if len(o.clientOrderId) > 0 {
+		a.clients[o.clientOrderId] = o
+	}
+
+	switch outcome.Status {
+
+	case spot.OutcomeFilled, spot.OutcomeLost:
+		s.fill(a, o, o.size)
+
+	case spot.OutcomePartiallyFilled:
+		s.fill(a, o, o.size.Div(decimal.New(2, 0)).Truncate(precision))
+
+	case spot.OutcomeExpired:
+		o.status = expiredStatus
+
+	case spot.OutcomeCanceled:
+		o.status = cancelledStatus
+	}
+
+	if o.isAlive() && outcome.FillAfter > 0 {
+
+		time.AfterFunc(outcome.FillAfter, func() {
+
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			if o.isAlive() {
+				s.fill(a, o, o.size.Sub(o.filled))
+			}
+		})
+	}
+
+	if outcome.Status == spot.OutcomeLost {
+
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if connection, _, err := hijacker.Hijack(); err == nil {
+				_ = connection.Close()
+				return
+			}
+		}
+
+		apiError(w, http.StatusGatewayTimeout, "DEADLINE_EXCEEDED", "Timeout")
+		return
+	}
+
+	success(o)
+}
+
+func orderJson(o *order) map[string]interface{} {
+
+	average := decimal.Zero
+
+	if o.filled.IsPositive() {
+		average = o.value.DivRound(o.filled, precision)
+	}
+
+	return map[string]interface{}{
+		"order_id":              o.id,
+		"product_id":            o.product.Name,
+		"side":                  o.side,
+		"client_order_id":       o.clientOrderId,
+		"status":                o.status,
+		"time_in_force":         o.timeInForce,
+		"created_time":          o.created.Format(time.RFC3339Nano),
+		"completion_percentage": o.filled.Div(o.size).Mul(decimal.New(100, 0)).StringFixed(2),
+		"filled_size":           format(o.filled),
+		"average_filled_price":  format(average),
+		"number_of_fills":       "",
+		"filled_value":          format(o.value),
+		"total_fees":            format(o.fees),
+		"order_type":            o.orderType,
+		"reject_reason":         "",
+		"settled":               !o.isAlive(),
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, a *account) {
+
+	o := a.orders[strings.TrimPrefix(r.URL.Path, orderPath)]
+
+	if o == nil {
+		apiError(w, http.StatusNotFound, "NOT_FOUND", "order not found")
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{"order": orderJson(o)})
+}
+
+// page cuts list by offset kept in cursor, returns next cursor or empty one
+func page(r *http.Request, size int) (int, int, string) {
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	if err != nil || limit < 1 {
+		limit = defaultLimit
+	}
+
+	from, err := strconv.Atoi(r.URL.Query().Get("cursor"))
+
+	if err != nil || from < 0 {
+		from = 0
+	}
+
+	if from > size {
+		from = size
+	}
+
+	to := from + limit
+
+	if to >= size {
+		return from, size, ""
+	}
+
+	return from, to, strconv.Itoa(to)
+}
+
+func (s *Server) listOrders(w http.ResponseWriter, r *http.Request, a *account) {
+
+	query := r.URL.Query()
+
+	var start time.Time
+
+	if len(query.Get("start_date")) > 0 {
+
+		var err error
+
+		start, err = time.Parse(time.RFC3339, query.Get("start_date"))
+
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid start_date")
+			return
+		}
+	}
+
+	products := make(map[string]bool)
+
+	for _, id := range query["product_ids"] {
+		products[id] = true
+	}
+
+	orders := make([]*order, 0)
+
+	for _, o := range a.orders {
+		if (len(products) == 0 || products[o.product.Name]) && !o.created.Before(start) {
+			orders = append(orders, o)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].created.After(orders[j].created) })
+
+	from, to, cursor := page(r, len(orders))
+
+	result := make([]interface{}, 0, to-from)
+
+	for _, o := range orders[from:to] {
+		result = append(result, orderJson(o))
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{"orders": result, "has_next": len(cursor) > 0, "cursor": cursor})
+}
+
+func (s *Server) listFills(w http.ResponseWriter, r *http.Request, a *account) {
+
+	orderId := r.URL.Query().Get("order_id")
+
+	fills := make([]*fill, 0)
+
+	for _, f := range a.fills {
+		if len(orderId) == 0 || f.order.id == orderId {
+			fills = append(fills, f)
+		}
+	}
+
+	from, to, cursor := page(r, len(fills))
+
+	result := make([]interface{}, 0, to-from)
+
+	for _, f := range fills[from:to] {
+		result = append(result, map[string]interface{}{
+			"entry_id":            f.entryId,
+			"trade_id":            f.tradeId,
+			"order_id":            f.order.id,
+			"trade_time":          f.time.Format(time.RFC3339Nano),
+			"trade_type":          "FILL",
+			"price":               format(f.price),
+			"size":                format(f.size),
+			"commission":          format(f.commission),
+			"product_id":          f.order.product.Name,
+			"liquidity_indicator": "TAKER",
+			"size_in_quote":       false,
+			"side":                f.order.side,
+		})
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{"fills": result, "cursor": cursor})
+}
+
+// listAccounts returns one account per currency, open buy orders hold quote, open sell orders hold base
+func (s *Server) listAccounts(w http.ResponseWriter, r *http.Request, a *account) {
+
+	holds := make(map[string]decimal.Decimal)
+
+	for _, o := range a.orders {
+
+		if !o.isAlive() {
+			continue
+		}
+
+		left := o.size.Sub(o.filled)
+
+		if o.side == buySide {
+			holds[o.product.Quote] = holds[o.product.Quote].Add(left.Mul(o.price))
+		} else {
+			holds[o.product.Base] = holds[o.product.Base].Add(left)
+		}
+	}
+
+	currencies := make([]string, 0, len(a.balances))
+
+	for currency := range a.balances {
+		currencies = append(currencies, currency)
+	}
+
+	sort.Strings(currencies)
+
+	from, to, cursor := page(r, len(currencies))
+
+	result := make([]interface{}, 0, to-from)
+
+	for i, currency := range currencies[from:to] {
+		result = append(result, map[string]interface{}{
+			"uuid":              uuid(0xa, int64(from+i)),
+			"name":              currency + " Wallet",
+			"currency":          currency,
+			"available_balance": map[string]string{"value": format(a.balances[currency].Sub(holds[currency])), "currency": currency},
+			"hold":              map[string]string{"value": format(holds[currency]), "currency": currency},
+			"active":            true,
+		})
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"accounts": result, "has_next": len(cursor) > 0, "cursor": cursor, "size": len(result)})
+}
+
+func (s *Server) product(w http.ResponseWriter, r *http.Request, _ *account) {
+
+	p := s.products[strings.TrimPrefix(r.URL.Path, productPath)]
+
+	if p == nil {
+		apiError(w, http.StatusNotFound, "NOT_FOUND", "product not found")
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"product_id":        p.Name,
+		"price":             format(p.Price),
+		"base_currency_id":  p.Base,
+		"quote_currency_id": p.Quote,
+		"product_type":      "SPOT",
+	})
+}
+
+// handleOutcomes appends outcomes posted as comma separated list, GET returns outcomes which are not taken yet
+func (s *Server) handleOutcomes(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost {
+
+		body, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		outcomes, err := spot.ParseOutcomes(string(body))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Push(outcomes...)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]string, len(s.outcomes))
+
+	for i, o := range s.outcomes {
+		list[i] = o.String()
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{"default": s.defaultOutcome.String(), "outcomes": list})
+}
@@ -0,0 +1,44 @@
+package eccoinbase
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// productsCache keeps products by id, unknown products are kept as nil, so every instrument costs one call
+type productsCache struct {
+	lock     sync.Mutex
+	products map[string]*product
+}
+
+func newProductsCache() *productsCache {
+	return &productsCache{products: make(map[string]*product)}
+}
+
+func (c *productsCache) get(coinbase *client, apiKey string, secretKey string, productId string) (*product, error) {
+
+	c.lock.Lock()
+	p, ok := c.products[productId]
+	c.lock.Unlock()
+
+	if ok {
+		return p, nil
+	}
+
+	p = &product{}
+
+	err := coinbase.call(apiKey, secretKey, http.MethodGet, productPath+url.PathEscape(productId), nil, nil, p)
+
+	if isNotFoundError(err) {
+		p = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.products[productId] = p
+	c.lock.Unlock()
+
+	return p, nil
+}
@@ -0,0 +1,21 @@
+package eccoinbase
+
+import (
+	"msq.ai/connectors/proto"
+	"msq.ai/connectors/registry"
+	"time"
+)
+
+const propertiesApiUrlName = "api.url"
+
+func init() {
+	registry.Register(registry.Exchange{Name: "COINBASE", ExecPoolSize: 200, Run: run})
+}
+
+func run(settings registry.Settings, in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse,
+	execPoolSize int) func() time.Duration {
+
+	RunCoinbaseConnector(in, out, execPoolSize, Config{ApiUrl: settings.MustGet(propertiesApiUrlName)})
+
+	return nil
+}
@@ -0,0 +1,547 @@
+package ecfix
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/connector"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"time"
+)
+
+const execTypeTrade = "F"
+const execTypeOrderStatus = "I"
+
+const ordStatusFilled = "2"
+const ordStatusDoneForDay = "3"
+const ordStatusCanceled = "4"
+const ordStatusRejected = "8"
+const ordStatusExpired = "C"
+
+const ordRejReasonUnknownOrder = "5"
+
+const businessRejectReasonUnknownId = "1"
+
+const sideBuy = "1"
+const sideSell = "2"
+
+const ordTypeMarket = "1"
+const ordTypeLimit = "2"
+
+const timeInForceGtc = "1"
+const timeInForceFok = "4"
+
+// handlInstAutomated is automated execution order, private, no broker intervention
+const handlInstAutomated = "1"
+
+// OrderStatusRequest is sent this number of times while check waits for the status
+const statusAttempts = 3
+
+const statusWaitTime = 10 * time.Second
+
+// FIX doesn't number orders and trades, OrderID and ExecIDs are kept as external refs
+const unknownOrderId = -1
+const unknownTradeId = -1
+
+var errStatusNotAnswered = errors.New("OrderStatusRequest isn't answered")
+
+func isFinal(ordStatus string) bool {
+	return ordStatus == ordStatusFilled || ordStatus == ordStatusCanceled || ordStatus == ordStatusRejected ||
+		ordStatus == ordStatusExpired || ordStatus == ordStatusDoneForDay
+}
+
+func commissionAssetOf(m *Message) string {
+
+	if m.Has(TagCommCurrency) {
+		return m.Get(TagCommCurrency)
+	}
+
+	return m.Get(TagCurrency)
+}
+
+func decimalOf(m *Message, tag int) (decimal.Decimal, error) {
+
+	if !m.Has(tag) {
+		return decimal.Zero, nil
+	}
+
+	return decimal.NewFromString(m.Get(tag))
+}
+
+func rejectText(m *Message) string {
+
+	if m.Type() == MsgTypeReject {
+		return "Session reject [" + m.Get(TagText) + "]"
+	}
+
+	return "Business reject [" + m.Get(TagBusinessRejectReason) + "] [" + m.Get(TagText) + "]"
+}
+
+func describe(m *Message) string {
+	return "OrderID [" + m.Get(TagOrderId) + "] OrdStatus [" + m.Get(TagOrdStatus) + "] CumQty [" + m.Get(TagCumQty) +
+		"] AvgPx [" + m.Get(TagAvgPx) + "] Text [" + m.Get(TagText) + "]"
+}
+
+// orderState collects execution reports of the order, fills are taken from trade reports once per ExecID
+type orderState struct {
+	execIds map[string]bool
+	fills   []cmd.Fill
+	last    *Message
+}
+
+func newOrderState() *orderState {
+	return &orderState{execIds: make(map[string]bool), fills: make([]cmd.Fill, 0)}
+}
+
+func (s *orderState) apply(m *Message) error {
+
+	s.last = m
+
+	if m.Get(TagExecType) != execTypeTrade || s.execIds[m.Get(TagExecId)] {
+		return nil
+	}
+
+	fill := cmd.Fill{ExternalTradeId: unknownTradeId, ExternalTradeRef: m.Get(TagExecId), CommissionAsset: commissionAssetOf(m)}
+
+	var err error
+
+	if fill.Quantity, err = decimalOf(m, TagLastQty); err != nil {
+		return err
+	}
+
+	if fill.Price, err = decimalOf(m, TagLastPx); err != nil {
+		return err
+	}
+
+	if fill.Commission, err = decimalOf(m, TagCommission); err != nil {
+		return err
+	}
+
+	s.execIds[m.Get(TagExecId)] = true
+	s.fills = append(s.fills, fill)
+
+	return nil
+}
+
+// Config keeps settings of FIX connector
+type Config struct {
+	Session SessionConfig
+	// StatusPollTime is pause between OrderStatusRequests of alive order, reports may be missed till resend
+	StatusPollTime time.Duration
+}
+
+func RunFixConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse, execPoolSize int, config Config) {
+
+	ctxLog := log.WithFields(log.Fields{"id": "FixConnector"})
+
+	if config.StatusPollTime < time.Second {
+		ctxLog.Fatal("StatusPollTime is less than second !")
+	}
+
+	routes := newRouter(ctxLog)
+
+	session, err := NewSession(config.Session, routes.route)
+
+	if err != nil {
+		ctxLog.Fatal("FIX session error ", err)
+	}
+
+	go session.Run()
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	errorResponse := func(response *proto.ExecResponse, err error) *proto.ExecResponse {
+
+		response.Status = proto.StatusError
+
+		response.Description = response.Description + " Parse error [" + err.Error() + "]"
+
+		return response
+	}
+
+	send := func(request *proto.ExecRequest, m *Message) error {
+
+		seq, err := session.Send(m)
+
+		if err != nil {
+			return err
+		}
+
+		routes.sentFor(clOrdIdOf(request.Cmd.Id), seq)
+
+		return nil
+	}
+
+	statusRequest := func(request *proto.ExecRequest) error {
+
+		m := NewMessage(MsgTypeOrderStatusRequest)
+
+		m.Add(TagClOrdId, clOrdIdOf(request.Cmd.Id))
+		m.Add(TagSymbol, request.RawCmd.Instrument)
+
+		if request.RawCmd.Direction == constants.OrderDirectionBuyName {
+			m.Add(TagSide, sideBuy)
+		} else {
+			m.Add(TagSide, sideSell)
+		}
+
+		return send(request, m)
+	}
+
+	notFilled := func(request *proto.ExecRequest, response *proto.ExecResponse, ordStatus string) *proto.ExecResponse {
+
+		if request.RawCmd.OrderType == constants.OrderTypeLimitName &&
+			(ordStatus == ordStatusExpired || ordStatus == ordStatusCanceled || ordStatus == ordStatusDoneForDay) {
+			response.Description = "Order rejected " + response.Description
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		response.Description = "Order wasn't fill " + response.Description
+		return response
+	}
+
+	progress := func(request *proto.ExecRequest, m *Message) {
+
+		quantity, err := decimalOf(m, TagCumQty)
+
+		if err != nil {
+			ctxLog.Error("Cannot parse executed quantity ["+m.Get(TagCumQty)+"] ", err)
+			return
+		}
+
+		var response = proto.ExecResponse{Request: request, Status: proto.StatusOpen, ExecutedQuantity: quantity}
+
+		if quantity.IsPositive() {
+			response.Status = proto.StatusPartiallyFilled
+		}
+
+		response.Description = "Order is alive " + describe(m)
+
+		request.Progress(&response)
+	}
+
+	// settle builds final response from the last execution report, fills are taken from trade reports. When some trade
+	// reports are missed one fill of cumulative quantity at average price is made.
+	settle := func(request *proto.ExecRequest, response *proto.ExecResponse, state *orderState) *proto.ExecResponse {
+
+		m := state.last
+
+		response.Description = describe(m)
+
+		if m.Get(TagOrdStatus) == ordStatusRejected {
+			response.Description = "Order rejected " + response.Description
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		executed, err := decimalOf(m, TagCumQty)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		if executed.IsZero() {
+			return notFilled(request, response, m.Get(TagOrdStatus))
+		}
+
+		response.Order = &cmd.Order{ExternalOrderId: unknownOrderId, ExternalOrderRef: m.Get(TagOrderId),
+			ExecutionId: request.Cmd.Id}
+
+		quantity := decimal.Zero
+
+		for _, f := range state.fills {
+			quantity = quantity.Add(f.Quantity)
+		}
+
+		if quantity.Equal(executed) {
+
+			response.Order.Fills = state.fills
+
+		} else {
+
+			ctxLog.Warn("Trade reports don't match CumQty, order is settled at AvgPx ", describe(m))
+
+			fill := cmd.Fill{ExternalTradeId: unknownTradeId, Quantity: executed, CommissionAsset: commissionAssetOf(m)}
+
+			if fill.Price, err = decimalOf(m, TagAvgPx); err != nil {
+				return errorResponse(response, err)
+			}
+
+			if fill.Commission, err = decimalOf(m, TagCommission); err != nil {
+				return errorResponse(response, err)
+			}
+
+			response.Order.Fills = []cmd.Fill{fill}
+		}
+
+		cmd.ApplyFills(response.Order)
+
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	// park builds response of alive order which isn't followed any more, the last report may be unknown
+	park := func(request *proto.ExecRequest, response *proto.ExecResponse, state *orderState) *proto.ExecResponse {
+
+		if state.last == nil {
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown")
+		}
+
+		quantity, err := decimalOf(state.last, TagCumQty)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		return proto.Park(response, quantity, describe(state.last))
+	}
+
+	// follow takes reports of the order till it gets final status, OrderStatusRequest is sent from time to time since
+	// reports may be delayed by reconnect and resend. Time till the first report of new order is time outside. The
+	// order is parked when the request may not follow it any more.
+	follow := func(request *proto.ExecRequest, response *proto.ExecResponse, messages <-chan *Message,
+		state *orderState, start time.Time) *proto.ExecResponse {
+
+		reported := ""
+
+		if state.last != nil {
+
+			reported = state.last.Get(TagOrdStatus) + "/" + state.last.Get(TagCumQty)
+			progress(request, state.last)
+
+			if !request.KeepFollowing() {
+				return park(request, response, state)
+			}
+		}
+
+		ticker := time.NewTicker(config.StatusPollTime)
+
+		defer ticker.Stop()
+
+		till := time.NewTimer(time.Until(request.FollowTill))
+
+		defer till.Stop()
+
+		for {
+
+			select {
+
+			case <-till.C:
+				return park(request, response, state)
+
+			case <-ticker.C:
+
+				if err := statusRequest(request); err != nil {
+					ctxLog.Warn("Order status isn't requested ", err)
+				}
+
+			case m := <-messages:
+
+				if state.last == nil {
+					response.OutsideExecution = time.Now().Sub(start)
+				}
+
+				if m.Type() != MsgTypeExecutionReport {
+					response.Description = rejectText(m)
+					return response
+				}
+
+				if err := state.apply(m); err != nil {
+					return errorResponse(response, err)
+				}
+
+				if isFinal(m.Get(TagOrdStatus)) {
+					return settle(request, response, state)
+				}
+
+				if current := m.Get(TagOrdStatus) + "/" + m.Get(TagCumQty); current != reported {
+					reported = current
+					progress(request, m)
+				}
+			}
+		}
+	}
+
+	place := func(request *proto.ExecRequest, response *proto.ExecResponse, messages <-chan *Message) *proto.ExecResponse {
+
+		m := NewMessage(MsgTypeNewOrderSingle)
+
+		m.Add(TagClOrdId, clOrdIdOf(request.Cmd.Id))
+
+		if len(request.RawCmd.ApiKey) > 0 {
+			m.Add(TagAccount, request.RawCmd.ApiKey)
+		}
+
+		m.Add(TagHandlInst, handlInstAutomated)
+		m.Add(TagSymbol, request.RawCmd.Instrument)
+
+		if request.RawCmd.Direction == constants.OrderDirectionBuyName {
+			m.Add(TagSide, sideBuy)
+		} else if request.RawCmd.Direction == constants.OrderDirectionSellName {
+			m.Add(TagSide, sideSell)
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong Direction with empty cmd ! ", request)
+			return nil
+		}
+
+		m.Add(TagTransactTime, Now())
+		m.Add(TagOrderQty, request.RawCmd.Amount)
+
+		if request.RawCmd.OrderType == constants.OrderTypeMarketName {
+
+			m.Add(TagOrdType, ordTypeMarket)
+
+		} else if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+
+			m.Add(TagOrdType, ordTypeLimit)
+			m.Add(TagPrice, request.RawCmd.LimitPrice)
+
+			if request.RawCmd.TimeInForce == constants.TimeInForceGtcName {
+				m.Add(TagTimeInForce, timeInForceGtc)
+			} else if request.RawCmd.TimeInForce == constants.TimeInForceFokName {
+				m.Add(TagTimeInForce, timeInForceFok)
+			} else {
+				msg := "Protocol violation! ExecRequest has wrong TimeInForce."
+				ctxLog.Error(msg, request)
+				response.Description = msg
+				return response
+			}
+
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong OrderType ! ", request)
+			return nil
+		}
+
+		if !session.waitLogon(request.Cmd.ExecuteTillTime) {
+			response.Description = "TimedOut while FIX session isn't logged on"
+			response.Status = proto.StatusTimedOut
+			return response
+		}
+
+		start := time.Now()
+
+		if err := send(request, m); err != nil {
+			ctxLog.Error("Trade error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		return follow(request, response, messages, newOrderState(), start)
+	}
+
+	trade := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		clOrdId := clOrdIdOf(request.Cmd.Id)
+
+		messages := routes.register(clOrdId)
+
+		defer routes.unregister(clOrdId)
+
+		return place(request, response, messages)
+	}
+
+	// status asks status of the order, reports of trades which come before the answer are taken into state. Nil state
+	// means acceptor doesn't know the order.
+	status := func(request *proto.ExecRequest, messages <-chan *Message) (*orderState, error) {
+
+		state := newOrderState()
+
+		for attempt := 1; attempt <= statusAttempts; attempt++ {
+
+			if !session.waitLogon(time.Now().Add(statusWaitTime)) {
+				continue
+			}
+
+			if err := statusRequest(request); err != nil {
+				ctxLog.Warn("Order status isn't requested ", err)
+				continue
+			}
+
+			timeout := time.After(statusWaitTime)
+
+			for waiting := true; waiting; {
+
+				select {
+
+				case <-timeout:
+					waiting = false
+
+				case m := <-messages:
+
+					if m.Type() == MsgTypeBusinessMessageReject &&
+						m.Get(TagBusinessRejectReason) == businessRejectReasonUnknownId {
+						return nil, nil
+					}
+
+					if m.Type() != MsgTypeExecutionReport {
+						return nil, errors.New(rejectText(m))
+					}
+
+					if m.Get(TagExecType) == execTypeOrderStatus && m.Get(TagOrdStatus) == ordStatusRejected &&
+						m.Get(TagOrdRejReason) == ordRejReasonUnknownOrder {
+						return nil, nil
+					}
+
+					if err := state.apply(m); err != nil {
+						return nil, err
+					}
+
+					if m.Get(TagExecType) == execTypeOrderStatus || isFinal(m.Get(TagOrdStatus)) {
+						return state, nil
+					}
+				}
+			}
+		}
+
+		return nil, errStatusNotAnswered
+	}
+
+	check := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		clOrdId := clOrdIdOf(request.Cmd.Id)
+
+		messages := routes.register(clOrdId)
+
+		defer routes.unregister(clOrdId)
+
+		state, err := status(request, messages)
+
+		if err != nil {
+			ctxLog.Error("Check error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		if state == nil {
+
+			if request.Cmd.ExecuteTillTime.After(time.Now()) {
+				return place(request, response, messages)
+			}
+
+			ctxLog.Info("Check error, order not exist and will be marked timed_out ", request.RawCmd.Id)
+			response.Description = "Order with ClOrdID " + clOrdId + " not exist"
+			response.Status = proto.StatusTimedOut
+			return response
+		}
+
+		ctxLog.Trace("Order status from FIX ", state.last)
+
+		if isFinal(state.last.Get(TagOrdStatus)) {
+			return settle(request, response, state)
+		}
+
+		return follow(request, response, messages, state, time.Time{})
+	}
+
+	info := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+		response.Description = "Order type " + request.RawCmd.OrderType + " isn't supported by FIX connector"
+		response.Status = proto.StatusRejected
+		return response
+	}
+
+	connector.RunConnector(ctxLog, in, out, execPoolSize, trade, check, info)
+}
@@ -0,0 +1,208 @@
+package ecfix_test
+
+import (
+	"github.com/shopspring/decimal"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/ecfix"
+	"msq.ai/exchange/ecfix/mock"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTimeout = 20 * time.Second
+
+// listener keeps accepted connections, so the test can cut connector off the acceptor
+type listener struct {
+	net.Listener
+
+	lock  sync.Mutex
+	conns []net.Conn
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+
+	conn, err := l.Listener.Accept()
+
+	if err == nil {
+		l.lock.Lock()
+		l.conns = append(l.conns, conn)
+		l.lock.Unlock()
+	}
+
+	return conn, err
+}
+
+// cut closes the listener and all its connections, connector can't connect again
+func (l *listener) cut() {
+
+	_ = l.Listener.Close()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+}
+
+type testConnector struct {
+	in       chan *proto.ExecRequest
+	out      chan *proto.ExecResponse
+	listener *listener
+}
+
+func newServer() *mock.Server {
+	return mock.NewServer("MOCK", []spot.Symbol{{Name: "BTCUSD", Base: "BTC", Quote: "USD",
+		Price: decimal.NewFromInt(30000)}}, spot.Outcome{Status: spot.OutcomeFilled})
+}
+
+// startConnector runs connector with session CLIENT->MOCK kept in storeDir against the server on a new port
+func startConnector(t *testing.T, server *mock.Server, storeDir string, resetOnLogon bool,
+	statusPollTime time.Duration) *testConnector {
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal("Cannot listen ", err)
+	}
+
+	c := &testConnector{
+		in:       make(chan *proto.ExecRequest),
+		out:      make(chan *proto.ExecResponse, 100),
+		listener: &listener{Listener: tcp},
+	}
+
+	t.Cleanup(c.listener.cut)
+
+	go server.Serve(c.listener)
+
+	config := ecfix.Config{
+		Session: ecfix.SessionConfig{
+			Address:           tcp.Addr().String(),
+			SenderCompId:      "CLIENT",
+			TargetCompId:      "MOCK",
+			HeartBtInt:        30 * time.Second,
+			StoreDir:          storeDir,
+			ResetOnLogon:      resetOnLogon,
+			ReconnectInterval: 100 * time.Millisecond,
+		},
+		StatusPollTime: statusPollTime,
+	}
+
+	ecfix.RunFixConnector(c.in, c.out, 2, config)
+
+	return c
+}
+
+// market wraps market buy as coordinator does
+func market(id int64) *proto.ExecRequest {
+
+	now := time.Now()
+
+	raw := &cmd.RawCommand{Id: strconv.FormatInt(id, 10), Instrument: "BTCUSD",
+		Direction: constants.OrderDirectionBuyName, OrderType: constants.OrderTypeMarketName, Amount: "0.01"}
+
+	command := &cmd.Command{Id: id, InstrumentName: raw.Instrument, ExecuteTillTime: now.Add(time.Minute),
+		Amount: decimal.RequireFromString(raw.Amount)}
+
+	return &proto.ExecRequest{What: proto.ExecuteCmd, RawCmd: raw, Cmd: command, FollowTill: now.Add(time.Minute)}
+}
+
+// execute sends the request and waits for its last response, progress responses are skipped
+func (c *testConnector) execute(t *testing.T, r *proto.ExecRequest) *proto.ExecResponse {
+
+	t.Helper()
+
+	c.in <- r
+
+	deadline := time.After(testTimeout)
+
+	for {
+		select {
+		case <-deadline:
+			t.Fatal("No response of command ", r.RawCmd.Id)
+			return nil
+		case response := <-c.out:
+			if response.Request == r && (proto.IsFinal(response.Status) || response.Parked) {
+				return response
+			}
+		}
+	}
+}
+
+func TestReportsOfLostConnectionAreResent(t *testing.T) {
+
+	server := newServer()
+
+	// status isn't polled while the test runs, the reports may come only by resend after reconnect
+	c := startConnector(t, server, t.TempDir(), false, time.Minute)
+
+	server.Push(spot.Outcome{Status: spot.OutcomeLost})
+
+	response := c.execute(t, market(1))
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.01")) {
+		t.Fatal("Order of lost reports isn't settled ", response.Status, " ", response.Description)
+	}
+
+	// trade report is resent, so the fill keeps its ExecID
+	if len(response.Order.Fills) != 1 || len(response.Order.Fills[0].ExternalTradeRef) == 0 {
+		t.Error("Fill isn't taken from resent trade report ", response.Order.Fills)
+	}
+
+	// session goes on in sequence after the resend
+	if response = c.execute(t, market(2)); response.Status != proto.StatusOk {
+		t.Fatal("Order after resend isn't executed ", response.Status, " ", response.Description)
+	}
+}
+
+func TestReportsLostBySequenceResetAreRequested(t *testing.T) {
+
+	server := newServer()
+
+	// reset on logon drops the reports which weren't written, only OrderStatusRequest may recover the order
+	c := startConnector(t, server, t.TempDir(), true, time.Second)
+
+	server.Push(spot.Outcome{Status: spot.OutcomeLost})
+
+	response := c.execute(t, market(1))
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.01")) {
+		t.Fatal("Order of lost reports isn't settled ", response.Status, " ", response.Description)
+	}
+
+	// order status report carries no trades, the order is settled at AvgPx
+	if len(response.Order.Fills) != 1 || len(response.Order.Fills[0].ExternalTradeRef) != 0 {
+		t.Error("Fill isn't made of order status ", response.Order.Fills)
+	}
+}
+
+func TestSequencesSurviveRestart(t *testing.T) {
+
+	server := newServer()
+
+	storeDir := t.TempDir()
+
+	first := startConnector(t, server, storeDir, false, time.Second)
+
+	if response := first.execute(t, market(1)); response.Status != proto.StatusOk {
+		t.Fatal("Order before restart isn't executed ", response.Status, " ", response.Description)
+	}
+
+	first.listener.cut()
+
+	// acceptor keeps the sequences of the session, logon from 1 would be answered by logout
+	second := startConnector(t, server, storeDir, false, time.Second)
+
+	response := second.execute(t, market(2))
+
+	if response.Status != proto.StatusOk || len(response.Order.Fills[0].ExternalTradeRef) == 0 {
+		t.Fatal("Order after restart isn't executed in sequence ", response.Status, " ", response.Description)
+	}
+}
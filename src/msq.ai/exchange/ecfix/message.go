@@ -0,0 +1,345 @@
+package ecfix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const BeginString = "FIX.4.4"
+
+const soh = '\x01'
+
+// SendingTimeFormat is UTCTimestamp of FIX with milliseconds
+const SendingTimeFormat = "20060102-15:04:05.000"
+
+const TagAccount = 1
+const TagAvgPx = 6
+const TagBeginSeqNo = 7
+const TagBeginString = 8
+const TagBodyLength = 9
+const TagCheckSum = 10
+const TagClOrdId = 11
+const TagCommission = 12
+const TagCommType = 13
+const TagCumQty = 14
+const TagCurrency = 15
+const TagEndSeqNo = 16
+const TagExecId = 17
+const TagHandlInst = 21
+const TagLastPx = 31
+const TagLastQty = 32
+const TagMsgSeqNum = 34
+const TagMsgType = 35
+const TagNewSeqNo = 36
+const TagOrderId = 37
+const TagOrderQty = 38
+const TagOrdStatus = 39
+const TagOrdType = 40
+const TagPossDupFlag = 43
+const TagPrice = 44
+const TagRefSeqNum = 45
+const TagSenderCompId = 49
+const TagSendingTime = 52
+const TagSide = 54
+const TagSymbol = 55
+const TagTargetCompId = 56
+const TagText = 58
+const TagTimeInForce = 59
+const TagTransactTime = 60
+const TagEncryptMethod = 98
+const TagOrdRejReason = 103
+const TagHeartBtInt = 108
+const TagTestReqId = 112
+const TagOrigSendingTime = 122
+const TagGapFillFlag = 123
+const TagResetSeqNumFlag = 141
+const TagExecType = 150
+const TagLeavesQty = 151
+const TagRefMsgType = 372
+const TagBusinessRejectRefId = 379
+const TagBusinessRejectReason = 380
+const TagCommCurrency = 479
+const TagUsername = 553
+const TagPassword = 554
+
+const MsgTypeHeartbeat = "0"
+const MsgTypeTestRequest = "1"
+const MsgTypeResendRequest = "2"
+const MsgTypeReject = "3"
+const MsgTypeSequenceReset = "4"
+const MsgTypeLogout = "5"
+const MsgTypeExecutionReport = "8"
+const MsgTypeLogon = "A"
+const MsgTypeNewOrderSingle = "D"
+const MsgTypeOrderStatusRequest = "H"
+const MsgTypeBusinessMessageReject = "j"
+
+// IsAdmin returns true for session level messages, they are never resent, gap fill is sent instead
+func IsAdmin(msgType string) bool {
+	return msgType == MsgTypeHeartbeat || msgType == MsgTypeTestRequest || msgType == MsgTypeResendRequest ||
+		msgType == MsgTypeReject || msgType == MsgTypeSequenceReset || msgType == MsgTypeLogout || msgType == MsgTypeLogon
+}
+
+// header fields go first in this order, the rest keep order they were added in
+var headerOrder = []int{TagMsgType, TagSenderCompId, TagTargetCompId, TagMsgSeqNum, TagPossDupFlag, TagSendingTime,
+	TagOrigSendingTime}
+
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message keeps fields of FIX message without BeginString, BodyLength and CheckSum, they are made by Encode. Repeating
+// groups aren't supported, none of used messages needs them.
+type Message struct {
+	Fields []Field
+}
+
+func NewMessage(msgType string) *Message {
+	return &Message{Fields: []Field{{Tag: TagMsgType, Value: msgType}}}
+}
+
+func (m *Message) Type() string {
+	return m.Get(TagMsgType)
+}
+
+// Get returns value of the first field with the tag or empty string
+func (m *Message) Get(tag int) string {
+
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+
+	return ""
+}
+
+func (m *Message) Has(tag int) bool {
+
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetInt returns value of the field as int, zero when there is no such field or it isn't a number
+func (m *Message) GetInt(tag int) int {
+
+	value, err := strconv.Atoi(m.Get(tag))
+
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+func (m *Message) Add(tag int, value string) *Message {
+	m.Fields = append(m.Fields, Field{Tag: tag, Value: value})
+	return m
+}
+
+// Set replaces value of the field or adds it
+func (m *Message) Set(tag int, value string) *Message {
+
+	for i := range m.Fields {
+		if m.Fields[i].Tag == tag {
+			m.Fields[i].Value = value
+			return m
+		}
+	}
+
+	return m.Add(tag, value)
+}
+
+func (m *Message) String() string {
+	return string(bytes.Replace(m.Encode(), []byte{soh}, []byte{'|'}, -1))
+}
+
+// Encode returns message on the wire with BeginString, BodyLength and CheckSum
+func (m *Message) Encode() []byte {
+
+	var body bytes.Buffer
+
+	written := make(map[int]bool)
+
+	for _, tag := range headerOrder {
+		for _, f := range m.Fields {
+			if f.Tag == tag && !written[tag] {
+				body.WriteString(strconv.Itoa(f.Tag) + "=" + f.Value)
+				body.WriteByte(soh)
+				written[tag] = true
+			}
+		}
+	}
+
+	for _, f := range m.Fields {
+		if !isHeader(f.Tag) {
+			body.WriteString(strconv.Itoa(f.Tag) + "=" + f.Value)
+			body.WriteByte(soh)
+		}
+	}
+
+	var result bytes.Buffer
+
+	result.WriteString(strconv.Itoa(TagBeginString) + "=" + BeginString)
+	result.WriteByte(soh)
+	result.WriteString(strconv.Itoa(TagBodyLength) + "=" + strconv.Itoa(body.Len()))
+	result.WriteByte(soh)
+	result.Write(body.Bytes())
+
+	result.WriteString(fmt.Sprintf("%d=%03d", TagCheckSum, checkSum(result.Bytes())))
+	result.WriteByte(soh)
+
+	return result.Bytes()
+}
+
+func isHeader(tag int) bool {
+
+	for _, t := range headerOrder {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkSum(data []byte) int {
+
+	var sum int
+
+	for _, b := range data {
+		sum += int(b)
+	}
+
+	return sum % 256
+}
+
+func readField(r *bufio.Reader, tag int) (string, []byte, error) {
+
+	raw, err := r.ReadBytes(soh)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	prefix := strconv.Itoa(tag) + "="
+
+	if !bytes.HasPrefix(raw, []byte(prefix)) {
+		return "", nil, fmt.Errorf("tag %d expected, got [%s]", tag, string(raw))
+	}
+
+	return string(raw[len(prefix) : len(raw)-1]), raw, nil
+}
+
+// ReadMessage reads the next message, it checks BeginString, BodyLength and CheckSum. Raw message is returned too.
+func ReadMessage(r *bufio.Reader) (*Message, []byte, error) {
+
+	beginString, rawBegin, err := readField(r, TagBeginString)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if beginString != BeginString {
+		return nil, nil, errors.New("unsupported BeginString " + beginString)
+	}
+
+	bodyLength, rawLength, err := readField(r, TagBodyLength)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	length, err := strconv.Atoi(bodyLength)
+
+	if err != nil || length < 0 {
+		return nil, nil, errors.New("wrong BodyLength " + bodyLength)
+	}
+
+	body := make([]byte, length)
+
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	sum, rawSum, err := readField(r, TagCheckSum)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw := make([]byte, 0, len(rawBegin)+len(rawLength)+length+len(rawSum))
+
+	raw = append(raw, rawBegin...)
+	raw = append(raw, rawLength...)
+	raw = append(raw, body...)
+
+	if expected := fmt.Sprintf("%03d", checkSum(raw)); sum != expected {
+		return nil, nil, errors.New("wrong CheckSum " + sum + " expected " + expected)
+	}
+
+	raw = append(raw, rawSum...)
+
+	m, err := parseBody(body)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, raw, nil
+}
+
+// ParseMessage parses raw message which was read or encoded before
+func ParseMessage(raw []byte) (*Message, error) {
+
+	m, _, err := ReadMessage(bufio.NewReader(bytes.NewReader(raw)))
+
+	return m, err
+}
+
+func parseBody(body []byte) (*Message, error) {
+
+	m := &Message{Fields: make([]Field, 0)}
+
+	for _, part := range bytes.Split(body, []byte{soh}) {
+
+		if len(part) == 0 {
+			continue
+		}
+
+		i := bytes.IndexByte(part, '=')
+
+		if i < 1 {
+			return nil, errors.New("wrong field [" + string(part) + "]")
+		}
+
+		tag, err := strconv.Atoi(string(part[:i]))
+
+		if err != nil {
+			return nil, errors.New("wrong tag [" + string(part) + "]")
+		}
+
+		m.Add(tag, string(part[i+1:]))
+	}
+
+	if len(m.Fields) == 0 || m.Fields[0].Tag != TagMsgType {
+		return nil, errors.New("MsgType isn't the first field of body")
+	}
+
+	return m, nil
+}
+
+func Now() string {
+	return time.Now().UTC().Format(SendingTimeFormat)
+}
@@ -0,0 +1,838 @@
+package mock
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/ecfix"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const outcomesPath = "/mock/outcomes"
+
+const yesValue = "Y"
+
+const execTypeNew = "0"
+const execTypeCanceled = "4"
+const execTypeRejected = "8"
+const execTypeExpired = "C"
+const execTypeTrade = "F"
+const execTypeOrderStatus = "I"
+
+const ordStatusNew = "0"
+const ordStatusPartiallyFilled = "1"
+const ordStatusFilled = "2"
+const ordStatusCanceled = "4"
+const ordStatusRejected = "8"
+const ordStatusExpired = "C"
+
+const ordRejReasonUnknownSymbol = "1"
+const ordRejReasonExceedsLimit = "3"
+const ordRejReasonUnknownOrder = "5"
+const ordRejReasonOther = "99"
+
+const businessRejectReasonOther = "0"
+const businessRejectReasonNotAvailable = "4"
+
+const sideBuy = "1"
+const sideSell = "2"
+
+const ordTypeMarket = "1"
+const ordTypeLimit = "2"
+
+const timeInForceFok = "4"
+
+// commission is absolute amount in quote currency
+const commTypeAbsolute = "3"
+
+const heartbeatCheckTime = time.Second
+
+// all amounts are formatted with this precision
+const precision = 8
+
+var commissionRate = decimal.New(1, -3)
+
+type order struct {
+	clOrdId     string
+	orderId     string
+	symbol      *spot.Symbol
+	side        string
+	ordType     string
+	timeInForce string
+	price       decimal.Decimal
+	quantity    decimal.Decimal
+	executed    decimal.Decimal
+	cost        decimal.Decimal
+	commission  decimal.Decimal
+	ordStatus   string
+}
+
+func (o *order) isAlive() bool {
+	return o.ordStatus == ordStatusNew || o.ordStatus == ordStatusPartiallyFilled
+}
+
+// session keeps state of initiator between connections, sequence numbers and sent messages are kept in memory only
+type session struct {
+	compId string
+
+	conn     net.Conn
+	loggedOn bool
+	lastSent time.Time
+
+	heartBtInt time.Duration
+
+	nextOut int
+	nextIn  int
+	sent    map[int]*ecfix.Message
+
+	resendTill int
+
+	orders map[string]*order
+}
+
+// Server imitates FIX 4.4 acceptor of a broker. Every initiator, known by its SenderCompID, gets its own session and
+// orders. What happens with new orders is scripted with outcomes of Binance spot mock, LOST fills the order but drops the
+// connection instead of execution reports, initiator gets them by resend request after it connects again.
+type Server struct {
+	lock sync.Mutex
+
+	compId  string
+	symbols map[string]*spot.Symbol
+
+	defaultOutcome spot.Outcome
+	outcomes       []spot.Outcome
+
+	sessions map[string]*session
+
+	nextOrderId int64
+	nextExecId  int64
+
+	ctxLog *log.Entry
+}
+
+func NewServer(compId string, symbols []spot.Symbol, defaultOutcome spot.Outcome) *Server {
+
+	s := &Server{
+		compId:         compId,
+		symbols:        make(map[string]*spot.Symbol),
+		defaultOutcome: defaultOutcome,
+		outcomes:       make([]spot.Outcome, 0),
+		sessions:       make(map[string]*session),
+		nextOrderId:    1,
+		nextExecId:     1,
+		ctxLog:         log.WithFields(log.Fields{"id": "FixMock"}),
+	}
+
+	for i := range symbols {
+		s.symbols[symbols[i].Name] = &symbols[i]
+	}
+
+	return s
+}
+
+// Push appends outcomes to the script, they are taken by new orders one by one, default outcome is used after
+func (s *Server) Push(outcomes ...spot.Outcome) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.outcomes = append(s.outcomes, outcomes...)
+}
+
+// Handler serves outcomes of the script over HTTP, GET shows them and POST appends comma separated list
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(outcomesPath, s.handleOutcomes)
+
+	return mux
+}
+
+// Serve accepts FIX connections till listener fails
+func (s *Server) Serve(listener net.Listener) error {
+
+	for {
+
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return err
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func format(val decimal.Decimal) string {
+	return val.StringFixed(precision)
+}
+
+func (s *Server) nextOutcome() spot.Outcome {
+
+	if len(s.outcomes) == 0 {
+		return s.defaultOutcome
+	}
+
+	outcome := s.outcomes[0]
+
+	s.outcomes = s.outcomes[1:]
+
+	return outcome
+}
+
+func (s *Server) execId() string {
+
+	id := s.nextExecId
+
+	s.nextExecId++
+
+	return "E" + strconv.FormatInt(id, 10)
+}
+
+func (s *Server) write(ss *session, raw []byte) {
+
+	if ss.conn == nil {
+		return
+	}
+
+	ss.lastSent = time.Now()
+
+	if _, err := ss.conn.Write(raw); err != nil {
+		s.ctxLog.Error("Write error ", err)
+		s.disconnect(ss)
+	}
+}
+
+// send numbers and keeps the message, it is written only when initiator is connected and resent on its request
+func (s *Server) send(ss *session, m *ecfix.Message) {
+
+	seq := ss.nextOut
+
+	ss.nextOut++
+
+	m.Set(ecfix.TagSenderCompId, s.compId)
+	m.Set(ecfix.TagTargetCompId, ss.compId)
+	m.Set(ecfix.TagMsgSeqNum, strconv.Itoa(seq))
+	m.Set(ecfix.TagSendingTime, ecfix.Now())
+
+	ss.sent[seq] = m
+
+	s.write(ss, m.Encode())
+}
+
+func (s *Server) disconnect(ss *session) {
+
+	if ss.conn != nil {
+		ss.conn.Close()
+	}
+
+	ss.conn = nil
+	ss.loggedOn = false
+}
+
+func (s *Server) logout(ss *session, text string) {
+
+	s.ctxLog.Warn("Logout of ", ss.compId, " ", text)
+	s.send(ss, ecfix.NewMessage(ecfix.MsgTypeLogout).Add(ecfix.TagText, text))
+	s.disconnect(ss)
+}
+
+func (s *Server) resend(ss *session, begin int, end int) {
+
+	last := ss.nextOut - 1
+
+	if end == 0 || end > last {
+		end = last
+	}
+
+	gap := 0
+
+	gapFill := func(next int) {
+
+		if gap == 0 {
+			return
+		}
+
+		m := ecfix.NewMessage(ecfix.MsgTypeSequenceReset)
+
+		m.Set(ecfix.TagSenderCompId, s.compId)
+		m.Set(ecfix.TagTargetCompId, ss.compId)
+		m.Set(ecfix.TagMsgSeqNum, strconv.Itoa(gap))
+		m.Set(ecfix.TagPossDupFlag, yesValue)
+		m.Set(ecfix.TagSendingTime, ecfix.Now())
+		m.Add(ecfix.TagGapFillFlag, yesValue)
+		m.Add(ecfix.TagNewSeqNo, strconv.Itoa(next))
+
+		s.write(ss, m.Encode())
+
+		gap = 0
+	}
+
+	for seq := begin; seq <= end && ss.conn != nil; seq++ {
+
+		m := ss.sent[seq]
+
+		if m == nil || ecfix.IsAdmin(m.Type()) {
+
+			if gap == 0 {
+				gap = seq
+			}
+
+			continue
+		}
+
+		gapFill(seq)
+
+		resent := &ecfix.Message{Fields: append([]ecfix.Field{}, m.Fields...)}
+
+		resent.Set(ecfix.TagPossDupFlag, yesValue)
+		resent.Set(ecfix.TagOrigSendingTime, m.Get(ecfix.TagSendingTime))
+		resent.Set(ecfix.TagSendingTime, ecfix.Now())
+
+		s.write(ss, resent.Encode())
+	}
+
+	gapFill(end + 1)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func (s *Server) serveConn(conn net.Conn) {
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	var ss *session
+
+	for {
+
+		m, _, err := ecfix.ReadMessage(reader)
+
+		if err != nil {
+
+			s.lock.Lock()
+
+			if ss != nil && ss.conn == conn {
+				s.ctxLog.Info("Connection of ", ss.compId, " is closed ", err)
+				s.disconnect(ss)
+			}
+
+			s.lock.Unlock()
+
+			return
+		}
+
+		s.ctxLog.Trace("Received ", m)
+
+		s.lock.Lock()
+
+		if ss == nil {
+			ss = s.logon(conn, m)
+		} else if ss.conn == conn {
+			s.handle(ss, m)
+		}
+
+		s.lock.Unlock()
+
+		if ss == nil {
+			return
+		}
+	}
+}
+
+// logon takes the first message of connection, nil is returned when connection is refused
+func (s *Server) logon(conn net.Conn, m *ecfix.Message) *session {
+
+	if m.Type() != ecfix.MsgTypeLogon || m.Get(ecfix.TagTargetCompId) != s.compId {
+		s.ctxLog.Warn("Connection is refused, first message isn't Logon to ", s.compId, " ", m)
+		return nil
+	}
+
+	compId := m.Get(ecfix.TagSenderCompId)
+
+	ss := s.sessions[compId]
+
+	if ss == nil {
+		ss = &session{compId: compId, nextOut: 1, nextIn: 1, sent: make(map[int]*ecfix.Message),
+			orders: make(map[string]*order)}
+		s.sessions[compId] = ss
+	}
+
+	if ss.conn != nil {
+		s.ctxLog.Warn("Session ", compId, " is connected again, previous connection is closed")
+		s.disconnect(ss)
+	}
+
+	ss.conn = conn
+	ss.resendTill = 0
+	ss.heartBtInt = time.Duration(m.GetInt(ecfix.TagHeartBtInt)) * time.Second
+
+	reset := m.Get(ecfix.TagResetSeqNumFlag) == yesValue
+
+	if reset {
+		ss.nextOut = 1
+		ss.nextIn = 1
+		ss.sent = make(map[int]*ecfix.Message)
+	}
+
+	seq := m.GetInt(ecfix.TagMsgSeqNum)
+
+	if seq < ss.nextIn {
+		s.logout(ss, "MsgSeqNum too low, expecting "+strconv.Itoa(ss.nextIn)+" but received "+strconv.Itoa(seq))
+		return nil
+	}
+
+	answer := ecfix.NewMessage(ecfix.MsgTypeLogon)
+
+	answer.Add(ecfix.TagEncryptMethod, "0")
+	answer.Add(ecfix.TagHeartBtInt, m.Get(ecfix.TagHeartBtInt))
+
+	if reset {
+		answer.Add(ecfix.TagResetSeqNumFlag, yesValue)
+	}
+
+	ss.loggedOn = true
+
+	s.send(ss, answer)
+
+	s.ctxLog.Info("Session ", compId, " is logged on, next outgoing ", ss.nextOut, " expected incoming ", ss.nextIn)
+
+	if seq > ss.nextIn {
+		s.askResend(ss, seq)
+	} else {
+		ss.nextIn = seq + 1
+	}
+
+	go s.heartbeats(ss, conn)
+
+	return ss
+}
+
+func (s *Server) heartbeats(ss *session, conn net.Conn) {
+
+	ticker := time.NewTicker(heartbeatCheckTime)
+
+	defer ticker.Stop()
+
+	for range ticker.C {
+
+		s.lock.Lock()
+
+		if ss.conn != conn {
+			s.lock.Unlock()
+			return
+		}
+
+		if ss.heartBtInt > 0 && time.Now().Sub(ss.lastSent) >= ss.heartBtInt {
+			s.send(ss, ecfix.NewMessage(ecfix.MsgTypeHeartbeat))
+		}
+
+		s.lock.Unlock()
+	}
+}
+
+func (s *Server) askResend(ss *session, seq int) {
+
+	if seq <= ss.resendTill {
+		return
+	}
+
+	s.ctxLog.Warn("Gap of messages of ", ss.compId, " from ", ss.nextIn, " till ", seq)
+
+	ss.resendTill = seq
+
+	s.send(ss, ecfix.NewMessage(ecfix.MsgTypeResendRequest).Add(ecfix.TagBeginSeqNo, strconv.Itoa(ss.nextIn)).
+		Add(ecfix.TagEndSeqNo, "0"))
+}
+
+func (s *Server) handle(ss *session, m *ecfix.Message) {
+
+	msgType := m.Type()
+	seq := m.GetInt(ecfix.TagMsgSeqNum)
+
+	if msgType == ecfix.MsgTypeSequenceReset && m.Get(ecfix.TagGapFillFlag) != yesValue {
+		ss.nextIn = m.GetInt(ecfix.TagNewSeqNo)
+		return
+	}
+
+	if seq < ss.nextIn {
+
+		if m.Get(ecfix.TagPossDupFlag) != yesValue {
+			s.logout(ss, "MsgSeqNum too low, expecting "+strconv.Itoa(ss.nextIn)+" but received "+strconv.Itoa(seq))
+		}
+
+		return
+	}
+
+	if seq > ss.nextIn {
+
+		s.askResend(ss, seq)
+
+		if msgType == ecfix.MsgTypeResendRequest {
+			s.resend(ss, m.GetInt(ecfix.TagBeginSeqNo), m.GetInt(ecfix.TagEndSeqNo))
+		}
+
+		return
+	}
+
+	ss.nextIn = seq + 1
+
+	switch msgType {
+
+	case ecfix.MsgTypeHeartbeat:
+
+	case ecfix.MsgTypeSequenceReset:
+		ss.nextIn = m.GetInt(ecfix.TagNewSeqNo)
+
+	case ecfix.MsgTypeTestRequest:
+		s.send(ss, ecfix.NewMessage(ecfix.MsgTypeHeartbeat).Add(ecfix.TagTestReqId, m.Get(ecfix.TagTestReqId)))
+
+	case ecfix.MsgTypeResendRequest:
+		s.resend(ss, m.GetInt(ecfix.TagBeginSeqNo), m.GetInt(ecfix.TagEndSeqNo))
+
+	case ecfix.MsgTypeLogout:
+		s.send(ss, ecfix.NewMessage(ecfix.MsgTypeLogout))
+		s.disconnect(ss)
+
+	case ecfix.MsgTypeNewOrderSingle:
+		s.newOrder(ss, m)
+
+	case ecfix.MsgTypeOrderStatusRequest:
+		s.orderStatus(ss, m)
+
+	default:
+		s.send(ss, ecfix.NewMessage(ecfix.MsgTypeReject).Add(ecfix.TagRefSeqNum, strconv.Itoa(seq)).
+			Add(ecfix.TagRefMsgType, msgType).Add(ecfix.TagText, "Unsupported message type"))
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func (s *Server) report(o *order, execType string) *ecfix.Message {
+
+	m := ecfix.NewMessage(ecfix.MsgTypeExecutionReport)
+
+	m.Add(ecfix.TagOrderId, o.orderId)
+	m.Add(ecfix.TagClOrdId, o.clOrdId)
+	m.Add(ecfix.TagExecId, s.execId())
+	m.Add(ecfix.TagExecType, execType)
+	m.Add(ecfix.TagOrdStatus, o.ordStatus)
+	m.Add(ecfix.TagSymbol, o.symbol.Name)
+	m.Add(ecfix.TagSide, o.side)
+	m.Add(ecfix.TagOrderQty, format(o.quantity))
+	m.Add(ecfix.TagOrdType, o.ordType)
+
+	if o.ordType == ordTypeLimit {
+		m.Add(ecfix.TagPrice, format(o.price))
+	}
+
+	leaves := o.quantity.Sub(o.executed)
+
+	if !o.isAlive() {
+		leaves = decimal.Zero
+	}
+
+	avgPx := decimal.Zero
+
+	if o.executed.IsPositive() {
+		avgPx = o.cost.Div(o.executed)
+	}
+
+	m.Add(ecfix.TagLeavesQty, format(leaves))
+	m.Add(ecfix.TagCumQty, format(o.executed))
+	m.Add(ecfix.TagAvgPx, format(avgPx))
+	m.Add(ecfix.TagTransactTime, ecfix.Now())
+
+	return m
+}
+
+// reject answers new order which isn't accepted, the order isn't kept
+func (s *Server) reject(ss *session, m *ecfix.Message, reason string, text string) {
+
+	r := ecfix.NewMessage(ecfix.MsgTypeExecutionReport)
+
+	r.Add(ecfix.TagOrderId, "NONE")
+	r.Add(ecfix.TagClOrdId, m.Get(ecfix.TagClOrdId))
+	r.Add(ecfix.TagExecId, s.execId())
+	r.Add(ecfix.TagExecType, execTypeRejected)
+	r.Add(ecfix.TagOrdStatus, ordStatusRejected)
+	r.Add(ecfix.TagOrdRejReason, reason)
+	r.Add(ecfix.TagSymbol, m.Get(ecfix.TagSymbol))
+	r.Add(ecfix.TagSide, m.Get(ecfix.TagSide))
+	r.Add(ecfix.TagLeavesQty, "0")
+	r.Add(ecfix.TagCumQty, "0")
+	r.Add(ecfix.TagAvgPx, "0")
+	r.Add(ecfix.TagText, text)
+
+	s.send(ss, r)
+}
+
+func (s *Server) businessReject(ss *session, m *ecfix.Message, reason string, text string) {
+
+	r := ecfix.NewMessage(ecfix.MsgTypeBusinessMessageReject)
+
+	r.Add(ecfix.TagRefSeqNum, m.Get(ecfix.TagMsgSeqNum))
+	r.Add(ecfix.TagRefMsgType, m.Type())
+	r.Add(ecfix.TagBusinessRejectRefId, m.Get(ecfix.TagClOrdId))
+	r.Add(ecfix.TagBusinessRejectReason, reason)
+	r.Add(ecfix.TagText, text)
+
+	s.send(ss, r)
+}
+
+// fill executes quantity of the order at its price, market orders are executed at price of the symbol
+func (s *Server) fill(o *order, quantity decimal.Decimal) *ecfix.Message {
+
+	price := o.price
+
+	if o.ordType == ordTypeMarket {
+		price = o.symbol.Price
+	}
+
+	cost := quantity.Mul(price)
+	commission := cost.Mul(commissionRate).Round(precision)
+
+	o.executed = o.executed.Add(quantity)
+	o.cost = o.cost.Add(cost)
+	o.commission = o.commission.Add(commission)
+
+	if o.executed.Equal(o.quantity) {
+		o.ordStatus = ordStatusFilled
+	} else {
+		o.ordStatus = ordStatusPartiallyFilled
+	}
+
+	m := s.report(o, execTypeTrade)
+
+	m.Add(ecfix.TagLastQty, format(quantity))
+	m.Add(ecfix.TagLastPx, format(price))
+	m.Add(ecfix.TagCommission, format(commission))
+	m.Add(ecfix.TagCommType, commTypeAbsolute)
+	m.Add(ecfix.TagCommCurrency, o.symbol.Quote)
+
+	return m
+}
+
+// fillLater fills the rest of resting order after the pause, report is sent to the session whether it is connected or not
+func (s *Server) fillLater(ss *session, o *order, after time.Duration) {
+
+	time.AfterFunc(after, func() {
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if o.isAlive() {
+			s.send(ss, s.fill(o, o.quantity.Sub(o.executed)))
+		}
+	})
+}
+
+func (s *Server) newOrder(ss *session, m *ecfix.Message) {
+
+	clOrdId := m.Get(ecfix.TagClOrdId)
+
+	if ss.orders[clOrdId] != nil {
+
+		if m.Get(ecfix.TagPossDupFlag) == yesValue {
+			s.ctxLog.Info("Resent order ", clOrdId, " is known already")
+			return
+		}
+
+		s.businessReject(ss, m, businessRejectReasonOther, "Duplicate ClOrdID")
+		return
+	}
+
+	symbol := s.symbols[m.Get(ecfix.TagSymbol)]
+
+	if symbol == nil {
+		s.reject(ss, m, ordRejReasonUnknownSymbol, "Unknown symbol "+m.Get(ecfix.TagSymbol))
+		return
+	}
+
+	quantity, err := decimal.NewFromString(m.Get(ecfix.TagOrderQty))
+
+	if err != nil || !quantity.IsPositive() {
+		s.reject(ss, m, ordRejReasonOther, "Wrong OrderQty "+m.Get(ecfix.TagOrderQty))
+		return
+	}
+
+	o := &order{
+		clOrdId:     clOrdId,
+		symbol:      symbol,
+		side:        m.Get(ecfix.TagSide),
+		ordType:     m.Get(ecfix.TagOrdType),
+		timeInForce: m.Get(ecfix.TagTimeInForce),
+		quantity:    quantity,
+		ordStatus:   ordStatusNew,
+	}
+
+	if o.side != sideBuy && o.side != sideSell {
+		s.reject(ss, m, ordRejReasonOther, "Wrong Side "+o.side)
+		return
+	}
+
+	if o.ordType == ordTypeLimit {
+
+		o.price, err = decimal.NewFromString(m.Get(ecfix.TagPrice))
+
+		if err != nil || !o.price.IsPositive() {
+			s.reject(ss, m, ordRejReasonOther, "Wrong Price "+m.Get(ecfix.TagPrice))
+			return
+		}
+
+	} else if o.ordType != ordTypeMarket {
+		s.reject(ss, m, ordRejReasonOther, "Unsupported OrdType "+o.ordType)
+		return
+	}
+
+	outcome := s.nextOutcome()
+
+	s.ctxLog.Info("Order ", clOrdId, " of ", ss.compId, " gets outcome ", outcome.String())
+
+	switch outcome.Status {
+	case spot.OutcomeRejected:
+		s.reject(ss, m, ordRejReasonExceedsLimit, "Insufficient funds")
+		return
+	case spot.OutcomeError:
+		s.businessReject(ss, m, businessRejectReasonNotAvailable, "Application not available")
+		return
+	case spot.OutcomeRateLimit:
+		s.businessReject(ss, m, businessRejectReasonOther, "Throttle limit exceeded")
+		return
+	}
+
+	o.orderId = "O" + strconv.FormatInt(s.nextOrderId, 10)
+	s.nextOrderId++
+
+	ss.orders[clOrdId] = o
+
+	if outcome.Status == spot.OutcomeLost {
+
+		// reports are numbered and kept, but connection is dropped before they are written
+		conn := ss.conn
+		ss.conn = nil
+
+		s.send(ss, s.report(o, execTypeNew))
+		s.send(ss, s.fill(o, o.quantity))
+
+		ss.conn = conn
+		s.disconnect(ss)
+		return
+	}
+
+	s.send(ss, s.report(o, execTypeNew))
+
+	switch outcome.Status {
+
+	case spot.OutcomeFilled:
+		s.send(ss, s.fill(o, o.quantity))
+
+	case spot.OutcomeExpired:
+		o.ordStatus = ordStatusExpired
+		s.send(ss, s.report(o, execTypeExpired))
+
+	case spot.OutcomeCanceled:
+		o.ordStatus = ordStatusCanceled
+		s.send(ss, s.report(o, execTypeCanceled))
+
+	case spot.OutcomePartiallyFilled, spot.OutcomeNew:
+
+		if o.timeInForce == timeInForceFok {
+			o.ordStatus = ordStatusExpired
+			s.send(ss, s.report(o, execTypeExpired))
+			return
+		}
+
+		if outcome.Status == spot.OutcomePartiallyFilled {
+			s.send(ss, s.fill(o, o.quantity.Div(decimal.New(2, 0)).Round(precision)))
+		}
+
+		if outcome.FillAfter > 0 {
+			s.fillLater(ss, o, outcome.FillAfter)
+		}
+	}
+}
+
+func (s *Server) orderStatus(ss *session, m *ecfix.Message) {
+
+	o := ss.orders[m.Get(ecfix.TagClOrdId)]
+
+	if o == nil {
+
+		r := ecfix.NewMessage(ecfix.MsgTypeExecutionReport)
+
+		r.Add(ecfix.TagOrderId, "NONE")
+		r.Add(ecfix.TagClOrdId, m.Get(ecfix.TagClOrdId))
+		r.Add(ecfix.TagExecId, s.execId())
+		r.Add(ecfix.TagExecType, execTypeOrderStatus)
+		r.Add(ecfix.TagOrdStatus, ordStatusRejected)
+		r.Add(ecfix.TagOrdRejReason, ordRejReasonUnknownOrder)
+		r.Add(ecfix.TagSymbol, m.Get(ecfix.TagSymbol))
+		r.Add(ecfix.TagSide, m.Get(ecfix.TagSide))
+		r.Add(ecfix.TagLeavesQty, "0")
+		r.Add(ecfix.TagCumQty, "0")
+		r.Add(ecfix.TagAvgPx, "0")
+		r.Add(ecfix.TagText, "Unknown order")
+
+		s.send(ss, r)
+		return
+	}
+
+	r := s.report(o, execTypeOrderStatus)
+
+	r.Add(ecfix.TagCommission, format(o.commission))
+	r.Add(ecfix.TagCommType, commTypeAbsolute)
+	r.Add(ecfix.TagCommCurrency, o.symbol.Quote)
+
+	s.send(ss, r)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func (s *Server) handleOutcomes(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost {
+
+		body, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		outcomes, err := spot.ParseOutcomes(string(body))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Push(outcomes...)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]string, len(s.outcomes))
+
+	for i, o := range s.outcomes {
+		list[i] = o.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"default": s.defaultOutcome.String(), "outcomes": list}); err != nil {
+		s.ctxLog.Error("Encode error ", err)
+	}
+}
@@ -0,0 +1,45 @@
+package ecfix
+
+import (
+	"msq.ai/connectors/proto"
+	"msq.ai/connectors/registry"
+	"time"
+)
+
+const propertiesAddressName = "fix.address"
+const propertiesSenderCompIdName = "fix.sender.comp.id"
+const propertiesTargetCompIdName = "fix.target.comp.id"
+const propertiesHeartbeatSecondsName = "fix.heartbeat.seconds"
+const propertiesUsernameName = "fix.username"
+const propertiesPasswordName = "fix.password"
+const propertiesStoreDirName = "fix.store.dir"
+const propertiesResetOnLogonName = "fix.reset.on.logon"
+const propertiesReconnectSecondsName = "fix.reconnect.seconds"
+const propertiesStatusPollSecondsName = "fix.status.poll.seconds"
+
+func init() {
+	registry.Register(registry.Exchange{Name: "FIX", ExecPoolSize: 200, Run: run})
+}
+
+func run(settings registry.Settings, in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse,
+	execPoolSize int) func() time.Duration {
+
+	config := Config{
+		Session: SessionConfig{
+			Address:           settings.MustGet(propertiesAddressName),
+			SenderCompId:      settings.MustGet(propertiesSenderCompIdName),
+			TargetCompId:      settings.MustGet(propertiesTargetCompIdName),
+			HeartBtInt:        time.Duration(settings.GetInt(propertiesHeartbeatSecondsName, 30)) * time.Second,
+			Username:          settings.GetString(propertiesUsernameName, ""),
+			Password:          settings.GetString(propertiesPasswordName, ""),
+			StoreDir:          settings.GetString(propertiesStoreDirName, "./store"),
+			ResetOnLogon:      settings.GetBool(propertiesResetOnLogonName, false),
+			ReconnectInterval: time.Duration(settings.GetInt(propertiesReconnectSecondsName, 10)) * time.Second,
+		},
+		StatusPollTime: time.Duration(settings.GetInt(propertiesStatusPollSecondsName, 30)) * time.Second,
+	}
+
+	RunFixConnector(in, out, execPoolSize, config)
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package ecfix
+
+import (
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+)
+
+const routeBufferSize = 64
+
+type route struct {
+	messages chan *Message
+	seqs     []int
+}
+
+// router passes messages of the session to commands which wait for them. ExecutionReport is routed by ClOrdID,
+// BusinessMessageReject by BusinessRejectRefID or RefSeqNum and session Reject by RefSeqNum of the message sent for
+// the command.
+type router struct {
+	lock   sync.Mutex
+	ctxLog *log.Entry
+	routes map[string]*route
+	sent   map[int]string
+}
+
+func newRouter(ctxLog *log.Entry) *router {
+	return &router{ctxLog: ctxLog, routes: make(map[string]*route), sent: make(map[int]string)}
+}
+
+func (r *router) register(clOrdId string) <-chan *Message {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	rt := &route{messages: make(chan *Message, routeBufferSize)}
+
+	r.routes[clOrdId] = rt
+
+	return rt.messages
+}
+
+func (r *router) unregister(clOrdId string) {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	rt := r.routes[clOrdId]
+
+	if rt == nil {
+		return
+	}
+
+	for _, seq := range rt.seqs {
+		delete(r.sent, seq)
+	}
+
+	delete(r.routes, clOrdId)
+}
+
+// sentFor remembers MsgSeqNum of the message which is sent for the command, rejects refer to it
+func (r *router) sentFor(clOrdId string, seq int) {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	rt := r.routes[clOrdId]
+
+	if rt == nil {
+		return
+	}
+
+	rt.seqs = append(rt.seqs, seq)
+	r.sent[seq] = clOrdId
+}
+
+func (r *router) route(m *Message) {
+
+	var clOrdId string
+
+	switch m.Type() {
+	case MsgTypeExecutionReport:
+		clOrdId = m.Get(TagClOrdId)
+	case MsgTypeBusinessMessageReject:
+		clOrdId = m.Get(TagBusinessRejectRefId)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(clOrdId) == 0 || r.routes[clOrdId] == nil {
+		if m.Type() == MsgTypeReject || m.Type() == MsgTypeBusinessMessageReject {
+			clOrdId = r.sent[m.GetInt(TagRefSeqNum)]
+		}
+	}
+
+	rt := r.routes[clOrdId]
+
+	if rt == nil {
+		r.ctxLog.Warn("Message isn't waited by any command ", m)
+		return
+	}
+
+	select {
+	case rt.messages <- m:
+	default:
+		r.ctxLog.Error("Command doesn't take messages, dropped ", clOrdId, " ", m)
+	}
+}
+
+func clOrdIdOf(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
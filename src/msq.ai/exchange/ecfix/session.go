@@ -0,0 +1,533 @@
+package ecfix
+
+import (
+	"bufio"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+
+const heartbeatCheckTime = time.Second
+
+const yesValue = "Y"
+
+// SessionConfig keeps settings of FIX initiator session
+type SessionConfig struct {
+	Address      string
+	SenderCompId string
+	TargetCompId string
+
+	HeartBtInt time.Duration
+
+	Username string
+	Password string
+
+	StoreDir string
+
+	// ResetOnLogon starts both sequences from 1 on every logon, sent messages can't be resent after it
+	ResetOnLogon bool
+
+	ReconnectInterval time.Duration
+}
+
+// Session is FIX initiator session. It keeps connection to acceptor with logon and heartbeats, persists sequence numbers
+// and sent messages, asks resend of missed messages and answers resend requests of acceptor. Application messages which
+// come in sequence are passed to onApp, they are passed from one goroutine in order they come.
+type Session struct {
+	config SessionConfig
+	ctxLog *log.Entry
+	onApp  func(*Message)
+	store  *fileStore
+
+	lock sync.Mutex
+
+	conn      net.Conn
+	loggedOn  bool
+	connected time.Time
+
+	lastSent     time.Time
+	lastReceived time.Time
+	testRequest  bool
+
+	// messages up to resendTill are asked by resend request, the gap isn't asked again till they come
+	resendTill int
+}
+
+func NewSession(config SessionConfig, onApp func(*Message)) (*Session, error) {
+
+	if len(config.Address) < 1 || len(config.SenderCompId) < 1 || len(config.TargetCompId) < 1 {
+		return nil, errors.New("address, SenderCompID and TargetCompID of FIX session are required")
+	}
+
+	if config.HeartBtInt < time.Second {
+		return nil, errors.New("HeartBtInt of FIX session is less than second")
+	}
+
+	if onApp == nil {
+		return nil, errors.New("onApp is nil")
+	}
+
+	store, err := newFileStore(config.StoreDir, config.SenderCompId, config.TargetCompId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctxLog := log.WithFields(log.Fields{"id": "FixSession", "session": config.SenderCompId + "->" + config.TargetCompId})
+
+	return &Session{config: config, ctxLog: ctxLog, onApp: onApp, store: store}, nil
+}
+
+// Run connects to acceptor and keeps the session, connection is made again after ReconnectInterval when it is lost
+func (s *Session) Run() {
+
+	for {
+
+		err := s.connect()
+
+		if err != nil {
+			s.ctxLog.Error("FIX session error ", err)
+		}
+
+		time.Sleep(s.config.ReconnectInterval)
+	}
+}
+
+func (s *Session) IsLoggedOn() bool {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.loggedOn
+}
+
+// waitLogon returns true as soon as session is logged on or false when it isn't logged on till the time
+func (s *Session) waitLogon(till time.Time) bool {
+
+	for {
+
+		if s.IsLoggedOn() {
+			return true
+		}
+
+		if till.Before(time.Now()) {
+			return false
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Send sends application message and returns its MsgSeqNum. Message is stored before it is written, so it is counted as
+// sent even when writing fails, acceptor asks it by resend request after reconnect.
+func (s *Session) Send(m *Message) (int, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.loggedOn {
+		return 0, errors.New("FIX session isn't logged on")
+	}
+
+	return s.send(m)
+}
+
+func (s *Session) send(m *Message) (int, error) {
+
+	seq := s.store.nextOut
+
+	m.Set(TagSenderCompId, s.config.SenderCompId)
+	m.Set(TagTargetCompId, s.config.TargetCompId)
+	m.Set(TagMsgSeqNum, strconv.Itoa(seq))
+	m.Set(TagSendingTime, Now())
+
+	raw := m.Encode()
+
+	if err := s.store.save(seq, raw); err != nil {
+		return 0, err
+	}
+
+	if err := s.store.setNextOut(seq + 1); err != nil {
+		return 0, err
+	}
+
+	s.write(raw)
+
+	return seq, nil
+}
+
+func (s *Session) write(raw []byte) {
+
+	if s.conn == nil {
+		return
+	}
+
+	s.lastSent = time.Now()
+
+	if _, err := s.conn.Write(raw); err != nil {
+		s.ctxLog.Error("Write error ", err)
+		s.disconnect()
+	}
+}
+
+func (s *Session) disconnect() {
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+
+	s.conn = nil
+	s.loggedOn = false
+}
+
+func (s *Session) connect() error {
+
+	conn, err := net.DialTimeout("tcp", s.config.Address, dialTimeout)
+
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+
+	s.conn = conn
+	s.loggedOn = false
+	s.connected = time.Now()
+	s.lastReceived = time.Now()
+	s.testRequest = false
+	s.resendTill = 0
+
+	if s.config.ResetOnLogon {
+		if err = s.store.reset(); err != nil {
+			s.disconnect()
+			s.lock.Unlock()
+			return err
+		}
+	}
+
+	logon := NewMessage(MsgTypeLogon)
+
+	logon.Add(TagEncryptMethod, "0")
+	logon.Add(TagHeartBtInt, strconv.Itoa(int(s.config.HeartBtInt/time.Second)))
+
+	if s.config.ResetOnLogon {
+		logon.Add(TagResetSeqNumFlag, yesValue)
+	}
+
+	if len(s.config.Username) > 0 {
+		logon.Add(TagUsername, s.config.Username)
+		logon.Add(TagPassword, s.config.Password)
+	}
+
+	_, err = s.send(logon)
+
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	s.ctxLog.Info("Logon is sent to ", s.config.Address)
+
+	stop := make(chan struct{})
+
+	defer close(stop)
+
+	go s.heartbeats(conn, stop)
+
+	reader := bufio.NewReader(conn)
+
+	for {
+
+		m, _, err := ReadMessage(reader)
+
+		if err != nil {
+
+			s.lock.Lock()
+
+			if s.conn == conn {
+				s.disconnect()
+			}
+
+			s.lock.Unlock()
+
+			return err
+		}
+
+		s.ctxLog.Trace("Received ", m)
+
+		for _, app := range s.handle(conn, m) {
+			s.onApp(app)
+		}
+	}
+}
+
+// heartbeats sends Heartbeat when nothing is sent for HeartBtInt and TestRequest when nothing is received for a bit longer,
+// connection is closed when acceptor keeps silence after TestRequest or doesn't answer logon
+func (s *Session) heartbeats(conn net.Conn, stop <-chan struct{}) {
+
+	interval := s.config.HeartBtInt
+
+	ticker := time.NewTicker(heartbeatCheckTime)
+
+	defer ticker.Stop()
+
+	for {
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.lock.Lock()
+
+		if s.conn != conn {
+			s.lock.Unlock()
+			return
+		}
+
+		now := time.Now()
+
+		if !s.loggedOn {
+
+			if now.Sub(s.connected) > interval {
+				s.ctxLog.Error("Logon isn't answered, disconnecting")
+				s.disconnect()
+			}
+
+		} else if now.Sub(s.lastReceived) > 2*interval {
+
+			s.ctxLog.Error("Acceptor is silent, disconnecting")
+			s.disconnect()
+
+		} else if now.Sub(s.lastReceived) > interval+interval/5 && !s.testRequest {
+
+			s.testRequest = true
+			s.sendAdmin(NewMessage(MsgTypeTestRequest).Add(TagTestReqId, strconv.FormatInt(now.UnixNano(), 10)))
+
+		} else if now.Sub(s.lastSent) >= interval {
+
+			s.sendAdmin(NewMessage(MsgTypeHeartbeat))
+		}
+
+		s.lock.Unlock()
+	}
+}
+
+func (s *Session) sendAdmin(m *Message) {
+
+	if _, err := s.send(m); err != nil {
+		s.ctxLog.Error("Send error ", err)
+		s.disconnect()
+	}
+}
+
+func (s *Session) logout(text string) {
+
+	s.ctxLog.Error("Logout ", text)
+	s.sendAdmin(NewMessage(MsgTypeLogout).Add(TagText, text))
+	s.disconnect()
+}
+
+// handle processes session level part of the message, application messages to be passed to onApp are returned
+func (s *Session) handle(conn net.Conn, m *Message) []*Message {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn != conn {
+		return nil
+	}
+
+	s.lastReceived = time.Now()
+	s.testRequest = false
+
+	if m.Get(TagSenderCompId) != s.config.TargetCompId || m.Get(TagTargetCompId) != s.config.SenderCompId {
+		s.logout("CompID problem")
+		return nil
+	}
+
+	msgType := m.Type()
+	seq := m.GetInt(TagMsgSeqNum)
+
+	if !s.loggedOn {
+
+		if msgType != MsgTypeLogon {
+			s.logout("First message isn't Logon")
+			return nil
+		}
+
+		if m.Get(TagResetSeqNumFlag) == yesValue && !s.config.ResetOnLogon {
+			s.ctxLog.Warn("Acceptor resets sequence numbers on logon")
+			s.setNextIn(1)
+		}
+
+		s.loggedOn = true
+
+		s.ctxLog.Info("Logged on, next outgoing ", s.store.nextOut, " expected incoming ", s.store.nextIn)
+	}
+
+	if msgType == MsgTypeSequenceReset && m.Get(TagGapFillFlag) != yesValue {
+		s.resetSequence(m)
+		return nil
+	}
+
+	expected := s.store.nextIn
+
+	if seq < expected {
+
+		if m.Get(TagPossDupFlag) != yesValue {
+			s.logout("MsgSeqNum too low, expecting " + strconv.Itoa(expected) + " but received " + strconv.Itoa(seq))
+		}
+
+		return nil
+	}
+
+	if seq > expected {
+
+		if seq > s.resendTill {
+			s.ctxLog.Warn("Gap of incoming messages from ", expected, " till ", seq)
+			s.resendTill = seq
+			s.sendAdmin(NewMessage(MsgTypeResendRequest).Add(TagBeginSeqNo, strconv.Itoa(expected)).
+				Add(TagEndSeqNo, "0"))
+		}
+
+		// resend request is answered at once, acceptor may wait for it before it fills the gap of ours
+		if msgType == MsgTypeResendRequest {
+			s.resend(m.GetInt(TagBeginSeqNo), m.GetInt(TagEndSeqNo))
+		}
+
+		if msgType == MsgTypeLogout {
+			s.disconnect()
+		}
+
+		return nil
+	}
+
+	switch msgType {
+
+	case MsgTypeSequenceReset:
+		s.resetSequence(m)
+		return nil
+
+	case MsgTypeTestRequest:
+		s.setNextIn(seq + 1)
+		s.sendAdmin(NewMessage(MsgTypeHeartbeat).Add(TagTestReqId, m.Get(TagTestReqId)))
+		return nil
+
+	case MsgTypeResendRequest:
+		s.setNextIn(seq + 1)
+		s.resend(m.GetInt(TagBeginSeqNo), m.GetInt(TagEndSeqNo))
+		return nil
+
+	case MsgTypeLogout:
+		s.setNextIn(seq + 1)
+		s.ctxLog.Info("Logout from acceptor ", m.Get(TagText))
+		s.sendAdmin(NewMessage(MsgTypeLogout))
+		s.disconnect()
+		return nil
+
+	case MsgTypeLogon, MsgTypeHeartbeat:
+		s.setNextIn(seq + 1)
+		return nil
+	}
+
+	s.setNextIn(seq + 1)
+
+	return []*Message{m}
+}
+
+func (s *Session) setNextIn(seq int) {
+
+	if err := s.store.setNextIn(seq); err != nil {
+		s.ctxLog.Error("Store error ", err)
+	}
+}
+
+func (s *Session) resetSequence(m *Message) {
+
+	next := m.GetInt(TagNewSeqNo)
+
+	if next < s.store.nextIn {
+		s.logout("NewSeqNo " + strconv.Itoa(next) + " is less than expected " + strconv.Itoa(s.store.nextIn))
+		return
+	}
+
+	s.setNextIn(next)
+}
+
+// resend sends stored application messages again with PossDupFlag, session messages and the ones which aren't stored
+// are replaced by SequenceReset-GapFill
+func (s *Session) resend(begin int, end int) {
+
+	last := s.store.nextOut - 1
+
+	if end == 0 || end > last {
+		end = last
+	}
+
+	s.ctxLog.Warn("Resend of messages from ", begin, " till ", end)
+
+	gap := 0
+
+	gapFill := func(next int) {
+
+		if gap == 0 {
+			return
+		}
+
+		m := NewMessage(MsgTypeSequenceReset)
+
+		m.Set(TagSenderCompId, s.config.SenderCompId)
+		m.Set(TagTargetCompId, s.config.TargetCompId)
+		m.Set(TagMsgSeqNum, strconv.Itoa(gap))
+		m.Set(TagPossDupFlag, yesValue)
+		m.Set(TagSendingTime, Now())
+		m.Add(TagGapFillFlag, yesValue)
+		m.Add(TagNewSeqNo, strconv.Itoa(next))
+
+		s.write(m.Encode())
+
+		gap = 0
+	}
+
+	for seq := begin; seq <= end && s.conn != nil; seq++ {
+
+		var m *Message
+
+		if raw := s.store.message(seq); raw != nil {
+
+			var err error
+
+			if m, err = ParseMessage(raw); err != nil {
+				s.ctxLog.Error("Stored message can't be parsed ", err)
+				m = nil
+			}
+		}
+
+		if m == nil || IsAdmin(m.Type()) {
+
+			if gap == 0 {
+				gap = seq
+			}
+
+			continue
+		}
+
+		gapFill(seq)
+
+		m.Set(TagPossDupFlag, yesValue)
+		m.Set(TagOrigSendingTime, m.Get(TagSendingTime))
+		m.Set(TagSendingTime, Now())
+
+		s.write(m.Encode())
+	}
+
+	gapFill(end + 1)
+}
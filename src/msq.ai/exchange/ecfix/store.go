@@ -0,0 +1,183 @@
+package ecfix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fileStore persists sequence numbers and sent messages of the session, so sequence survives restarts and messages
+// asked by resend request can be sent again. Sequence numbers are kept as "outgoing incoming" in .seqnums file, messages
+// are appended to .body file as "seq length" line followed by raw message and new line.
+type fileStore struct {
+	seqPath  string
+	bodyPath string
+
+	nextOut int
+	nextIn  int
+
+	messages map[int][]byte
+	body     *os.File
+}
+
+func newFileStore(dir string, senderCompId string, targetCompId string) (*fileStore, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Join(dir, senderCompId+"-"+targetCompId)
+
+	s := &fileStore{
+		seqPath:  prefix + ".seqnums",
+		bodyPath: prefix + ".body",
+		nextOut:  1,
+		nextIn:   1,
+		messages: make(map[int][]byte),
+	}
+
+	if err := s.loadSeqs(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadMessages(); err != nil {
+		return nil, err
+	}
+
+	body, err := os.OpenFile(s.bodyPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.body = body
+
+	return s, nil
+}
+
+func (s *fileStore) loadSeqs() error {
+
+	data, err := ioutil.ReadFile(s.seqPath)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Fields(string(data))
+
+	if len(parts) != 2 {
+		return fmt.Errorf("wrong sequence numbers file %s", s.seqPath)
+	}
+
+	if s.nextOut, err = strconv.Atoi(parts[0]); err != nil {
+		return err
+	}
+
+	if s.nextIn, err = strconv.Atoi(parts[1]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileStore) loadMessages() error {
+
+	file, err := os.Open(s.bodyPath)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for {
+
+		line, err := reader.ReadString('\n')
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var seq, length int
+
+		if _, err = fmt.Sscanf(line, "%d %d\n", &seq, &length); err != nil {
+			return fmt.Errorf("wrong messages file %s [%s]", s.bodyPath, err.Error())
+		}
+
+		raw := make([]byte, length+1)
+
+		if _, err = io.ReadFull(reader, raw); err != nil {
+			// message which was being written on crash is lost, it will be gap filled if asked
+			return nil
+		}
+
+		s.messages[seq] = raw[:length]
+	}
+}
+
+func (s *fileStore) saveSeqs() error {
+	return ioutil.WriteFile(s.seqPath, []byte(strconv.Itoa(s.nextOut)+" "+strconv.Itoa(s.nextIn)+"\n"), 0644)
+}
+
+func (s *fileStore) setNextOut(seq int) error {
+	s.nextOut = seq
+	return s.saveSeqs()
+}
+
+func (s *fileStore) setNextIn(seq int) error {
+	s.nextIn = seq
+	return s.saveSeqs()
+}
+
+func (s *fileStore) save(seq int, raw []byte) error {
+
+	s.messages[seq] = raw
+
+	if _, err := fmt.Fprintf(s.body, "%d %d\n", seq, len(raw)); err != nil {
+		return err
+	}
+
+	if _, err := s.body.Write(append(raw, '\n')); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileStore) message(seq int) []byte {
+	return s.messages[seq]
+}
+
+// reset starts both sequences from 1 and forgets sent messages, it is done on logon with ResetSeqNumFlag
+func (s *fileStore) reset() error {
+
+	s.messages = make(map[int][]byte)
+
+	if err := s.body.Truncate(0); err != nil {
+		return err
+	}
+
+	s.nextOut = 1
+	s.nextIn = 1
+
+	return s.saveSeqs()
+}
@@ -3,10 +3,12 @@ package ecib
 import (
 	"encoding/json"
 	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
 	log "github.com/sirupsen/logrus"
 	"msq.ai/connectors/connector"
 	"msq.ai/connectors/proto"
 	"msq.ai/constants"
+	"msq.ai/data/cmd"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -16,9 +18,59 @@ import (
 const sleepTime = time.Second * 5
 const pingTime = 5
 const timeOutTime = 15
-const K1 = 1024
+
+// ACCOUNT-INFO carries all balances and positions of the account, so replies may take hundreds of KB
+const readLimit = 1024 * 1024
+
 const cidName = "cid"
 
+const placeOrderCode = "PLACE-ORDER"
+const orderStatusRequestCode = "ORDER-STATUS-REQUEST"
+const accountInfoRequestCode = "ACCOUNT-INFO-REQUEST"
+
+const orderAckCode = "ORDER-ACK"
+const orderStatusCode = "ORDER-STATUS"
+const accountInfoCode = "ACCOUNT-INFO"
+const errorCode = "ERROR"
+
+// order statuses of IB API
+const apiPendingStatus = "ApiPending"
+const pendingSubmitStatus = "PendingSubmit"
+const preSubmittedStatus = "PreSubmitted"
+const submittedStatus = "Submitted"
+const pendingCancelStatus = "PendingCancel"
+const filledStatus = "Filled"
+const cancelledStatus = "Cancelled"
+const apiCancelledStatus = "ApiCancelled"
+const inactiveStatus = "Inactive"
+
+// orderNotFoundError is IB error "Can't find order", bridge sends it when it doesn't know the cid
+const orderNotFoundError = 135
+
+// orderRejectedError is IB error "Order rejected", order isn't placed
+const orderRejectedError = 201
+
+// IB accounts are one way, position is signed amount of the symbol
+const positionSideBoth = "BOTH"
+
+const replyTime = 15 * time.Second
+const placeReplyTime = 60 * time.Second
+
+// replies are delivered without blocking the reader, a worker which doesn't take them in time loses the surplus
+const rspBufferSize = 16
+const followPollTime = 5 * time.Second
+
+// order is placed and checked at most so many times by one request
+const maxAttempts = 4
+
+// IB reports average fill price only, so the order gets one fill without trade id
+const unknownTradeId = -1
+
+func isAlive(status string) bool {
+	return status == apiPendingStatus || status == pendingSubmitStatus || status == preSubmittedStatus ||
+		status == submittedStatus || status == pendingCancelStatus
+}
+
 type ibMarketOrder struct {
 	Code      string `json:"code"`
 	Account   string `json:"account"`
@@ -42,8 +94,86 @@ type ibLimitOrder struct {
 	Cid string `json:"cid"`
 }
 
+type ibStatusRequest struct {
+	Code string `json:"code"`
+	Oid  int64  `json:"oid,omitempty"`
+	Cid  string `json:"cid"`
+}
+
+type ibAccountRequest struct {
+	Code    string `json:"code"`
+	Account string `json:"account"`
+	Cid     string `json:"cid"`
+}
+
+type ibBalance struct {
+	Currency  string      `json:"currency"`
+	Cash      json.Number `json:"cash"`
+	Available json.Number `json:"available"`
+}
+
+type ibPosition struct {
+	Symbol        string      `json:"symbol"`
+	Position      json.Number `json:"position"`
+	AvgCost       json.Number `json:"avg_cost"`
+	UnrealizedPnl json.Number `json:"unrealized_pnl"`
+}
+
+// ibReply is any message of the bridge. Requests and replies are JSON text messages of the websocket, every request
+// carries cid which is id of the command and every reply carries cid of the request it answers. Replies of orders are
+// sent to all connected clients.
+//
+// PLACE-ORDER {account, op, symbol, qty, order_type, price, cid} is answered by ORDER-ACK {cid, oid} with order id
+// given by IB followed by ORDER-STATUS, or by ERROR. The bridge registers cid as soon as it takes PLACE-ORDER and never
+// places the same cid twice, PLACE-ORDER of known cid is answered by ORDER-ACK and ORDER-STATUS of the existing order.
+//
+// ORDER-STATUS-REQUEST {oid, cid} is answered by ORDER-STATUS {cid, oid, status, filled, remaining, avg_fill_price,
+// commission, commission_currency} of the order found by cid or by oid, or by ERROR 135 when neither is known.
+// ORDER-STATUS also comes unasked on every change of the order.
+//
+// ACCOUNT-INFO-REQUEST {account, cid} is answered by ACCOUNT-INFO {cid, account, balances, positions}.
+//
+// ERROR {cid, error_code, error_msg} carries error code of IB API, 135 is unknown order, 201 is rejected order, the
+// rest are failures of IB or the bridge.
+//
+// The bridge takes requests of one connection in order, so ERROR 135 of a status request sent after PLACE-ORDER
+// timed out means PLACE-ORDER didn't reach the bridge and the order may be placed again. Missing reply tells nothing,
+// the order may be placed and the reply lost, so it is never placed again after a timeout.
+type ibReply struct {
+	Code               string       `json:"code"`
+	Oid                int64        `json:"oid"`
+	Status             string       `json:"status"`
+	Filled             json.Number  `json:"filled"`
+	Remaining          json.Number  `json:"remaining"`
+	AvgFillPrice       json.Number  `json:"avg_fill_price"`
+	Commission         json.Number  `json:"commission"`
+	CommissionCurrency string       `json:"commission_currency"`
+	Account            string       `json:"account"`
+	Balances           []ibBalance  `json:"balances"`
+	Positions          []ibPosition `json:"positions"`
+	ErrorCode          int          `json:"error_code"`
+	ErrorMsg           string       `json:"error_msg"`
+}
+
+func (r *ibReply) String() string {
+	return r.Code + " oid [" + strconv.FormatInt(r.Oid, 10) + "] status [" + r.Status + "] filled [" + r.Filled.String() +
+		"] avg_fill_price [" + r.AvgFillPrice.String() + "] error [" + strconv.Itoa(r.ErrorCode) + " " + r.ErrorMsg + "]"
+}
+
+// toDecimal parses number of the bridge, absent number is zero
+func toDecimal(n json.Number) (decimal.Decimal, error) {
+
+	if len(n) == 0 {
+		return decimal.Zero, nil
+	}
+
+	return decimal.NewFromString(n.String())
+}
+
 type rsp struct {
+	Cid    int64
 	RawMap *map[string]interface{}
+	Reply  *ibReply
 }
 
 func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse, wsUrl string, execPoolSize int) {
@@ -57,6 +187,9 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 	var bytesChanelLock sync.Mutex
 	bytesChanel := make(chan *[]byte)
 
+	// the reader reports broken connection to the writer which reconnects, so replies aren't waited till ping fails
+	brokenChanel := make(chan *websocket.Conn, 1)
+
 	sendBytes := func(bts *[]byte) {
 		bytesChanelLock.Lock()
 		bytesChanel <- bts
@@ -72,7 +205,7 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 	channels := make(chan chan *rsp, execPoolSize)
 
 	for i := 0; i < execPoolSize; i++ {
-		channels <- make(chan *rsp)
+		channels <- make(chan *rsp, rspBufferSize)
 	}
 
 	inChannelsLock.Unlock()
@@ -84,7 +217,17 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 		return in
 	}
 
+	// returnChannel drops replies which came after the worker stopped waiting, so the next worker doesn't get them
 	returnChannel := func(c chan *rsp) {
+
+		for drained := false; !drained; {
+			select {
+			case <-c:
+			default:
+				drained = true
+			}
+		}
+
 		inChannelsLock.Lock()
 		channels <- c
 		inChannelsLock.Unlock()
@@ -113,6 +256,96 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 		return c
 	}
 
+	//------------------------------------------------------------------------------------------------------------------
+	// in-flight orders are cids of orders which workers wait for with oid given by IB, zero till it is known
+
+	var inflightLock sync.Mutex
+	inflight := make(map[int64]int64)
+
+	addInflight := func(id int64) {
+		inflightLock.Lock()
+		inflight[id] = 0
+		inflightLock.Unlock()
+	}
+
+	rmInflight := func(id int64) {
+		inflightLock.Lock()
+		delete(inflight, id)
+		inflightLock.Unlock()
+	}
+
+	setInflightOid := func(id int64, oid int64) {
+		inflightLock.Lock()
+		if _, ok := inflight[id]; ok {
+			inflight[id] = oid
+		}
+		inflightLock.Unlock()
+	}
+
+	// oids given by IB are remembered till the order is settled, the bridge finds orders by oid, cid is enough only for
+	// orders placed through the bridge since its start, e.g. after restart of the connector
+
+	var oidsLock sync.Mutex
+	oids := make(map[int64]int64)
+
+	rememberOid := func(id int64, oid int64) {
+		oidsLock.Lock()
+		oids[id] = oid
+		oidsLock.Unlock()
+	}
+
+	knownOid := func(id int64) int64 {
+		oidsLock.Lock()
+		oid := oids[id]
+		oidsLock.Unlock()
+		return oid
+	}
+
+	forgetOid := func(id int64) {
+		oidsLock.Lock()
+		delete(oids, id)
+		oidsLock.Unlock()
+	}
+
+	// reconcile asks status of every in-flight order after connection is made again, replies sent during the gap are
+	// lost and PLACE-ORDER may be lost too, the answers go to waiting workers as usual
+	reconcile := func() {
+
+		inflightLock.Lock()
+
+		requests := make([]ibStatusRequest, 0, len(inflight))
+
+		for id, oid := range inflight {
+
+			// oid of checked order isn't replied yet, it is known from the previous request of the command
+			if oid == 0 {
+				oid = knownOid(id)
+			}
+
+			requests = append(requests, ibStatusRequest{Code: orderStatusRequestCode, Oid: oid, Cid: strconv.FormatInt(id, 10)})
+		}
+
+		inflightLock.Unlock()
+
+		if len(requests) == 0 {
+			return
+		}
+
+		ctxLog.Info("Reconciling in-flight orders after reconnect ", len(requests))
+
+		for i := range requests {
+
+			bts, err := json.Marshal(requests[i])
+
+			if err != nil {
+				ctxLog.Error("Marshal error", err)
+				continue
+			}
+
+			sendBytes(&bts)
+		}
+	}
+
 	//------------------------------------------------------------------------------------------------------------------
 
 	updateLastReceiveTime := func() {
@@ -173,8 +406,7 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 				continue
 			}
 
-			c.SetReadLimit(K1)
-			c.SetReadLimit(K1)
+			c.SetReadLimit(readLimit)
 
 			updateLastReceiveTime()
 
@@ -188,6 +420,9 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 			connection = c
 			lock.Unlock()
 
+			// the writer calls createConnection, so status requests are queued from another goroutine
+			go reconcile()
+
 			return c
 		}
 	}
@@ -198,10 +433,18 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 
 		for {
 
-			tp, bytes, err := getConnection().ReadMessage()
+			con := getConnection()
+
+			tp, bytes, err := con.ReadMessage()
 
 			if err != nil {
 				ctxLog.Error("WS ReadMessage error", err)
+
+				select {
+				case brokenChanel <- con:
+				default:
+				}
+
 				time.Sleep(time.Second)
 				continue
 			}
@@ -219,20 +462,32 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 					continue
 				}
 
-				cidStr := rawMap[cidName] // TODO check !!!
+				var cidStr string
 
-				if cidStr == nil {
-					ctxLog.Error("cidStr is nil [" + string(bytes) + "]")
+				switch value := rawMap[cidName].(type) {
+				case string:
+					cidStr = value
+				case float64:
+					cidStr = strconv.FormatFloat(value, 'f', -1, 64)
+				default:
+					ctxLog.Error("cid is absent [" + string(bytes) + "]")
 					continue
 				}
 
-				cid, err := strconv.ParseInt(cidStr.(string), 10, 64)
+				cid, err := strconv.ParseInt(cidStr, 10, 64)
 
 				if err != nil {
 					ctxLog.Error("Cannot convert cidStr to int64 [" + string(bytes) + "]")
 					continue
 				}
 
+				var reply ibReply
+
+				if err := json.Unmarshal(bytes, &reply); err != nil {
+					ctxLog.Error("Reply Unmarshal error [" + string(bytes) + "] " + err.Error())
+					continue
+				}
+
 				c := getDic(cid)
 
 				if c == nil {
@@ -240,7 +495,16 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 					continue
 				}
 
-				c <- &rsp{RawMap: &rawMap}
+				if reply.Oid > 0 {
+					setInflightOid(cid, reply.Oid)
+					rememberOid(cid, reply.Oid)
+				}
+
+				select {
+				case c <- &rsp{Cid: cid, RawMap: &rawMap, Reply: &reply}:
+				default:
+					ctxLog.Error("Worker doesn't take replies, dropped [" + string(bytes) + "]")
+				}
 
 			} else {
 				ctxLog.Error("Got BinaryMessage from WS !!!")
@@ -287,6 +551,13 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 
 				}
 
+			case broken := <-brokenChanel:
+				{
+					if broken == con {
+						con = createConnection()
+					}
+				}
+
 			case m := <-bytesChanel:
 				{
 					if err := con.WriteMessage(websocket.TextMessage, *m); err != nil {
@@ -312,7 +583,7 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 
 			var market = ibMarketOrder{
 				Cid:       request.RawCmd.Id,
-				Code:      "PLACE-ORDER",
+				Code:      placeOrderCode,
 				Account:   request.RawCmd.ApiKey,
 				Op:        request.RawCmd.Direction,
 				Symbol:    request.RawCmd.Instrument,
@@ -328,7 +599,7 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 
 			var limit = ibLimitOrder{
 				Cid:       request.RawCmd.Id,
-				Code:      "PLACE-ORDER",
+				Code:      placeOrderCode,
 				Account:   request.RawCmd.ApiKey,
 				Op:        request.RawCmd.Direction,
 				Symbol:    request.RawCmd.Instrument,
@@ -349,78 +620,506 @@ func RunIbConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse
 
 	//------------------------------------------------------------------------------------------------------------------
 
-	check := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+	errorResponse := func(response *proto.ExecResponse, err error) *proto.ExecResponse {
 
-		// TODO
+		response.Status = proto.StatusError
+
+		response.Description = response.Description + " Parse error [" + err.Error() + "]"
+
+		return response
+	}
+
+	send := func(value interface{}) error {
+
+		bts, err := json.Marshal(value)
+
+		if err != nil {
+			return err
+		}
+
+		sendBytes(&bts)
 
 		return nil
 	}
 
+	// requestStatus asks status of the order by oid when it is known and by cid always, replies carry the cid
+	requestStatus := func(request *proto.ExecRequest, oid int64) error {
+
+		if oid == 0 {
+			oid = knownOid(request.Cmd.Id)
+		}
+
+		return send(ibStatusRequest{Code: orderStatusRequestCode, Oid: oid, Cid: request.RawCmd.Id})
+	}
+
+	// wait returns the next reply for the cid or nil when it doesn't come in time
+	wait := func(in chan *rsp, cid int64, timeout time.Duration) *ibReply {
+
+		timer := time.NewTimer(timeout)
+
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+				return nil
+			case result := <-in:
+
+				if result.Cid != cid {
+					ctxLog.Warn("Reply of another cid is skipped ", result.Cid, " ", result.Reply)
+					continue
+				}
+
+				ctxLog.Trace(result.Reply)
+				return result.Reply
+			}
+		}
+	}
+
+	notFilled := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+			response.Description = "Order rejected " + response.Description
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		response.Description = "Order wasn't fill " + response.Description
+		return response
+	}
+
+	progress := func(request *proto.ExecRequest, reply *ibReply) {
+
+		quantity, err := toDecimal(reply.Filled)
+
+		if err != nil {
+			ctxLog.Error("Cannot parse filled quantity ["+reply.Filled.String()+"] ", err)
+			return
+		}
+
+		var response = proto.ExecResponse{Request: request, Status: proto.StatusOpen, ExecutedQuantity: quantity}
+
+		if quantity.IsPositive() {
+			response.Status = proto.StatusPartiallyFilled
+		}
+
+		response.Description = "Order is alive " + reply.String()
+
+		request.Progress(&response)
+	}
+
+	// settle builds final response of the order, IB reports average price of all fills so they are kept as one fill
+	settle := func(request *proto.ExecRequest, response *proto.ExecResponse, reply *ibReply) *proto.ExecResponse {
+
+		forgetOid(request.Cmd.Id)
+
+		response.Description = reply.String()
+
+		if reply.Status == inactiveStatus {
+			response.Description = "Order rejected " + response.Description
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		filled, err := toDecimal(reply.Filled)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		if filled.IsZero() {
+			return notFilled(request, response)
+		}
+
+		fill := cmd.Fill{ExternalTradeId: unknownTradeId, Quantity: filled, CommissionAsset: reply.CommissionCurrency}
+
+		if fill.Price, err = toDecimal(reply.AvgFillPrice); err != nil {
+			return errorResponse(response, err)
+		}
+
+		if fill.Commission, err = toDecimal(reply.Commission); err != nil {
+			return errorResponse(response, err)
+		}
+
+		response.Order = &cmd.Order{ExternalOrderId: reply.Oid, ExecutionId: request.Cmd.Id, Fills: []cmd.Fill{fill}}
+
+		cmd.ApplyFills(response.Order)
+
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	// park builds response of alive order which isn't followed any more, acknowledge doesn't tell filled quantity
+	park := func(request *proto.ExecRequest, response *proto.ExecResponse, reply *ibReply) *proto.ExecResponse {
+
+		if reply.Code != orderStatusCode {
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown "+reply.String())
+		}
+
+		filled, err := toDecimal(reply.Filled)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		return proto.Park(response, filled, reply.String())
+	}
+
+	// follow waits for final status of the order, bridge sends changes of the order and status is asked from time to
+	// time in case some of them are lost. Nil is returned when the order isn't known by the bridge, the last reply is
+	// returned when the request may not follow the order any more.
+	follow := func(request *proto.ExecRequest, in chan *rsp, reply *ibReply) (*ibReply, error) {
+
+		reported := ""
+
+		oid := int64(0)
+
+		for {
+
+			if reply != nil && reply.Oid > 0 {
+				oid = reply.Oid
+			}
+
+			if reply != nil && reply.Code == orderStatusCode {
+
+				if !isAlive(reply.Status) {
+					return reply, nil
+				}
+
+				if current := reply.Status + "/" + reply.Filled.String(); current != reported {
+					reported = current
+					progress(request, reply)
+				}
+			}
+
+			if reply != nil && !request.KeepFollowing() {
+				return reply, nil
+			}
+
+			next := wait(in, request.Cmd.Id, followPollTime)
+
+			if next == nil {
+
+				if err := requestStatus(request, oid); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			if next.Code == errorCode {
+
+				if next.ErrorCode == orderNotFoundError {
+					return nil, nil
+				}
+
+				ctxLog.Error("Follow error ", next)
+				continue
+			}
+
+			if next.Code == orderStatusCode || next.Code == orderAckCode {
+				reply = next
+			}
+		}
+	}
+
+	// checkOrder asks status of the order and follows it, true is returned when the order doesn't exist and may be
+	// placed
+	checkOrder := func(request *proto.ExecRequest, response *proto.ExecResponse) (*proto.ExecResponse, bool) {
+
+		in := getChannel()
+
+		addDic(request.Cmd.Id, in)
+
+		addInflight(request.Cmd.Id)
+
+		release := func() {
+			rmInflight(request.Cmd.Id)
+			rmDic(request.Cmd.Id)
+			returnChannel(in)
+		}
+
+		if err := requestStatus(request, 0); err != nil {
+			release()
+			ctxLog.Error("Check error ", err)
+			response.Description = err.Error()
+			return response, false
+		}
+
+		reply := wait(in, request.Cmd.Id, replyTime)
+
+		// the order may exist, it is checked again later
+		if reply == nil {
+			release()
+			ctxLog.Warn("Didn't get order status from WS ", request.RawCmd.Id)
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown, didn't get order status"), false
+		}
+
+		if reply.Code == errorCode && reply.ErrorCode != orderNotFoundError {
+			release()
+			ctxLog.Error("Check error ", reply)
+			response.Description = reply.String()
+			return response, false
+		}
+
+		// the bridge knows neither cid nor oid, only this answer lets the order be placed
+		if reply.Code == errorCode {
+
+			release()
+
+			if request.Cmd.ExecuteTillTime.After(time.Now()) {
+				return response, true
+			}
+
+			ctxLog.Info("Check error, order not exist and will be marked timed_out ", request.RawCmd.Id)
+			response.Description = "Order with cid " + request.RawCmd.Id + " not exist"
+			response.Status = proto.StatusTimedOut
+			return response, false
+		}
+
+		final, err := follow(request, in, reply)
+
+		release()
+
+		if err != nil {
+			ctxLog.Error("Check error ", err)
+			response.Description = err.Error()
+			return response, false
+		}
+
+		if final == nil {
+			ctxLog.Error("Order isn't known by bridge after its status was sent ", request)
+			return park(request, response, reply), false
+		}
+
+		if final.Code != orderStatusCode || isAlive(final.Status) {
+			return park(request, response, final), false
+		}
+
+		return settle(request, response, final), false
+	}
+
 	//------------------------------------------------------------------------------------------------------------------
 
-	trade := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+	// placeOrder places the order and follows it, true is returned when fate of the order is unknown and it must be
+	// checked
+	placeOrder := func(request *proto.ExecRequest, response *proto.ExecResponse) (*proto.ExecResponse, bool) {
 
 		bts, err := requestToBytes(request)
 
 		if err != nil {
 			log.Error("Marshal error", err)
 			response.Description = "Marshal error [" + err.Error() + "]"
-			return response
+			return response, false
 		}
 
 		in := getChannel()
 
 		addDic(request.Cmd.Id, in)
 
-		sendBytes(bts)
+		addInflight(request.Cmd.Id)
 
-		for {
-			ticker := time.NewTicker(time.Second * 60)
+		release := func() {
+			rmInflight(request.Cmd.Id)
+			rmDic(request.Cmd.Id)
+			returnChannel(in)
+		}
 
-			var result *rsp
+		start := time.Now()
 
-			select {
+		sendBytes(bts)
 
-			case <-ticker.C:
-				{
-					ctxLog.Error("Didn't get response from WS during 60 sec!", request)
-				}
+		var reply *ibReply
 
-			case result = <-in:
-				{
-					ctxLog.Trace(result)
-				}
+		// status request of reconcile is answered by ERROR 135 when PLACE-ORDER written to the broken connection is
+		// taken by the bridge later or is lost, the answer to PLACE-ORDER is waited for anyway
+		for till := start.Add(placeReplyTime); time.Now().Before(till); {
+
+			reply = wait(in, request.Cmd.Id, time.Until(till))
+
+			if reply == nil || reply.Code != errorCode || reply.ErrorCode != orderNotFoundError {
+				break
 			}
 
-			if result == nil {
-				return check(request, response)
+			ctxLog.Warn("Order isn't known by bridge yet ", request.RawCmd.Id)
+
+			reply = nil
+		}
+
+		if reply == nil {
+			release()
+			ctxLog.Error("Didn't get response from WS during 60 sec!", request)
+			return response, true
+		}
+
+		response.OutsideExecution = time.Now().Sub(start)
+
+		if reply.Code == errorCode {
+
+			release()
+
+			response.Description = reply.String()
+
+			if reply.ErrorCode == orderRejectedError {
+				response.Status = proto.StatusRejected
+			} else {
+				ctxLog.Error("Trade error ", reply)
 			}
 
-			// TODO check is it final order status, if not
+			return response, false
+		}
+
+		final, err := follow(request, in, reply)
 
-			break // TODO
+		release()
+
+		if err != nil {
+			ctxLog.Error("Trade error ", err)
+			response.Description = err.Error()
+			return response, false
+		}
+
+		if final == nil {
+			ctxLog.Error("Order isn't known by bridge after it was placed ", request)
+			return response, true
+		}
+
+		if final.Code != orderStatusCode || isAlive(final.Status) {
+			return park(request, response, final), false
 		}
 
-		rmDic(request.Cmd.Id)
+		return settle(request, response, final), false
+	}
 
-		returnChannel(in)
+	//------------------------------------------------------------------------------------------------------------------
 
-		// TODO prepare response
+	// execute alternates placing and checking of the order till its fate is known, e.g. placed order isn't answered and
+	// is checked, checked order is definitely unknown by the bridge and is placed again. Attempts are limited, so
+	// connection which loses orders doesn't keep the worker forever, the order is parked and checked later then.
+	execute := func(request *proto.ExecRequest, response *proto.ExecResponse, place bool) *proto.ExecResponse {
 
-		response.Status = proto.StatusOk
-		response.Order = nil // TODO
+		for attempt := 1; ; attempt++ {
 
-		return response
+			again := false
+
+			if place {
+				response, again = placeOrder(request, response)
+			} else {
+				response, again = checkOrder(request, response)
+			}
+
+			if !again {
+				return response
+			}
+
+			if attempt >= maxAttempts {
+				ctxLog.Error("Order fate is unknown after ", attempt, " attempts ", request)
+				return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown after "+
+					strconv.Itoa(attempt)+" attempts")
+			}
+
+			place = !place
+		}
+	}
+
+	trade := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+		return execute(request, response, true)
+	}
+
+	check := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+		return execute(request, response, false)
 	}
 
 	//------------------------------------------------------------------------------------------------------------------
 
 	info := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
 
-		// TODO
+		if request.RawCmd.OrderType != constants.OrderTypeInfoName {
+			response.Description = "Order type " + request.RawCmd.OrderType + " isn't supported by IB"
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		in := getChannel()
 
-		return nil
+		addDic(request.Cmd.Id, in)
 
+		defer func() {
+			rmDic(request.Cmd.Id)
+			returnChannel(in)
+		}()
+
+		err := send(ibAccountRequest{Code: accountInfoRequestCode, Account: request.RawCmd.ApiKey, Cid: request.RawCmd.Id})
+
+		if err != nil {
+			ctxLog.Error("Info error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		reply := wait(in, request.Cmd.Id, replyTime)
+
+		if reply == nil {
+			response.Description = "Didn't get account info from WS"
+			return response
+		}
+
+		if reply.Code != accountInfoCode {
+			ctxLog.Error("Info error ", reply)
+			response.Description = reply.String()
+			return response
+		}
+
+		response.Balances = make([]cmd.Balance, 0)
+
+		for _, b := range reply.Balances {
+
+			cash, err := toDecimal(b.Cash)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			free, err := toDecimal(b.Available)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			locked := cash.Sub(free)
+
+			if locked.IsNegative() {
+				locked = decimal.Zero
+			}
+
+			response.Balances = append(response.Balances, cmd.Balance{Asset: b.Currency, Free: free, Locked: locked})
+		}
+
+		response.Positions = make([]cmd.Position, 0)
+
+		for _, p := range reply.Positions {
+
+			position := cmd.Position{Symbol: p.Symbol, PositionSide: positionSideBoth}
+
+			if position.Amount, err = toDecimal(p.Position); err != nil {
+				return errorResponse(response, err)
+			}
+
+			if position.EntryPrice, err = toDecimal(p.AvgCost); err != nil {
+				return errorResponse(response, err)
+			}
+
+			if position.UnrealizedProfit, err = toDecimal(p.UnrealizedPnl); err != nil {
+				return errorResponse(response, err)
+			}
+
+			response.Positions = append(response.Positions, position)
+		}
+
+		response.Status = proto.StatusOk
+
+		return response
 	}
 
 	//------------------------------------------------------------------------------------------------------------------
@@ -0,0 +1,270 @@
+package ecib
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/ecib/mock"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTimeout = 30 * time.Second
+
+type testConnector struct {
+	server  *mock.Server
+	in      chan *proto.ExecRequest
+	out     chan *proto.ExecResponse
+	account string
+	refused int32
+}
+
+// startConnector runs connector against the bridge simulator which replies after delay, symbols cost 150 USD.
+// Connections are refused while refused is set.
+func startConnector(t *testing.T, symbols []string, delay time.Duration) *testConnector {
+
+	list := make([]spot.Symbol, 0, len(symbols))
+
+	for _, name := range symbols {
+		list = append(list, spot.Symbol{Name: name, Base: name, Quote: "USD", Price: decimal.NewFromInt(150)})
+	}
+
+	c := &testConnector{
+		server: mock.NewServer(list, map[string]decimal.Decimal{"USD": decimal.NewFromInt(1000000)},
+			spot.Outcome{Status: spot.OutcomeFilled}, delay),
+		in:      make(chan *proto.ExecRequest),
+		out:     make(chan *proto.ExecResponse, 100),
+		account: "DU" + strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+
+	handler := c.server.Handler()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if atomic.LoadInt32(&c.refused) == 1 {
+			http.Error(w, "bridge is down", http.StatusServiceUnavailable)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+
+	t.Cleanup(httpServer.Close)
+
+	RunIbConnector(c.in, c.out, "ws"+strings.TrimPrefix(httpServer.URL, "http")+"/", 2)
+
+	return c
+}
+
+// request wraps buy of the test account as coordinator does, price makes it a limit order
+func (c *testConnector) request(what proto.ExecType, id int64, symbol string, qty string, price string,
+	followFor time.Duration) *proto.ExecRequest {
+
+	now := time.Now()
+
+	raw := &cmd.RawCommand{Id: strconv.FormatInt(id, 10), Instrument: symbol, Direction: constants.OrderDirectionBuyName,
+		OrderType: constants.OrderTypeMarketName, Amount: qty, ApiKey: c.account}
+
+	if len(price) > 0 {
+		raw.OrderType = constants.OrderTypeLimitName
+		raw.LimitPrice = price
+		raw.TimeInForce = constants.TimeInForceGtcName
+	}
+
+	if what == proto.InfoCmd {
+		raw.OrderType = constants.OrderTypeInfoName
+	}
+
+	command := &cmd.Command{Id: id, InstrumentName: symbol, ExecuteTillTime: now.Add(time.Minute), ApiKey: c.account}
+
+	command.Amount, _ = decimal.NewFromString(qty)
+
+	return &proto.ExecRequest{What: what, RawCmd: raw, Cmd: command, FollowTill: now.Add(followFor)}
+}
+
+// execute sends the request and waits for its last response, progress responses are skipped
+func (c *testConnector) execute(t *testing.T, r *proto.ExecRequest) *proto.ExecResponse {
+
+	t.Helper()
+
+	c.in <- r
+
+	deadline := time.After(testTimeout)
+
+	for {
+		select {
+		case <-deadline:
+			t.Fatal("No response of command ", r.RawCmd.Id)
+			return nil
+		case response := <-c.out:
+			if response.Request == r && (proto.IsFinal(response.Status) || response.Parked) {
+				return response
+			}
+		}
+	}
+}
+
+// positions returns amounts of positions of the test account by symbols
+func (c *testConnector) positions(t *testing.T, id int64) map[string]string {
+
+	t.Helper()
+
+	response := c.execute(t, c.request(proto.InfoCmd, id, "", "", "", time.Minute))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Info error ", response.Description)
+	}
+
+	result := make(map[string]string)
+
+	for _, p := range response.Positions {
+		result[p.Symbol] = p.Amount.String()
+	}
+
+	return result
+}
+
+func TestInfoOfAccountWithManyPositions(t *testing.T) {
+
+	symbols := make([]string, 15)
+
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("STOCK%02d", i)
+	}
+
+	c := startConnector(t, symbols, 0)
+
+	for i, symbol := range symbols {
+
+		response := c.execute(t, c.request(proto.ExecuteCmd, int64(i+1), symbol, "10", "", time.Minute))
+
+		if response.Status != proto.StatusOk {
+			t.Fatal("Position of ", symbol, " isn't opened ", response.Description)
+		}
+	}
+
+	// the reply of so many positions is a few KB
+	positions := c.positions(t, 100)
+
+	if len(positions) != len(symbols) {
+		t.Fatal("Wrong positions ", positions)
+	}
+
+	for _, symbol := range symbols {
+		if positions[symbol] != "10" {
+			t.Error("Wrong position of ", symbol, " ", positions[symbol])
+		}
+	}
+}
+
+func TestParkedOrderIsCheckedByOidAfterBridgeRestart(t *testing.T) {
+
+	c := startConnector(t, []string{"AAPL"}, 0)
+
+	// the order is filled after the first status poll of follow
+	c.server.Push(spot.Outcome{Status: spot.OutcomeNew, FillAfter: followPollTime + 3*time.Second})
+
+	response := c.execute(t, c.request(proto.ExecuteCmd, 1, "AAPL", "20", "150", time.Second))
+
+	if response.Status != proto.StatusOpen || !response.Parked {
+		t.Fatal("Resting order isn't parked ", response.Status, " ", response.Description)
+	}
+
+	// restarted bridge doesn't know cid of the order, IB finds it by oid
+	c.server.Restart()
+
+	response = c.execute(t, c.request(proto.CheckCmd, 1, "AAPL", "20", "150", time.Minute))
+
+	if response.Status != proto.StatusOk || response.Order.ExternalOrderId == 0 {
+		t.Fatal("Order isn't found by oid ", response.Status, " ", response.Description)
+	}
+
+	// order unknown by cid would be placed again by check
+	if positions := c.positions(t, 2); positions["AAPL"] != "20" {
+		t.Fatal("Order is placed twice ", positions)
+	}
+}
+
+func TestLostReplyDoesNotPlaceOrderTwice(t *testing.T) {
+
+	// slow bridge answers status request of reconcile a while after reconnect
+	c := startConnector(t, []string{"AAPL"}, 200*time.Millisecond)
+
+	// the order is filled, but connections are closed instead of replies
+	c.server.Push(spot.Outcome{Status: spot.OutcomeLost})
+
+	response := c.execute(t, c.request(proto.ExecuteCmd, 1, "AAPL", "10", "", time.Minute))
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.NewFromInt(10)) {
+		t.Fatal("Order of lost reply isn't settled ", response.Status, " ", response.Description)
+	}
+
+	if positions := c.positions(t, 2); positions["AAPL"] != "10" {
+		t.Fatal("Order is placed twice ", positions)
+	}
+}
+
+func TestUnknownOrderIsPlacedByCheck(t *testing.T) {
+
+	c := startConnector(t, []string{"AAPL"}, 0)
+
+	// ERROR 135 is the only answer which lets check place the order
+	response := c.execute(t, c.request(proto.CheckCmd, 1, "AAPL", "10", "", time.Minute))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Unknown order isn't placed by check ", response.Status, " ", response.Description)
+	}
+
+	if positions := c.positions(t, 2); positions["AAPL"] != "10" {
+		t.Fatal("Wrong position ", positions)
+	}
+}
+
+func TestCheckWithoutReplyParksOrder(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("waits for reply timeout")
+	}
+
+	// the bridge answers status request after the connector stops waiting for it
+	c := startConnector(t, []string{"AAPL"}, replyTime+time.Second)
+
+	response := c.execute(t, c.request(proto.CheckCmd, 1, "AAPL", "10", "", time.Minute))
+
+	if response.Status != proto.StatusOpen || !response.Parked {
+		t.Fatal("Order without status isn't parked ", response.Status, " ", response.Description)
+	}
+}
+
+func TestOrderFilledWhileBridgeIsDownIsReconciled(t *testing.T) {
+
+	c := startConnector(t, []string{"AAPL"}, 0)
+
+	c.server.Push(spot.Outcome{Status: spot.OutcomePartiallyFilled, FillAfter: 2 * time.Second})
+
+	// the bridge goes down while the order is followed, the fill is sent to nobody
+	time.AfterFunc(time.Second, func() {
+		atomic.StoreInt32(&c.refused, 1)
+		c.server.Disconnect()
+	})
+
+	time.AfterFunc(3*time.Second, func() { atomic.StoreInt32(&c.refused, 0) })
+
+	response := c.execute(t, c.request(proto.ExecuteCmd, 1, "AAPL", "20", "150", time.Minute))
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.NewFromInt(20)) {
+		t.Fatal("Order filled while bridge is down isn't settled ", response.Status, " ", response.Description)
+	}
+
+	if positions := c.positions(t, 2); positions["AAPL"] != "20" {
+		t.Fatal("Wrong position ", positions)
+	}
+}
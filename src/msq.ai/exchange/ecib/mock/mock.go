@@ -0,0 +1,689 @@
+package mock
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const wsPath = "/"
+const outcomesPath = "/mock/outcomes"
+const disconnectPath = "/mock/disconnect"
+
+const wsWriteTime = time.Second
+
+const placeOrderCode = "PLACE-ORDER"
+const orderStatusRequestCode = "ORDER-STATUS-REQUEST"
+const cancelOrderRequestCode = "CANCEL-ORDER-REQUEST"
+const accountInfoRequestCode = "ACCOUNT-INFO-REQUEST"
+
+const orderAckCode = "ORDER-ACK"
+const orderStatusCode = "ORDER-STATUS"
+const accountInfoCode = "ACCOUNT-INFO"
+const errorCode = "ERROR"
+
+const submittedStatus = "Submitted"
+const filledStatus = "Filled"
+const cancelledStatus = "Cancelled"
+
+const buyOp = "BUY"
+const sellOp = "SELL"
+const marketType = "MKT"
+const limitType = "LMT"
+
+// error codes of IB API
+const rateLimitError = 100
+const notCancellableError = 161
+const orderNotFoundError = 135
+const noSecurityError = 200
+const orderRejectedError = 201
+const notConnectedError = 504
+
+// the first order id given by the mock, IB gives ids per client from the next valid id
+const firstOid = 1000
+
+// all amounts are formatted with this precision
+const precision = 8
+
+// fixed pricing of IB stocks, per share with minimum per order
+var commissionPerShare = decimal.New(5, -3)
+var commissionMinimum = decimal.New(1, 0)
+
+type order struct {
+	cid       string
+	oid       int64
+	account   string
+	symbol    *spot.Symbol
+	op        string
+	orderType string
+	price     decimal.Decimal
+	qty       decimal.Decimal
+	filled    decimal.Decimal
+	cost      decimal.Decimal
+
+	commission decimal.Decimal
+	status     string
+}
+
+func (o *order) isAlive() bool {
+	return o.status == submittedStatus
+}
+
+type position struct {
+	amount decimal.Decimal
+	cost   decimal.Decimal
+}
+
+type account struct {
+	cash      map[string]decimal.Decimal
+	positions map[string]*position
+}
+
+// request is any request of the connector to the bridge, numbers may come as strings or numbers
+type request struct {
+	Code      string      `json:"code"`
+	Cid       string      `json:"cid"`
+	Oid       json.Number `json:"oid"`
+	Account   string      `json:"account"`
+	Op        string      `json:"op"`
+	Symbol    string      `json:"symbol"`
+	Qty       json.Number `json:"qty"`
+	OrderType string      `json:"order_type"`
+	Price     json.Number `json:"price"`
+}
+
+// Server imitates websocket bridge to IB which is etc/IB/ib.js talks to. Orders and accounts live as long as the process,
+// they are shared by all connections, replies of orders are sent to all connected clients as the bridge does. What
+// happens with placed orders is scripted with outcomes of Binance spot mock: EXPIRED and CANCELED orders are cancelled
+// by IB, REJECTED gets IB error 201, ERROR gets error 504 and RATE_LIMIT error 100. LOST places and fills the order,
+// but all connections are closed instead of replies. Every reply may be delayed and connections can be closed on
+// request to test reconnects.
+type Server struct {
+	lock sync.Mutex
+
+	symbols         map[string]*spot.Symbol
+	initialBalances map[string]decimal.Decimal
+
+	defaultOutcome spot.Outcome
+	outcomes       []spot.Outcome
+
+	delay time.Duration
+
+	accounts map[string]*account
+	orders   map[string]*order
+	oids     map[int64]*order
+
+	nextOid int64
+
+	connections map[*websocket.Conn]bool
+	upgrader    websocket.Upgrader
+
+	ctxLog *log.Entry
+}
+
+func NewServer(symbols []spot.Symbol, balances map[string]decimal.Decimal, defaultOutcome spot.Outcome,
+	delay time.Duration) *Server {
+
+	s := &Server{
+		symbols:         make(map[string]*spot.Symbol),
+		initialBalances: balances,
+		defaultOutcome:  defaultOutcome,
+		outcomes:        make([]spot.Outcome, 0),
+		delay:           delay,
+		accounts:        make(map[string]*account),
+		orders:          make(map[string]*order),
+		oids:            make(map[int64]*order),
+		nextOid:         firstOid,
+		connections:     make(map[*websocket.Conn]bool),
+		ctxLog:          log.WithFields(log.Fields{"id": "IbMock"}),
+	}
+
+	for i := range symbols {
+		s.symbols[symbols[i].Name] = &symbols[i]
+	}
+
+	return s
+}
+
+// Push appends outcomes to the script, they are taken by placed orders one by one, default outcome is used after
+func (s *Server) Push(outcomes ...spot.Outcome) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.outcomes = append(s.outcomes, outcomes...)
+}
+
+// Disconnect closes all connections as if the bridge restarted
+func (s *Server) Disconnect() {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.disconnect()
+}
+
+// Restart closes all connections and forgets cids of orders as restarted bridge does, IB keeps the orders, so they are
+// found by oid only
+func (s *Server) Restart() {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.orders = make(map[string]*order)
+
+	s.disconnect()
+}
+
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(wsPath, s.handleWs)
+	mux.HandleFunc(outcomesPath, s.handleOutcomes)
+	mux.HandleFunc(disconnectPath, s.handleDisconnect)
+
+	return mux
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func format(val decimal.Decimal) json.Number {
+	return json.Number(val.StringFixed(precision))
+}
+
+func toDecimal(n json.Number) (decimal.Decimal, error) {
+	return decimal.NewFromString(n.String())
+}
+
+func (s *Server) nextOutcome() spot.Outcome {
+
+	if len(s.outcomes) == 0 {
+		return s.defaultOutcome
+	}
+
+	outcome := s.outcomes[0]
+
+	s.outcomes = s.outcomes[1:]
+
+	return outcome
+}
+
+func (s *Server) getAccount(name string) *account {
+
+	a := s.accounts[name]
+
+	if a == nil {
+
+		a = &account{cash: make(map[string]decimal.Decimal), positions: make(map[string]*position)}
+
+		for currency, amount := range s.initialBalances {
+			a.cash[currency] = amount
+		}
+
+		s.accounts[name] = a
+	}
+
+	return a
+}
+
+// send writes reply to all connections, must be called under lock
+func (s *Server) send(reply interface{}) {
+
+	for connection := range s.connections {
+
+		_ = connection.SetWriteDeadline(time.Now().Add(wsWriteTime))
+
+		if err := connection.WriteJSON(reply); err != nil {
+			s.ctxLog.Error("Write error ", err)
+			delete(s.connections, connection)
+			_ = connection.Close()
+		}
+	}
+}
+
+func (s *Server) disconnect() {
+
+	for connection := range s.connections {
+		_ = connection.Close()
+	}
+
+	s.connections = make(map[*websocket.Conn]bool)
+}
+
+func (s *Server) sendError(cid string, code int, msg string) {
+	s.send(map[string]interface{}{"code": errorCode, "cid": cid, "error_code": code, "error_msg": msg})
+}
+
+func (s *Server) sendStatus(o *order) {
+
+	avgFillPrice := decimal.Zero
+
+	if o.filled.IsPositive() {
+		avgFillPrice = o.cost.Div(o.filled)
+	}
+
+	remaining := o.qty.Sub(o.filled)
+
+	if !o.isAlive() {
+		remaining = decimal.Zero
+	}
+
+	s.send(map[string]interface{}{
+		"code":                orderStatusCode,
+		"cid":                 o.cid,
+		"oid":                 o.oid,
+		"status":              o.status,
+		"filled":              format(o.filled),
+		"remaining":           format(remaining),
+		"avg_fill_price":      format(avgFillPrice),
+		"commission":          format(o.commission),
+		"commission_currency": o.symbol.Quote,
+	})
+}
+
+// fill executes quantity of the order, market orders are executed at price of the symbol
+func (s *Server) fill(o *order, qty decimal.Decimal) {
+
+	price := o.price
+
+	if o.orderType == marketType {
+		price = o.symbol.Price
+	}
+
+	cost := qty.Mul(price)
+
+	commission := qty.Mul(commissionPerShare)
+
+	if o.commission.IsZero() && commission.LessThan(commissionMinimum) {
+		commission = commissionMinimum
+	}
+
+	o.filled = o.filled.Add(qty)
+	o.cost = o.cost.Add(cost)
+	o.commission = o.commission.Add(commission)
+
+	if o.filled.Equal(o.qty) {
+		o.status = filledStatus
+	}
+
+	a := s.getAccount(o.account)
+
+	p := a.positions[o.symbol.Name]
+
+	if p == nil {
+		p = &position{}
+		a.positions[o.symbol.Name] = p
+	}
+
+	// position keeps signed amount and cost, short positions have both negative
+	signed := qty
+
+	if o.op == buyOp {
+		a.cash[o.symbol.Quote] = a.cash[o.symbol.Quote].Sub(cost).Sub(commission)
+	} else {
+		a.cash[o.symbol.Quote] = a.cash[o.symbol.Quote].Add(cost).Sub(commission)
+		signed = qty.Neg()
+	}
+
+	if p.amount.Sign()*signed.Sign() >= 0 {
+		p.cost = p.cost.Add(signed.Mul(price))
+	} else if signed.Abs().LessThanOrEqual(p.amount.Abs()) {
+		p.cost = p.cost.Add(p.cost.Div(p.amount).Mul(signed))
+	} else {
+		p.cost = p.amount.Add(signed).Mul(price)
+	}
+
+	p.amount = p.amount.Add(signed)
+}
+
+// fillLater fills the rest of resting order after the pause, status is sent to whoever is connected at that time
+func (s *Server) fillLater(o *order, after time.Duration) {
+
+	time.AfterFunc(after, func() {
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if o.isAlive() {
+			s.fill(o, o.qty.Sub(o.filled))
+			s.sendStatus(o)
+		}
+	})
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func (s *Server) handleWs(w http.ResponseWriter, r *http.Request) {
+
+	connection, err := s.upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		s.ctxLog.Error("Upgrade error ", err)
+		return
+	}
+
+	s.lock.Lock()
+	s.connections[connection] = true
+	s.lock.Unlock()
+
+	s.ctxLog.Info("Client is connected ", r.RemoteAddr)
+
+	for {
+
+		_, bytes, err := connection.ReadMessage()
+
+		if err != nil {
+			break
+		}
+
+		s.ctxLog.Trace("recv: ", string(bytes))
+
+		var req request
+
+		if err := json.Unmarshal(bytes, &req); err != nil {
+			s.ctxLog.Error("Unmarshal error [" + string(bytes) + "]")
+			continue
+		}
+
+		if s.delay > 0 {
+			time.Sleep(s.delay)
+		}
+
+		s.lock.Lock()
+
+		switch req.Code {
+		case placeOrderCode:
+			s.placeOrder(&req)
+		case orderStatusRequestCode:
+			s.orderStatus(&req)
+		case cancelOrderRequestCode:
+			s.cancelOrder(&req)
+		case accountInfoRequestCode:
+			s.accountInfo(&req)
+		default:
+			s.sendError(req.Cid, 0, "Unknown code "+req.Code)
+		}
+
+		s.lock.Unlock()
+	}
+
+	s.lock.Lock()
+	delete(s.connections, connection)
+	s.lock.Unlock()
+
+	_ = connection.Close()
+}
+
+// findOrder looks for the order by cid and then by oid
+func (s *Server) findOrder(req *request) *order {
+
+	if o := s.orders[req.Cid]; o != nil && len(req.Cid) > 0 {
+		return o
+	}
+
+	if oid, err := req.Oid.Int64(); err == nil {
+		return s.oids[oid]
+	}
+
+	return nil
+}
+
+func (s *Server) placeOrder(req *request) {
+
+	// cid is client order reference at IB, the same order is never placed twice
+	if o := s.orders[req.Cid]; o != nil {
+		s.ctxLog.Info("Order is placed already ", req.Cid)
+		s.send(map[string]interface{}{"code": orderAckCode, "cid": o.cid, "oid": o.oid})
+		s.sendStatus(o)
+		return
+	}
+
+	symbol := s.symbols[req.Symbol]
+
+	if symbol == nil {
+		s.sendError(req.Cid, noSecurityError, "No security definition has been found for the request")
+		return
+	}
+
+	qty, err := toDecimal(req.Qty)
+
+	if err != nil || !qty.IsPositive() {
+		s.sendError(req.Cid, orderRejectedError, "Order rejected - reason: wrong quantity "+req.Qty.String())
+		return
+	}
+
+	o := &order{cid: req.Cid, account: req.Account, symbol: symbol, op: req.Op, orderType: req.OrderType, qty: qty,
+		status: submittedStatus}
+
+	if o.op != buyOp && o.op != sellOp {
+		s.sendError(req.Cid, orderRejectedError, "Order rejected - reason: wrong action "+o.op)
+		return
+	}
+
+	if o.orderType == limitType {
+
+		o.price, err = toDecimal(req.Price)
+
+		if err != nil || !o.price.IsPositive() {
+			s.sendError(req.Cid, orderRejectedError, "Order rejected - reason: wrong price "+req.Price.String())
+			return
+		}
+
+	} else if o.orderType != marketType {
+		s.sendError(req.Cid, orderRejectedError, "Order rejected - reason: unsupported order type "+o.orderType)
+		return
+	}
+
+	outcome := s.nextOutcome()
+
+	s.ctxLog.Info("Order ", req.Cid, " gets outcome ", outcome.String())
+
+	switch outcome.Status {
+	case spot.OutcomeRejected:
+		s.sendError(req.Cid, orderRejectedError, "Order rejected - reason: insufficient buying power")
+		return
+	case spot.OutcomeError:
+		s.sendError(req.Cid, notConnectedError, "Not connected")
+		return
+	case spot.OutcomeRateLimit:
+		s.sendError(req.Cid, rateLimitError, "Max rate of messages per second has been exceeded")
+		return
+	}
+
+	o.oid = s.nextOid
+	s.nextOid++
+
+	s.orders[o.cid] = o
+	s.oids[o.oid] = o
+
+	if outcome.Status == spot.OutcomeLost {
+		s.fill(o, o.qty)
+		s.disconnect()
+		return
+	}
+
+	s.send(map[string]interface{}{"code": orderAckCode, "cid": o.cid, "oid": o.oid})
+	s.sendStatus(o)
+
+	switch outcome.Status {
+
+	case spot.OutcomeFilled:
+		s.fill(o, o.qty)
+		s.sendStatus(o)
+
+	case spot.OutcomeExpired, spot.OutcomeCanceled:
+		o.status = cancelledStatus
+		s.sendStatus(o)
+
+	case spot.OutcomePartiallyFilled, spot.OutcomeNew:
+
+		if outcome.Status == spot.OutcomePartiallyFilled {
+			s.fill(o, o.qty.Div(decimal.New(2, 0)).Round(precision))
+			s.sendStatus(o)
+		}
+
+		if outcome.FillAfter > 0 {
+			s.fillLater(o, outcome.FillAfter)
+		}
+	}
+}
+
+func (s *Server) orderStatus(req *request) {
+
+	o := s.findOrder(req)
+
+	if o == nil {
+		s.sendError(req.Cid, orderNotFoundError, "Can't find order")
+		return
+	}
+
+	if len(req.Cid) > 0 && req.Cid != o.cid {
+		s.sendError(req.Cid, orderNotFoundError, "Can't find order, oid belongs to another cid")
+		return
+	}
+
+	s.sendStatus(o)
+}
+
+func (s *Server) cancelOrder(req *request) {
+
+	o := s.findOrder(req)
+
+	if o == nil {
+		s.sendError(req.Cid, orderNotFoundError, "Can't find order")
+		return
+	}
+
+	if !o.isAlive() {
+		s.sendError(o.cid, notCancellableError, "Cancel attempted when order is not in a cancellable state")
+		return
+	}
+
+	o.status = cancelledStatus
+
+	s.sendStatus(o)
+}
+
+func (s *Server) accountInfo(req *request) {
+
+	a := s.getAccount(req.Account)
+
+	// buying power is held by resting buy orders
+	held := make(map[string]decimal.Decimal)
+
+	for _, o := range s.oids {
+		if o.account == req.Account && o.isAlive() && o.op == buyOp && o.orderType == limitType {
+			held[o.symbol.Quote] = held[o.symbol.Quote].Add(o.qty.Sub(o.filled).Mul(o.price))
+		}
+	}
+
+	currencies := make([]string, 0, len(a.cash))
+
+	for currency := range a.cash {
+		currencies = append(currencies, currency)
+	}
+
+	sort.Strings(currencies)
+
+	balances := make([]map[string]interface{}, 0, len(currencies))
+
+	for _, currency := range currencies {
+		balances = append(balances, map[string]interface{}{
+			"currency":  currency,
+			"cash":      format(a.cash[currency]),
+			"available": format(a.cash[currency].Sub(held[currency])),
+		})
+	}
+
+	names := make([]string, 0, len(a.positions))
+
+	for name := range a.positions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	positions := make([]map[string]interface{}, 0, len(names))
+
+	for _, name := range names {
+
+		p := a.positions[name]
+
+		if p.amount.IsZero() {
+			continue
+		}
+
+		avgCost := p.cost.Div(p.amount)
+
+		positions = append(positions, map[string]interface{}{
+			"symbol":         name,
+			"position":       format(p.amount),
+			"avg_cost":       format(avgCost),
+			"unrealized_pnl": format(s.symbols[name].Price.Sub(avgCost).Mul(p.amount)),
+		})
+	}
+
+	s.send(map[string]interface{}{"code": accountInfoCode, "cid": req.Cid, "account": req.Account, "balances": balances,
+		"positions": positions})
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func (s *Server) handleOutcomes(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost {
+
+		body, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		outcomes, err := spot.ParseOutcomes(string(body))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Push(outcomes...)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]string, len(s.outcomes))
+
+	for i, o := range s.outcomes {
+		list[i] = o.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"default": s.defaultOutcome.String(), "outcomes": list}); err != nil {
+		s.ctxLog.Error("Encode error ", err)
+	}
+}
+
+func (s *Server) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.ctxLog.Warn("Disconnect is requested")
+
+	s.Disconnect()
+
+	w.WriteHeader(http.StatusOK)
+}
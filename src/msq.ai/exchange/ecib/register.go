@@ -0,0 +1,28 @@
+package ecib
+
+import (
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/proto"
+	"msq.ai/connectors/registry"
+	"time"
+)
+
+const propertiesWsUrlName = "ws.url"
+
+func init() {
+	registry.Register(registry.Exchange{Name: "IB", ExecPoolSize: 20, Run: run})
+}
+
+func run(settings registry.Settings, in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse,
+	execPoolSize int) func() time.Duration {
+
+	wsUrl := settings.MustGet(propertiesWsUrlName)
+
+	if len(wsUrl) < 1 {
+		log.Fatal("[" + propertiesWsUrlName + "] is wrong ! = [" + wsUrl + "]")
+	}
+
+	RunIbConnector(in, out, wsUrl, execPoolSize)
+
+	return nil
+}
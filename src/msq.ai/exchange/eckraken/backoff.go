@@ -0,0 +1,41 @@
+package eckraken
+
+import (
+	"sync"
+	"time"
+)
+
+// BackOff remembers rate limit errors of Kraken, counters of Kraken decay with time, so new commands are paused till
+// back off time passes
+type BackOff struct {
+	lock  sync.Mutex
+	pause time.Duration
+	until time.Time
+}
+
+func NewBackOff(pause time.Duration) *BackOff {
+	return &BackOff{pause: pause}
+}
+
+func (b *BackOff) hit() {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.until = time.Now().Add(b.pause)
+}
+
+// PauseTime returns time left till the end of back off, zero when requests may be sent
+func (b *BackOff) PauseTime() time.Duration {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	left := b.until.Sub(time.Now())
+
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
@@ -0,0 +1,318 @@
+package eckraken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiKeyHeader = "API-Key"
+const apiSignHeader = "API-Sign"
+
+const assetPairsPath = "/0/public/AssetPairs"
+const addOrderPath = "/0/private/AddOrder"
+const queryOrdersPath = "/0/private/QueryOrders"
+const openOrdersPath = "/0/private/OpenOrders"
+const closedOrdersPath = "/0/private/ClosedOrders"
+const queryTradesPath = "/0/private/QueryTrades"
+const balanceExPath = "/0/private/BalanceEx"
+
+const invalidNonceError = "EAPI:Invalid nonce"
+const unknownPairError = "EQuery:Unknown asset pair"
+
+var rateLimitErrors = []string{"EAPI:Rate limit exceeded", "EOrder:Rate limit exceeded", "EGeneral:Too many requests"}
+
+// request with invalid nonce isn't processed by Kraken, it happens when nonce window of the key is too small
+const nonceAttempts = 3
+
+const dialTimeout = 5 * time.Second
+const keepAliveTime = 30 * time.Second
+const idleConnTimeout = 90 * time.Second
+const tlsHandshakeTimeout = 5 * time.Second
+const responseHeaderTimeout = 10 * time.Second
+const requestTimeout = 30 * time.Second
+
+// APIError keeps errors which Kraken returns in error array of the response
+type APIError struct {
+	Errors []string
+}
+
+func (e *APIError) Error() string {
+	return "Kraken error [" + strings.Join(e.Errors, ", ") + "]"
+}
+
+func hasError(err error, codes ...string) bool {
+
+	apiErr, ok := err.(*APIError)
+
+	if !ok {
+		return false
+	}
+
+	for _, e := range apiErr.Errors {
+		for _, code := range codes {
+			if strings.HasPrefix(e, code) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func IsRateLimitError(err error) bool {
+	return hasError(err, rateLimitErrors...)
+}
+
+type response struct {
+	Error  []string        `json:"error"`
+	Result json.RawMessage `json:"result"`
+}
+
+// nonces are microseconds of local time which are forced to grow, one sequence is shared by all API keys
+type nonces struct {
+	lock sync.Mutex
+	last int64
+}
+
+func (n *nonces) next() int64 {
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	nonce := time.Now().UnixNano() / int64(time.Microsecond)
+
+	if nonce <= n.last {
+		nonce = n.last + 1
+	}
+
+	n.last = nonce
+
+	return nonce
+}
+
+// keyLocks serializes private calls of every API key, Kraken rejects nonce which isn't greater than previous one of
+// the key, so calls sent in parallel could overtake each other
+type keyLocks struct {
+	lock  sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (k *keyLocks) get(apiKey string) *sync.Mutex {
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	lock, ok := k.locks[apiKey]
+
+	if !ok {
+		lock = &sync.Mutex{}
+		k.locks[apiKey] = lock
+	}
+
+	return lock
+}
+
+// sign is HMAC-SHA512 of URI path and SHA256 of nonce with POST data, keyed by base64 decoded secret
+func sign(path string, nonce string, body string, secret []byte) string {
+
+	sha := sha256.Sum256([]byte(nonce + body))
+
+	mac := hmac.New(sha512.New, secret)
+
+	mac.Write([]byte(path))
+	mac.Write(sha[:])
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type client struct {
+	apiUrl     string
+	httpClient *http.Client
+	nonces     *nonces
+	locks      *keyLocks
+}
+
+func newClient(apiUrl string, maxIdleConns int) *client {
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: keepAliveTime,
+		}).DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+	}
+
+	return &client{
+		apiUrl:     apiUrl,
+		httpClient: &http.Client{Transport: transport, Timeout: requestTimeout},
+		nonces:     &nonces{},
+		locks:      &keyLocks{locks: make(map[string]*sync.Mutex)},
+	}
+}
+
+func (c *client) do(request *http.Request, result interface{}) error {
+
+	resp, err := c.httpClient.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	_ = resp.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kraken HTTP status %d [%s]", resp.StatusCode, string(body))
+	}
+
+	var r response
+
+	if err = json.Unmarshal(body, &r); err != nil {
+		return err
+	}
+
+	if len(r.Error) > 0 {
+		return &APIError{Errors: r.Error}
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(r.Result, result)
+}
+
+func (c *client) public(path string, values url.Values, result interface{}) error {
+
+	request, err := http.NewRequest(http.MethodGet, c.apiUrl+path+"?"+values.Encode(), nil)
+
+	if err != nil {
+		return err
+	}
+
+	return c.do(request, result)
+}
+
+// private signs and posts values with a new nonce, call is repeated when Kraken says nonce is invalid
+func (c *client) private(apiKey string, secretKey string, path string, values url.Values, result interface{}) error {
+
+	secret, err := base64.StdEncoding.DecodeString(secretKey)
+
+	if err != nil {
+		return fmt.Errorf("secret key isn't base64 [%s]", err.Error())
+	}
+
+	lock := c.locks.get(apiKey)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for attempt := 1; ; attempt++ {
+
+		nonce := strconv.FormatInt(c.nonces.next(), 10)
+
+		values.Set("nonce", nonce)
+
+		body := values.Encode()
+
+		request, err := http.NewRequest(http.MethodPost, c.apiUrl+path, strings.NewReader(body))
+
+		if err != nil {
+			return err
+		}
+
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set(apiKeyHeader, apiKey)
+		request.Header.Set(apiSignHeader, sign(path, nonce, body, secret))
+
+		err = c.do(request, result)
+
+		if !hasError(err, invalidNonceError) || attempt == nonceAttempts {
+			return err
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+type addOrderResult struct {
+	Descr struct {
+		Order string `json:"order"`
+	} `json:"descr"`
+	Txid []string `json:"txid"`
+}
+
+type orderInfo struct {
+	Userref int64   `json:"userref"`
+	ClOrdId string  `json:"cl_ord_id"`
+	Status  string  `json:"status"`
+	Opentm  float64 `json:"opentm"`
+	Closetm float64 `json:"closetm"`
+	Descr   struct {
+		Pair      string `json:"pair"`
+		Type      string `json:"type"`
+		Ordertype string `json:"ordertype"`
+		Price     string `json:"price"`
+	} `json:"descr"`
+	Vol     string   `json:"vol"`
+	VolExec string   `json:"vol_exec"`
+	Cost    string   `json:"cost"`
+	Fee     string   `json:"fee"`
+	Price   string   `json:"price"`
+	Oflags  string   `json:"oflags"`
+	Reason  string   `json:"reason"`
+	Trades  []string `json:"trades"`
+}
+
+type openOrdersResult struct {
+	Open map[string]*orderInfo `json:"open"`
+}
+
+type closedOrdersResult struct {
+	Closed map[string]*orderInfo `json:"closed"`
+	Count  int                   `json:"count"`
+}
+
+type tradeInfo struct {
+	Ordertxid string  `json:"ordertxid"`
+	Pair      string  `json:"pair"`
+	Time      float64 `json:"time"`
+	Type      string  `json:"type"`
+	Price     string  `json:"price"`
+	Cost      string  `json:"cost"`
+	Fee       string  `json:"fee"`
+	Vol       string  `json:"vol"`
+}
+
+type assetPair struct {
+	Altname string `json:"altname"`
+	Base    string `json:"base"`
+	Quote   string `json:"quote"`
+}
+
+type balanceInfo struct {
+	Balance   string `json:"balance"`
+	HoldTrade string `json:"hold_trade"`
+}
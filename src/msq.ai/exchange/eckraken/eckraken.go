@@ -0,0 +1,641 @@
+package eckraken
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/connector"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pendingValue = "pending"
+const openValue = "open"
+const closedValue = "closed"
+const canceledValue = "canceled"
+const expiredValue = "expired"
+
+const buyValue = "buy"
+const sellValue = "sell"
+const marketValue = "market"
+const limitValue = "limit"
+const gtcValue = "GTC"
+
+// fee is taken in base currency when order has this flag, in quote currency otherwise
+const feeInBaseFlag = "fcib"
+
+// cl_ord_id of Kraken is free text up to 18 characters, id of command in base 36 fits it
+const clOrdIdPrefix = "msq"
+
+const followPollTime = 2 * time.Second
+
+const tradesAttempts = 3
+
+// QueryTrades accepts no more than 20 trade ids at once
+const tradesPageLimit = 20
+
+// Kraken doesn't number trades, ids of trades are kept as external trade refs
+const unknownTradeId = -1
+
+// clOrdId carries full id of the command, so orders of different commands never match each other
+func clOrdId(id int64) string {
+	return clOrdIdPrefix + strconv.FormatInt(id, 36)
+}
+
+func isAlive(status string) bool {
+	return status == pendingValue || status == openValue
+}
+
+// ofCommand returns txid of the order placed for the command, orders with other cl_ord_id are never accepted
+func ofCommand(orders map[string]*orderInfo, id string) string {
+
+	for txid, order := range orders {
+		if order.ClOrdId == id {
+			return txid
+		}
+	}
+
+	return ""
+}
+
+func toFills(trades []string, infos map[string]*tradeInfo, commissionAsset string) ([]cmd.Fill, error) {
+
+	var err error
+
+	result := make([]cmd.Fill, len(trades))
+
+	for i, id := range trades {
+
+		t := infos[id]
+
+		if t == nil {
+			return nil, fmt.Errorf("trade %s isn't returned by Kraken", id)
+		}
+
+		result[i].ExternalTradeId = unknownTradeId
+		result[i].ExternalTradeRef = id
+		result[i].CommissionAsset = commissionAsset
+
+		result[i].Quantity, err = decimal.NewFromString(t.Vol)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i].Price, err = decimal.NewFromString(t.Price)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result[i].Commission, err = decimal.NewFromString(t.Fee)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Config keeps settings of Kraken connector
+type Config struct {
+	ApiUrl string
+}
+
+func RunKrakenConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse, execPoolSize int, config Config,
+	backOff *BackOff) {
+
+	ctxLog := log.WithFields(log.Fields{"id": "KrakenConnector"})
+
+	if len(config.ApiUrl) < 1 {
+		ctxLog.Fatal("ApiUrl is empty !")
+	}
+
+	if backOff == nil {
+		ctxLog.Fatal("backOff is nil !")
+	}
+
+	kraken := newClient(config.ApiUrl, execPoolSize)
+
+	pairs := newPairsCache()
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	errorResponse := func(response *proto.ExecResponse, err error) *proto.ExecResponse {
+
+		response.Status = proto.StatusError
+
+		response.Description = response.Description + " Parse error [" + err.Error() + "]"
+
+		return response
+	}
+
+	// private calls Kraken with keys of the request, rate limit errors start back off of new commands
+	private := func(request *proto.ExecRequest, path string, values url.Values, result interface{}) error {
+
+		err := kraken.private(request.RawCmd.ApiKey, request.RawCmd.SecretKey, path, values, result)
+
+		if IsRateLimitError(err) {
+			backOff.hit()
+		}
+
+		return err
+	}
+
+	queryOrder := func(request *proto.ExecRequest, txid string) (*orderInfo, error) {
+
+		result := make(map[string]*orderInfo)
+
+		err := private(request, queryOrdersPath, url.Values{"txid": {txid}, "trades": {"true"}}, &result)
+
+		if err != nil {
+			return nil, err
+		}
+
+		order := result[txid]
+
+		if order == nil {
+			return nil, fmt.Errorf("order %s isn't returned by Kraken", txid)
+		}
+
+		return order, nil
+	}
+
+	// findOrder looks for order with cl_ord_id of the command among open orders and then among recently closed ones
+	findOrder := func(request *proto.ExecRequest) (string, *orderInfo, error) {
+
+		id := clOrdId(request.Cmd.Id)
+
+		values := url.Values{"cl_ord_id": {id}, "trades": {"true"}}
+
+		var open openOrdersResult
+
+		err := private(request, openOrdersPath, values, &open)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		if txid := ofCommand(open.Open, id); len(txid) > 0 {
+			return txid, open.Open[txid], nil
+		}
+
+		var closed closedOrdersResult
+
+		err = private(request, closedOrdersPath, values, &closed)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		if txid := ofCommand(closed.Closed, id); len(txid) > 0 {
+			return txid, closed.Closed[txid], nil
+		}
+
+		return "", nil, nil
+	}
+
+	notFilled := func(request *proto.ExecRequest, response *proto.ExecResponse, status string) *proto.ExecResponse {
+
+		if request.RawCmd.OrderType == constants.OrderTypeLimitName && (status == expiredValue || status == canceledValue) {
+			response.Description = "Order rejected"
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		response.Description = "Order wasn't fill"
+		return response
+	}
+
+	progress := func(request *proto.ExecRequest, order *orderInfo) {
+
+		quantity, err := decimal.NewFromString(order.VolExec)
+
+		if err != nil {
+			ctxLog.Error("Cannot parse executed quantity ["+order.VolExec+"] ", err)
+			return
+		}
+
+		var response = proto.ExecResponse{Request: request, Status: proto.StatusOpen, ExecutedQuantity: quantity}
+
+		if quantity.IsPositive() {
+			response.Status = proto.StatusPartiallyFilled
+		}
+
+		response.Description = "Order is " + order.Status + " executed [" + order.VolExec + "]"
+
+		request.Progress(&response)
+	}
+
+	// follow polls the order till it gets final status, every change of status or executed quantity is reported.
+	// Returns the last known state of the order when the request may not follow it any more, nil if it is unknown.
+	follow := func(request *proto.ExecRequest, txid string, order *orderInfo) *orderInfo {
+
+		status := ""
+		executed := ""
+
+		for polled := false; ; polled = true {
+
+			if order != nil {
+
+				if !isAlive(order.Status) {
+					return order
+				}
+
+				if order.Status != status || order.VolExec != executed {
+
+					status = order.Status
+					executed = order.VolExec
+
+					progress(request, order)
+				}
+			}
+
+			// state of new order is queried at once, even when the request may not follow it any more
+			if polled || order != nil {
+
+				if !request.KeepFollowing() {
+					return order
+				}
+
+				time.Sleep(followPollTime)
+			}
+
+			current, err := queryOrder(request, txid)
+
+			if err != nil {
+				ctxLog.Error("Follow error ", err)
+			} else {
+				order = current
+			}
+		}
+	}
+
+	// park builds response of alive order which isn't followed any more
+	park := func(request *proto.ExecRequest, response *proto.ExecResponse, order *orderInfo) *proto.ExecResponse {
+
+		if order == nil {
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown")
+		}
+
+		quantity, err := decimal.NewFromString(order.VolExec)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		return proto.Park(response, quantity, "status ["+order.Status+"] executed ["+order.VolExec+"]")
+	}
+
+	// orderTrades loads trades which order lists, Kraken keeps their ids in the order
+	orderTrades := func(request *proto.ExecRequest, order *orderInfo) (map[string]*tradeInfo, decimal.Decimal, error) {
+
+		var quantity = decimal.Zero
+
+		result := make(map[string]*tradeInfo)
+
+		for from := 0; from < len(order.Trades); from += tradesPageLimit {
+
+			to := from + tradesPageLimit
+
+			if to > len(order.Trades) {
+				to = len(order.Trades)
+			}
+
+			page := make(map[string]*tradeInfo)
+
+			err := private(request, queryTradesPath, url.Values{"txid": {strings.Join(order.Trades[from:to], ",")}}, &page)
+
+			if err != nil {
+				return nil, decimal.Zero, err
+			}
+
+			for id, t := range page {
+
+				q, err := decimal.NewFromString(t.Vol)
+
+				if err != nil {
+					return nil, decimal.Zero, err
+				}
+
+				quantity = quantity.Add(q)
+
+				result[id] = t
+			}
+		}
+
+		return result, quantity, nil
+	}
+
+	// settle builds final response of existing order, fills are taken from trades of the order
+	settle := func(request *proto.ExecRequest, response *proto.ExecResponse, pair *assetPair, txid string,
+		order *orderInfo) *proto.ExecResponse {
+
+		response.Description = fmt.Sprintf("%s %+v", txid, *order)
+
+		executed, err := decimal.NewFromString(order.VolExec)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		if order.Status != closedValue && executed.IsZero() {
+			return notFilled(request, response, order.Status)
+		}
+
+		response.Order = &cmd.Order{ExternalOrderId: order.Userref, ExternalOrderRef: txid, ExecutionId: request.Cmd.Id}
+
+		commissionAsset := pair.Quote
+
+		if strings.Contains(order.Oflags, feeInBaseFlag) {
+			commissionAsset = pair.Base
+		}
+
+		for attempt := 1; response.Order.Fills == nil; attempt++ {
+
+			trades, quantity, err := orderTrades(request, order)
+
+			if err != nil {
+				ctxLog.Error("Trades error ", err)
+				response.Description = err.Error()
+				return response
+			}
+
+			if quantity.Equal(executed) && len(trades) == len(order.Trades) {
+
+				response.Order.Fills, err = toFills(order.Trades, trades, commissionAsset)
+
+				if err != nil {
+					return errorResponse(response, err)
+				}
+
+				break
+			}
+
+			if attempt == tradesAttempts {
+				response.Description = "Trades of order don't match executed quantity [" + order.VolExec + "] " +
+					response.Description
+				return response
+			}
+
+			ctxLog.Warn("Trades of order don't match executed quantity yet ", txid)
+
+			time.Sleep(followPollTime)
+
+			if refreshed, err := queryOrder(request, txid); err == nil {
+				order = refreshed
+			}
+		}
+
+		cmd.ApplyFills(response.Order)
+
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	// pairOf returns asset pair of the command, nil response means pair is known and command can go on
+	// finish follows the order while the request allows and then parks it if it is still alive or settles it
+	finish := func(request *proto.ExecRequest, response *proto.ExecResponse, pair *assetPair, txid string,
+		order *orderInfo) *proto.ExecResponse {
+
+		order = follow(request, txid, order)
+
+		if order == nil || isAlive(order.Status) {
+			return park(request, response, order)
+		}
+
+		return settle(request, response, pair, txid, order)
+	}
+
+	// findLost looks for the order which answer is lost by its cl_ord_id, unknown order is parked, so it is checked
+	// again later and traded again or timed out then
+	findLost := func(request *proto.ExecRequest, response *proto.ExecResponse, pair *assetPair,
+		cause error) *proto.ExecResponse {
+
+		txid, order, err := findOrder(request)
+
+		if err != nil {
+			ctxLog.Error("Find order error ", err)
+		}
+
+		if order == nil {
+			ctxLog.Warn("Order of lost answer isn't found ", request.RawCmd.Id)
+			return proto.Park(response, request.Cmd.ExecutedQuantity, "state is unknown after ["+cause.Error()+"]")
+		}
+
+		ctxLog.Info("Order of lost answer is found ", txid)
+
+		return finish(request, response, pair, txid, order)
+	}
+
+	pairOf := func(request *proto.ExecRequest, response *proto.ExecResponse) (*assetPair, *proto.ExecResponse) {
+
+		pair, err := pairs.get(kraken, request.RawCmd.Instrument)
+
+		if err != nil {
+			ctxLog.Error("Asset pairs error ", err)
+			response.Description = err.Error()
+			return nil, response
+		}
+
+		if pair == nil {
+			response.Description = "Unknown asset pair " + request.RawCmd.Instrument
+			response.Status = proto.StatusRejected
+			return nil, response
+		}
+
+		return pair, nil
+	}
+
+	trade := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		pair, failed := pairOf(request, response)
+
+		if failed != nil {
+			return failed
+		}
+
+		values := url.Values{}
+
+		values.Set("pair", request.RawCmd.Instrument)
+		values.Set("volume", request.RawCmd.Amount)
+		values.Set("cl_ord_id", clOrdId(request.Cmd.Id))
+
+		if request.RawCmd.OrderType == constants.OrderTypeMarketName {
+			values.Set("ordertype", marketValue)
+		} else if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+			values.Set("ordertype", limitValue)
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong OrderType ! ", request)
+			return nil
+		}
+
+		if request.RawCmd.Direction == constants.OrderDirectionBuyName {
+			values.Set("type", buyValue)
+		} else if request.RawCmd.Direction == constants.OrderDirectionSellName {
+			values.Set("type", sellValue)
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong Direction with empty cmd ! ", request)
+			return nil
+		}
+
+		if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+
+			values.Set("price", request.RawCmd.LimitPrice)
+
+			if request.RawCmd.TimeInForce == constants.TimeInForceGtcName {
+				values.Set("timeinforce", gtcValue)
+			} else if request.RawCmd.TimeInForce == constants.TimeInForceFokName {
+				response.Description = "Time in force FOK isn't supported by Kraken"
+				response.Status = proto.StatusRejected
+				return response
+			} else {
+				msg := "Protocol violation! ExecRequest has wrong TimeInForce."
+				ctxLog.Error(msg, request)
+				response.Description = msg
+				return response
+			}
+		}
+
+		var added addOrderResult
+		var err error
+
+		// rate limited requests are repeated, order isn't created by Kraken when it answers with rate limit error
+		for {
+
+			start := time.Now()
+
+			err = private(request, addOrderPath, values, &added)
+
+			response.OutsideExecution = time.Now().Sub(start)
+
+			if !IsRateLimitError(err) {
+				break
+			}
+
+			ctxLog.Warn("Trade is rate limited, will be repeated after back off ", err)
+
+			time.Sleep(backOff.PauseTime())
+
+			if request.Cmd.ExecuteTillTime.Before(time.Now()) {
+				response.Description = "TimedOut while repeating rejected trade " + err.Error()
+				response.Status = proto.StatusTimedOut
+				return response
+			}
+		}
+
+		// order may be placed when the answer is lost, Kraken rejects it only with errors of the answer
+		if _, answered := err.(*APIError); err != nil && !answered {
+			ctxLog.Error("Trade answer is lost ", err)
+			return findLost(request, response, pair, err)
+		}
+
+		if err != nil {
+			ctxLog.Error("Trade error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		if len(added.Txid) == 0 {
+			response.Description = "Order without txid " + added.Descr.Order
+			return response
+		}
+
+		txid := added.Txid[0]
+
+		ctxLog.Trace("Order from Kraken ", txid, " ", added.Descr.Order)
+
+		return finish(request, response, pair, txid, nil)
+	}
+
+	check := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		pair, failed := pairOf(request, response)
+
+		if failed != nil {
+			return failed
+		}
+
+		txid, order, err := findOrder(request)
+
+		if err != nil {
+			ctxLog.Error("Check error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		if order == nil {
+
+			if request.Cmd.ExecuteTillTime.After(time.Now()) {
+				return trade(request, response)
+			}
+
+			ctxLog.Info("Check error, order not exist and will be marked timed_out ", request.RawCmd.Id)
+			response.Description = "Order with cl_ord_id " + clOrdId(request.Cmd.Id) + " not exist"
+			response.Status = proto.StatusTimedOut
+			return response
+		}
+
+		ctxLog.Trace("Order from Kraken ", txid, " ", order)
+
+		return finish(request, response, pair, txid, order)
+	}
+
+	info := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		if request.RawCmd.OrderType != constants.OrderTypeInfoName {
+			response.Description = "Order type " + request.RawCmd.OrderType + " isn't supported by Kraken"
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		balances := make(map[string]*balanceInfo)
+
+		err := private(request, balanceExPath, url.Values{}, &balances)
+
+		if err != nil {
+			ctxLog.Error("Info error ", err)
+			response.Description = err.Error()
+			return response
+		}
+
+		response.Balances = make([]cmd.Balance, 0)
+
+		for asset, b := range balances {
+
+			total, err := decimal.NewFromString(b.Balance)
+
+			if err != nil {
+				return errorResponse(response, err)
+			}
+
+			locked := decimal.Zero
+
+			if len(b.HoldTrade) > 0 {
+
+				locked, err = decimal.NewFromString(b.HoldTrade)
+
+				if err != nil {
+					return errorResponse(response, err)
+				}
+			}
+
+			if !total.IsZero() || !locked.IsZero() {
+				response.Balances = append(response.Balances, cmd.Balance{Asset: asset, Free: total.Sub(locked), Locked: locked})
+			}
+		}
+
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	connector.RunConnector(ctxLog, in, out, execPoolSize, trade, check, info)
+}
@@ -0,0 +1,230 @@
+package eckraken
+
+import (
+	"bytes"
+	"github.com/shopspring/decimal"
+	"io/ioutil"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"msq.ai/exchange/eckraken/mock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTimeout = 20 * time.Second
+
+const testSecret = "c2VjcmV0" // base64 of "secret"
+
+type testConnector struct {
+	server *mock.Server
+	in     chan *proto.ExecRequest
+	out    chan *proto.ExecResponse
+	apiKey string
+
+	lock    sync.Mutex
+	nonces  []int64
+	dropped bool
+}
+
+// startConnector runs connector against mock Kraken with XBTUSD at 30000, nonces of private calls are recorded in
+// order of their arrival and AddOrder is dropped unanswered while dropped is set
+func startConnector(t *testing.T, execPoolSize int) *testConnector {
+
+	c := &testConnector{
+		server: mock.NewServer(
+			[]spot.Symbol{{Name: "XBTUSD", Base: "XXBT", Quote: "ZUSD", Price: decimal.NewFromInt(30000)}},
+			map[string]decimal.Decimal{"ZUSD": decimal.NewFromInt(1000000)},
+			spot.Outcome{Status: spot.OutcomeFilled}),
+		in:     make(chan *proto.ExecRequest),
+		out:    make(chan *proto.ExecResponse, 100),
+		apiKey: t.Name(),
+	}
+
+	handler := c.server.Handler()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if strings.HasPrefix(r.URL.Path, "/0/private/") {
+
+			body, _ := ioutil.ReadAll(r.Body)
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			values, _ := url.ParseQuery(string(body))
+
+			nonce, _ := strconv.ParseInt(values.Get("nonce"), 10, 64)
+
+			c.lock.Lock()
+			c.nonces = append(c.nonces, nonce)
+			dropped := c.dropped && r.URL.Path == addOrderPath
+			c.lock.Unlock()
+
+			if dropped {
+				if connection, _, err := w.(http.Hijacker).Hijack(); err == nil {
+					_ = connection.Close()
+					return
+				}
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+
+	t.Cleanup(httpServer.Close)
+
+	RunKrakenConnector(c.in, c.out, execPoolSize, Config{ApiUrl: httpServer.URL}, NewBackOff(time.Second))
+
+	return c
+}
+
+func (c *testConnector) setDropped(dropped bool) {
+	c.lock.Lock()
+	c.dropped = dropped
+	c.lock.Unlock()
+}
+
+// request wraps market buy of the test account as coordinator does
+func (c *testConnector) request(what proto.ExecType, id int64, executeFor time.Duration) *proto.ExecRequest {
+
+	now := time.Now()
+
+	raw := &cmd.RawCommand{Id: strconv.FormatInt(id, 10), Instrument: "XBTUSD",
+		Direction: constants.OrderDirectionBuyName, OrderType: constants.OrderTypeMarketName, Amount: "0.01",
+		ApiKey: c.apiKey, SecretKey: testSecret}
+
+	command := &cmd.Command{Id: id, InstrumentName: raw.Instrument, ExecuteTillTime: now.Add(executeFor),
+		ApiKey: raw.ApiKey, SecretKey: raw.SecretKey}
+
+	command.Amount, _ = decimal.NewFromString(raw.Amount)
+
+	return &proto.ExecRequest{What: what, RawCmd: raw, Cmd: command, FollowTill: now.Add(time.Minute)}
+}
+
+// await returns the last response of every request, progress responses are skipped
+func (c *testConnector) await(t *testing.T, requests ...*proto.ExecRequest) map[*proto.ExecRequest]*proto.ExecResponse {
+
+	t.Helper()
+
+	responses := make(map[*proto.ExecRequest]*proto.ExecResponse)
+
+	deadline := time.After(testTimeout)
+
+	for len(responses) < len(requests) {
+		select {
+		case <-deadline:
+			t.Fatal("Got ", len(responses), " responses of ", len(requests), " requests")
+		case response := <-c.out:
+			if proto.IsFinal(response.Status) || response.Parked {
+				responses[response.Request] = response
+			}
+		}
+	}
+
+	return responses
+}
+
+func (c *testConnector) execute(t *testing.T, r *proto.ExecRequest) *proto.ExecResponse {
+
+	t.Helper()
+
+	c.in <- r
+
+	return c.await(t, r)[r]
+}
+
+func TestNoncesOfParallelCommandsGrow(t *testing.T) {
+
+	c := startConnector(t, 4)
+
+	requests := make([]*proto.ExecRequest, 12)
+
+	for i := range requests {
+		requests[i] = c.request(proto.ExecuteCmd, int64(i+1), time.Minute)
+	}
+
+	go func() {
+		for _, r := range requests {
+			c.in <- r
+		}
+	}()
+
+	for r, response := range c.await(t, requests...) {
+		if response.Status != proto.StatusOk {
+			t.Error("Command ", r.RawCmd.Id, " isn't executed ", response.Status, " ", response.Description)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// a repeated invalid nonce would be hidden by the client, so Kraken must never see a nonce out of order
+	for i := 1; i < len(c.nonces); i++ {
+		if c.nonces[i] <= c.nonces[i-1] {
+			t.Fatal("Nonce ", c.nonces[i], " arrived after ", c.nonces[i-1])
+		}
+	}
+}
+
+func TestLostAnswerIsRecoveredByClOrdId(t *testing.T) {
+
+	c := startConnector(t, 1)
+
+	// the order is filled, but connection is closed instead of the answer
+	c.server.Push(spot.Outcome{Status: spot.OutcomeLost})
+
+	response := c.execute(t, c.request(proto.ExecuteCmd, 1, time.Minute))
+
+	if response.Status != proto.StatusOk || !response.Order.ExecutedQuantity.Equal(decimal.RequireFromString("0.01")) {
+		t.Fatal("Order of lost answer isn't settled ", response.Status, " ", response.Description)
+	}
+}
+
+func TestUnknownOrderOfLostAnswerIsParkedAndChecked(t *testing.T) {
+
+	c := startConnector(t, 1)
+
+	c.setDropped(true)
+
+	response := c.execute(t, c.request(proto.ExecuteCmd, 1, time.Minute))
+
+	if response.Status != proto.StatusOpen || !response.Parked {
+		t.Fatal("Unknown order isn't parked ", response.Status, " ", response.Description)
+	}
+
+	c.setDropped(false)
+
+	// the order never reached Kraken, parked check places it while the command has time
+	response = c.execute(t, c.request(proto.CheckCmd, 1, time.Minute))
+
+	if response.Status != proto.StatusOk {
+		t.Fatal("Unknown order isn't traded by check ", response.Status, " ", response.Description)
+	}
+
+	txid := response.Order.ExternalOrderRef
+
+	// and the order isn't placed again by the next check
+	response = c.execute(t, c.request(proto.CheckCmd, 1, time.Minute))
+
+	if response.Status != proto.StatusOk || response.Order.ExternalOrderRef != txid {
+		t.Fatal("Order of command is placed twice ", response.Status, " ", response.Order)
+	}
+}
+
+func TestUnknownOrderIsTimedOutByCheck(t *testing.T) {
+
+	c := startConnector(t, 1)
+
+	response := c.execute(t, c.request(proto.CheckCmd, 1, -time.Second))
+
+	if response.Status != proto.StatusTimedOut {
+		t.Fatal("Unknown order isn't timed out ", response.Status, " ", response.Description)
+	}
+}
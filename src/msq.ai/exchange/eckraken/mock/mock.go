@@ -0,0 +1,760 @@
+package mock
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	spot "msq.ai/exchange/ecbinance/mock"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiKeyHeader = "API-Key"
+const apiSignHeader = "API-Sign"
+
+const timePath = "/0/public/Time"
+const assetPairsPath = "/0/public/AssetPairs"
+const addOrderPath = "/0/private/AddOrder"
+const cancelOrderPath = "/0/private/CancelOrder"
+const queryOrdersPath = "/0/private/QueryOrders"
+const openOrdersPath = "/0/private/OpenOrders"
+const closedOrdersPath = "/0/private/ClosedOrders"
+const queryTradesPath = "/0/private/QueryTrades"
+const balanceExPath = "/0/private/BalanceEx"
+const outcomesPath = "/mock/outcomes"
+
+const pendingStatus = "pending"
+const openStatus = "open"
+const closedStatus = "closed"
+const canceledStatus = "canceled"
+const expiredStatus = "expired"
+
+const buyType = "buy"
+const sellType = "sell"
+const marketType = "market"
+const limitType = "limit"
+
+const feeInBaseFlag = "fcib"
+
+// ClosedOrders returns 50 orders per page, the mock has no paging and returns the last ones only
+const closedOrdersLimit = 50
+
+// all amounts are formatted with precision of Kraken
+const precision = 8
+
+// taker fee of the lowest volume tier
+var commissionRate = decimal.New(26, -4)
+
+type order struct {
+	txid      string
+	pair      *spot.Symbol
+	clOrdId   string
+	side      string
+	orderType string
+	price     decimal.Decimal
+	volume    decimal.Decimal
+	executed  decimal.Decimal
+	cost      decimal.Decimal
+	fee       decimal.Decimal
+	oflags    string
+	status    string
+	reason    string
+	opentm    float64
+	closetm   float64
+	trades    []string
+}
+
+func (o *order) isAlive() bool {
+	return o.status == pendingStatus || o.status == openStatus
+}
+
+type trade struct {
+	txid  string
+	order *order
+	price decimal.Decimal
+	vol   decimal.Decimal
+	cost  decimal.Decimal
+	fee   decimal.Decimal
+	time  float64
+}
+
+type account struct {
+	nonce    int64
+	balances map[string]decimal.Decimal
+	orders   map[string]*order
+	trades   map[string]*trade
+}
+
+// Server imitates Kraken spot REST API for one process, every API key gets its own account with initial balances.
+// Pairs are named by their altname, e.g. XBTUSD, and by the canonical name made of base and quote, e.g. XXBTZUSD.
+// What happens with added orders is scripted with outcomes of Binance spot mock.
+type Server struct {
+	lock sync.Mutex
+
+	pairs           map[string]*spot.Symbol
+	initialBalances map[string]decimal.Decimal
+
+	defaultOutcome spot.Outcome
+	outcomes       []spot.Outcome
+
+	accounts map[string]*account
+
+	nextOrderId int64
+	nextTradeId int64
+
+	ctxLog *log.Entry
+}
+
+func NewServer(pairs []spot.Symbol, balances map[string]decimal.Decimal, defaultOutcome spot.Outcome) *Server {
+
+	s := &Server{
+		pairs:           make(map[string]*spot.Symbol),
+		initialBalances: balances,
+		defaultOutcome:  defaultOutcome,
+		outcomes:        make([]spot.Outcome, 0),
+		accounts:        make(map[string]*account),
+		nextOrderId:     1,
+		nextTradeId:     1,
+		ctxLog:          log.WithFields(log.Fields{"id": "KrakenMock"}),
+	}
+
+	for i := range pairs {
+		s.pairs[pairs[i].Name] = &pairs[i]
+		s.pairs[pairs[i].Base+pairs[i].Quote] = &pairs[i]
+	}
+
+	return s
+}
+
+// Push appends outcomes to the script, they are taken by added orders one by one, default outcome is used after
+func (s *Server) Push(outcomes ...spot.Outcome) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.outcomes = append(s.outcomes, outcomes...)
+}
+
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(timePath, s.handleTime)
+	mux.HandleFunc(assetPairsPath, s.handleAssetPairs)
+	mux.HandleFunc(addOrderPath, s.private(s.addOrder))
+	mux.HandleFunc(cancelOrderPath, s.private(s.cancelOrder))
+	mux.HandleFunc(queryOrdersPath, s.private(s.queryOrders))
+	mux.HandleFunc(openOrdersPath, s.private(s.openOrders))
+	mux.HandleFunc(closedOrdersPath, s.private(s.closedOrders))
+	mux.HandleFunc(queryTradesPath, s.private(s.queryTrades))
+	mux.HandleFunc(balanceExPath, s.private(s.balanceEx))
+	mux.HandleFunc(outcomesPath, s.handleOutcomes)
+
+	return mux
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func now() float64 {
+	return float64(time.Now().UnixNano()/int64(time.Microsecond)) / 1e6
+}
+
+func format(val decimal.Decimal) string {
+	return val.StringFixed(precision)
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// result writes successful response, Kraken answers with HTTP 200 and empty error array
+func result(w http.ResponseWriter, value interface{}) {
+	writeJson(w, map[string]interface{}{"error": []string{}, "result": value})
+}
+
+// apiError writes failed response, Kraken answers with HTTP 200 and error array
+func apiError(w http.ResponseWriter, msg string) {
+	writeJson(w, map[string]interface{}{"error": []string{msg}})
+}
+
+// getAccount must be called under lock
+func (s *Server) getAccount(apiKey string) *account {
+
+	a := s.accounts[apiKey]
+
+	if a == nil {
+
+		a = &account{
+			balances: make(map[string]decimal.Decimal),
+			orders:   make(map[string]*order),
+			trades:   make(map[string]*trade),
+		}
+
+		for asset, free := range s.initialBalances {
+			a.balances[asset] = free
+		}
+
+		s.accounts[apiKey] = a
+	}
+
+	return a
+}
+
+// private checks API key, signature presence and nonce of the request, which must grow for every API key, and calls
+// handler under lock. Signature itself isn't verified, the mock doesn't know secrets.
+func (s *Server) private(handler func(w http.ResponseWriter, a *account, p url.Values)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost {
+			apiError(w, "EGeneral:Invalid arguments")
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			apiError(w, "EGeneral:Invalid arguments")
+			return
+		}
+
+		p, err := url.ParseQuery(string(body))
+
+		if err != nil {
+			apiError(w, "EGeneral:Invalid arguments")
+			return
+		}
+
+		apiKey := r.Header.Get(apiKeyHeader)
+
+		if len(apiKey) == 0 {
+			apiError(w, "EAPI:Invalid key")
+			return
+		}
+
+		signature := r.Header.Get(apiSignHeader)
+
+		if _, err := base64.StdEncoding.DecodeString(signature); err != nil || len(signature) == 0 {
+			apiError(w, "EAPI:Invalid signature")
+			return
+		}
+
+		nonce, err := strconv.ParseInt(p.Get("nonce"), 10, 64)
+
+		if err != nil {
+			apiError(w, "EAPI:Invalid nonce")
+			return
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		a := s.getAccount(apiKey)
+
+		if nonce <= a.nonce {
+			apiError(w, "EAPI:Invalid nonce")
+			return
+		}
+
+		a.nonce = nonce
+
+		handler(w, a, p)
+	}
+}
+
+func (s *Server) nextOutcome() spot.Outcome {
+
+	if len(s.outcomes) == 0 {
+		return s.defaultOutcome
+	}
+
+	outcome := s.outcomes[0]
+
+	s.outcomes = s.outcomes[1:]
+
+	return outcome
+}
+
+// clOrdIdMatches returns true when request has no cl_ord_id or it is equal to one of the order
+func clOrdIdMatches(o *order, p url.Values) bool {
+	return len(p.Get("cl_ord_id")) == 0 || o.clOrdId == p.Get("cl_ord_id")
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+// fill executes volume of the order, must be called under lock
+func (s *Server) fill(a *account, o *order, volume decimal.Decimal) {
+
+	t := &trade{txid: fmt.Sprintf("TMOCK-%06d", s.nextTradeId), order: o, price: o.price, vol: volume, time: now()}
+
+	s.nextTradeId++
+
+	t.cost = volume.Mul(o.price)
+
+	base := o.pair.Base
+	quote := o.pair.Quote
+
+	if strings.Contains(o.oflags, feeInBaseFlag) {
+		t.fee = volume.Mul(commissionRate)
+		a.balances[base] = a.balances[base].Sub(t.fee)
+	} else {
+		t.fee = t.cost.Mul(commissionRate)
+		a.balances[quote] = a.balances[quote].Sub(t.fee)
+	}
+
+	if o.side == buyType {
+		a.balances[base] = a.balances[base].Add(volume)
+		a.balances[quote] = a.balances[quote].Sub(t.cost)
+	} else {
+		a.balances[base] = a.balances[base].Sub(volume)
+		a.balances[quote] = a.balances[quote].Add(t.cost)
+	}
+
+	o.executed = o.executed.Add(volume)
+	o.cost = o.cost.Add(t.cost)
+	o.fee = o.fee.Add(t.fee)
+	o.trades = append(o.trades, t.txid)
+	o.status = openStatus
+
+	if o.executed.Equal(o.volume) {
+		o.status = closedStatus
+		o.closetm = t.time
+	}
+
+	a.trades[t.txid] = t
+}
+
+func description(o *order) string {
+
+	if o.orderType == limitType {
+		return o.side + " " + format(o.volume) + " " + o.pair.Name + " @ limit " + format(o.price)
+	}
+
+	return o.side + " " + format(o.volume) + " " + o.pair.Name + " @ market"
+}
+
+func (s *Server) addOrder(w http.ResponseWriter, a *account, p url.Values) {
+
+	pair := s.pairs[p.Get("pair")]
+
+	if pair == nil {
+		apiError(w, "EQuery:Unknown asset pair")
+		return
+	}
+
+	volume, err := decimal.NewFromString(p.Get("volume"))
+
+	if err != nil || !volume.IsPositive() {
+		apiError(w, "EGeneral:Invalid arguments:volume")
+		return
+	}
+
+	o := &order{
+		txid:      fmt.Sprintf("OMOCK-%06d", s.nextOrderId),
+		pair:      pair,
+		side:      p.Get("type"),
+		orderType: p.Get("ordertype"),
+		price:     pair.Price,
+		volume:    volume,
+		oflags:    p.Get("oflags"),
+		status:    pendingStatus,
+		opentm:    now(),
+		trades:    make([]string, 0),
+	}
+
+	if o.side != buyType && o.side != sellType {
+		apiError(w, "EGeneral:Invalid arguments:type")
+		return
+	}
+
+	if o.orderType != marketType && o.orderType != limitType {
+		apiError(w, "EGeneral:Invalid arguments:ordertype")
+		return
+	}
+
+	if o.clOrdId = p.Get("cl_ord_id"); len(o.clOrdId) > 18 {
+		apiError(w, "EGeneral:Invalid arguments:cl_ord_id")
+		return
+	}
+
+	if o.orderType == limitType {
+
+		o.price, err = decimal.NewFromString(p.Get("price"))
+
+		if err != nil || !o.price.IsPositive() {
+			apiError(w, "EGeneral:Invalid arguments:price")
+			return
+		}
+
+		if tif := p.Get("timeinforce"); len(tif) > 0 && tif != "GTC" && tif != "IOC" && tif != "GTD" {
+			apiError(w, "EGeneral:Invalid arguments:timeinforce")
+			return
+		}
+	}
+
+	outcome := s.nextOutcome()
+
+	s.ctxLog.Info("Order ", o.clOrdId, " ", o.side, " ", o.orderType, " ", format(volume), " ", pair.Name, " outcome ", outcome)
+
+	switch outcome.Status {
+
+	case spot.OutcomeRejected:
+		apiError(w, "EOrder:Insufficient funds")
+		return
+
+	case spot.OutcomeError:
+		apiError(w, "EService:Unavailable")
+		return
+
+	case spot.OutcomeRateLimit:
+		apiError(w, "EAPI:Rate limit exceeded")
+		return
+	}
+
+	s.nextOrderId++
+
+	a.orders[o.txid] = o
+
+	o.status = openStatus
+
+	switch outcome.Status {
+
+	case spot.OutcomeFilled, spot.OutcomeLost:
+		s.fill(a, o, volume)
+
+	case spot.OutcomePartiallyFilled:
+		s.fill(a, o, volume.Div(decimal.New(2, 0)).Truncate(precision))
+
+	case spot.OutcomeExpired:
+		o.status = expiredStatus
+		o.closetm = now()
+
+	case spot.OutcomeCanceled:
+		o.status = canceledStatus
+		o.reason = "User requested"
+		o.closetm = now()
+	}
+
+	if o.isAlive() && outcome.FillAfter > 0 {
+
+		time.AfterFunc(outcome.FillAfter, func() {
+
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			if o.isAlive() {
+				s.fill(a, o, o.volume.Sub(o.executed))
+			}
+		})
+	}
+
+	if outcome.Status == spot.OutcomeLost {
+
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if connection, _, err := hijacker.Hijack(); err == nil {
+				_ = connection.Close()
+				return
+			}
+		}
+
+		apiError(w, "EService:Unavailable")
+		return
+	}
+
+	result(w, map[string]interface{}{
+		"descr": map[string]interface{}{"order": description(o)},
+		"txid":  []string{o.txid},
+	})
+}
+
+func orderJson(o *order, withTrades bool) map[string]interface{} {
+
+	price := decimal.Zero
+
+	if o.executed.IsPositive() {
+		price = o.cost.DivRound(o.executed, precision)
+	}
+
+	descrPrice := "0"
+
+	if o.orderType == limitType {
+		descrPrice = format(o.price)
+	}
+
+	fields := map[string]interface{}{
+		"refid":     nil,
+		"userref":   0,
+		"cl_ord_id": o.clOrdId,
+		"status":    o.status,
+		"reason":    nil,
+		"opentm":    o.opentm,
+		"closetm":   o.closetm,
+		"starttm":   0,
+		"expiretm":  0,
+		"descr": map[string]interface{}{
+			"pair":      o.pair.Name,
+			"type":      o.side,
+			"ordertype": o.orderType,
+			"price":     descrPrice,
+			"price2":    "0",
+			"leverage":  "none",
+			"order":     description(o),
+			"close":     "",
+		},
+		"vol":        format(o.volume),
+		"vol_exec":   format(o.executed),
+		"cost":       format(o.cost),
+		"fee":        format(o.fee),
+		"price":      format(price),
+		"stopprice":  "0.00000000",
+		"limitprice": "0.00000000",
+		"misc":       "",
+		"oflags":     o.oflags,
+	}
+
+	if len(o.reason) > 0 {
+		fields["reason"] = o.reason
+	}
+
+	if withTrades && len(o.trades) > 0 {
+		fields["trades"] = o.trades
+	}
+
+	return fields
+}
+
+func (s *Server) queryOrders(w http.ResponseWriter, a *account, p url.Values) {
+
+	orders := make(map[string]interface{})
+
+	for _, txid := range strings.Split(p.Get("txid"), ",") {
+
+		o := a.orders[txid]
+
+		if o == nil {
+			apiError(w, "EOrder:Invalid order")
+			return
+		}
+
+		if clOrdIdMatches(o, p) {
+			orders[txid] = orderJson(o, p.Get("trades") == "true")
+		}
+	}
+
+	result(w, orders)
+}
+
+func (s *Server) openOrders(w http.ResponseWriter, a *account, p url.Values) {
+
+	orders := make(map[string]interface{})
+
+	for txid, o := range a.orders {
+		if o.isAlive() && clOrdIdMatches(o, p) {
+			orders[txid] = orderJson(o, p.Get("trades") == "true")
+		}
+	}
+
+	result(w, map[string]interface{}{"open": orders})
+}
+
+func (s *Server) closedOrders(w http.ResponseWriter, a *account, p url.Values) {
+
+	closed := make([]*order, 0)
+
+	for _, o := range a.orders {
+		if !o.isAlive() && clOrdIdMatches(o, p) {
+			closed = append(closed, o)
+		}
+	}
+
+	sort.Slice(closed, func(i, j int) bool { return closed[i].closetm > closed[j].closetm })
+
+	count := len(closed)
+
+	if len(closed) > closedOrdersLimit {
+		closed = closed[:closedOrdersLimit]
+	}
+
+	orders := make(map[string]interface{})
+
+	for _, o := range closed {
+		orders[o.txid] = orderJson(o, p.Get("trades") == "true")
+	}
+
+	result(w, map[string]interface{}{"closed": orders, "count": count})
+}
+
+func (s *Server) cancelOrder(w http.ResponseWriter, a *account, p url.Values) {
+
+	o := a.orders[p.Get("txid")]
+
+	if o == nil || !o.isAlive() {
+		apiError(w, "EOrder:Unknown order")
+		return
+	}
+
+	o.status = canceledStatus
+	o.reason = "User requested"
+	o.closetm = now()
+
+	result(w, map[string]interface{}{"count": 1})
+}
+
+func (s *Server) queryTrades(w http.ResponseWriter, a *account, p url.Values) {
+
+	trades := make(map[string]interface{})
+
+	for _, txid := range strings.Split(p.Get("txid"), ",") {
+
+		t := a.trades[txid]
+
+		if t == nil {
+			apiError(w, "EGeneral:Invalid arguments")
+			return
+		}
+
+		trades[txid] = map[string]interface{}{
+			"ordertxid": t.order.txid,
+			"postxid":   "",
+			"pair":      t.order.pair.Base + t.order.pair.Quote,
+			"time":      t.time,
+			"type":      t.order.side,
+			"ordertype": t.order.orderType,
+			"price":     format(t.price),
+			"cost":      format(t.cost),
+			"fee":       format(t.fee),
+			"vol":       format(t.vol),
+			"margin":    "0.00000000",
+			"misc":      "",
+		}
+	}
+
+	result(w, trades)
+}
+
+// balanceEx returns balances with funds held by open orders, buy orders hold quote, sell orders hold base
+func (s *Server) balanceEx(w http.ResponseWriter, a *account, _ url.Values) {
+
+	holds := make(map[string]decimal.Decimal)
+
+	for _, o := range a.orders {
+
+		if !o.isAlive() {
+			continue
+		}
+
+		left := o.volume.Sub(o.executed)
+
+		if o.side == buyType {
+			holds[o.pair.Quote] = holds[o.pair.Quote].Add(left.Mul(o.price))
+		} else {
+			holds[o.pair.Base] = holds[o.pair.Base].Add(left)
+		}
+	}
+
+	balances := make(map[string]interface{})
+
+	for asset, balance := range a.balances {
+		balances[asset] = map[string]interface{}{"balance": format(balance), "hold_trade": format(holds[asset])}
+	}
+
+	result(w, balances)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+func (s *Server) handleTime(w http.ResponseWriter, r *http.Request) {
+
+	t := time.Now().UTC()
+
+	result(w, map[string]interface{}{"unixtime": t.Unix(), "rfc1123": t.Format(time.RFC1123)})
+}
+
+// handleAssetPairs returns comma separated pairs or all of them, keyed by canonical name as Kraken does
+func (s *Server) handleAssetPairs(w http.ResponseWriter, r *http.Request) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pairs := make(map[string]interface{})
+
+	add := func(pair *spot.Symbol) {
+		pairs[pair.Base+pair.Quote] = map[string]interface{}{
+			"altname":       pair.Name,
+			"wsname":        pair.Base + "/" + pair.Quote,
+			"base":          pair.Base,
+			"quote":         pair.Quote,
+			"pair_decimals": precision,
+			"lot_decimals":  precision,
+			"ordermin":      "0.0001",
+		}
+	}
+
+	if spec := r.URL.Query().Get("pair"); len(spec) > 0 {
+
+		for _, name := range strings.Split(spec, ",") {
+
+			pair := s.pairs[name]
+
+			if pair == nil {
+				apiError(w, "EQuery:Unknown asset pair")
+				return
+			}
+
+			add(pair)
+		}
+
+	} else {
+		for _, pair := range s.pairs {
+			add(pair)
+		}
+	}
+
+	result(w, pairs)
+}
+
+// handleOutcomes appends outcomes posted as comma separated list, GET returns outcomes which are not taken yet
+func (s *Server) handleOutcomes(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost {
+
+		body, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		outcomes, err := spot.ParseOutcomes(string(body))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Push(outcomes...)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]string, len(s.outcomes))
+
+	for i, o := range s.outcomes {
+		list[i] = o.String()
+	}
+
+	writeJson(w, map[string]interface{}{"default": s.defaultOutcome.String(), "outcomes": list})
+}
@@ -0,0 +1,47 @@
+package eckraken
+
+import (
+	"net/url"
+	"sync"
+)
+
+// pairsCache keeps asset pairs by the name they were asked with, unknown pairs are kept as nil, so every instrument
+// costs one call of public API
+type pairsCache struct {
+	lock  sync.Mutex
+	pairs map[string]*assetPair
+}
+
+func newPairsCache() *pairsCache {
+	return &pairsCache{pairs: make(map[string]*assetPair)}
+}
+
+func (c *pairsCache) get(kraken *client, instrument string) (*assetPair, error) {
+
+	c.lock.Lock()
+	pair, ok := c.pairs[instrument]
+	c.lock.Unlock()
+
+	if ok {
+		return pair, nil
+	}
+
+	result := make(map[string]*assetPair)
+
+	err := kraken.public(assetPairsPath, url.Values{"pair": {instrument}}, &result)
+
+	if err != nil && !hasError(err, unknownPairError) {
+		return nil, err
+	}
+
+	// Kraken answers with canonical name of the pair, e.g. XXBTZUSD for XBTUSD
+	for _, p := range result {
+		pair = p
+	}
+
+	c.lock.Lock()
+	c.pairs[instrument] = pair
+	c.lock.Unlock()
+
+	return pair, nil
+}
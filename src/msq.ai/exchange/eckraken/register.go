@@ -0,0 +1,26 @@
+package eckraken
+
+import (
+	"msq.ai/connectors/proto"
+	"msq.ai/connectors/registry"
+	"time"
+)
+
+const propertiesApiUrlName = "api.url"
+const propertiesBackOffMsName = "rate.limit.back.off.ms"
+
+func init() {
+	registry.Register(registry.Exchange{Name: "KRAKEN", ExecPoolSize: 200, Run: run})
+}
+
+func run(settings registry.Settings, in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse,
+	execPoolSize int) func() time.Duration {
+
+	config := Config{ApiUrl: settings.MustGet(propertiesApiUrlName)}
+
+	backOff := NewBackOff(time.Duration(settings.GetInt(propertiesBackOffMsName, 5000)) * time.Millisecond)
+
+	RunKrakenConnector(in, out, execPoolSize, config, backOff)
+
+	return backOff.PauseTime
+}
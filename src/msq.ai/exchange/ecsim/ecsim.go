@@ -0,0 +1,353 @@
+package ecsim
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/connector"
+	"msq.ai/connectors/proto"
+	"msq.ai/constants"
+	"msq.ai/data/cmd"
+	"msq.ai/db/postgres/dao"
+	pgh "msq.ai/db/postgres/helper"
+	"strconv"
+	"time"
+)
+
+// simulator has no ids of its own, orders and trades are numbered by execution id
+const externalRefPrefix = "SIM-"
+
+var errNotFinished = errors.New("simulated order is neither open nor finished")
+
+type Config struct {
+	Instruments map[string]Instrument
+	Prices      PriceSource
+	Fees        Fees
+	Slippage    Slippage
+	// Balances fund every account at its first order
+	Balances map[string]decimal.Decimal
+	// PollTime is how often resting limit orders are matched with the price
+	PollTime time.Duration
+}
+
+// RunSimConnector executes commands of paper trading accounts. Market orders and marketable limit orders are filled at
+// once by the price of the source moved by slippage, the rest of limit orders are rejected if FOK or rest till the
+// price crosses the limit or the command times out if GTC. Balances and orders are kept in DB, so check finds the state
+// of the order after restart.
+func RunSimConnector(in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse, execPoolSize int, dbUrl string, config Config) {
+
+	ctxLog := log.WithFields(log.Fields{"id": "SimConnector"})
+
+	if config.Prices == nil {
+		ctxLog.Fatal("Price source is nil !")
+	}
+
+	if config.PollTime <= 0 {
+		ctxLog.Fatal("PollTime isn't positive !")
+	}
+
+	db, err := pgh.GetDbByUrl(dbUrl)
+
+	if err != nil {
+		ctxLog.Fatal("Cannot connect to DB with URL ["+dbUrl+"] ", err)
+	}
+
+	db.SetMaxOpenConns(execPoolSize)
+
+	//------------------------------------------------------------------------------------------------------------------
+
+	errorResponse := func(response *proto.ExecResponse, err error) *proto.ExecResponse {
+
+		ctxLog.Error("Simulation error ", err)
+
+		response.Status = proto.StatusError
+		response.Description = "Simulation error [" + err.Error() + "]"
+
+		return response
+	}
+
+	rejected := func(response *proto.ExecResponse, description string) *proto.ExecResponse {
+		response.Status = proto.StatusRejected
+		response.Description = "Order rejected " + description
+		return response
+	}
+
+	isBuy := func(request *proto.ExecRequest) bool {
+		return request.RawCmd.Direction == constants.OrderDirectionBuyName
+	}
+
+	// reserve is locked for resting limit order, buy order locks cost and maker fee at limit price
+	reserve := func(request *proto.ExecRequest, instrument Instrument) dao.BalanceChange {
+
+		if isBuy(request) {
+			cost, fee := costOf(request.Cmd.Amount, request.Cmd.LimitPrice, config.Fees.Maker)
+			return dao.BalanceChange{Asset: instrument.Quote, Locked: cost.Add(fee)}
+		}
+
+		return dao.BalanceChange{Asset: instrument.Base, Locked: request.Cmd.Amount}
+	}
+
+	// trades gives changes of balances by the trade, funds are taken from free or locked balance
+	trades := func(request *proto.ExecRequest, instrument Instrument, price decimal.Decimal, rate decimal.Decimal,
+		fromLocked bool) ([]dao.BalanceChange, decimal.Decimal) {
+
+		amount := request.Cmd.Amount
+
+		cost, fee := costOf(amount, price, rate)
+
+		var paid, received dao.BalanceChange
+
+		if isBuy(request) {
+			paid = dao.BalanceChange{Asset: instrument.Quote, Free: cost.Add(fee).Neg()}
+			received = dao.BalanceChange{Asset: instrument.Base, Free: amount}
+		} else {
+			paid = dao.BalanceChange{Asset: instrument.Base, Free: amount.Neg()}
+			received = dao.BalanceChange{Asset: instrument.Quote, Free: cost.Sub(fee)}
+		}
+
+		if fromLocked {
+			paid = reserve(request, instrument)
+			paid.Locked = paid.Locked.Neg()
+		}
+
+		return []dao.BalanceChange{paid, received}, fee
+	}
+
+	settle := func(request *proto.ExecRequest, response *proto.ExecResponse, order *dao.SimulatedOrder) *proto.ExecResponse {
+
+		if order.Status == dao.SimulatedOrderCanceled {
+			return rejected(response, "limit order is expired unfilled")
+		}
+
+		ref := externalRefPrefix + strconv.FormatInt(order.ExecutionId, 10)
+
+		response.Order = &cmd.Order{ExternalOrderId: order.ExecutionId, ExternalOrderRef: ref, ExecutionId: request.Cmd.Id,
+			Fills: []cmd.Fill{{ExternalTradeId: order.ExecutionId, ExternalTradeRef: ref, Quantity: order.ExecutedQuantity,
+				Price: order.Price, Commission: order.Commission, CommissionAsset: order.CommissionAsset}}}
+
+		cmd.ApplyFills(response.Order)
+
+		response.Description = "Simulated " + order.Status
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	// finish moves resting order to final status, the order which is finished already by somebody else is settled as it is
+	finish := func(request *proto.ExecRequest, response *proto.ExecResponse, order *dao.SimulatedOrder,
+		changes []dao.BalanceChange) *proto.ExecResponse {
+
+		finished, err := dao.FinishSimulatedOrder(db, order, changes)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		if !finished {
+
+			if order, err = dao.LoadSimulatedOrder(db, request.Cmd.Id); err != nil {
+				return errorResponse(response, err)
+			}
+
+			if order == nil || order.Status == dao.SimulatedOrderOpen {
+				return errorResponse(response, errNotFinished)
+			}
+		}
+
+		return settle(request, response, order)
+	}
+
+	// rest matches open limit order with the price till it crosses the limit or the command times out, the order is
+	// parked when the request may not follow it any more
+	rest := func(request *proto.ExecRequest, response *proto.ExecResponse, instrument Instrument,
+		order *dao.SimulatedOrder) *proto.ExecResponse {
+
+		request.Progress(&proto.ExecResponse{Request: request, Status: proto.StatusOpen,
+			Description: "Simulated order rests at " + order.Price.String()})
+
+		ticker := time.NewTicker(config.PollTime)
+
+		defer ticker.Stop()
+
+		for range ticker.C {
+
+			if request.Cmd.ExecuteTillTime.Before(time.Now()) {
+
+				unlock := reserve(request, instrument)
+				unlock.Free = unlock.Locked
+				unlock.Locked = unlock.Locked.Neg()
+
+				order.Status = dao.SimulatedOrderCanceled
+
+				return finish(request, response, order, []dao.BalanceChange{unlock})
+			}
+
+			price, ok := config.Prices.Price(instrument.Name)
+
+			if !ok || (isBuy(request) && price.GreaterThan(order.Price)) || (!isBuy(request) && price.LessThan(order.Price)) {
+
+				if !request.KeepFollowing() {
+					return proto.Park(response, decimal.Zero, "Simulated order rests at "+order.Price.String())
+				}
+
+				continue
+			}
+
+			changes, fee := trades(request, instrument, order.Price, config.Fees.Maker, true)
+
+			order.Status = dao.SimulatedOrderFilled
+			order.ExecutedQuantity = request.Cmd.Amount
+			order.Commission = fee
+
+			return finish(request, response, order, changes)
+		}
+
+		return response
+	}
+
+	trade := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		instrument, ok := config.Instruments[request.RawCmd.Instrument]
+
+		if !ok {
+			return rejected(response, "instrument "+request.RawCmd.Instrument+" isn't simulated")
+		}
+
+		price, ok := config.Prices.Price(instrument.Name)
+
+		if !ok {
+			response.Description = "Price of " + instrument.Name + " isn't known yet"
+			return response
+		}
+
+		start := time.Now()
+
+		order := &dao.SimulatedOrder{ExecutionId: request.Cmd.Id, AccountId: request.Cmd.AccountId,
+			Status: dao.SimulatedOrderFilled, ExecutedQuantity: request.Cmd.Amount, CommissionAsset: instrument.Quote}
+
+		var changes []dao.BalanceChange
+
+		if request.RawCmd.OrderType == constants.OrderTypeMarketName {
+
+			order.Price = config.Slippage.apply(price, request.Cmd.Amount, isBuy(request))
+
+		} else if request.RawCmd.OrderType == constants.OrderTypeLimitName {
+
+			limit := request.Cmd.LimitPrice
+
+			marketable := (isBuy(request) && price.LessThanOrEqual(limit)) || (!isBuy(request) && price.GreaterThanOrEqual(limit))
+
+			if !marketable && request.RawCmd.TimeInForce == constants.TimeInForceFokName {
+				return rejected(response, "FOK limit "+limit.String()+" isn't reached by price "+price.String())
+			}
+
+			if !marketable {
+
+				order.Status = dao.SimulatedOrderOpen
+				order.Price = limit
+				order.ExecutedQuantity = decimal.Zero
+
+				enough, err := dao.InsertSimulatedOrder(db, order, []dao.BalanceChange{reserve(request, instrument)}, config.Balances)
+
+				if err != nil {
+					return errorResponse(response, err)
+				}
+
+				if !enough {
+					return rejected(response, "insufficient simulated balance")
+				}
+
+				response.OutsideExecution = time.Now().Sub(start)
+
+				return rest(request, response, instrument, order)
+			}
+
+			// taker never gets worse price than the limit
+			order.Price = config.Slippage.apply(price, request.Cmd.Amount, isBuy(request))
+
+			if (isBuy(request) && order.Price.GreaterThan(limit)) || (!isBuy(request) && order.Price.LessThan(limit)) {
+				order.Price = limit
+			}
+
+		} else {
+			ctxLog.Fatal("Protocol violation! ExecRequest wrong OrderType ! ", request)
+			return nil
+		}
+
+		if !order.Price.IsPositive() {
+			return rejected(response, "slippage leaves no price for amount "+request.Cmd.Amount.String())
+		}
+
+		changes, order.Commission = trades(request, instrument, order.Price, config.Fees.Taker, false)
+
+		enough, err := dao.InsertSimulatedOrder(db, order, changes, config.Balances)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		if !enough {
+			return rejected(response, "insufficient simulated balance")
+		}
+
+		response.OutsideExecution = time.Now().Sub(start)
+
+		return settle(request, response, order)
+	}
+
+	check := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		order, err := dao.LoadSimulatedOrder(db, request.Cmd.Id)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		if order == nil {
+
+			if request.Cmd.ExecuteTillTime.After(time.Now()) {
+				return trade(request, response)
+			}
+
+			ctxLog.Info("Check error, order not exist and will be marked timed_out ", request.RawCmd.Id)
+			response.Description = "Simulated order " + request.RawCmd.Id + " not exist"
+			response.Status = proto.StatusTimedOut
+			return response
+		}
+
+		if order.Status != dao.SimulatedOrderOpen {
+			return settle(request, response, order)
+		}
+
+		instrument, ok := config.Instruments[request.RawCmd.Instrument]
+
+		if !ok {
+			response.Description = "Open order of instrument " + request.RawCmd.Instrument + " isn't simulated any more"
+			return response
+		}
+
+		return rest(request, response, instrument, order)
+	}
+
+	info := func(request *proto.ExecRequest, response *proto.ExecResponse) *proto.ExecResponse {
+
+		if request.RawCmd.OrderType != constants.OrderTypeInfoName {
+			response.Description = "Order type " + request.RawCmd.OrderType + " isn't supported by simulator"
+			response.Status = proto.StatusRejected
+			return response
+		}
+
+		balances, err := dao.LoadSimulatedBalances(db, request.Cmd.AccountId, config.Balances)
+
+		if err != nil {
+			return errorResponse(response, err)
+		}
+
+		response.Balances = balances
+		response.Status = proto.StatusOk
+
+		return response
+	}
+
+	connector.RunConnector(ctxLog, in, out, execPoolSize, trade, check, info)
+}
@@ -0,0 +1,40 @@
+package ecsim
+
+import (
+	"github.com/shopspring/decimal"
+	"msq.ai/constants"
+)
+
+var bpsDivider = decimal.New(10000, 0)
+
+// Fees are rates of traded quote amount, orders which are filled at once pay taker rate and resting orders pay maker rate
+type Fees struct {
+	Maker decimal.Decimal
+	Taker decimal.Decimal
+}
+
+// Slippage moves execution price of taker orders against the order by Bps plus ImpactBps for every unit of amount, so
+// zero ImpactBps gives fixed slippage and positive one imitates thin book
+type Slippage struct {
+	Bps       decimal.Decimal
+	ImpactBps decimal.Decimal
+}
+
+func (s Slippage) apply(price decimal.Decimal, amount decimal.Decimal, buy bool) decimal.Decimal {
+
+	shift := price.Mul(s.Bps.Add(s.ImpactBps.Mul(amount))).Div(bpsDivider)
+
+	if buy {
+		return price.Add(shift).Round(constants.DecimalScale)
+	}
+
+	return price.Sub(shift).Round(constants.DecimalScale)
+}
+
+// costOf gives quote amount of the trade and its fee, both are rounded to scale of DB
+func costOf(amount decimal.Decimal, price decimal.Decimal, rate decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+
+	cost := amount.Mul(price).Round(constants.DecimalScale)
+
+	return cost, cost.Mul(rate).Round(constants.DecimalScale)
+}
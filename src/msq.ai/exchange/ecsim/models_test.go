@@ -0,0 +1,56 @@
+package ecsim
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestSlippageMovesPriceAgainstOrder(t *testing.T) {
+
+	price := decimal.NewFromInt(100)
+
+	cases := []struct {
+		name     string
+		slippage Slippage
+		amount   string
+		buy      bool
+		expected string
+	}{
+		{name: "no slippage", amount: "2", buy: true, expected: "100"},
+		{name: "fixed slippage raises buy price", slippage: Slippage{Bps: decimal.NewFromInt(10)}, amount: "2",
+			buy: true, expected: "100.1"},
+		{name: "fixed slippage lowers sell price", slippage: Slippage{Bps: decimal.NewFromInt(10)}, amount: "2",
+			expected: "99.9"},
+		{name: "impact grows with amount", slippage: Slippage{Bps: decimal.NewFromInt(10),
+			ImpactBps: decimal.NewFromInt(5)}, amount: "2", buy: true, expected: "100.2"},
+		{name: "thin book eats sell price", slippage: Slippage{ImpactBps: decimal.NewFromInt(10000)}, amount: "1",
+			expected: "0"},
+	}
+
+	for _, c := range cases {
+
+		t.Run(c.name, func(t *testing.T) {
+
+			got := c.slippage.apply(price, decimal.RequireFromString(c.amount), c.buy)
+
+			if !got.Equal(decimal.RequireFromString(c.expected)) {
+				t.Error("Expected ", c.expected, " got ", got)
+			}
+		})
+	}
+}
+
+func TestCostOfIsRoundedToDbScale(t *testing.T) {
+
+	cost, fee := costOf(decimal.RequireFromString("0.333333333333333333"), decimal.NewFromInt(3),
+		decimal.RequireFromString("0.001"))
+
+	if !cost.Equal(decimal.RequireFromString("0.999999999999999999")) {
+		t.Error("Wrong cost ", cost)
+	}
+
+	// fee is taken from rounded cost, so balance changes match the stored trade
+	if !fee.Equal(decimal.RequireFromString("0.001")) {
+		t.Error("Wrong fee ", fee)
+	}
+}
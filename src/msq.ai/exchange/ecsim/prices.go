@@ -0,0 +1,244 @@
+package ecsim
+
+import (
+	"encoding/csv"
+	"github.com/go-errors/errors"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PriceSource gives the last price of instrument, false means the price isn't known yet
+type PriceSource interface {
+	Price(instrument string) (decimal.Decimal, bool)
+}
+
+type Instrument struct {
+	Name  string
+	Base  string
+	Quote string
+}
+
+// ParseInstruments parses list like "BTCUSDT:BTC:USDT,ETHUSDT:ETH:USDT"
+func ParseInstruments(spec string) (map[string]Instrument, error) {
+
+	result := make(map[string]Instrument)
+
+	for _, s := range strings.Split(spec, ",") {
+
+		if len(strings.TrimSpace(s)) == 0 {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSpace(s), ":")
+
+		if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+			return nil, errors.New("wrong instrument [" + s + "]")
+		}
+
+		result[parts[0]] = Instrument{Name: parts[0], Base: parts[1], Quote: parts[2]}
+	}
+
+	return result, nil
+}
+
+// ParseBalances parses list like "USDT:100000,BTC:1"
+func ParseBalances(spec string) (map[string]decimal.Decimal, error) {
+
+	result := make(map[string]decimal.Decimal)
+
+	for _, s := range strings.Split(spec, ",") {
+
+		if len(strings.TrimSpace(s)) == 0 {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSpace(s), ":")
+
+		if len(parts) != 2 {
+			return nil, errors.New("wrong balance [" + s + "]")
+		}
+
+		amount, err := decimal.NewFromString(parts[1])
+
+		if err != nil || amount.IsNegative() {
+			return nil, errors.New("wrong amount of balance [" + s + "]")
+		}
+
+		result[parts[0]] = amount
+	}
+
+	return result, nil
+}
+
+func readCsv(fileName string, fields int) ([][]string, error) {
+
+	file, err := os.Open(fileName)
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = fields
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	return records, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+type staticPrices struct {
+	prices map[string]decimal.Decimal
+}
+
+func (s *staticPrices) Price(instrument string) (decimal.Decimal, bool) {
+	price, ok := s.prices[instrument]
+	return price, ok
+}
+
+// LoadStaticPrices reads csv file with lines "instrument,price", the prices never change
+func LoadStaticPrices(fileName string) (PriceSource, error) {
+
+	records, err := readCsv(fileName, 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &staticPrices{prices: make(map[string]decimal.Decimal)}
+
+	for _, r := range records {
+
+		price, err := decimal.NewFromString(r[1])
+
+		if err != nil || !price.IsPositive() {
+			return nil, errors.New("wrong price [" + strings.Join(r, ",") + "] in " + fileName)
+		}
+
+		s.prices[r[0]] = price
+	}
+
+	return s, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+
+type tick struct {
+	offset     time.Duration
+	instrument string
+	price      decimal.Decimal
+}
+
+type tickReplay struct {
+	lock   sync.RWMutex
+	prices map[string]decimal.Decimal
+}
+
+func (t *tickReplay) Price(instrument string) (decimal.Decimal, bool) {
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	price, ok := t.prices[instrument]
+
+	return price, ok
+}
+
+// LoadTickReplay reads csv file with lines "time_ms,instrument,price" ordered by time and replays the ticks from now
+// keeping intervals between them divided by speed. When loop is true the replay starts over after the last tick.
+func LoadTickReplay(fileName string, speed float64, loop bool) (PriceSource, error) {
+
+	if speed <= 0 {
+		return nil, errors.New("speed of replay must be positive")
+	}
+
+	records, err := readCsv(fileName, 3)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("no ticks in " + fileName)
+	}
+
+	ticks := make([]tick, 0, len(records))
+
+	var first, previous int64
+
+	for i, r := range records {
+
+		ms, err := strconv.ParseInt(r[0], 10, 64)
+
+		if err != nil {
+			return nil, errors.New("wrong time of tick [" + strings.Join(r, ",") + "] in " + fileName)
+		}
+
+		if i == 0 {
+			first = ms
+		} else if ms < previous {
+			return nil, errors.New("ticks aren't ordered by time [" + strings.Join(r, ",") + "] in " + fileName)
+		}
+
+		previous = ms
+
+		price, err := decimal.NewFromString(r[2])
+
+		if err != nil || !price.IsPositive() {
+			return nil, errors.New("wrong price of tick [" + strings.Join(r, ",") + "] in " + fileName)
+		}
+
+		ticks = append(ticks, tick{offset: time.Duration(ms-first) * time.Millisecond, instrument: r[1], price: price})
+	}
+
+	// all ticks at one moment would be replayed over and over without pause
+	if ticks[len(ticks)-1].offset == 0 {
+		loop = false
+	}
+
+	t := &tickReplay{prices: make(map[string]decimal.Decimal)}
+
+	go func() {
+
+		ctxLog := log.WithFields(log.Fields{"id": "TickReplay"})
+
+		for {
+
+			start := time.Now()
+
+			for _, k := range ticks {
+
+				if wait := time.Duration(float64(k.offset)/speed) - time.Now().Sub(start); wait > 0 {
+					time.Sleep(wait)
+				}
+
+				t.lock.Lock()
+				t.prices[k.instrument] = k.price
+				t.lock.Unlock()
+			}
+
+			if !loop {
+				ctxLog.Info("Replay of ticks is over, the last prices stay")
+				return
+			}
+
+			ctxLog.Trace("Replay of ticks starts over")
+		}
+	}()
+
+	return t, nil
+}
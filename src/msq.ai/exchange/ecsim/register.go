@@ -0,0 +1,93 @@
+package ecsim
+
+import (
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"msq.ai/connectors/proto"
+	"msq.ai/connectors/registry"
+	"msq.ai/constants"
+	"time"
+)
+
+const propertiesInstrumentsName = "sim.instruments"
+const propertiesPricesSourceName = "sim.prices.source"
+const propertiesPricesFileName = "sim.prices.file"
+const propertiesTicksFileName = "sim.ticks.file"
+const propertiesTicksSpeedName = "sim.ticks.speed"
+const propertiesTicksLoopName = "sim.ticks.loop"
+const propertiesFeeMakerName = "sim.fee.maker"
+const propertiesFeeTakerName = "sim.fee.taker"
+const propertiesSlippageBpsName = "sim.slippage.bps"
+const propertiesSlippageImpactBpsName = "sim.slippage.impact.bps"
+const propertiesBalancesName = "sim.balances"
+const propertiesPollMsName = "sim.poll.ms"
+const pricesSourceStatic = "static"
+const pricesSourceTicks = "ticks"
+
+func init() {
+	registry.Register(registry.Exchange{Name: constants.ExchangeSimulatorName, ExecPoolSize: 50, Run: run})
+}
+
+func run(settings registry.Settings, in <-chan *proto.ExecRequest, out chan<- *proto.ExecResponse,
+	execPoolSize int) func() time.Duration {
+
+	ctxLog := log.WithFields(log.Fields{"id": "SimConnector"})
+
+	decimalOf := func(name string, defValue string) decimal.Decimal {
+
+		value, err := decimal.NewFromString(settings.GetString(name, defValue))
+
+		if err != nil {
+			ctxLog.Fatal("Cannot parse "+name+" ", err)
+		}
+
+		return value
+	}
+
+	instruments, err := ParseInstruments(settings.MustGet(propertiesInstrumentsName))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse instruments ", err)
+	}
+
+	balances, err := ParseBalances(settings.GetString(propertiesBalancesName, ""))
+
+	if err != nil {
+		ctxLog.Fatal("Cannot parse balances ", err)
+	}
+
+	var prices PriceSource
+
+	switch source := settings.GetString(propertiesPricesSourceName, pricesSourceStatic); source {
+	case pricesSourceStatic:
+		prices, err = LoadStaticPrices(settings.MustGet(propertiesPricesFileName))
+	case pricesSourceTicks:
+		prices, err = LoadTickReplay(settings.MustGet(propertiesTicksFileName), settings.GetFloat64(propertiesTicksSpeedName, 1),
+			settings.GetBool(propertiesTicksLoopName, true))
+	default:
+		ctxLog.Fatal("Unknown source of prices ", source)
+	}
+
+	if err != nil {
+		ctxLog.Fatal("Cannot load prices ", err)
+	}
+
+	config := Config{
+		Instruments: instruments,
+		Prices:      prices,
+		Fees: Fees{
+			Maker: decimalOf(propertiesFeeMakerName, "0"),
+			Taker: decimalOf(propertiesFeeTakerName, "0"),
+		},
+		Slippage: Slippage{
+			Bps:       decimalOf(propertiesSlippageBpsName, "0"),
+			ImpactBps: decimalOf(propertiesSlippageImpactBpsName, "0"),
+		},
+		Balances: balances,
+		PollTime: time.Duration(settings.GetInt(propertiesPollMsName, 1000)) * time.Millisecond,
+	}
+
+	RunSimConnector(in, out, execPoolSize, settings.MustGet(constants.PostgresUrlPropertyName), config)
+
+	return nil
+}
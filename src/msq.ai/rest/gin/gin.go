@@ -5,20 +5,35 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-errors/errors"
+	"github.com/shopspring/decimal"
 	log "github.com/sirupsen/logrus"
 	con "msq.ai/constants"
 	comd "msq.ai/data/cmd"
 	"msq.ai/db/postgres/dao"
 	dic "msq.ai/db/postgres/dictionaries"
 	pgh "msq.ai/db/postgres/helper"
-	"msq.ai/utils/math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExecution int) {
+// the highest leverage of Binance futures
+const maxLeverage = 125
+
+// the highest priority which may be requested, priority of execution type is taken when it is not requested
+const maxPriority = 100
+
+// isExact reports whether value is stored in DB without rounding
+func isExact(value decimal.Decimal) bool {
+	return value.Equal(value.Truncate(con.DecimalScale))
+}
+
+// Validator checks MARKET or LIMIT command against trading rules of exchange, error is the reason of rejection
+type Validator func(raw comd.RawCommand) error
+
+// RunGinRestService serves commands, commands of exchanges which have validators are checked before they are inserted
+func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExecution int, validators map[string]Validator) {
 
 	ctxLog := log.WithFields(log.Fields{"id": "GinRestService"})
 
@@ -42,24 +57,39 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 
 	executionStatusCompletedId := dictionaries.ExecutionStatuses().GetIdByName(con.ExecutionStatusCompletedName)
 	executionStatusErrorId := dictionaries.ExecutionStatuses().GetIdByName(con.ExecutionStatusErrorName)
-	orderTypeInfoId := dictionaries.OrderTypes().GetIdByName(con.OrderTypeInfoName)
+	orderTypeLeverageId := dictionaries.OrderTypes().GetIdByName(con.OrderTypeLeverageName)
+
+	// commands of simulated accounts are stored for the simulator
+	exchangeSimulatorId := dictionaries.Exchanges().GetIdByName(con.ExchangeSimulatorName)
+
+	if exchangeSimulatorId < 1 {
+		ctxLog.Fatal("Exchange " + con.ExchangeSimulatorName + " isn't found in DB")
+	}
+
+	requestOrderTypeIds := []int16{
+		dictionaries.OrderTypes().GetIdByName(con.OrderTypeInfoName),
+		orderTypeLeverageId,
+		dictionaries.OrderTypes().GetIdByName(con.OrderTypeMarginIsolatedName),
+		dictionaries.OrderTypes().GetIdByName(con.OrderTypeMarginCrossedName),
+	}
 
 	db.SetMaxIdleConns(10)
 	db.SetMaxOpenConns(30)
 	db.SetConnMaxLifetime(time.Minute * 20)
 
-	dbLoadCommandById := func(id int64) (*comd.Command, *comd.Order, *[]*comd.Balance, *sql.NullString, error) {
-		return dao.LoadCommandById(db, id, executionStatusCompletedId, executionStatusErrorId, orderTypeInfoId)
+	dbLoadCommandById := func(id int64) (*comd.Command, *comd.Order, *[]*comd.Balance, *[]*comd.Position, *sql.NullString, error) {
+		return dao.LoadCommandById(db, id, executionStatusCompletedId, executionStatusErrorId, requestOrderTypeIds)
 	}
 
-	dbInsertCommand := func(exchangeId int16, instrumentVal string, directionId int16, orderTypeId int16, limitPrice float64,
-		timeInForce int16, amount float64, executionTypeId int16, future time.Time, refPositionIdVal string, now time.Time,
-		accountId int64, apiKey string, secretKey string, fingerPrint string) (int64, error) {
+	dbInsertCommand := func(exchangeId int16, instrumentVal string, directionId int16, orderTypeId int16, limitPrice decimal.Decimal,
+		timeInForce int16, amount decimal.Decimal, executionTypeId int16, future time.Time, refPositionIdVal string, now time.Time,
+		accountId int64, apiKey string, secretKey string, fingerPrint string, priority int16) (int64, error) {
 
 		statusCreatedId := dictionaries.ExecutionStatuses().GetIdByName(con.ExecutionStatusCreatedName)
 
 		return dao.InsertCommand(db, exchangeId, instrumentVal, directionId, orderTypeId, limitPrice, timeInForce, amount,
-			statusCreatedId, executionTypeId, future, refPositionIdVal, now, accountId, apiKey, secretKey, fingerPrint)
+			statusCreatedId, executionTypeId, future, refPositionIdVal, now, accountId, apiKey, secretKey, fingerPrint,
+			priority, exchangeSimulatorId)
 	}
 
 	// curl -X GET localhost:8080/execution/v1/command/25
@@ -85,7 +115,7 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 
 		ctxLog.Trace("id [", id, "]")
 
-		command, order, balances, description, err := dbLoadCommandById(id)
+		command, order, balances, positions, description, err := dbLoadCommandById(id)
 
 		if err != nil {
 
@@ -115,7 +145,7 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 				c.JSON(http.StatusOK, comd.ToRawWithOrder(command, dictionaries, order))
 				return
 			} else if balances != nil {
-				c.JSON(http.StatusOK, comd.ToRawWithBalances(command, dictionaries, balances))
+				c.JSON(http.StatusOK, comd.ToRawWithBalances(command, dictionaries, balances, positions))
 				return
 			} else {
 				ctxLog.Fatal("Illegal state! Execution Status is Completed, but haven't neither order neither balances")
@@ -133,6 +163,9 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 	// SELL
 	// curl -X PUT -d "cmd[exchange]=BINANCE&cmd[instrument]=BTTBTC&cmd[direction]=SELL&cmd[order_type]=MARKET&cmd[time_in_force]=GTC&cmd[amount]=10000&cmd[execution_type]=OPEN&cmd[account_id]=1&cmd[api_key]=B8U0U&cmd[secret_key]=0abqCy&cmd[finger_print]=qwe" localhost:8080/execution/v1/command/
 
+	// LEVERAGE (amount is the leverage), MARGIN_ISOLATED and MARGIN_CROSSED set up a symbol of futures account
+	// curl -X PUT -d "cmd[exchange]=BINANCE_FUTURES&cmd[instrument]=BTCUSDT&cmd[direction]=ACCOUNT&cmd[order_type]=LEVERAGE&cmd[time_in_force]=FOK&cmd[amount]=10&cmd[execution_type]=REQUEST&cmd[account_id]=1&cmd[api_key]=JbOlqQ&cmd[secret_key]=xfPip87&cmd[finger_print]=lvrg" localhost:8080/execution/v1/command/
+
 	// INFO
 	// curl -X PUT -d "cmd[exchange]=BINANCE&cmd[instrument]= &cmd[direction]=ACCOUNT&cmd[order_type]=INFO&cmd[time_in_force]=FOK&cmd[amount]=0&cmd[execution_type]=REQUEST&cmd[account_id]=1&cmd[api_key]=JbOlqQ&cmd[secret_key]=xfPip87&cmd[finger_print]=asdfda" localhost:8080/execution/v1/command/
 
@@ -239,15 +272,15 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 
 		ctxLog.Trace("limit_price [", limitPriceVal, "]")
 
-		var limitPrice float64 = -1
+		var limitPrice = decimal.NewFromInt(-1)
 
 		if orderTypeId == dictionaries.OrderTypes().GetIdByName(con.OrderTypeLimitName) {
 
-			limitPrice, err = strconv.ParseFloat(limitPriceVal, 64)
+			limitPrice, err = decimal.NewFromString(limitPriceVal)
 
 			if err != nil {
 
-				logErrWithST("Cannot parse limit_price ["+limitPriceVal+"]", err)
+				logErrWithST("Cannot parse limit_price ["+limitPriceVal+"]", errors.New(err))
 
 				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 					"error": "Wrong 'limit_price' parameter [" + limitPriceVal + "]",
@@ -258,7 +291,7 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 
 			ctxLog.Trace("limit_price [", limitPrice, "]")
 
-			if math.IsZero(limitPrice) || limitPrice < 0 {
+			if limitPrice.Sign() <= 0 || !isExact(limitPrice) {
 
 				logErr("Wrong 'limit_price' parameter [" + limitPriceVal + "]")
 
@@ -297,11 +330,11 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 
 		ctxLog.Trace("amount [", amountVal, "]")
 
-		amount, err := strconv.ParseFloat(amountVal, 64)
+		amount, err := decimal.NewFromString(amountVal)
 
 		if err != nil {
 
-			logErrWithST("Cannot parse amount ["+amountVal+"]", err)
+			logErrWithST("Cannot parse amount ["+amountVal+"]", errors.New(err))
 
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 				"error": "Wrong 'amount' parameter [" + amountVal + "]",
@@ -312,7 +345,7 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 
 		ctxLog.Trace("amount [", amount, "]")
 
-		if amount < 0 {
+		if amount.IsNegative() || !isExact(amount) {
 
 			logErr("Wrong 'amount' parameter [" + amountVal + "]")
 
@@ -323,6 +356,18 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 			return
 		}
 
+		if orderTypeId == orderTypeLeverageId && (!amount.IsInteger() || amount.LessThan(decimal.NewFromInt(1)) ||
+			amount.GreaterThan(decimal.NewFromInt(maxLeverage))) {
+
+			logErr("Wrong 'amount' parameter of leverage [" + amountVal + "]")
+
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Wrong 'amount' parameter of leverage [" + amountVal + "]",
+			})
+
+			return
+		}
+
 		//--------------------------------------------------------------------------------------------------------------
 
 		executionTypeVal := strings.ToUpper(cmd["execution_type"])
@@ -346,6 +391,34 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 
 		//--------------------------------------------------------------------------------------------------------------
 
+		priorityVal := cmd["priority"]
+
+		ctxLog.Trace("priority [", priorityVal, "]")
+
+		var priority int16 = -1
+
+		if len(priorityVal) > 0 {
+
+			value, err := strconv.Atoi(priorityVal)
+
+			if err != nil || value < 0 || value > maxPriority {
+
+				logErr("Wrong 'priority' parameter [" + priorityVal + "]")
+
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"error": "Wrong 'priority' parameter [" + priorityVal + "]",
+				})
+
+				return
+			}
+
+			priority = int16(value)
+		}
+
+		ctxLog.Trace("priority [", priority, "]")
+
+		//--------------------------------------------------------------------------------------------------------------
+
 		refPositionIdVal := cmd["ref_position_id"]
 
 		ctxLog.Trace("ref_position_id [", refPositionIdVal, "]")
@@ -430,12 +503,33 @@ func RunGinRestService(dburl string, dictionaries *dic.Dictionaries, timeForExec
 
 		//--------------------------------------------------------------------------------------------------------------
 
+		validate, ok := validators[exchangeVal]
+
+		if ok && (orderTypeVal == con.OrderTypeMarketName || orderTypeVal == con.OrderTypeLimitName) {
+
+			raw := comd.RawCommand{Exchange: exchangeVal, Instrument: instrumentVal, Direction: directionVal,
+				OrderType: orderTypeVal, LimitPrice: limitPriceVal, Amount: amountVal, TimeInForce: timeInForceVal}
+
+			if err := validate(raw); err != nil {
+
+				logErr("Trading rules violation [" + err.Error() + "]")
+
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"error": "Trading rules violation [" + err.Error() + "]",
+				})
+
+				return
+			}
+		}
+
+		//--------------------------------------------------------------------------------------------------------------
+
 		now := time.Now()
 
 		future := now.Add(delta)
 
 		id, err := dbInsertCommand(exchangeId, instrumentVal, directionId, orderTypeId, limitPrice, timeInForceId, amount,
-			executionTypeId, future, refPositionIdVal, now, accountId, apiKey, secretKey, fingerPrint)
+			executionTypeId, future, refPositionIdVal, now, accountId, apiKey, secretKey, fingerPrint, priority)
 
 		if err != nil {
 